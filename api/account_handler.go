@@ -0,0 +1,138 @@
+// api/account_handler.go contains the HTTP handlers for password changes, resets and staff invitations.
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/BodaciousX/RVParkBackend/middleware"
+	"github.com/BodaciousX/RVParkBackend/user"
+)
+
+type ChangePasswordRequest struct {
+	OldPassword string `json:"oldPassword"`
+	NewPassword string `json:"newPassword"`
+}
+
+type ResetPasswordRequest struct {
+	Password string `json:"password"`
+}
+
+type PasswordResetRequest struct {
+	Email string `json:"email"`
+}
+
+// SetPasswordRequest redeems the token from a reset or invitation email
+type SetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type InviteUserRequest struct {
+	Email    string    `json:"email"`
+	Username string    `json:"username"`
+	Role     user.Role `json:"role"`
+}
+
+// handleChangeMyPassword keeps the session making the request and signs the
+// user out everywhere else
+func (s *Server) handleChangeMyPassword(w http.ResponseWriter, r *http.Request) {
+	var req ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorMessage(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	currentUser := r.Context().Value(middleware.UserContextKey).(*user.User)
+	token, _ := s.authMiddleware.SessionToken(r)
+	if err := s.userService.ChangePassword(r.Context(), currentUser.ID, token, req.OldPassword, req.NewPassword); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleResetUserPassword(w http.ResponseWriter, r *http.Request) {
+	var req ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorMessage(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if err := s.userService.ResetPassword(r.Context(), r.PathValue("id"), req.Password); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRequestPasswordReset always answers 202 so it can't be used to find
+// out which emails have accounts
+func (s *Server) handleRequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var req PasswordResetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorMessage(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if err := s.userService.RequestPasswordReset(r.Context(), req.Email); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) handleConfirmPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var req SetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorMessage(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if err := s.userService.ConfirmPasswordReset(r.Context(), req.Token, req.Password); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleInviteUser(w http.ResponseWriter, r *http.Request) {
+	var req InviteUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorMessage(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	invited, err := s.userService.InviteUser(r.Context(), user.User{
+		Email:    req.Email,
+		Username: req.Username,
+		Role:     req.Role,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(invited)
+}
+
+func (s *Server) handleAcceptInvite(w http.ResponseWriter, r *http.Request) {
+	var req SetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorMessage(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if err := s.userService.AcceptInvite(r.Context(), req.Token, req.Password); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
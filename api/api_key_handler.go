@@ -0,0 +1,74 @@
+// api/api_key_handler.go contains the HTTP handlers for managing API keys.
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/BodaciousX/RVParkBackend/middleware"
+	"github.com/BodaciousX/RVParkBackend/user"
+)
+
+type CreateAPIKeyRequest struct {
+	Name string `json:"name"`
+	// The user the key acts as; the administrator creating it if empty
+	UserID    string       `json:"userId,omitempty"`
+	Scopes    []user.Scope `json:"scopes"`
+	ExpiresAt *time.Time   `json:"expiresAt,omitempty"`
+}
+
+// CreateAPIKeyResponse is the only time the key itself is shown
+type CreateAPIKeyResponse struct {
+	user.APIKey
+	Key string `json:"key"`
+}
+
+func (s *Server) handleListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := s.userService.ListAPIKeys(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+func (s *Server) handleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorMessage(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	currentUser := r.Context().Value(middleware.UserContextKey).(*user.User)
+	if req.UserID == "" {
+		req.UserID = currentUser.ID
+	}
+
+	apiKey, secret, err := s.userService.CreateAPIKey(r.Context(), user.APIKey{
+		Name:      req.Name,
+		UserID:    req.UserID,
+		Scopes:    req.Scopes,
+		ExpiresAt: req.ExpiresAt,
+		CreatedBy: currentUser.ID,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(CreateAPIKeyResponse{APIKey: *apiKey, Key: secret})
+}
+
+func (s *Server) handleRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	if err := s.userService.RevokeAPIKey(r.Context(), r.PathValue("id")); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
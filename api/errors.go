@@ -0,0 +1,62 @@
+// api/errors.go contains the JSON error envelope shared by all handlers.
+package api
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/BodaciousX/RVParkBackend/apperr"
+)
+
+// ErrorResponse is the body of every non-2xx API response
+type ErrorResponse = apperr.ErrorResponse
+
+type ErrorBody = apperr.ErrorBody
+
+// writeError maps a service error to its status code. Errors that aren't one
+// of the apperr kinds are logged and reported as a generic 500 so internal
+// details don't leak to clients.
+func writeError(w http.ResponseWriter, err error) {
+	var validation *apperr.ValidationError
+	var rateLimit *apperr.RateLimitError
+	switch {
+	case errors.As(err, &validation):
+		apperr.WriteBody(w, http.StatusUnprocessableEntity, ErrorBody{
+			Message: validation.Error(),
+			Fields:  validation.Fields,
+		})
+	case errors.Is(err, apperr.ErrValidation):
+		writeErrorMessage(w, http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, apperr.ErrNotFound):
+		writeErrorMessage(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, apperr.ErrConflict):
+		writeErrorMessage(w, http.StatusConflict, err.Error())
+	case errors.Is(err, apperr.ErrStale):
+		writeErrorMessage(w, http.StatusPreconditionFailed, err.Error())
+	case errors.As(err, &rateLimit):
+		// Round up so a client that waits exactly Retry-After isn't turned away
+		seconds := int(math.Ceil(rateLimit.RetryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		writeErrorMessage(w, http.StatusTooManyRequests, err.Error())
+	default:
+		log.Printf("Internal error: %v", err)
+		writeErrorMessage(w, http.StatusInternalServerError, "internal server error")
+	}
+}
+
+// writeErrorMessage writes an error envelope for failures detected in the
+// handler itself, such as a malformed request body
+func writeErrorMessage(w http.ResponseWriter, status int, message string) {
+	apperr.WriteMessage(w, status, message)
+}
+
+func notFound(w http.ResponseWriter) {
+	writeErrorMessage(w, http.StatusNotFound, "not found")
+}
+
+func methodNotAllowed(w http.ResponseWriter) {
+	writeErrorMessage(w, http.StatusMethodNotAllowed, "method not allowed")
+}
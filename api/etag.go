@@ -0,0 +1,40 @@
+// api/etag.go contains the ETag and If-Match handling used for optimistic
+// concurrency on updates.
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+func setETag(w http.ResponseWriter, version int) {
+	w.Header().Set("ETag", strconv.Quote(strconv.Itoa(version)))
+}
+
+// ifMatchVersion returns the version named by the If-Match header. Updates
+// must send it so they can't overwrite changes the client hasn't seen; when it
+// is missing or can't match any version the error response is written and
+// ok is false. "*" matches whatever version is current and is returned as 0,
+// which the repositories treat as any version. Weak tags, as proxies that
+// re-encode responses send back, name the same version as the strong tag.
+func ifMatchVersion(w http.ResponseWriter, r *http.Request) (version int, ok bool) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" {
+		writeErrorMessage(w, http.StatusPreconditionRequired, "If-Match header with the resource ETag is required")
+		return 0, false
+	}
+	if header == "*" {
+		return 0, true
+	}
+
+	tag, err := strconv.Unquote(strings.TrimPrefix(header, "W/"))
+	if err == nil {
+		version, err = strconv.Atoi(tag)
+	}
+	if err != nil || version < 1 {
+		writeErrorMessage(w, http.StatusPreconditionFailed, "If-Match does not match the current ETag")
+		return 0, false
+	}
+	return version, true
+}
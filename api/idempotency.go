@@ -0,0 +1,119 @@
+// api/idempotency.go lets clients retry POST requests safely by sending an
+// Idempotency-Key header.
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/BodaciousX/RVParkBackend/idempotency"
+	"github.com/BodaciousX/RVParkBackend/middleware"
+	"github.com/BodaciousX/RVParkBackend/user"
+)
+
+// maxIdempotentBody caps the request body read for fingerprinting
+const maxIdempotentBody = 1 << 20
+
+// replayedHeaders are the response headers stored alongside the body
+var replayedHeaders = []string{"Content-Type", "ETag", "Location"}
+
+// idempotent replays the stored response when a POST is retried with the same
+// Idempotency-Key and body. Reusing a key for a different request is rejected.
+// Requests that fail with a 5xx are forgotten so they can be retried.
+func (s *Server) idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if r.Method != http.MethodPost || key == "" || s.idempotencyService == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBody))
+		if err != nil {
+			writeErrorMessage(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		// A route and its unversioned alias are the same request
+		path := r.URL.Path
+		if strings.HasPrefix(path, APIPrefix+"/") {
+			path = strings.TrimPrefix(path, APIPrefix)
+		}
+		hash := sha256.New()
+		io.WriteString(hash, r.Method+" "+path+"\n")
+		hash.Write(body)
+		fingerprint := hex.EncodeToString(hash.Sum(nil))
+
+		userID := r.Context().Value(middleware.UserContextKey).(*user.User).ID
+		record, err := s.idempotencyService.Begin(r.Context(), userID, key, fingerprint)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if record != nil {
+			for name, value := range record.Header {
+				w.Header().Set(name, value)
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(record.StatusCode)
+			w.Write(record.Body)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		// Store the outcome even if the client has gone away, since that is
+		// exactly when it will retry
+		ctx := context.WithoutCancel(r.Context())
+		if recorder.status >= http.StatusInternalServerError {
+			err = s.idempotencyService.Release(ctx, userID, key)
+		} else {
+			header := map[string]string{}
+			for _, name := range replayedHeaders {
+				if value := w.Header().Get(name); value != "" {
+					header[name] = value
+				}
+			}
+			err = s.idempotencyService.Complete(ctx, idempotency.Record{
+				UserID:     userID,
+				Key:        key,
+				StatusCode: recorder.status,
+				Header:     header,
+				Body:       recorder.body.Bytes(),
+			})
+		}
+		if err != nil {
+			log.Printf("Failed to store idempotency key %q: %v", key, err)
+		}
+	})
+}
+
+// responseRecorder passes a response through while keeping a copy of it
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	body        bytes.Buffer
+	wroteHeader bool
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.wroteHeader = true
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
@@ -0,0 +1,46 @@
+// api/job_handler.go contains the HTTP handlers for background job administration.
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+func (s *Server) handleListJobs(w http.ResponseWriter, r *http.Request) {
+	jobs, err := s.jobService.ListJobs()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jobs)
+}
+
+func (s *Server) handleTriggerJob(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	run, err := s.jobService.Trigger(name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(run)
+}
+
+func (s *Server) handleListJobRuns(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	runs, err := s.jobService.ListRuns(name, limit)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(runs)
+}
@@ -0,0 +1,66 @@
+// api/oidc_handler.go contains the HTTP handlers for single sign-on.
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/BodaciousX/RVParkBackend/user"
+)
+
+// OIDCLoginResponse gives the identity provider address to send the user to
+type OIDCLoginResponse struct {
+	AuthorizationURL string `json:"authorizationUrl"`
+}
+
+// OIDCCallbackRequest passes on what the identity provider sent back to the
+// app's callback page
+type OIDCCallbackRequest struct {
+	Code      string `json:"code"`
+	State     string `json:"state"`
+	UseCookie bool   `json:"useCookie"`
+}
+
+func (s *Server) handleStartOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	address, err := s.userService.StartOIDCLogin(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(OIDCLoginResponse{AuthorizationURL: address})
+}
+
+func (s *Server) handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	var req OIDCCallbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorMessage(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if err := s.checkUseCookie(req.UseCookie); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	result, err := s.userService.CompleteOIDCLogin(r.Context(), user.OIDCCallback{
+		Code:      req.Code,
+		State:     req.State,
+		UserAgent: r.UserAgent(),
+		IPAddress: s.authMiddleware.ClientIP(r),
+	})
+	switch {
+	case errors.Is(err, user.ErrInvalidCredentials):
+		writeErrorMessage(w, http.StatusUnauthorized, "invalid credentials")
+		return
+	case errors.Is(err, user.ErrNoGroupRole):
+		writeErrorMessage(w, http.StatusForbidden, err.Error())
+		return
+	case err != nil:
+		writeError(w, err)
+		return
+	}
+
+	s.writeLoginResult(w, result, req.UseCookie)
+}
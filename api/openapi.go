@@ -0,0 +1,719 @@
+// api/openapi.go describes the API as an OpenAPI 3 document served at
+// /openapi.json. Schemas are generated from the Go request and response types,
+// so the spec table below only needs to name them.
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BodaciousX/RVParkBackend/job"
+	"github.com/BodaciousX/RVParkBackend/middleware"
+	"github.com/BodaciousX/RVParkBackend/notify"
+	"github.com/BodaciousX/RVParkBackend/payment"
+	"github.com/BodaciousX/RVParkBackend/space"
+	"github.com/BodaciousX/RVParkBackend/tenant"
+	"github.com/BodaciousX/RVParkBackend/user"
+)
+
+// operationSpec documents one route. Request and Response hold a value of
+// the body type, or nil when there is no body.
+type operationSpec struct {
+	Summary   string
+	Query     []queryParam
+	Request   interface{}
+	Response  interface{}
+	Status    int   // success status, defaults to 200
+	Errors    []int // statuses besides the 401/403/500 every route can return
+	Paged     bool  // takes limit/cursor/sort and returns paging headers
+	Versioned bool  // GET returns an ETag, PUT requires If-Match
+}
+
+type queryParam struct {
+	Name        string
+	Type        string // OpenAPI type, defaults to string
+	Format      string
+	Description string
+}
+
+// operations documents every route in the route table, keyed by "METHOD path"
+var operations = map[string]operationSpec{
+	"POST /login": {
+		Summary:  "Log in with email and password, or start a two-factor sign-in",
+		Request:  LoginRequest{},
+		Response: LoginResponse{},
+		Errors:   []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusTooManyRequests},
+	},
+	"POST /login/2fa": {
+		Summary:  "Finish a two-factor sign-in with a code from the authenticator app or a recovery code",
+		Request:  LoginChallengeRequest{},
+		Response: LoginResponse{},
+		Errors:   []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusConflict, http.StatusUnprocessableEntity, http.StatusTooManyRequests},
+	},
+	"POST /login/2fa/enroll": {
+		Summary:  "Set up an authenticator during a sign-in that requires one",
+		Request:  EnrollChallengeRequest{},
+		Response: user.TOTPEnrollment{},
+		Errors:   []int{http.StatusBadRequest, http.StatusConflict, http.StatusUnprocessableEntity},
+	},
+	"POST /login/oidc": {
+		Summary:  "Start a single sign-on, returning the identity provider address to send the user to",
+		Response: OIDCLoginResponse{},
+		Errors:   []int{http.StatusNotFound},
+	},
+	"POST /login/oidc/callback": {
+		Summary:  "Finish a single sign-on with the code and state the identity provider sent back",
+		Request:  OIDCCallbackRequest{},
+		Response: LoginResponse{},
+		Errors:   []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity},
+	},
+	"GET /validate-token": {
+		Summary:  "Return the user the bearer token belongs to",
+		Response: ValidateTokenResponse{},
+	},
+	"POST /logout": {
+		Summary: "End the current session, clearing the session cookies if it used them",
+	},
+	"GET /me/sessions": {
+		Summary:  "List the current user's active sessions",
+		Response: []SessionResponse{},
+	},
+	"DELETE /me/sessions": {
+		Summary: "Sign the current user out on every device",
+		Status:  http.StatusNoContent,
+	},
+	"DELETE /me/sessions/{id}": {
+		Summary: "End one of the current user's sessions",
+		Status:  http.StatusNoContent,
+		Errors:  []int{http.StatusNotFound},
+	},
+	"POST /me/password": {
+		Summary: "Change the current user's password, signing out their other sessions",
+		Request: ChangePasswordRequest{},
+		Status:  http.StatusNoContent,
+		Errors:  []int{http.StatusBadRequest, http.StatusUnprocessableEntity},
+	},
+	"GET /me/2fa": {
+		Summary:  "Show whether the current user signs in with two factors",
+		Response: user.TwoFactorStatus{},
+	},
+	"POST /me/2fa/enroll": {
+		Summary:  "Start setting up an authenticator app for the current user",
+		Response: user.TOTPEnrollment{},
+		Errors:   []int{http.StatusConflict},
+	},
+	"POST /me/2fa/confirm": {
+		Summary:  "Turn on two-factor sign-in with a code from the new authenticator",
+		Request:  TwoFactorCodeRequest{},
+		Response: RecoveryCodesResponse{},
+		Errors:   []int{http.StatusBadRequest, http.StatusConflict, http.StatusUnprocessableEntity, http.StatusTooManyRequests},
+	},
+	"POST /me/2fa/disable": {
+		Summary: "Turn off two-factor sign-in with a current or recovery code",
+		Request: TwoFactorCodeRequest{},
+		Status:  http.StatusNoContent,
+		Errors:  []int{http.StatusBadRequest, http.StatusConflict, http.StatusUnprocessableEntity, http.StatusTooManyRequests},
+	},
+	"POST /me/2fa/recovery-codes": {
+		Summary:  "Replace the current user's recovery codes",
+		Request:  TwoFactorCodeRequest{},
+		Response: RecoveryCodesResponse{},
+		Errors:   []int{http.StatusBadRequest, http.StatusConflict, http.StatusUnprocessableEntity, http.StatusTooManyRequests},
+	},
+	"POST /password-reset": {
+		Summary: "Email a password reset link, if the address has an account",
+		Request: PasswordResetRequest{},
+		Status:  http.StatusAccepted,
+		Errors:  []int{http.StatusBadRequest},
+	},
+	"POST /password-reset/confirm": {
+		Summary: "Set a new password with the token from a reset email",
+		Request: SetPasswordRequest{},
+		Status:  http.StatusNoContent,
+		Errors:  []int{http.StatusBadRequest, http.StatusUnprocessableEntity},
+	},
+	"POST /invites/accept": {
+		Summary: "Choose a password with the token from an invitation email",
+		Request: SetPasswordRequest{},
+		Status:  http.StatusNoContent,
+		Errors:  []int{http.StatusBadRequest, http.StatusUnprocessableEntity},
+	},
+
+	"GET /users": {
+		Summary: "List users",
+		Query: []queryParam{
+			{Name: "search", Description: "Case-insensitive match on email or username"},
+			{Name: "role", Description: "ADMIN or STAFF"},
+		},
+		Response: []user.User{},
+		Errors:   []int{http.StatusUnprocessableEntity},
+		Paged:    true,
+	},
+	"POST /users": {
+		Summary:  "Create a user",
+		Request:  CreateUserRequest{},
+		Response: user.User{},
+		Status:   http.StatusCreated,
+		Errors:   []int{http.StatusBadRequest, http.StatusConflict, http.StatusUnprocessableEntity},
+	},
+	"GET /users/{id}": {
+		Summary:  "Get a user",
+		Response: user.User{},
+		Errors:   []int{http.StatusNotFound},
+	},
+	"PUT /users/{id}": {
+		Summary:  "Update a user",
+		Request:  user.User{},
+		Response: user.User{},
+		Errors:   []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity},
+	},
+	"DELETE /users/{id}": {
+		Summary: "Delete a user",
+		Status:  http.StatusNoContent,
+		Errors:  []int{http.StatusNotFound},
+	},
+	"GET /users/{id}/sessions": {
+		Summary:  "List a user's active sessions",
+		Response: []SessionResponse{},
+		Errors:   []int{http.StatusNotFound},
+	},
+	"DELETE /users/{id}/sessions": {
+		Summary: "Sign a user out on every device",
+		Status:  http.StatusNoContent,
+		Errors:  []int{http.StatusNotFound},
+	},
+	"POST /users/{id}/unlock": {
+		Summary: "Clear a user's failed sign-ins, ending any lockout",
+		Status:  http.StatusNoContent,
+		Errors:  []int{http.StatusNotFound},
+	},
+	"POST /users/{id}/password": {
+		Summary: "Set a user's password and sign them out everywhere",
+		Request: ResetPasswordRequest{},
+		Status:  http.StatusNoContent,
+		Errors:  []int{http.StatusBadRequest, http.StatusNotFound, http.StatusUnprocessableEntity},
+	},
+	"GET /users/{id}/login-events": {
+		Summary:  "List a user's recent sign-in attempts",
+		Query:    []queryParam{{Name: "limit", Type: "integer"}},
+		Response: []user.LoginEvent{},
+		Errors:   []int{http.StatusNotFound},
+	},
+	"DELETE /users/{id}/2fa": {
+		Summary: "Remove a user's authenticator and recovery codes and sign them out everywhere",
+		Status:  http.StatusNoContent,
+		Errors:  []int{http.StatusNotFound},
+	},
+	"GET /api-keys": {
+		Summary:  "List API keys that haven't been revoked",
+		Response: []user.APIKey{},
+	},
+	"POST /api-keys": {
+		Summary:  "Create an API key for a script or integration",
+		Request:  CreateAPIKeyRequest{},
+		Response: CreateAPIKeyResponse{},
+		Status:   http.StatusCreated,
+		Errors:   []int{http.StatusBadRequest, http.StatusUnprocessableEntity},
+	},
+	"DELETE /api-keys/{id}": {
+		Summary: "Revoke an API key",
+		Status:  http.StatusNoContent,
+		Errors:  []int{http.StatusNotFound},
+	},
+	"POST /invites": {
+		Summary:  "Create a user and email them a link to choose a password",
+		Request:  InviteUserRequest{},
+		Response: user.User{},
+		Status:   http.StatusCreated,
+		Errors:   []int{http.StatusBadRequest, http.StatusConflict, http.StatusUnprocessableEntity},
+	},
+
+	"GET /spaces": {
+		Summary:  "List spaces",
+		Response: []space.Space{},
+	},
+	"GET /spaces/vacant": {
+		Summary:  "List vacant spaces",
+		Response: []space.Space{},
+	},
+	"GET /spaces/{id}": {
+		Summary:   "Get a space",
+		Response:  space.Space{},
+		Errors:    []int{http.StatusNotFound},
+		Versioned: true,
+	},
+	"PUT /spaces/{id}": {
+		Summary:   "Update a space",
+		Request:   space.Space{},
+		Response:  space.Space{},
+		Errors:    []int{http.StatusBadRequest, http.StatusNotFound, http.StatusUnprocessableEntity},
+		Versioned: true,
+	},
+	"POST /spaces/{id}/reserve": {
+		Summary: "Reserve a vacant space",
+		Errors:  []int{http.StatusNotFound, http.StatusConflict},
+	},
+	"POST /spaces/{id}/unreserve": {
+		Summary: "Release a reserved space",
+		Errors:  []int{http.StatusNotFound, http.StatusConflict},
+	},
+	"POST /spaces/{id}/move-in": {
+		Summary:  "Move a tenant into a space",
+		Request:  MoveInRequest{},
+		Response: space.Space{},
+		Errors:   []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity},
+	},
+	"POST /spaces/{id}/move-out": {
+		Summary: "Move the tenant out of a space",
+		Errors:  []int{http.StatusNotFound, http.StatusConflict},
+	},
+
+	"GET /tenants": {
+		Summary: "List tenants",
+		Query: []queryParam{
+			{Name: "search", Description: "Case-insensitive match anywhere in the name"},
+			{Name: "spaceId"},
+			{Name: "section"},
+		},
+		Response: []tenant.Tenant{},
+		Errors:   []int{http.StatusUnprocessableEntity},
+		Paged:    true,
+	},
+	"POST /tenants": {
+		Summary:  "Create a tenant",
+		Request:  CreateTenantRequest{},
+		Response: tenant.Tenant{},
+		Status:   http.StatusCreated,
+		Errors:   []int{http.StatusBadRequest, http.StatusConflict, http.StatusUnprocessableEntity},
+	},
+	"GET /tenants/{id}": {
+		Summary:   "Get a tenant",
+		Response:  tenant.Tenant{},
+		Errors:    []int{http.StatusNotFound},
+		Versioned: true,
+	},
+	"PUT /tenants/{id}": {
+		Summary:   "Update a tenant",
+		Request:   tenant.Tenant{},
+		Response:  tenant.Tenant{},
+		Errors:    []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity},
+		Versioned: true,
+	},
+	"DELETE /tenants/{id}": {
+		Summary: "Delete a tenant",
+		Status:  http.StatusNoContent,
+		Errors:  []int{http.StatusNotFound},
+	},
+	"GET /tenants/{id}/notifications": {
+		Summary:  "List notifications sent to a tenant",
+		Response: []notify.Notification{},
+	},
+
+	"GET /payments": {
+		Summary: "List payments",
+		Query: []queryParam{
+			{Name: "tenant", Description: "Tenant ID; start and end may be omitted when set"},
+			{Name: "start", Format: "date-time", Description: "Required unless tenant is set"},
+			{Name: "end", Format: "date-time", Description: "Required unless tenant is set"},
+			{Name: "dateField", Description: "dueDate (default) or paidDate"},
+			{Name: "paid", Type: "boolean"},
+			{Name: "minAmount", Type: "number"},
+			{Name: "maxAmount", Type: "number"},
+		},
+		Response: []payment.Payment{},
+		Errors:   []int{http.StatusBadRequest, http.StatusUnprocessableEntity},
+		Paged:    true,
+	},
+	"POST /payments": {
+		Summary:  "Record a payment",
+		Request:  CreatePaymentRequest{},
+		Response: payment.Payment{},
+		Status:   http.StatusCreated,
+		Errors:   []int{http.StatusBadRequest, http.StatusUnprocessableEntity},
+	},
+	"GET /payments/{id}": {
+		Summary:   "Get a payment",
+		Response:  payment.Payment{},
+		Errors:    []int{http.StatusNotFound},
+		Versioned: true,
+	},
+	"PUT /payments/{id}": {
+		Summary:   "Update a payment",
+		Request:   payment.Payment{},
+		Response:  payment.Payment{},
+		Errors:    []int{http.StatusBadRequest, http.StatusNotFound, http.StatusUnprocessableEntity},
+		Versioned: true,
+	},
+	"DELETE /payments/{id}": {
+		Summary: "Delete a payment",
+		Status:  http.StatusNoContent,
+		Errors:  []int{http.StatusNotFound},
+	},
+
+	"GET /jobs": {
+		Summary:  "List background jobs with their next and last runs",
+		Response: []job.JobStatus{},
+	},
+	"POST /jobs/{name}/run": {
+		Summary:  "Run a job now",
+		Response: job.Run{},
+		Errors:   []int{http.StatusNotFound, http.StatusConflict},
+	},
+	"GET /jobs/{name}/runs": {
+		Summary:  "List recent runs of a job",
+		Query:    []queryParam{{Name: "limit", Type: "integer"}},
+		Response: []job.Run{},
+		Errors:   []int{http.StatusNotFound},
+	},
+}
+
+// openAPISchema is the subset of the OpenAPI schema object the API needs
+type openAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Enum                 []string                  `json:"enum,omitempty"`
+	Nullable             bool                      `json:"nullable,omitempty"`
+	Items                *openAPISchema            `json:"items,omitempty"`
+	Properties           map[string]*openAPISchema `json:"properties,omitempty"`
+	Required             []string                  `json:"required,omitempty"`
+	AdditionalProperties *openAPISchema            `json:"additionalProperties,omitempty"`
+}
+
+type openAPIParameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required,omitempty"`
+	Schema      *openAPISchema `json:"schema"`
+}
+
+type openAPIMedia struct {
+	Schema *openAPISchema `json:"schema"`
+}
+
+type openAPIBody struct {
+	Required bool                    `json:"required"`
+	Content  map[string]openAPIMedia `json:"content"`
+}
+
+type openAPIHeader struct {
+	Description string         `json:"description,omitempty"`
+	Schema      *openAPISchema `json:"schema"`
+}
+
+type openAPIResponse struct {
+	Description string                   `json:"description"`
+	Headers     map[string]openAPIHeader `json:"headers,omitempty"`
+	Content     map[string]openAPIMedia  `json:"content,omitempty"`
+}
+
+type openAPIOperation struct {
+	Summary     string                     `json:"summary"`
+	Description string                     `json:"description,omitempty"`
+	Tags        []string                   `json:"tags"`
+	Parameters  []openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIBody               `json:"requestBody,omitempty"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+	Security    []map[string][]string      `json:"security"`
+}
+
+type openAPIDocument struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       map[string]string                       `json:"info"`
+	Servers    []map[string]string                     `json:"servers"`
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components openAPIComponents                       `json:"components"`
+}
+
+type openAPIComponents struct {
+	Schemas         map[string]*openAPISchema    `json:"schemas"`
+	SecuritySchemes map[string]map[string]string `json:"securitySchemes"`
+}
+
+// enums lists the allowed values of string types that have a fixed set
+var enums = map[reflect.Type][]string{
+	reflect.TypeOf(user.Role("")):  {string(user.RoleAdmin), string(user.RoleStaff)},
+	reflect.TypeOf(user.Scope("")): scopeNames(),
+}
+
+func scopeNames() []string {
+	names := make([]string, len(user.Scopes))
+	for i, scope := range user.Scopes {
+		names[i] = string(scope)
+	}
+	return names
+}
+
+// buildOpenAPI builds the document for the routes the server registered.
+// It fails if a route has no entry in operations, so nothing goes undocumented.
+func (s *Server) buildOpenAPI() (*openAPIDocument, error) {
+	doc := &openAPIDocument{
+		OpenAPI: "3.0.3",
+		Info:    map[string]string{"title": "RV Park API", "version": "1.0.0"},
+		Servers: []map[string]string{{"url": APIPrefix}},
+		Paths:   map[string]map[string]*openAPIOperation{},
+		Components: openAPIComponents{
+			Schemas: map[string]*openAPISchema{},
+			SecuritySchemes: map[string]map[string]string{
+				"bearerAuth": {"type": "http", "scheme": "bearer"},
+				"apiKeyAuth": {"type": "apiKey", "in": "header", "name": middleware.APIKeyHeader},
+			},
+		},
+	}
+	// Sessions are in a bearer token, or a cookie where the server allows them
+	sessionAuth := []map[string][]string{{"bearerAuth": {}}}
+	if s.authMiddleware.CookiesEnabled() {
+		doc.Components.SecuritySchemes["cookieAuth"] = map[string]string{"type": "apiKey", "in": "cookie", "name": middleware.SessionCookieName}
+		sessionAuth = append(sessionAuth, map[string][]string{"cookieAuth": {}})
+	}
+	schemas := schemaRegistry(doc.Components.Schemas)
+	errorSchema := schemas.schemaFor(reflect.TypeOf(ErrorResponse{}))
+
+	for _, rt := range s.routeTable {
+		spec, ok := operations[rt.Method+" "+rt.Path]
+		if !ok {
+			return nil, fmt.Errorf("no OpenAPI entry for %s %s", rt.Method, rt.Path)
+		}
+
+		op := &openAPIOperation{
+			Summary:   spec.Summary,
+			Tags:      []string{strings.SplitN(strings.TrimPrefix(rt.Path, "/"), "/", 2)[0]},
+			Responses: map[string]openAPIResponse{},
+			Security:  []map[string][]string{},
+		}
+
+		for _, name := range pathParams(rt.Path) {
+			op.Parameters = append(op.Parameters, openAPIParameter{
+				Name: name, In: "path", Required: true, Schema: &openAPISchema{Type: "string"},
+			})
+		}
+		for _, q := range spec.Query {
+			op.Parameters = append(op.Parameters, q.parameter())
+		}
+		if spec.Paged {
+			op.Parameters = append(op.Parameters, pagingParams...)
+		}
+		if spec.Versioned && rt.Method == http.MethodPut {
+			op.Parameters = append(op.Parameters, ifMatchParam)
+		}
+		if rt.Idempotent {
+			op.Parameters = append(op.Parameters, idempotencyKeyParam)
+		}
+
+		if spec.Request != nil {
+			op.RequestBody = &openAPIBody{
+				Required: true,
+				Content:  jsonContent(schemas.schemaFor(reflect.TypeOf(spec.Request))),
+			}
+		}
+
+		status := spec.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := openAPIResponse{Description: http.StatusText(status)}
+		if spec.Response != nil {
+			success.Content = jsonContent(schemas.schemaFor(reflect.TypeOf(spec.Response)))
+		}
+		if spec.Paged {
+			success.Headers = pagingHeaders
+		}
+		if spec.Versioned {
+			success.Headers = etagHeaders
+		}
+		op.Responses[fmt.Sprint(status)] = success
+
+		errors := append([]int{http.StatusInternalServerError}, spec.Errors...)
+		if spec.Versioned && rt.Method == http.MethodPut {
+			errors = append(errors, http.StatusPreconditionFailed, http.StatusPreconditionRequired)
+		}
+		switch rt.Access {
+		case accessAuth:
+			// 403 is for API keys without the scope, or on a route keys can't
+			// use, and cookie sessions without the CSRF token
+			errors = append(errors, http.StatusUnauthorized, http.StatusForbidden)
+			op.Security = append([]map[string][]string{}, sessionAuth...)
+		case accessAdmin:
+			errors = append(errors, http.StatusUnauthorized, http.StatusForbidden)
+			op.Security = append([]map[string][]string{}, sessionAuth...)
+			op.Description = "Requires the ADMIN role."
+		}
+		if rt.Scope != "" {
+			op.Security = append(op.Security, map[string][]string{"apiKeyAuth": {}})
+			op.Description = strings.TrimSpace(op.Description + " API keys need the " + string(rt.Scope) + " scope.")
+		}
+		for _, code := range errors {
+			op.Responses[fmt.Sprint(code)] = openAPIResponse{
+				Description: http.StatusText(code),
+				Content:     jsonContent(errorSchema),
+			}
+		}
+
+		if doc.Paths[rt.Path] == nil {
+			doc.Paths[rt.Path] = map[string]*openAPIOperation{}
+		}
+		doc.Paths[rt.Path][strings.ToLower(rt.Method)] = op
+	}
+	return doc, nil
+}
+
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	doc, err := s.buildOpenAPI()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(doc)
+}
+
+var pagingParams = []openAPIParameter{
+	{Name: "limit", In: "query", Description: "Page size, at most 500", Schema: &openAPISchema{Type: "integer"}},
+	{Name: "cursor", In: "query", Description: "X-Next-Cursor from the previous page", Schema: &openAPISchema{Type: "string"}},
+	{Name: "sort", In: "query", Description: `Field to sort by, prefixed with "-" for descending`, Schema: &openAPISchema{Type: "string"}},
+}
+
+var ifMatchParam = openAPIParameter{
+	Name:        "If-Match",
+	In:          "header",
+	Description: "ETag from the last GET; the update fails with 412 if the resource has changed since. \"*\" updates whatever version is current",
+	Required:    true,
+	Schema:      &openAPISchema{Type: "string"},
+}
+
+var idempotencyKeyParam = openAPIParameter{
+	Name:        "Idempotency-Key",
+	In:          "header",
+	Description: "Unique key for this request; up to 255 characters. Retrying with the same key and body replays the first response",
+	Schema:      &openAPISchema{Type: "string"},
+}
+
+var etagHeaders = map[string]openAPIHeader{
+	"ETag": {Description: "Current version, to send back in If-Match", Schema: &openAPISchema{Type: "string"}},
+}
+
+var pagingHeaders = map[string]openAPIHeader{
+	"X-Total-Count": {Description: "Items matching the filter across all pages", Schema: &openAPISchema{Type: "integer"}},
+	"X-Next-Cursor": {Description: "Cursor for the next page, absent on the last page", Schema: &openAPISchema{Type: "string"}},
+	"Link":          {Description: `URL of the next page with rel="next"`, Schema: &openAPISchema{Type: "string"}},
+}
+
+func (q queryParam) parameter() openAPIParameter {
+	schema := &openAPISchema{Type: q.Type, Format: q.Format}
+	if schema.Type == "" {
+		schema.Type = "string"
+	}
+	return openAPIParameter{Name: q.Name, In: "query", Description: q.Description, Schema: schema}
+}
+
+// pathParams returns the wildcard names in a ServeMux pattern path
+func pathParams(path string) []string {
+	var names []string
+	for _, segment := range strings.Split(path, "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			names = append(names, strings.TrimSuffix(strings.TrimPrefix(segment, "{"), "}"))
+		}
+	}
+	return names
+}
+
+func jsonContent(schema *openAPISchema) map[string]openAPIMedia {
+	return map[string]openAPIMedia{"application/json": {Schema: schema}}
+}
+
+// schemaRegistry holds the named schemas under components, keyed by Go type name
+type schemaRegistry map[string]*openAPISchema
+
+var (
+	timeType  = reflect.TypeOf(time.Time{})
+	moneyType = reflect.TypeOf(payment.Money(0))
+)
+
+// schemaFor returns the schema for t, registering named structs as components
+// and referring to them by $ref
+func (reg schemaRegistry) schemaFor(t reflect.Type) *openAPISchema {
+	if values, ok := enums[t]; ok {
+		return &openAPISchema{Type: "string", Enum: values}
+	}
+	if t == moneyType {
+		// Money counts cents but is written as dollars with two decimals
+		return &openAPISchema{Type: "number", Format: "decimal"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := reg.schemaFor(t.Elem())
+		if schema.Ref == "" {
+			schema.Nullable = true
+		}
+		return schema
+	case reflect.Struct:
+		if t == timeType {
+			return &openAPISchema{Type: "string", Format: "date-time"}
+		}
+		name := t.Name()
+		if _, ok := reg[name]; !ok {
+			reg[name] = &openAPISchema{} // placeholder in case the type refers to itself
+			reg[name] = reg.structSchema(t)
+		}
+		return &openAPISchema{Ref: "#/components/schemas/" + name}
+	case reflect.Slice, reflect.Array:
+		return &openAPISchema{Type: "array", Items: reg.schemaFor(t.Elem())}
+	case reflect.Map:
+		return &openAPISchema{Type: "object", AdditionalProperties: reg.schemaFor(t.Elem())}
+	case reflect.String:
+		return &openAPISchema{Type: "string"}
+	case reflect.Bool:
+		return &openAPISchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &openAPISchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &openAPISchema{Type: "number", Format: "double"}
+	default:
+		return &openAPISchema{}
+	}
+}
+
+// structSchema follows encoding/json's rules: fields tagged "-" are skipped,
+// embedded structs are flattened and omitempty fields are optional
+func (reg schemaRegistry) structSchema(t reflect.Type) *openAPISchema {
+	schema := &openAPISchema{Type: "object", Properties: map[string]*openAPISchema{}}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			embedded := reg.structSchema(field.Type)
+			for prop, propSchema := range embedded.Properties {
+				schema.Properties[prop] = propSchema
+			}
+			schema.Required = append(schema.Required, embedded.Required...)
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+		schema.Properties[name] = reg.schemaFor(field.Type)
+		if !strings.Contains(options, "omitempty") && field.Type.Kind() != reflect.Pointer {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	sort.Strings(schema.Required)
+	return schema
+}
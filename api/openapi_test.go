@@ -0,0 +1,88 @@
+// api/openapi_test.go
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/BodaciousX/RVParkBackend/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newSpecTestServer() *Server {
+	return NewServer(nil, nil, nil, nil, nil, nil, nil, middleware.NewAuthMiddleware(nil, middleware.DefaultCookieConfig(), nil))
+}
+
+// Every route must be documented, and every documented route must exist
+func TestOpenAPICoversRoutes(t *testing.T) {
+	s := newSpecTestServer()
+
+	registered := map[string]bool{}
+	for _, rt := range s.routeTable {
+		key := rt.Method + " " + rt.Path
+		registered[key] = true
+		_, ok := operations[key]
+		assert.True(t, ok, "route %s has no entry in operations", key)
+	}
+	for key := range operations {
+		assert.True(t, registered[key], "operations documents %s, which is not routed", key)
+	}
+
+	_, err := s.buildOpenAPI()
+	assert.NoError(t, err)
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	s := newSpecTestServer()
+
+	req := httptest.NewRequest(http.MethodGet, "/openapi.json", nil)
+	rr := httptest.NewRecorder()
+	s.Mux.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var doc openAPIDocument
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &doc))
+	assert.Equal(t, "/v1", doc.Servers[0]["url"])
+
+	// Request shapes come from the Go types
+	moveIn := doc.Paths["/spaces/{id}/move-in"]["post"]
+	require.NotNil(t, moveIn)
+	assert.Equal(t, "#/components/schemas/MoveInRequest", moveIn.RequestBody.Content["application/json"].Schema.Ref)
+	assert.Equal(t, "id", moveIn.Parameters[0].Name)
+	assert.Equal(t, "Idempotency-Key", moveIn.Parameters[1].Name)
+	assert.Contains(t, moveIn.Responses, "409")
+	// Routes returning one-time secrets don't offer replays
+	assert.Empty(t, doc.Paths["/api-keys"]["post"].Parameters)
+
+	createPayment := doc.Components.Schemas["CreatePaymentRequest"]
+	require.NotNil(t, createPayment)
+	assert.Equal(t, "number", createPayment.Properties["amountDue"].Type)
+	assert.Equal(t, "date-time", createPayment.Properties["dueDate"].Format)
+	createTenant := doc.Components.Schemas["CreateTenantRequest"]
+	require.NotNil(t, createTenant)
+	assert.Equal(t, "string", createTenant.Properties["phone"].Type)
+	assert.Equal(t, "boolean", createTenant.Properties["smsOptOut"].Type)
+
+	// Hidden fields stay hidden and embedded structs are flattened
+	assert.NotContains(t, doc.Components.Schemas["User"].Properties, "PasswordHash")
+	assert.Equal(t, []string{"ADMIN", "STAFF"}, doc.Components.Schemas["User"].Properties["role"].Enum)
+	assert.Contains(t, doc.Components.Schemas["JobStatus"].Properties, "name")
+
+	// Auth requirements are reflected in security and error responses
+	login := doc.Paths["/login"]["post"]
+	assert.Empty(t, login.Security)
+	assert.NotContains(t, login.Responses, "403")
+	listUsers := doc.Paths["/users"]["get"]
+	assert.NotEmpty(t, listUsers.Security)
+	assert.Contains(t, listUsers.Responses, "403")
+	assert.Contains(t, listUsers.Responses["200"].Headers, "X-Total-Count")
+
+	// Only routes opened to API keys accept them, and say which scope they need
+	assert.Len(t, listUsers.Security, 1)
+	postPayment := doc.Paths["/payments"]["post"]
+	assert.Contains(t, postPayment.Security, map[string][]string{"apiKeyAuth": {}})
+	assert.Contains(t, postPayment.Description, "payments:write")
+}
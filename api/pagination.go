@@ -0,0 +1,79 @@
+// api/pagination.go contains helpers shared by the paginated list endpoints.
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/BodaciousX/RVParkBackend/apperr"
+	"github.com/BodaciousX/RVParkBackend/paging"
+	"github.com/BodaciousX/RVParkBackend/payment"
+)
+
+// pageRequest reads the limit, cursor and sort query parameters
+func pageRequest(r *http.Request) (paging.Request, error) {
+	query := r.URL.Query()
+	page := paging.Request{
+		Cursor: query.Get("cursor"),
+		Sort:   query.Get("sort"),
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return page, apperr.Invalid("limit", "limit must be a positive integer")
+		}
+		page.Limit = limit
+	}
+	return page, nil
+}
+
+// writePage writes the items of a page as a JSON array. The total and the
+// cursor for the next page go in X-Total-Count, X-Next-Cursor and a Link
+// header so the body stays compatible with unpaginated clients.
+func writePage[T any](w http.ResponseWriter, r *http.Request, page *paging.Page[T]) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+	if page.NextCursor != "" {
+		next := *r.URL
+		query := next.Query()
+		query.Set("cursor", page.NextCursor)
+		next.RawQuery = query.Encode()
+
+		w.Header().Set("X-Next-Cursor", page.NextCursor)
+		w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="next"`, next.RequestURI()))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(page.Items); err != nil {
+		log.Printf("failed to encode response: %v", err)
+	}
+}
+
+// optionalMoney parses an amount query parameter, returning nil when it is absent
+func optionalMoney(r *http.Request, name string) (*payment.Money, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	parsed, err := payment.ParseMoney(value)
+	if err != nil {
+		return nil, apperr.Invalid(name, "%s must be a number", name)
+	}
+	return &parsed, nil
+}
+
+// optionalBool parses a true/false query parameter, returning nil when it is absent
+func optionalBool(r *http.Request, name string) (*bool, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, apperr.Invalid(name, "%s must be true or false", name)
+	}
+	return &parsed, nil
+}
@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"strings"
 	"time"
 
 	"github.com/BodaciousX/RVParkBackend/payment"
@@ -14,85 +13,83 @@ import (
 )
 
 type CreatePaymentRequest struct {
-	TenantID        string    `json:"tenantId"`
-	AmountDue       float64   `json:"amountDue"`
-	DueDate         time.Time `json:"dueDate"`
-	NextPaymentDate time.Time `json:"nextPaymentDate"`
-	PaidDate        time.Time `json:"paidDate"`
+	TenantID        string        `json:"tenantId"`
+	AmountDue       payment.Money `json:"amountDue"`
+	DueDate         time.Time     `json:"dueDate"`
+	NextPaymentDate time.Time     `json:"nextPaymentDate"`
+	PaidDate        *time.Time    `json:"paidDate,omitempty"` // Omitted for payments not yet made
 }
 
+// handlePaymentList lists payments whose due date (or paid date, with
+// dateField=paidDate) falls between start and end. A tenant's payments can be
+// listed without a range, or with only one end of it, to get their full history.
 func (s *Server) handlePaymentList(w http.ResponseWriter, r *http.Request) {
 	// Extract start and end dates from query parameters
 	startStr := r.URL.Query().Get("start")
 	endStr := r.URL.Query().Get("end")
 	tenantID := r.URL.Query().Get("tenant")
 
-	if startStr == "" || endStr == "" {
-		http.Error(w, "start and end dates are required", http.StatusBadRequest)
+	if tenantID == "" && (startStr == "" || endStr == "") {
+		writeErrorMessage(w, http.StatusBadRequest, "start and end dates are required")
 		return
 	}
 
-	start, err := time.Parse(time.RFC3339, startStr)
-	if err != nil {
-		http.Error(w, "invalid start date format", http.StatusBadRequest)
-		return
+	filter := payment.Filter{
+		TenantID:  tenantID,
+		DateField: payment.DateField(r.URL.Query().Get("dateField")),
 	}
 
-	end, err := time.Parse(time.RFC3339, endStr)
-	if err != nil {
-		http.Error(w, "invalid end date format", http.StatusBadRequest)
-		return
+	var err error
+	if startStr != "" {
+		if filter.From, err = time.Parse(time.RFC3339, startStr); err != nil {
+			writeErrorMessage(w, http.StatusBadRequest, "invalid start date format")
+			return
+		}
 	}
-
-	var payments []payment.Payment
-	if tenantID != "" {
-		// If tenant ID is provided, get payments for specific tenant
-		payments, err = s.paymentService.GetTenantPayments(tenantID)
-	} else {
-		// Otherwise get all payments in date range
-		payments, err = s.paymentService.GetPaymentsByDateRange(start, end)
+	if endStr != "" {
+		if filter.To, err = time.Parse(time.RFC3339, endStr); err != nil {
+			writeErrorMessage(w, http.StatusBadRequest, "invalid end date format")
+			return
+		}
 	}
 
-	if err != nil {
-		http.Error(w, fmt.Sprintf("failed to fetch payments: %v", err), http.StatusInternalServerError)
+	if filter.Paid, err = optionalBool(r, "paid"); err != nil {
+		writeError(w, err)
 		return
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(payments); err != nil {
-		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+	if filter.MinAmount, err = optionalMoney(r, "minAmount"); err != nil {
+		writeError(w, err)
 		return
 	}
-}
-
-func (s *Server) handleCreatePayment(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	if filter.MaxAmount, err = optionalMoney(r, "maxAmount"); err != nil {
+		writeError(w, err)
 		return
 	}
 
-	var req CreatePaymentRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+	page, err := pageRequest(r)
+	if err != nil {
+		writeError(w, err)
 		return
 	}
 
-	// Validate required fields
-	if req.TenantID == "" {
-		http.Error(w, "tenant ID is required", http.StatusBadRequest)
-		return
-	}
-	if req.AmountDue <= 0 {
-		http.Error(w, "amount due must be greater than 0", http.StatusBadRequest)
+	payments, err := s.paymentService.FindPayments(r.Context(), filter, page)
+	if err != nil {
+		writeError(w, err)
 		return
 	}
-	if req.DueDate.IsZero() {
-		http.Error(w, "due date is required", http.StatusBadRequest)
+
+	writePage(w, r, payments)
+}
+
+func (s *Server) handleCreatePayment(w http.ResponseWriter, r *http.Request) {
+	var req CreatePaymentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorMessage(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
 		return
 	}
-	if req.NextPaymentDate.IsZero() {
-		http.Error(w, "next payment date is required", http.StatusBadRequest)
-		return
+	// Older clients send the zero time for a payment not yet made
+	if req.PaidDate != nil && req.PaidDate.IsZero() {
+		req.PaidDate = nil
 	}
 
 	newPayment := payment.Payment{
@@ -100,77 +97,84 @@ func (s *Server) handleCreatePayment(w http.ResponseWriter, r *http.Request) {
 		TenantID:        req.TenantID,
 		AmountDue:       req.AmountDue,
 		DueDate:         req.DueDate,
-		PaidDate:        &req.PaidDate,
+		PaidDate:        req.PaidDate,
 		NextPaymentDate: req.NextPaymentDate,
-		CreatedAt:       time.Now(),
-		UpdatedAt:       time.Now(),
 	}
 
-	if err := s.paymentService.CreatePayment(newPayment); err != nil {
-		http.Error(w, fmt.Sprintf("failed to create payment: %v", err), http.StatusInternalServerError)
+	if err := s.paymentService.CreatePayment(r.Context(), newPayment); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Return the payment as stored, with the timestamps the service set
+	created, err := s.paymentService.GetPayment(r.Context(), newPayment.ID)
+	if err != nil {
+		writeError(w, err)
 		return
 	}
 
+	setETag(w, created.Version)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	if err := json.NewEncoder(w).Encode(newPayment); err != nil {
+	if err := json.NewEncoder(w).Encode(created); err != nil {
 		// Log the error but don't return it to the client since we already sent the status code
 		log.Printf("failed to encode response: %v", err)
 	}
 }
 
 func (s *Server) handleGetPayment(w http.ResponseWriter, r *http.Request) {
-	id := strings.TrimPrefix(r.URL.Path, "/payments/")
+	id := r.PathValue("id")
 
-	payment, err := s.paymentService.GetPayment(id)
+	payment, err := s.paymentService.GetPayment(r.Context(), id)
 	if err != nil {
-		http.Error(w, "payment not found", http.StatusNotFound)
+		writeError(w, err)
 		return
 	}
 
+	setETag(w, payment.Version)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(payment)
 }
 
 func (s *Server) handleUpdatePayment(w http.ResponseWriter, r *http.Request) {
-	id := strings.TrimPrefix(r.URL.Path, "/payments/")
+	id := r.PathValue("id")
+	version, ok := ifMatchVersion(w, r)
+	if !ok {
+		return
+	}
 
 	var updatePayment payment.Payment
 	if err := json.NewDecoder(r.Body).Decode(&updatePayment); err != nil {
-		http.Error(w, "invalid request body", http.StatusBadRequest)
+		writeErrorMessage(w, http.StatusBadRequest, "invalid request body")
 		return
 	}
 
 	updatePayment.ID = id
-	if err := s.paymentService.UpdatePayment(updatePayment); err != nil {
-		http.Error(w, "failed to update payment", http.StatusInternalServerError)
+	updatePayment.Version = version
+	if err := s.paymentService.UpdatePayment(r.Context(), updatePayment); err != nil {
+		writeError(w, err)
 		return
 	}
 
+	// Return the payment as stored, with the fields the service keeps
+	updatedPayment, err := s.paymentService.GetPayment(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	setETag(w, updatedPayment.Version)
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(updatePayment)
+	json.NewEncoder(w).Encode(updatedPayment)
 }
 
 func (s *Server) handleDeletePayment(w http.ResponseWriter, r *http.Request) {
-	id := strings.TrimPrefix(r.URL.Path, "/payments/")
+	id := r.PathValue("id")
 
-	if err := s.paymentService.DeletePayment(id); err != nil {
-		http.Error(w, "failed to delete payment", http.StatusInternalServerError)
+	if err := s.paymentService.DeletePayment(r.Context(), id); err != nil {
+		writeError(w, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
-
-func (s *Server) handlePaymentOperations(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodGet:
-		s.handleGetPayment(w, r)
-	case http.MethodPut:
-		s.handleUpdatePayment(w, r)
-	case http.MethodDelete:
-		s.handleDeletePayment(w, r)
-	default:
-		http.NotFound(w, r)
-	}
-}
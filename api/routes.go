@@ -0,0 +1,246 @@
+// api/routes.go contains the route table and the helpers that apply
+// middleware to groups of routes.
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/BodaciousX/RVParkBackend/middleware"
+	"github.com/BodaciousX/RVParkBackend/user"
+)
+
+// APIPrefix is the path every current route is served under
+const APIPrefix = "/v1"
+
+// Middleware wraps a handler, e.g. to authenticate the request first
+type Middleware func(http.Handler) http.Handler
+
+// access is the authentication a route requires
+type access int
+
+const (
+	accessPublic access = iota
+	accessAuth
+	accessAdmin
+)
+
+// route is an entry in the route table, recorded so the OpenAPI document can
+// be checked against what is actually served
+type route struct {
+	Method string
+	Path   string // without APIPrefix
+	Access access
+	Scope  user.Scope // what an API key needs to call the route, "" if keys can't
+	// Idempotent routes replay their response to retries with the same
+	// Idempotency-Key
+	Idempotent bool
+}
+
+// routeGroup registers routes that share a middleware chain. Each route is
+// served at APIPrefix+path and, so existing clients keep working, at the
+// unversioned path as a deprecated alias.
+type routeGroup struct {
+	mux        *http.ServeMux
+	routes     *[]route
+	access     access
+	middleware []Middleware
+	// keys is the resource API keys may use the group's routes for, with its
+	// read scope for GET and write scope otherwise. Without it keys are
+	// turned away.
+	keys string
+	// replay stores POST responses so retries with the same Idempotency-Key
+	// get them back. Routes whose responses hold secrets shown only once
+	// leave it unset, since the stored copy would outlive the secret's hash.
+	replay Middleware
+}
+
+// with returns a group requiring level that runs mw after the group's own middleware
+func (g routeGroup) with(level access, mw ...Middleware) routeGroup {
+	chain := make([]Middleware, 0, len(g.middleware)+len(mw))
+	chain = append(chain, g.middleware...)
+	chain = append(chain, mw...)
+	return routeGroup{mux: g.mux, routes: g.routes, access: level, middleware: chain, replay: g.replay}
+}
+
+// withReplay returns a copy of the group whose POST routes are replayed by mw
+func (g routeGroup) withReplay(mw Middleware) routeGroup {
+	g.replay = mw
+	return g
+}
+
+// withoutReplay returns a copy of the group whose responses are never stored
+func (g routeGroup) withoutReplay() routeGroup {
+	g.replay = nil
+	return g
+}
+
+// withKeys returns a copy of the group that accepts API keys scoped to resource
+func (g routeGroup) withKeys(resource string) routeGroup {
+	g.keys = resource
+	return g
+}
+
+// scope is what an API key needs to call method on the group's routes
+func (g routeGroup) scope(method string) user.Scope {
+	switch {
+	case g.keys == "":
+		return ""
+	case method == http.MethodGet:
+		return user.Scope(g.keys + ":read")
+	default:
+		return user.Scope(g.keys + ":write")
+	}
+}
+
+// handle registers handler for method and path. Path may use ServeMux
+// wildcards such as {id}.
+func (g routeGroup) handle(method, path string, handler http.HandlerFunc) {
+	var h http.Handler = handler
+	idempotent := method == http.MethodPost && g.replay != nil
+	if idempotent {
+		h = g.replay(h)
+	}
+	scope := g.scope(method)
+	switch {
+	case g.access == accessPublic:
+	case scope != "":
+		h = middleware.RequireScope(scope)(h)
+	default:
+		h = middleware.Interactive(h)
+	}
+	for i := len(g.middleware) - 1; i >= 0; i-- {
+		h = g.middleware[i](h)
+	}
+
+	g.mux.Handle(method+" "+APIPrefix+path, h)
+	g.mux.Handle(method+" "+path, deprecated(h))
+	*g.routes = append(*g.routes, route{Method: method, Path: path, Access: g.access, Scope: scope, Idempotent: idempotent})
+}
+
+// deprecated marks responses from an unversioned alias and points clients at
+// the /v1 route that replaces it
+func deprecated(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Add("Link", fmt.Sprintf(`<%s%s>; rel="successor-version"`, APIPrefix, r.URL.Path))
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) routes() {
+	public := routeGroup{mux: s.Mux, routes: &s.routeTable, middleware: []Middleware{middleware.CORS}}
+	authed := public.with(accessAuth, s.authMiddleware.RequireAuth).withReplay(s.idempotent)
+	admin := authed.with(accessAdmin, s.authMiddleware.RequireAdmin)
+
+	// These return secrets shown once, such as API keys and recovery codes
+	authedSecrets := authed.withoutReplay()
+	adminSecrets := admin.withoutReplay()
+
+	// API keys can only use the routes registered with these groups
+	spaces := authed.withKeys("spaces")
+	tenants := authed.withKeys("tenants")
+	payments := authed.withKeys("payments")
+	jobs := admin.withKeys("jobs")
+
+	// Session routes
+	public.handle(http.MethodPost, "/login", s.handleLogin)
+	public.handle(http.MethodPost, "/login/2fa", s.handleVerifyLoginChallenge)
+	public.handle(http.MethodPost, "/login/2fa/enroll", s.handleEnrollForChallenge)
+	public.handle(http.MethodPost, "/login/oidc", s.handleStartOIDCLogin)
+	public.handle(http.MethodPost, "/login/oidc/callback", s.handleOIDCCallback)
+	authed.handle(http.MethodGet, "/validate-token", s.handleValidateToken)
+	authed.handle(http.MethodPost, "/logout", s.handleLogout)
+	authed.handle(http.MethodGet, "/me/sessions", s.handleListMySessions)
+	authed.handle(http.MethodDelete, "/me/sessions", s.handleRevokeMySessions)
+	authed.handle(http.MethodDelete, "/me/sessions/{id}", s.handleRevokeMySession)
+	authed.handle(http.MethodPost, "/me/password", s.handleChangeMyPassword)
+	authed.handle(http.MethodGet, "/me/2fa", s.handleGetMyTwoFactor)
+	authedSecrets.handle(http.MethodPost, "/me/2fa/enroll", s.handleEnrollMyTwoFactor)
+	authedSecrets.handle(http.MethodPost, "/me/2fa/confirm", s.handleConfirmMyTwoFactor)
+	authed.handle(http.MethodPost, "/me/2fa/disable", s.handleDisableMyTwoFactor)
+	authedSecrets.handle(http.MethodPost, "/me/2fa/recovery-codes", s.handleRegenerateMyRecoveryCodes)
+
+	// Account recovery routes, used by people who can't sign in
+	public.handle(http.MethodPost, "/password-reset", s.handleRequestPasswordReset)
+	public.handle(http.MethodPost, "/password-reset/confirm", s.handleConfirmPasswordReset)
+	public.handle(http.MethodPost, "/invites/accept", s.handleAcceptInvite)
+
+	// User routes - Admin only
+	admin.handle(http.MethodGet, "/users", s.handleListUsers)
+	admin.handle(http.MethodPost, "/users", s.handleCreateUser)
+	admin.handle(http.MethodGet, "/users/{id}", s.handleGetUser)
+	admin.handle(http.MethodPut, "/users/{id}", s.handleUpdateUser)
+	admin.handle(http.MethodDelete, "/users/{id}", s.handleDeleteUser)
+	admin.handle(http.MethodGet, "/users/{id}/sessions", s.handleListUserSessions)
+	admin.handle(http.MethodDelete, "/users/{id}/sessions", s.handleRevokeUserSessions)
+	admin.handle(http.MethodPost, "/users/{id}/unlock", s.handleUnlockUser)
+	admin.handle(http.MethodPost, "/users/{id}/password", s.handleResetUserPassword)
+	admin.handle(http.MethodGet, "/users/{id}/login-events", s.handleListLoginEvents)
+	admin.handle(http.MethodDelete, "/users/{id}/2fa", s.handleResetUserTwoFactor)
+	admin.handle(http.MethodPost, "/invites", s.handleInviteUser)
+	admin.handle(http.MethodGet, "/api-keys", s.handleListAPIKeys)
+	adminSecrets.handle(http.MethodPost, "/api-keys", s.handleCreateAPIKey)
+	admin.handle(http.MethodDelete, "/api-keys/{id}", s.handleRevokeAPIKey)
+
+	// Space routes
+	spaces.handle(http.MethodGet, "/spaces", s.handleListSpaces)
+	spaces.handle(http.MethodGet, "/spaces/vacant", s.handleGetVacantSpaces)
+	spaces.handle(http.MethodGet, "/spaces/{id}", s.handleGetSpace)
+	spaces.handle(http.MethodPut, "/spaces/{id}", s.handleUpdateSpace)
+	spaces.handle(http.MethodPost, "/spaces/{id}/reserve", s.handleReserveSpace)
+	spaces.handle(http.MethodPost, "/spaces/{id}/unreserve", s.handleUnreserveSpace)
+	spaces.handle(http.MethodPost, "/spaces/{id}/move-in", s.handleMoveIn)
+	spaces.handle(http.MethodPost, "/spaces/{id}/move-out", s.handleMoveOut)
+
+	// Tenant routes
+	tenants.handle(http.MethodGet, "/tenants", s.handleListTenants)
+	tenants.handle(http.MethodPost, "/tenants", s.handleCreateTenant)
+	tenants.handle(http.MethodGet, "/tenants/{id}", s.handleGetTenant)
+	tenants.handle(http.MethodPut, "/tenants/{id}", s.handleUpdateTenant)
+	tenants.handle(http.MethodDelete, "/tenants/{id}", s.handleDeleteTenant)
+	tenants.handle(http.MethodGet, "/tenants/{id}/notifications", s.handleTenantNotifications)
+
+	// Payment routes
+	payments.handle(http.MethodGet, "/payments", s.handlePaymentList)
+	payments.handle(http.MethodPost, "/payments", s.handleCreatePayment)
+	payments.handle(http.MethodGet, "/payments/{id}", s.handleGetPayment)
+	payments.handle(http.MethodPut, "/payments/{id}", s.handleUpdatePayment)
+	payments.handle(http.MethodDelete, "/payments/{id}", s.handleDeletePayment)
+
+	// Background job routes - Admin only
+	jobs.handle(http.MethodGet, "/jobs", s.handleListJobs)
+	jobs.handle(http.MethodPost, "/jobs/{name}/run", s.handleTriggerJob)
+	jobs.handle(http.MethodGet, "/jobs/{name}/runs", s.handleListJobRuns)
+
+	// The API description itself is unversioned
+	s.Mux.Handle("GET /openapi.json", middleware.CORS(http.HandlerFunc(s.handleOpenAPI)))
+
+	// Anything else, including CORS preflight requests, which no route above
+	// matches by method, falls through to here
+	s.Mux.Handle("/", middleware.CORS(http.HandlerFunc(s.handleUnrouted)))
+}
+
+// routeMethods are the methods checked when building an Allow header
+var routeMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete}
+
+// handleUnrouted answers requests no route matched: 405 with an Allow header
+// when the path exists under other methods, otherwise 404
+func (s *Server) handleUnrouted(w http.ResponseWriter, r *http.Request) {
+	var allowed []string
+	for _, method := range routeMethods {
+		probe := r.Clone(r.Context())
+		probe.Method = method
+		if _, pattern := s.Mux.Handler(probe); pattern != "/" {
+			allowed = append(allowed, method)
+		}
+	}
+
+	if len(allowed) == 0 {
+		notFound(w)
+		return
+	}
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	methodNotAllowed(w)
+}
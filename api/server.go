@@ -5,7 +5,10 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/BodaciousX/RVParkBackend/idempotency"
+	"github.com/BodaciousX/RVParkBackend/job"
 	"github.com/BodaciousX/RVParkBackend/middleware"
+	"github.com/BodaciousX/RVParkBackend/notify"
 	"github.com/BodaciousX/RVParkBackend/payment"
 	"github.com/BodaciousX/RVParkBackend/space"
 	"github.com/BodaciousX/RVParkBackend/tenant"
@@ -18,7 +21,12 @@ type Server struct {
 	tenantService  tenant.Service
 	spaceService   space.Service
 	paymentService payment.Service
+	jobService     job.Service
+	notifyService  notify.Service
 	authMiddleware *middleware.AuthMiddleware
+	routeTable     []route
+
+	idempotencyService idempotency.Service
 }
 
 func NewServer(
@@ -26,6 +34,9 @@ func NewServer(
 	tenantService tenant.Service,
 	spaceService space.Service,
 	paymentService payment.Service,
+	jobService job.Service,
+	notifyService notify.Service,
+	idempotencyService idempotency.Service,
 	authMiddleware *middleware.AuthMiddleware,
 ) *Server {
 	s := &Server{
@@ -34,126 +45,28 @@ func NewServer(
 		tenantService:  tenantService,
 		spaceService:   spaceService,
 		paymentService: paymentService,
+		jobService:     jobService,
+		notifyService:  notifyService,
 		authMiddleware: authMiddleware,
-	}
-
-	// Public routes with CORS
-	s.Mux.Handle("/login", middleware.CORS(http.HandlerFunc(s.handleLogin)))
-	s.Mux.Handle("/validate-token", middleware.CORS(authMiddleware.RequireAuth(http.HandlerFunc(s.handleValidateToken))))
-
-	// Protected routes with auth and CORS
-	// User routes - Admin only
-	s.Mux.Handle("/users", middleware.CORS(authMiddleware.RequireAuth(authMiddleware.RequireAdmin(http.HandlerFunc(s.handleListUsers)))))
-	s.Mux.Handle("/users/", middleware.CORS(authMiddleware.RequireAuth(authMiddleware.RequireAdmin(http.HandlerFunc(s.handleUserOperations)))))
-
-	// Space routes
-	s.Mux.Handle("/spaces", middleware.CORS(authMiddleware.RequireAuth(http.HandlerFunc(s.handleListSpaces))))
-	s.Mux.Handle("/spaces/vacant", middleware.CORS(authMiddleware.RequireAuth(http.HandlerFunc(s.handleGetVacantSpaces))))
-	s.Mux.Handle("/spaces/", middleware.CORS(authMiddleware.RequireAuth(http.HandlerFunc(s.handleSpaceOperations))))
-
-	// Tenant routes
-	s.Mux.Handle("/tenants", middleware.CORS(authMiddleware.RequireAuth(http.HandlerFunc(s.handleTenantList))))
-	s.Mux.Handle("/tenants/", middleware.CORS(authMiddleware.RequireAuth(http.HandlerFunc(s.handleTenantOperations))))
-
-	// Payment routes
-	s.Mux.Handle("/payments", middleware.CORS(authMiddleware.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodGet:
-			s.handlePaymentList(w, r)
-		case http.MethodPost:
-			s.handleCreatePayment(w, r)
-		default:
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-		}
-	}))))
-	s.Mux.Handle("/payments/", middleware.CORS(authMiddleware.RequireAuth(http.HandlerFunc(s.handlePaymentOperations))))
 
-	// Logout route
-	s.Mux.Handle("/logout", middleware.CORS(
-		authMiddleware.RequireAuth(
-			http.HandlerFunc(s.handleLogout),
-		),
-	))
+		idempotencyService: idempotencyService,
+	}
 
+	s.routes()
 	return s
 }
 
+type ValidateTokenResponse struct {
+	User *user.User `json:"user"`
+	// CSRFToken is repeated for cookie sessions, for clients on another site
+	// that can't read the API's cookies after a reload
+	CSRFToken string `json:"csrfToken,omitempty"`
+}
+
 func (s *Server) handleValidateToken(w http.ResponseWriter, r *http.Request) {
 	user := r.Context().Value(middleware.UserContextKey).(*user.User)
+	csrf, _ := s.authMiddleware.CSRFToken(r)
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"user": user,
-	})
-}
-
-func (s *Server) handleUserOperations(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodGet:
-		s.handleGetUser(w, r)
-	case http.MethodPut:
-		s.handleUpdateUser(w, r)
-	case http.MethodDelete:
-		s.handleDeleteUser(w, r)
-	default:
-		http.NotFound(w, r)
-	}
-}
-
-func (s *Server) handleSpaceOperations(w http.ResponseWriter, r *http.Request) {
-	path := r.URL.Path
-	switch {
-	case r.Method == http.MethodGet:
-		s.handleGetSpace(w, r)
-	case r.Method == http.MethodPut:
-		s.handleUpdateSpace(w, r)
-	case r.Method == http.MethodPost && len(path) > 8:
-		switch {
-		case path[len(path)-8:] == "/reserve":
-			s.handleReserveSpace(w, r)
-		case path[len(path)-10:] == "/unreserve":
-			s.handleUnreserveSpace(w, r)
-		case path[len(path)-8:] == "/move-in":
-			s.handleMoveIn(w, r)
-		case path[len(path)-9:] == "/move-out":
-			s.handleMoveOut(w, r)
-		default:
-			http.NotFound(w, r)
-		}
-	default:
-		http.NotFound(w, r)
-	}
-}
-
-func (s *Server) handleTenantList(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodGet:
-		s.handleListTenants(w, r)
-	case http.MethodPost:
-		s.handleCreateTenant(w, r)
-	default:
-		http.NotFound(w, r)
-	}
-}
-
-func (s *Server) handleTenantOperations(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodGet:
-		s.handleGetTenant(w, r)
-	case http.MethodPut:
-		s.handleUpdateTenant(w, r)
-	case http.MethodDelete:
-		s.handleDeleteTenant(w, r)
-	default:
-		http.NotFound(w, r)
-	}
-}
-
-func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
-	user := r.Context().Value(middleware.UserContextKey).(*user.User)
-	if err := s.userService.RevokeAllTokens(user.ID); err != nil {
-		http.Error(w, "failed to logout", http.StatusInternalServerError)
-		return
-	}
-	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ValidateTokenResponse{User: user, CSRFToken: csrf})
 }
@@ -0,0 +1,96 @@
+// api/session_handler.go contains the HTTP handlers for listing and ending sessions.
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/BodaciousX/RVParkBackend/middleware"
+	"github.com/BodaciousX/RVParkBackend/user"
+)
+
+// SessionResponse is a session as shown to users. Current marks the session
+// the request was made with.
+type SessionResponse struct {
+	user.Token
+	Current bool `json:"current"`
+}
+
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	token, _ := s.authMiddleware.SessionToken(r)
+	if err := s.userService.Logout(r.Context(), token); err != nil {
+		writeError(w, err)
+		return
+	}
+	if _, ok := s.authMiddleware.CSRFToken(r); ok {
+		s.authMiddleware.ClearSessionCookies(w)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleListMySessions(w http.ResponseWriter, r *http.Request) {
+	currentUser := r.Context().Value(middleware.UserContextKey).(*user.User)
+	s.writeSessions(w, r, currentUser.ID)
+}
+
+func (s *Server) handleRevokeMySession(w http.ResponseWriter, r *http.Request) {
+	currentUser := r.Context().Value(middleware.UserContextKey).(*user.User)
+	if err := s.userService.RevokeSession(r.Context(), currentUser.ID, r.PathValue("id")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRevokeMySessions signs the user out on every device, this one included
+func (s *Server) handleRevokeMySessions(w http.ResponseWriter, r *http.Request) {
+	currentUser := r.Context().Value(middleware.UserContextKey).(*user.User)
+	if err := s.userService.RevokeAllTokens(r.Context(), currentUser.ID); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleListUserSessions(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if _, err := s.userService.GetUser(r.Context(), id); err != nil {
+		writeError(w, err)
+		return
+	}
+	s.writeSessions(w, r, id)
+}
+
+func (s *Server) handleRevokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if _, err := s.userService.GetUser(r.Context(), id); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := s.userService.RevokeAllTokens(r.Context(), id); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) writeSessions(w http.ResponseWriter, r *http.Request, userID string) {
+	sessions, err := s.userService.ListSessions(r.Context(), userID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	currentHash := ""
+	if token, ok := s.authMiddleware.SessionToken(r); ok {
+		currentHash = user.HashToken(token)
+	}
+
+	resp := make([]SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		resp = append(resp, SessionResponse{Token: session, Current: session.TokenHash == currentHash})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
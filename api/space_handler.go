@@ -4,15 +4,14 @@ package api
 import (
 	"encoding/json"
 	"net/http"
-	"strings"
 
 	"github.com/BodaciousX/RVParkBackend/space"
 )
 
 func (s *Server) handleListSpaces(w http.ResponseWriter, r *http.Request) {
-	spaces, err := s.spaceService.ListSpaces()
+	spaces, err := s.spaceService.ListSpaces(r.Context())
 	if err != nil {
-		http.Error(w, "failed to list spaces", http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
 
@@ -21,21 +20,26 @@ func (s *Server) handleListSpaces(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleUpdateSpace(w http.ResponseWriter, r *http.Request) {
-	id := strings.TrimPrefix(r.URL.Path, "/spaces/")
+	id := r.PathValue("id")
+	version, ok := ifMatchVersion(w, r)
+	if !ok {
+		return
+	}
 
 	var updateSpace space.Space
 	if err := json.NewDecoder(r.Body).Decode(&updateSpace); err != nil {
-		http.Error(w, "invalid request body", http.StatusBadRequest)
+		writeErrorMessage(w, http.StatusBadRequest, "invalid request body")
 		return
 	}
 
 	// Ensure the ID in the path matches the space
 	updateSpace.ID = id
+	updateSpace.Version = version
 
 	// Get current space first
-	currentSpace, err := s.spaceService.GetSpace(id)
+	currentSpace, err := s.spaceService.GetSpace(r.Context(), id)
 	if err != nil {
-		http.Error(w, "space not found", http.StatusNotFound)
+		writeError(w, err)
 		return
 	}
 
@@ -43,39 +47,41 @@ func (s *Server) handleUpdateSpace(w http.ResponseWriter, r *http.Request) {
 	updateSpace.Section = currentSpace.Section
 
 	// Update the space
-	if err := s.spaceService.UpdateSpace(updateSpace); err != nil {
-		http.Error(w, "failed to update space", http.StatusInternalServerError)
+	if err := s.spaceService.UpdateSpace(r.Context(), updateSpace); err != nil {
+		writeError(w, err)
 		return
 	}
 
 	// Get the updated space to return
-	updatedSpace, err := s.spaceService.GetSpace(id)
+	updatedSpace, err := s.spaceService.GetSpace(r.Context(), id)
 	if err != nil {
-		http.Error(w, "failed to get updated space", http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
 
+	setETag(w, updatedSpace.Version)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(updatedSpace)
 }
 
 func (s *Server) handleGetSpace(w http.ResponseWriter, r *http.Request) {
-	id := strings.TrimPrefix(r.URL.Path, "/spaces/")
+	id := r.PathValue("id")
 
-	space, err := s.spaceService.GetSpace(id)
+	space, err := s.spaceService.GetSpace(r.Context(), id)
 	if err != nil {
-		http.Error(w, "space not found", http.StatusNotFound)
+		writeError(w, err)
 		return
 	}
 
+	setETag(w, space.Version)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(space)
 }
 
 func (s *Server) handleGetVacantSpaces(w http.ResponseWriter, r *http.Request) {
-	spaces, err := s.spaceService.GetVacantSpaces()
+	spaces, err := s.spaceService.GetVacantSpaces(r.Context())
 	if err != nil {
-		http.Error(w, "failed to get vacant spaces", http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
 
@@ -84,11 +90,10 @@ func (s *Server) handleGetVacantSpaces(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleReserveSpace(w http.ResponseWriter, r *http.Request) {
-	id := strings.TrimPrefix(r.URL.Path, "/spaces/")
-	id = strings.TrimSuffix(id, "/reserve")
+	id := r.PathValue("id")
 
-	if err := s.spaceService.ReserveSpace(id); err != nil {
-		http.Error(w, "failed to reserve space", http.StatusInternalServerError)
+	if err := s.spaceService.ReserveSpace(r.Context(), id); err != nil {
+		writeError(w, err)
 		return
 	}
 
@@ -96,11 +101,10 @@ func (s *Server) handleReserveSpace(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleUnreserveSpace(w http.ResponseWriter, r *http.Request) {
-	id := strings.TrimPrefix(r.URL.Path, "/spaces/")
-	id = strings.TrimSuffix(id, "/unreserve")
+	id := r.PathValue("id")
 
-	if err := s.spaceService.UnreserveSpace(id); err != nil {
-		http.Error(w, "failed to unreserve space", http.StatusInternalServerError)
+	if err := s.spaceService.UnreserveSpace(r.Context(), id); err != nil {
+		writeError(w, err)
 		return
 	}
 
@@ -112,37 +116,36 @@ type MoveInRequest struct {
 }
 
 func (s *Server) handleMoveIn(w http.ResponseWriter, r *http.Request) {
-	id := strings.TrimPrefix(r.URL.Path, "/spaces/")
-	id = strings.TrimSuffix(id, "/move-in")
+	id := r.PathValue("id")
 
 	var req MoveInRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeErrorMessage(w, http.StatusBadRequest, "invalid request body")
 		return
 	}
 
-	if err := s.spaceService.MoveIn(id, req.TenantID); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := s.spaceService.MoveIn(r.Context(), id, req.TenantID); err != nil {
+		writeError(w, err)
 		return
 	}
 
 	// Get updated space to return
-	updatedSpace, err := s.spaceService.GetSpace(id)
+	updatedSpace, err := s.spaceService.GetSpace(r.Context(), id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
 
+	setETag(w, updatedSpace.Version)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(updatedSpace)
 }
 
 func (s *Server) handleMoveOut(w http.ResponseWriter, r *http.Request) {
-	id := strings.TrimPrefix(r.URL.Path, "/spaces/")
-	id = strings.TrimSuffix(id, "/move-out")
+	id := r.PathValue("id")
 
-	if err := s.spaceService.MoveOut(id); err != nil {
-		http.Error(w, "failed to move out tenant", http.StatusInternalServerError)
+	if err := s.spaceService.MoveOut(r.Context(), id); err != nil {
+		writeError(w, err)
 		return
 	}
 
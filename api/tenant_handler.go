@@ -4,7 +4,6 @@ package api
 import (
 	"encoding/json"
 	"net/http"
-	"strings"
 	"time"
 
 	"github.com/BodaciousX/RVParkBackend/tenant"
@@ -15,32 +14,38 @@ type CreateTenantRequest struct {
 	Name       string    `json:"name"`
 	MoveInDate time.Time `json:"moveInDate"`
 	SpaceID    string    `json:"spaceId"`
+	Email      string    `json:"email,omitempty"`
+	Phone      string    `json:"phone,omitempty"`
+	SMSOptOut  bool      `json:"smsOptOut,omitempty"`
 }
 
 func (s *Server) handleListTenants(w http.ResponseWriter, r *http.Request) {
-	// Method check example
-	if r.Method != http.MethodGet {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	page, err := pageRequest(r)
+	if err != nil {
+		writeError(w, err)
 		return
 	}
 
-	tenants, err := s.tenantService.ListTenants()
-	if err != nil {
-		http.Error(w, "failed to fetch tenants", http.StatusInternalServerError)
-		return
+	query := r.URL.Query()
+	filter := tenant.Filter{
+		Search:  query.Get("search"),
+		SpaceID: query.Get("spaceId"),
+		Section: query.Get("section"),
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(tenants); err != nil {
-		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+	tenants, err := s.tenantService.FindTenants(r.Context(), filter, page)
+	if err != nil {
+		writeError(w, err)
 		return
 	}
+
+	writePage(w, r, tenants)
 }
 
 func (s *Server) handleCreateTenant(w http.ResponseWriter, r *http.Request) {
 	var req CreateTenantRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request body", http.StatusBadRequest)
+		writeErrorMessage(w, http.StatusBadRequest, "invalid request body")
 		return
 	}
 
@@ -49,10 +54,13 @@ func (s *Server) handleCreateTenant(w http.ResponseWriter, r *http.Request) {
 		Name:       req.Name,
 		MoveInDate: req.MoveInDate,
 		SpaceID:    req.SpaceID,
+		Email:      req.Email,
+		Phone:      req.Phone,
+		SMSOptOut:  req.SMSOptOut,
 	}
 
-	if err := s.tenantService.CreateTenant(newTenant); err != nil {
-		http.Error(w, "failed to create tenant", http.StatusInternalServerError)
+	if err := s.tenantService.CreateTenant(r.Context(), newTenant); err != nil {
+		writeError(w, err)
 		return
 	}
 
@@ -62,46 +70,73 @@ func (s *Server) handleCreateTenant(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleGetTenant(w http.ResponseWriter, r *http.Request) {
-	id := strings.TrimPrefix(r.URL.Path, "/tenants/")
+	id := r.PathValue("id")
 
-	tenant, err := s.tenantService.GetTenant(id)
+	tenant, err := s.tenantService.GetTenant(r.Context(), id)
 	if err != nil {
-		http.Error(w, "tenant not found", http.StatusNotFound)
+		writeError(w, err)
 		return
 	}
 
+	setETag(w, tenant.Version)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(tenant)
 }
 
 func (s *Server) handleUpdateTenant(w http.ResponseWriter, r *http.Request) {
-	id := strings.TrimPrefix(r.URL.Path, "/tenants/")
+	id := r.PathValue("id")
+	version, ok := ifMatchVersion(w, r)
+	if !ok {
+		return
+	}
 
 	var updateTenant tenant.Tenant
 	if err := json.NewDecoder(r.Body).Decode(&updateTenant); err != nil {
-		http.Error(w, "invalid request body", http.StatusBadRequest)
+		writeErrorMessage(w, http.StatusBadRequest, "invalid request body")
 		return
 	}
 
 	// Ensure the ID in the path matches the tenant
 	updateTenant.ID = id
+	updateTenant.Version = version
+
+	if err := s.tenantService.UpdateTenant(r.Context(), updateTenant); err != nil {
+		writeError(w, err)
+		return
+	}
 
-	if err := s.tenantService.UpdateTenant(updateTenant); err != nil {
-		http.Error(w, "failed to update tenant", http.StatusInternalServerError)
+	// Return the tenant as stored, with the fields the service keeps
+	updatedTenant, err := s.tenantService.GetTenant(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
 		return
 	}
 
+	setETag(w, updatedTenant.Version)
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(updateTenant)
+	json.NewEncoder(w).Encode(updatedTenant)
 }
 
 func (s *Server) handleDeleteTenant(w http.ResponseWriter, r *http.Request) {
-	id := strings.TrimPrefix(r.URL.Path, "/tenants/")
+	id := r.PathValue("id")
 
-	if err := s.tenantService.DeleteTenant(id); err != nil {
-		http.Error(w, "failed to delete tenant", http.StatusInternalServerError)
+	if err := s.tenantService.DeleteTenant(r.Context(), id); err != nil {
+		writeError(w, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+func (s *Server) handleTenantNotifications(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	notifications, err := s.notifyService.ListTenantNotifications(id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notifications)
+}
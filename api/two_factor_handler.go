@@ -0,0 +1,164 @@
+// api/two_factor_handler.go contains the HTTP handlers for two-factor sign-in.
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/BodaciousX/RVParkBackend/middleware"
+	"github.com/BodaciousX/RVParkBackend/user"
+)
+
+// LoginChallengeRequest finishes a sign-in started at /login
+type LoginChallengeRequest struct {
+	Challenge string `json:"challenge"`
+	Code      string `json:"code"` // From the authenticator app, or a recovery code
+	UseCookie bool   `json:"useCookie"`
+}
+
+type EnrollChallengeRequest struct {
+	Challenge string `json:"challenge"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// RecoveryCodesResponse is shown once; the codes can't be retrieved later
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+func (s *Server) handleVerifyLoginChallenge(w http.ResponseWriter, r *http.Request) {
+	var req LoginChallengeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorMessage(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if err := s.checkUseCookie(req.UseCookie); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	result, err := s.userService.VerifyLoginChallenge(r.Context(), user.ChallengeResponse{
+		Challenge: req.Challenge,
+		Code:      req.Code,
+		UserAgent: r.UserAgent(),
+		IPAddress: s.authMiddleware.ClientIP(r),
+	})
+	switch {
+	case errors.Is(err, user.ErrInvalidCredentials):
+		writeErrorMessage(w, http.StatusUnauthorized, "invalid code")
+		return
+	case err != nil:
+		writeError(w, err)
+		return
+	}
+
+	s.writeLoginResult(w, result, req.UseCookie)
+}
+
+// handleEnrollForChallenge sets up an authenticator for a user whose role
+// requires one, part way through signing in
+func (s *Server) handleEnrollForChallenge(w http.ResponseWriter, r *http.Request) {
+	var req EnrollChallengeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorMessage(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	enrollment, err := s.userService.EnrollTOTPForChallenge(r.Context(), req.Challenge)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(enrollment)
+}
+
+func (s *Server) handleGetMyTwoFactor(w http.ResponseWriter, r *http.Request) {
+	currentUser := r.Context().Value(middleware.UserContextKey).(*user.User)
+	status, err := s.userService.GetTwoFactorStatus(r.Context(), currentUser.ID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+func (s *Server) handleEnrollMyTwoFactor(w http.ResponseWriter, r *http.Request) {
+	currentUser := r.Context().Value(middleware.UserContextKey).(*user.User)
+	enrollment, err := s.userService.EnrollTOTP(r.Context(), currentUser.ID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(enrollment)
+}
+
+func (s *Server) handleConfirmMyTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req TwoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorMessage(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	currentUser := r.Context().Value(middleware.UserContextKey).(*user.User)
+	codes, err := s.userService.ConfirmTOTP(r.Context(), currentUser.ID, req.Code)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+func (s *Server) handleDisableMyTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req TwoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorMessage(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	currentUser := r.Context().Value(middleware.UserContextKey).(*user.User)
+	if err := s.userService.DisableTwoFactor(r.Context(), currentUser.ID, req.Code); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleRegenerateMyRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	var req TwoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorMessage(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	currentUser := r.Context().Value(middleware.UserContextKey).(*user.User)
+	codes, err := s.userService.RegenerateRecoveryCodes(r.Context(), currentUser.ID, req.Code)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+func (s *Server) handleResetUserTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if err := s.userService.ResetTwoFactor(r.Context(), r.PathValue("id")); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
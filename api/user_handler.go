@@ -3,21 +3,34 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
-	"strings"
-	"time"
+	"strconv"
 
+	"github.com/BodaciousX/RVParkBackend/apperr"
 	"github.com/BodaciousX/RVParkBackend/user"
+	"github.com/google/uuid"
 )
 
 type LoginRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	// UseCookie keeps the session in an HttpOnly cookie instead of returning
+	// the token, when the server allows cookie sessions
+	UseCookie bool `json:"useCookie"`
 }
 
+// LoginResponse holds either a new session or, for accounts that sign in
+// with a second factor, the challenge to answer at /login/2fa. Cookie
+// sessions get CSRFToken, to send in the X-CSRF-Token header, in place of
+// Token.
 type LoginResponse struct {
-	User  user.User `json:"user"`
-	Token string    `json:"token"`
+	User      *user.User           `json:"user,omitempty"`
+	Token     string               `json:"token,omitempty"`
+	CSRFToken string               `json:"csrfToken,omitempty"`
+	TwoFactor *user.LoginChallenge `json:"twoFactor,omitempty"`
+	// Shown once, when finishing sign-in also finished setting up an authenticator
+	RecoveryCodes []string `json:"recoveryCodes,omitempty"`
 }
 
 type CreateUserRequest struct {
@@ -30,22 +43,51 @@ type CreateUserRequest struct {
 func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 	var req LoginRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request body", http.StatusBadRequest)
+		writeErrorMessage(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if err := s.checkUseCookie(req.UseCookie); err != nil {
+		writeError(w, err)
 		return
 	}
 
-	user, token, err := s.userService.Login(user.LoginCredentials{
-		Email:    req.Email,
-		Password: req.Password,
+	result, err := s.userService.Login(r.Context(), user.LoginCredentials{
+		Email:     req.Email,
+		Password:  req.Password,
+		UserAgent: r.UserAgent(),
+		IPAddress: s.authMiddleware.ClientIP(r),
 	})
-	if err != nil {
-		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+	switch {
+	case errors.Is(err, user.ErrInvalidCredentials):
+		writeErrorMessage(w, http.StatusUnauthorized, "invalid credentials")
+		return
+	case err != nil:
+		writeError(w, err)
 		return
 	}
 
-	resp := LoginResponse{
-		User:  *user,
-		Token: token,
+	s.writeLoginResult(w, result, req.UseCookie)
+}
+
+// checkUseCookie turns away requests for a cookie session when the server
+// doesn't allow them, before a session is started that couldn't be handed over
+func (s *Server) checkUseCookie(useCookie bool) error {
+	if useCookie && !s.authMiddleware.CookiesEnabled() {
+		return apperr.Invalid("useCookie", "cookie sessions are not enabled")
+	}
+	return nil
+}
+
+func (s *Server) writeLoginResult(w http.ResponseWriter, result *user.LoginResult, useCookie bool) {
+	resp := LoginResponse{TwoFactor: result.Challenge}
+	if result.Challenge == nil {
+		resp.User = result.User
+		resp.RecoveryCodes = result.RecoveryCodes
+		if useCookie {
+			resp.CSRFToken = s.authMiddleware.SetSessionCookies(w, result.Token)
+		} else {
+			resp.Token = result.Token
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -53,42 +95,64 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleListUsers(w http.ResponseWriter, r *http.Request) {
-	// The actual list users functionality would go here
-	// For now, we'll return a placeholder response
-	w.Header().Set("Content-Type", "application/json")
-	w.Write([]byte("[]"))
+	page, err := pageRequest(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := user.Filter{
+		Search: query.Get("search"),
+		Role:   user.Role(query.Get("role")),
+	}
+
+	users, err := s.userService.FindUsers(r.Context(), filter, page)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writePage(w, r, users)
 }
 
 func (s *Server) handleCreateUser(w http.ResponseWriter, r *http.Request) {
 	var req CreateUserRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request body", http.StatusBadRequest)
+		writeErrorMessage(w, http.StatusBadRequest, "invalid request body")
 		return
 	}
 
 	newUser := user.User{
-		Email:     req.Email,
-		Username:  req.Username,
-		Role:      req.Role,
-		CreatedAt: time.Now(),
+		ID:       uuid.New().String(),
+		Email:    req.Email,
+		Username: req.Username,
+		Role:     req.Role,
 	}
 
-	if err := s.userService.CreateUser(newUser, req.Password); err != nil {
-		http.Error(w, "failed to create user", http.StatusInternalServerError)
+	if err := s.userService.CreateUser(r.Context(), newUser, req.Password); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Return the user as stored, with the creation time the service set
+	created, err := s.userService.GetUser(r.Context(), newUser.ID)
+	if err != nil {
+		writeError(w, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(newUser)
+	json.NewEncoder(w).Encode(created)
 }
 
 func (s *Server) handleGetUser(w http.ResponseWriter, r *http.Request) {
-	id := strings.TrimPrefix(r.URL.Path, "/users/")
+	id := r.PathValue("id")
 
-	user, err := s.userService.GetUser(id)
+	user, err := s.userService.GetUser(r.Context(), id)
 	if err != nil {
-		http.Error(w, "user not found", http.StatusNotFound)
+		writeError(w, err)
 		return
 	}
 
@@ -97,17 +161,17 @@ func (s *Server) handleGetUser(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleUpdateUser(w http.ResponseWriter, r *http.Request) {
-	id := strings.TrimPrefix(r.URL.Path, "/users/")
+	id := r.PathValue("id")
 
 	var updateUser user.User
 	if err := json.NewDecoder(r.Body).Decode(&updateUser); err != nil {
-		http.Error(w, "invalid request body", http.StatusBadRequest)
+		writeErrorMessage(w, http.StatusBadRequest, "invalid request body")
 		return
 	}
 
 	updateUser.ID = id
-	if err := s.userService.UpdateUser(updateUser); err != nil {
-		http.Error(w, "failed to update user", http.StatusInternalServerError)
+	if err := s.userService.UpdateUser(r.Context(), updateUser); err != nil {
+		writeError(w, err)
 		return
 	}
 
@@ -116,12 +180,34 @@ func (s *Server) handleUpdateUser(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleDeleteUser(w http.ResponseWriter, r *http.Request) {
-	id := strings.TrimPrefix(r.URL.Path, "/users/")
+	id := r.PathValue("id")
 
-	if err := s.userService.DeleteUser(id); err != nil {
-		http.Error(w, "failed to delete user", http.StatusInternalServerError)
+	if err := s.userService.DeleteUser(r.Context(), id); err != nil {
+		writeError(w, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+func (s *Server) handleUnlockUser(w http.ResponseWriter, r *http.Request) {
+	if err := s.userService.UnlockUser(r.Context(), r.PathValue("id")); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleListLoginEvents(w http.ResponseWriter, r *http.Request) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	events, err := s.userService.ListLoginEvents(r.Context(), r.PathValue("id"), limit)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
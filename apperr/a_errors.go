@@ -0,0 +1,102 @@
+// apperr/a_errors.go
+package apperr
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Sentinel errors shared by the domain packages. Use errors.Is to test for
+// them; the API maps them to 404, 409, 422, 412 and 429 respectively.
+var (
+	ErrNotFound    = errors.New("not found")
+	ErrConflict    = errors.New("conflict")
+	ErrValidation  = errors.New("validation failed")
+	ErrStale       = errors.New("stale version")
+	ErrRateLimited = errors.New("rate limited")
+)
+
+type kindError struct {
+	kind    error
+	message string
+}
+
+func (e *kindError) Error() string { return e.message }
+func (e *kindError) Unwrap() error { return e.kind }
+
+// NotFound returns an error matching ErrNotFound with the given message
+func NotFound(format string, args ...interface{}) error {
+	return &kindError{kind: ErrNotFound, message: fmt.Sprintf(format, args...)}
+}
+
+// Conflict returns an error matching ErrConflict with the given message. Use it
+// when a request is valid but clashes with the current state.
+func Conflict(format string, args ...interface{}) error {
+	return &kindError{kind: ErrConflict, message: fmt.Sprintf(format, args...)}
+}
+
+// Stale returns an error matching ErrStale with the given message. Use it
+// when an update was based on a version of a record that is no longer current.
+func Stale(format string, args ...interface{}) error {
+	return &kindError{kind: ErrStale, message: fmt.Sprintf(format, args...)}
+}
+
+// RateLimitError tells a caller to wait before trying again. It matches
+// ErrRateLimited.
+type RateLimitError struct {
+	RetryAfter time.Duration
+	message    string
+}
+
+func (e *RateLimitError) Error() string { return e.message }
+func (e *RateLimitError) Unwrap() error { return ErrRateLimited }
+
+// RateLimited returns an error asking the caller to retry after retryAfter
+func RateLimited(retryAfter time.Duration, format string, args ...interface{}) error {
+	return &RateLimitError{RetryAfter: retryAfter, message: fmt.Sprintf(format, args...)}
+}
+
+// FieldError describes a problem with one input field. Field uses the JSON
+// name the client sent.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError collects every invalid field in a request so clients can
+// show them all at once. It matches ErrValidation.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		messages[i] = f.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (e *ValidationError) Unwrap() error { return ErrValidation }
+
+// Add records a problem with field
+func (e *ValidationError) Add(field, format string, args ...interface{}) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Err returns e if any field was added, otherwise nil
+func (e *ValidationError) Err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Invalid returns a validation error for a single field
+func Invalid(field, format string, args ...interface{}) error {
+	v := &ValidationError{}
+	v.Add(field, format, args...)
+	return v
+}
@@ -0,0 +1,56 @@
+// apperr/a_errors_test.go
+package apperr
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKinds(t *testing.T) {
+	notFound := NotFound("space %s not found", "A1")
+	assert.Equal(t, "space A1 not found", notFound.Error())
+	assert.True(t, errors.Is(notFound, ErrNotFound))
+	assert.False(t, errors.Is(notFound, ErrConflict))
+
+	// Wrapping keeps the kind
+	wrapped := fmt.Errorf("move out: %w", Conflict("space %s is not occupied", "A1"))
+	assert.True(t, errors.Is(wrapped, ErrConflict))
+
+	stale := Stale("tenant %s was modified", "t1")
+	assert.True(t, errors.Is(stale, ErrStale))
+	assert.False(t, errors.Is(stale, ErrConflict))
+
+	limited := fmt.Errorf("login: %w", RateLimited(90*time.Second, "too many attempts"))
+	assert.True(t, errors.Is(limited, ErrRateLimited))
+	var rateLimit *RateLimitError
+	if assert.True(t, errors.As(limited, &rateLimit)) {
+		assert.Equal(t, 90*time.Second, rateLimit.RetryAfter)
+	}
+}
+
+func TestValidationError(t *testing.T) {
+	invalid := &ValidationError{}
+	assert.NoError(t, invalid.Err())
+
+	invalid.Add("name", "tenant name is required")
+	invalid.Add("phone", "invalid phone number: %s", "too short")
+
+	err := invalid.Err()
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, ErrValidation))
+	assert.Equal(t, "tenant name is required; invalid phone number: too short", err.Error())
+
+	var validation *ValidationError
+	assert.True(t, errors.As(err, &validation))
+	assert.Equal(t, []FieldError{
+		{Field: "name", Message: "tenant name is required"},
+		{Field: "phone", Message: "invalid phone number: too short"},
+	}, validation.Fields)
+
+	single := Invalid("tenantId", "tenant ID is required")
+	assert.True(t, errors.Is(single, ErrValidation))
+}
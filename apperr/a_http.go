@@ -0,0 +1,50 @@
+// apperr/a_http.go
+package apperr
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ErrorResponse is the body of every non-2xx API response, written by the
+// handlers and by the middleware in front of them alike
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+type ErrorBody struct {
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Fields  []FieldError `json:"fields,omitempty"`
+}
+
+var errorCodes = map[int]string{
+	http.StatusBadRequest:           "bad_request",
+	http.StatusUnauthorized:         "unauthorized",
+	http.StatusForbidden:            "forbidden",
+	http.StatusNotFound:             "not_found",
+	http.StatusMethodNotAllowed:     "method_not_allowed",
+	http.StatusConflict:             "conflict",
+	http.StatusPreconditionFailed:   "precondition_failed",
+	http.StatusUnprocessableEntity:  "validation_failed",
+	http.StatusPreconditionRequired: "precondition_required",
+	http.StatusTooManyRequests:      "rate_limited",
+	http.StatusInternalServerError:  "internal_error",
+}
+
+// WriteMessage writes an error envelope with message and the status's code
+func WriteMessage(w http.ResponseWriter, status int, message string) {
+	WriteBody(w, status, ErrorBody{Message: message})
+}
+
+// WriteBody writes an error envelope, filling in the code for status if
+// body has none
+func WriteBody(w http.ResponseWriter, status int, body ErrorBody) {
+	if body.Code == "" {
+		body.Code = errorCodes[status]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: body})
+}
@@ -0,0 +1,56 @@
+// clock/c_clock.go
+package clock
+
+import (
+	"time"
+	_ "time/tzdata" // so park time zones load on hosts without zoneinfo
+)
+
+// Clock tells the current time in the park's time zone. Services take a Clock
+// instead of calling time.Now so "today" means the park's today, not the
+// server's.
+type Clock interface {
+	// Now returns the current time in the park's location
+	Now() time.Time
+}
+
+type parkClock struct {
+	loc *time.Location
+}
+
+// New returns a Clock reading the system time in loc
+func New(loc *time.Location) Clock {
+	if loc == nil {
+		loc = time.Local
+	}
+	return parkClock{loc: loc}
+}
+
+func (c parkClock) Now() time.Time {
+	return time.Now().In(c.loc)
+}
+
+// Date returns the calendar date of t, as read in t's own location, at
+// midnight UTC. Due dates and move-in dates are days rather than instants and
+// are stored and compared in this form, so "2024-06-01T00:00:00Z" is June 1st
+// wherever the park is.
+func Date(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// Today returns the park's current calendar date, in the form Date returns
+func Today(c Clock) time.Time {
+	return Date(c.Now())
+}
+
+// DaysBetween counts the calendar days from one date to another, negative
+// when to is earlier
+func DaysBetween(from, to time.Time) int {
+	return int(Date(to).Sub(Date(from)).Hours() / 24)
+}
+
+// DateOnly formats t's calendar date for a DATE column
+func DateOnly(t time.Time) string {
+	return t.Format(time.DateOnly)
+}
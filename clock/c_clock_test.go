@@ -0,0 +1,62 @@
+// clock/c_clock_test.go
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewUsesParkLocation(t *testing.T) {
+	chicago, err := time.LoadLocation("America/Chicago")
+	require.NoError(t, err)
+
+	assert.Equal(t, chicago, New(chicago).Now().Location())
+	assert.Equal(t, time.Local, New(nil).Now().Location())
+}
+
+func TestDate(t *testing.T) {
+	chicago, err := time.LoadLocation("America/Chicago")
+	require.NoError(t, err)
+
+	// 11:30pm on May 31st in the park is already June 1st in UTC
+	lateEvening := time.Date(2024, 5, 31, 23, 30, 0, 0, chicago)
+	assert.Equal(t, time.Date(2024, 5, 31, 0, 0, 0, 0, time.UTC), Date(lateEvening))
+	assert.Equal(t, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), Date(lateEvening.UTC()))
+
+	// Dates sent as midnight UTC keep their day
+	assert.Equal(t, "2024-06-01", DateOnly(Date(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC))))
+}
+
+func TestDaysBetween(t *testing.T) {
+	chicago, err := time.LoadLocation("America/Chicago")
+	require.NoError(t, err)
+
+	due := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	// Across the start of daylight saving time the count is still in whole days
+	assert.Equal(t, 14, DaysBetween(due, time.Date(2024, 3, 15, 1, 0, 0, 0, chicago)))
+	assert.Equal(t, -1, DaysBetween(due, time.Date(2024, 2, 29, 23, 0, 0, 0, chicago)))
+	assert.Equal(t, 0, DaysBetween(due, due))
+}
+
+func TestFake(t *testing.T) {
+	chicago, err := time.LoadLocation("America/Chicago")
+	require.NoError(t, err)
+
+	start := time.Date(2024, 3, 9, 23, 0, 0, 0, chicago)
+	clk := NewFake(start)
+	assert.Equal(t, start, clk.Now())
+
+	clk.Advance(90 * time.Minute)
+	assert.Equal(t, time.Date(2024, 3, 10, 0, 30, 0, 0, chicago), clk.Now())
+	assert.Equal(t, time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC), Today(clk))
+
+	// A calendar day over the daylight saving change is 23 hours long
+	clk.AdvanceDays(1)
+	assert.Equal(t, time.Date(2024, 3, 11, 0, 30, 0, 0, chicago), clk.Now())
+
+	clk.Set(start)
+	assert.Equal(t, start, clk.Now())
+}
@@ -0,0 +1,48 @@
+// clock/c_fake.go
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Fake is a Clock for tests. Time stands still until the test sets or
+// advances it, so expiry and due-date logic can be checked exactly.
+type Fake struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewFake returns a Fake reading now. Give now in the park's location, as
+// a real Clock would.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Set moves the clock to now
+func (f *Fake) Set(now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = now
+}
+
+// Advance moves the clock forward by d
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+}
+
+// AdvanceDays moves the clock forward by whole calendar days, keeping the
+// wall-clock time across daylight saving changes
+func (f *Fake) AdvanceDays(days int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.AddDate(0, 0, days)
+}
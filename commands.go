@@ -0,0 +1,301 @@
+// commands.go contains the admin subcommands of the server binary.
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BodaciousX/RVParkBackend/migrate"
+	"github.com/BodaciousX/RVParkBackend/user"
+	"github.com/google/uuid"
+)
+
+func printUsage() {
+	fmt.Fprint(os.Stderr, `Usage: rvpark <command> [arguments]
+
+Commands:
+  serve                                   run the HTTP server (default)
+  migrate up|down [steps]|status          manage database schema migrations
+  user create -email E -username U [-role ADMIN|STAFF] [-password P]
+  user reset-password -email E [-password P]
+  user revoke -email E                    sign a user out of every session
+  tokens clean                            delete expired and revoked tokens
+  seed                                    create the default admin and staff accounts
+  export [-start T] [-end T] [-out FILE] tenants|spaces|payments|all
+
+Passwords not given with -password are read from the first line of stdin.
+`)
+}
+
+// runCommand dispatches a subcommand against an open database
+func runCommand(db *sql.DB, command string, args []string) error {
+	switch command {
+	case "serve":
+		return serve(db)
+	case "migrate":
+		return runMigrateCommand(db, args)
+	case "user", "tokens", "seed", "export":
+	default:
+		printUsage()
+		return fmt.Errorf("unknown command %q", command)
+	}
+
+	svc, err := newAppServices(db)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch command {
+	case "user":
+		return runUserCommand(ctx, svc, args)
+	case "tokens":
+		return runTokensCommand(ctx, svc, args)
+	case "seed":
+		return runSeedCommand(ctx, svc)
+	default:
+		return runExportCommand(ctx, svc, args, os.Stdout)
+	}
+}
+
+// runMigrateCommand handles "migrate up", "migrate down [steps]" and "migrate status"
+func runMigrateCommand(db *sql.DB, args []string) error {
+	migrator, err := migrate.NewMigrator(db)
+	if err != nil {
+		return fmt.Errorf("failed to load migrations: %v", err)
+	}
+
+	action := "up"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	switch action {
+	case "up":
+		return migrator.Up()
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil {
+				return fmt.Errorf("invalid step count %q: %v", args[1], err)
+			}
+		}
+		return migrator.Down(steps)
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.Applied {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate action %q (expected up, down or status)", action)
+	}
+}
+
+// runUserCommand handles "user create", "user reset-password" and "user revoke"
+func runUserCommand(ctx context.Context, svc *appServices, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("user requires an action: create, reset-password or revoke")
+	}
+
+	action := args[0]
+	flags := flag.NewFlagSet("user "+action, flag.ContinueOnError)
+	email := flags.String("email", "", "email address of the user")
+	username := flags.String("username", "", "display name for a new user")
+	role := flags.String("role", string(user.RoleStaff), "role for a new user (ADMIN or STAFF)")
+	password := flags.String("password", "", "password (read from stdin when omitted)")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	if *email == "" {
+		return fmt.Errorf("-email is required")
+	}
+
+	switch action {
+	case "create":
+		if *username == "" {
+			return fmt.Errorf("-username is required")
+		}
+		userRole := user.Role(strings.ToUpper(*role))
+		if userRole != user.RoleAdmin && userRole != user.RoleStaff {
+			return fmt.Errorf("invalid role %q", *role)
+		}
+		pw, err := passwordFromFlagOrStdin(*password, os.Stdin)
+		if err != nil {
+			return err
+		}
+
+		newUser := user.User{
+			ID:       uuid.New().String(),
+			Email:    *email,
+			Username: *username,
+			Role:     userRole,
+		}
+		if err := svc.userService.CreateUser(ctx, newUser, pw); err != nil {
+			return fmt.Errorf("failed to create user: %v", err)
+		}
+		fmt.Printf("Created %s user %s (%s)\n", newUser.Role, newUser.Email, newUser.ID)
+		return nil
+
+	case "reset-password":
+		existing, err := svc.userService.GetUserByEmail(ctx, *email)
+		if err != nil {
+			return fmt.Errorf("user not found: %v", err)
+		}
+		pw, err := passwordFromFlagOrStdin(*password, os.Stdin)
+		if err != nil {
+			return err
+		}
+		if err := svc.userService.ResetPassword(ctx, existing.ID, pw); err != nil {
+			return fmt.Errorf("failed to reset password: %v", err)
+		}
+		fmt.Printf("Password reset for %s; existing sessions revoked\n", existing.Email)
+		return nil
+
+	case "revoke":
+		existing, err := svc.userService.GetUserByEmail(ctx, *email)
+		if err != nil {
+			return fmt.Errorf("user not found: %v", err)
+		}
+		if err := svc.userService.RevokeAllTokens(ctx, existing.ID); err != nil {
+			return fmt.Errorf("failed to revoke tokens: %v", err)
+		}
+		fmt.Printf("Revoked all sessions for %s\n", existing.Email)
+		return nil
+
+	default:
+		return fmt.Errorf("unknown user action %q", action)
+	}
+}
+
+// passwordFromFlagOrStdin keeps passwords out of shell history when piped in
+func passwordFromFlagOrStdin(password string, stdin io.Reader) (string, error) {
+	if password != "" {
+		return password, nil
+	}
+
+	line, err := bufio.NewReader(stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("failed to read password: %v", err)
+	}
+
+	password = strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return "", fmt.Errorf("password is required")
+	}
+	return password, nil
+}
+
+// runTokensCommand handles "tokens clean"
+func runTokensCommand(ctx context.Context, svc *appServices, args []string) error {
+	if len(args) == 0 || args[0] != "clean" {
+		return fmt.Errorf("tokens requires an action: clean")
+	}
+
+	if err := svc.tokenRepo.CleanExpiredTokens(ctx, svc.clock.Now()); err != nil {
+		return fmt.Errorf("failed to clean tokens: %v", err)
+	}
+	fmt.Println("Expired and revoked tokens removed")
+	return nil
+}
+
+// runSeedCommand creates the default accounts from ADMIN_* and STAFF_* env vars
+func runSeedCommand(ctx context.Context, svc *appServices) error {
+	if err := ensureAdminExists(ctx, svc.userService); err != nil {
+		return err
+	}
+	return ensureStaffExists(ctx, svc.userService)
+}
+
+// runExportCommand writes tenants, spaces and/or payments as JSON
+func runExportCommand(ctx context.Context, svc *appServices, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	startStr := flags.String("start", "", "earliest payment due date (RFC3339), defaults to all history")
+	endStr := flags.String("end", "", "latest payment due date (RFC3339), defaults to no limit")
+	out := flags.String("out", "", "output file (defaults to stdout)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	// Flags after the target aren't parsed, so they'd be silently ignored
+	if flags.NArg() > 1 {
+		return fmt.Errorf("unexpected arguments after %q: %s (flags go before the target)",
+			flags.Arg(0), strings.Join(flags.Args()[1:], " "))
+	}
+	what := "all"
+	if flags.NArg() > 0 {
+		what = flags.Arg(0)
+	}
+
+	// Without -end, payments due in future months are exported too
+	start := time.Unix(0, 0)
+	end := time.Date(9999, time.December, 31, 0, 0, 0, 0, time.UTC)
+	var err error
+	if *startStr != "" {
+		if start, err = time.Parse(time.RFC3339, *startStr); err != nil {
+			return fmt.Errorf("invalid -start: %v", err)
+		}
+	}
+	if *endStr != "" {
+		if end, err = time.Parse(time.RFC3339, *endStr); err != nil {
+			return fmt.Errorf("invalid -end: %v", err)
+		}
+	}
+
+	export := make(map[string]interface{})
+	if what == "tenants" || what == "all" {
+		tenants, err := svc.tenantService.ListTenants(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list tenants: %v", err)
+		}
+		export["tenants"] = tenants
+	}
+	if what == "spaces" || what == "all" {
+		spaces, err := svc.spaceService.ListSpaces(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list spaces: %v", err)
+		}
+		export["spaces"] = spaces
+	}
+	if what == "payments" || what == "all" {
+		payments, err := svc.paymentService.GetPaymentsByDateRange(ctx, start, end)
+		if err != nil {
+			return fmt.Errorf("failed to list payments: %v", err)
+		}
+		export["payments"] = payments
+	}
+	if len(export) == 0 {
+		return fmt.Errorf("unknown export target %q", what)
+	}
+
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		stdout = file
+	}
+
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(export)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BodaciousX/RVParkBackend/clock"
+	"github.com/BodaciousX/RVParkBackend/payment"
+	"github.com/BodaciousX/RVParkBackend/tenant"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestPasswordFromFlagOrStdin(t *testing.T) {
+	pw, err := passwordFromFlagOrStdin("fromflag", strings.NewReader("ignored\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "fromflag", pw)
+
+	pw, err = passwordFromFlagOrStdin("", strings.NewReader("fromstdin\r\nsecond line\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "fromstdin", pw)
+
+	_, err = passwordFromFlagOrStdin("", strings.NewReader(""))
+	assert.Error(t, err)
+}
+
+func TestExportCommand(t *testing.T) {
+	mockTenantService := new(MockTenantService)
+	mockPaymentService := new(MockPaymentService)
+	svc := &appServices{
+		clock:          clock.New(time.UTC),
+		tenantService:  mockTenantService,
+		paymentService: mockPaymentService,
+	}
+
+	mockTenantService.On("ListTenants").Return([]tenant.Tenant{{ID: "t1", Name: "John Doe"}}, nil)
+	// Without -end, nothing due in the future is left out
+	noLimit := mock.MatchedBy(func(end time.Time) bool { return end.After(time.Now().AddDate(100, 0, 0)) })
+	mockPaymentService.On("GetPaymentsByDateRange", mock.AnythingOfType("time.Time"), noLimit).
+		Return([]payment.Payment{{ID: "p1", TenantID: "t1"}}, nil)
+
+	var out bytes.Buffer
+	assert.NoError(t, runExportCommand(context.Background(), svc, []string{"tenants"}, &out))
+
+	var exported map[string][]tenant.Tenant
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &exported))
+	assert.Len(t, exported["tenants"], 1)
+	assert.NotContains(t, exported, "payments")
+
+	out.Reset()
+	assert.NoError(t, runExportCommand(context.Background(), svc, []string{"-start", "2024-01-01T00:00:00Z", "payments"}, &out))
+	assert.Contains(t, out.String(), `"p1"`)
+
+	assert.Error(t, runExportCommand(context.Background(), svc, []string{"-start", "yesterday", "payments"}, &out))
+	assert.Error(t, runExportCommand(context.Background(), svc, []string{"invoices"}, &out))
+	// Flags after the target would be ignored
+	assert.Error(t, runExportCommand(context.Background(), svc, []string{"payments", "-start", "2024-01-01T00:00:00Z"}, &out))
+}
+
+func TestGetDBConfig(t *testing.T) {
+	t.Setenv("DATABASE_URL", "postgres://user:pw@localhost/db")
+	t.Setenv("GO_ENV", "production")
+	t.Setenv("DB_STATEMENT_TIMEOUT", "")
+	assert.Equal(t, "postgres://user:pw@localhost/db?sslmode=require&statement_timeout=30000", getDBConfig())
+
+	t.Setenv("DATABASE_URL", "postgres://user:pw@localhost/db?sslmode=disable")
+	t.Setenv("GO_ENV", "development")
+	t.Setenv("DB_STATEMENT_TIMEOUT", "5s")
+	assert.Equal(t, "postgres://user:pw@localhost/db?sslmode=disable&statement_timeout=5000", getDBConfig())
+}
@@ -1,6 +1,6 @@
 module github.com/BodaciousX/RVParkBackend
 
-go 1.21
+go 1.22
 
 require (
 	github.com/lib/pq v1.10.9
@@ -0,0 +1,26 @@
+// idempotency/i_interface.go
+package idempotency
+
+import (
+	"context"
+	"time"
+)
+
+type Service interface {
+	// Begin claims key for a request. It returns nil when the caller should
+	// handle the request and then Complete or Release the key, or the stored
+	// record when the same request was already handled.
+	Begin(ctx context.Context, userID, key, fingerprint string) (*Record, error)
+	Complete(ctx context.Context, record Record) error
+	Release(ctx context.Context, userID, key string) error
+	CleanExpired(ctx context.Context) error
+}
+
+type Repository interface {
+	// Claim stores record unless the key is already held by a record created
+	// after expiredBefore, in which case that record is returned instead
+	Claim(ctx context.Context, record Record, expiredBefore time.Time) (*Record, error)
+	Complete(ctx context.Context, record Record) error
+	Delete(ctx context.Context, userID, key string) error
+	DeleteExpired(ctx context.Context, before time.Time) error
+}
@@ -0,0 +1,21 @@
+// idempotency/i_model.go
+package idempotency
+
+import "time"
+
+// Record is the stored outcome of a request sent with an Idempotency-Key.
+// Keys are scoped to the user who sent them.
+type Record struct {
+	UserID      string
+	Key         string
+	Fingerprint string // hash of the method, path and body of the original request
+	StatusCode  int    // 0 while the original request is still being handled
+	Header      map[string]string
+	Body        []byte
+	CreatedAt   time.Time
+}
+
+// Completed reports whether the original request has finished
+func (r Record) Completed() bool {
+	return r.StatusCode != 0
+}
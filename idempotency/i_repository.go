@@ -0,0 +1,99 @@
+// idempotency/i_repository.go
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+type sqlRepository struct {
+	db *sql.DB
+}
+
+func NewSQLRepository(db *sql.DB) Repository {
+	return &sqlRepository{db: db}
+}
+
+func (r *sqlRepository) Claim(ctx context.Context, record Record, expiredBefore time.Time) (*Record, error) {
+	// An expired record is taken over as if the key were new
+	query := `
+        INSERT INTO idempotency_keys (user_id, key, fingerprint, created_at)
+        VALUES ($1, $2, $3, $4)
+        ON CONFLICT (user_id, key) DO UPDATE SET
+            fingerprint = EXCLUDED.fingerprint,
+            status_code = NULL,
+            header = NULL,
+            body = NULL,
+            created_at = EXCLUDED.created_at
+        WHERE idempotency_keys.created_at < $5
+    `
+
+	result, err := r.db.ExecContext(ctx, query, record.UserID, record.Key, record.Fingerprint, record.CreatedAt, expiredBefore)
+	if err != nil {
+		return nil, err
+	}
+	if rows, err := result.RowsAffected(); err != nil || rows > 0 {
+		return nil, err
+	}
+
+	query = `
+        SELECT user_id, key, fingerprint, status_code, header, body, created_at
+        FROM idempotency_keys
+        WHERE user_id = $1 AND key = $2
+    `
+
+	var existing Record
+	var status sql.NullInt64
+	var header []byte
+	err = r.db.QueryRowContext(ctx, query, record.UserID, record.Key).Scan(
+		&existing.UserID,
+		&existing.Key,
+		&existing.Fingerprint,
+		&status,
+		&header,
+		&existing.Body,
+		&existing.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	existing.StatusCode = int(status.Int64)
+	if len(header) > 0 {
+		if err := json.Unmarshal(header, &existing.Header); err != nil {
+			return nil, err
+		}
+	}
+	return &existing, nil
+}
+
+func (r *sqlRepository) Complete(ctx context.Context, record Record) error {
+	header, err := json.Marshal(record.Header)
+	if err != nil {
+		return err
+	}
+
+	query := `
+        UPDATE idempotency_keys SET
+            status_code = $3,
+            header = $4,
+            body = $5
+        WHERE user_id = $1 AND key = $2
+    `
+	_, err = r.db.ExecContext(ctx, query, record.UserID, record.Key, record.StatusCode, header, record.Body)
+	return err
+}
+
+func (r *sqlRepository) Delete(ctx context.Context, userID, key string) error {
+	query := `DELETE FROM idempotency_keys WHERE user_id = $1 AND key = $2`
+	_, err := r.db.ExecContext(ctx, query, userID, key)
+	return err
+}
+
+func (r *sqlRepository) DeleteExpired(ctx context.Context, before time.Time) error {
+	query := `DELETE FROM idempotency_keys WHERE created_at < $1`
+	_, err := r.db.ExecContext(ctx, query, before)
+	return err
+}
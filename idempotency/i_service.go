@@ -0,0 +1,65 @@
+// idempotency/i_service.go
+package idempotency
+
+import (
+	"context"
+	"time"
+
+	"github.com/BodaciousX/RVParkBackend/apperr"
+	"github.com/BodaciousX/RVParkBackend/clock"
+)
+
+// DefaultTTL is how long a key is remembered when no retention is configured
+const DefaultTTL = 24 * time.Hour
+
+type service struct {
+	repo  Repository
+	ttl   time.Duration
+	clock clock.Clock
+}
+
+func NewService(repo Repository, ttl time.Duration, clk clock.Clock) Service {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &service{repo: repo, ttl: ttl, clock: clk}
+}
+
+func (s *service) Begin(ctx context.Context, userID, key, fingerprint string) (*Record, error) {
+	if len(key) > 255 {
+		return nil, apperr.Invalid("Idempotency-Key", "Idempotency-Key must be at most 255 characters")
+	}
+
+	now := s.clock.Now()
+	existing, err := s.repo.Claim(ctx, Record{
+		UserID:      userID,
+		Key:         key,
+		Fingerprint: fingerprint,
+		CreatedAt:   now,
+	}, now.Add(-s.ttl))
+	if err != nil || existing == nil {
+		return nil, err
+	}
+
+	if existing.Fingerprint != fingerprint {
+		return nil, apperr.Invalid("Idempotency-Key", "Idempotency-Key was already used for a different request")
+	}
+	if !existing.Completed() {
+		return nil, apperr.Conflict("a request with this Idempotency-Key is still being processed")
+	}
+	return existing, nil
+}
+
+func (s *service) Complete(ctx context.Context, record Record) error {
+	return s.repo.Complete(ctx, record)
+}
+
+// Release forgets a claimed key so the request can be retried, e.g. after
+// it failed with a server error
+func (s *service) Release(ctx context.Context, userID, key string) error {
+	return s.repo.Delete(ctx, userID, key)
+}
+
+func (s *service) CleanExpired(ctx context.Context) error {
+	return s.repo.DeleteExpired(ctx, s.clock.Now().Add(-s.ttl))
+}
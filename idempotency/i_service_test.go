@@ -0,0 +1,88 @@
+// idempotency/i_service_test.go
+package idempotency
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BodaciousX/RVParkBackend/apperr"
+	"github.com/BodaciousX/RVParkBackend/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockRepository struct {
+	mock.Mock
+}
+
+func (m *MockRepository) Claim(ctx context.Context, record Record, expiredBefore time.Time) (*Record, error) {
+	args := m.Called(record, expiredBefore)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*Record), args.Error(1)
+}
+
+func (m *MockRepository) Complete(ctx context.Context, record Record) error {
+	args := m.Called(record)
+	return args.Error(0)
+}
+
+func (m *MockRepository) Delete(ctx context.Context, userID, key string) error {
+	args := m.Called(userID, key)
+	return args.Error(0)
+}
+
+func (m *MockRepository) DeleteExpired(ctx context.Context, before time.Time) error {
+	args := m.Called(before)
+	return args.Error(0)
+}
+
+func TestBegin_NewKey(t *testing.T) {
+	mockRepo := new(MockRepository)
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	service := NewService(mockRepo, time.Hour, clock.NewFake(now))
+
+	mockRepo.On("Claim", mock.MatchedBy(func(r Record) bool {
+		return r.UserID == "u1" && r.Key == "k1" && r.Fingerprint == "f1" && !r.Completed() && r.CreatedAt.Equal(now)
+	}), now.Add(-time.Hour)).Return(nil, nil)
+
+	record, err := service.Begin(context.Background(), "u1", "k1", "f1")
+	assert.NoError(t, err)
+	assert.Nil(t, record)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestBegin_ExistingKey(t *testing.T) {
+	stored := &Record{UserID: "u1", Key: "k1", Fingerprint: "f1", StatusCode: 201, Body: []byte(`{"id":"p1"}`)}
+	inProgress := &Record{UserID: "u1", Key: "k2", Fingerprint: "f1"}
+
+	mockRepo := new(MockRepository)
+	mockRepo.On("Claim", mock.MatchedBy(func(r Record) bool { return r.Key == "k1" }), mock.Anything).Return(stored, nil)
+	mockRepo.On("Claim", mock.MatchedBy(func(r Record) bool { return r.Key == "k2" }), mock.Anything).Return(inProgress, nil)
+	service := NewService(mockRepo, 0, clock.New(time.UTC))
+
+	// A retry of the same request replays the stored response
+	record, err := service.Begin(context.Background(), "u1", "k1", "f1")
+	assert.NoError(t, err)
+	assert.Equal(t, stored, record)
+
+	// The same key with a different body is rejected
+	_, err = service.Begin(context.Background(), "u1", "k1", "f2")
+	assert.ErrorIs(t, err, apperr.ErrValidation)
+
+	// A retry while the original is still running must wait
+	_, err = service.Begin(context.Background(), "u1", "k2", "f1")
+	assert.ErrorIs(t, err, apperr.ErrConflict)
+}
+
+func TestBegin_KeyTooLong(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, 0, clock.New(time.UTC))
+
+	_, err := service.Begin(context.Background(), "u1", strings.Repeat("k", 256), "f1")
+	assert.ErrorIs(t, err, apperr.ErrValidation)
+	mockRepo.AssertNotCalled(t, "Claim", mock.Anything, mock.Anything)
+}
@@ -2,14 +2,24 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/BodaciousX/RVParkBackend/api"
+	"github.com/BodaciousX/RVParkBackend/apperr"
+	"github.com/BodaciousX/RVParkBackend/clock"
+	"github.com/BodaciousX/RVParkBackend/idempotency"
+	"github.com/BodaciousX/RVParkBackend/job"
 	"github.com/BodaciousX/RVParkBackend/middleware"
+	"github.com/BodaciousX/RVParkBackend/notify"
+	"github.com/BodaciousX/RVParkBackend/paging"
 	"github.com/BodaciousX/RVParkBackend/payment"
 	"github.com/BodaciousX/RVParkBackend/space"
 	"github.com/BodaciousX/RVParkBackend/tenant"
@@ -24,12 +34,12 @@ type MockUserService struct {
 	mock.Mock
 }
 
-func (m *MockUserService) CreateUser(user user.User, password string) error {
+func (m *MockUserService) CreateUser(ctx context.Context, user user.User, password string) error {
 	args := m.Called(user, password)
 	return args.Error(0)
 }
 
-func (m *MockUserService) GetUser(id string) (*user.User, error) {
+func (m *MockUserService) GetUser(ctx context.Context, id string) (*user.User, error) {
 	args := m.Called(id)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -37,7 +47,7 @@ func (m *MockUserService) GetUser(id string) (*user.User, error) {
 	return args.Get(0).(*user.User), args.Error(1)
 }
 
-func (m *MockUserService) GetUserByEmail(email string) (*user.User, error) {
+func (m *MockUserService) GetUserByEmail(ctx context.Context, email string) (*user.User, error) {
 	args := m.Called(email)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -45,57 +55,239 @@ func (m *MockUserService) GetUserByEmail(email string) (*user.User, error) {
 	return args.Get(0).(*user.User), args.Error(1)
 }
 
-func (m *MockUserService) UpdateUser(user user.User) error {
+func (m *MockUserService) UpdateUser(ctx context.Context, user user.User) error {
 	args := m.Called(user)
 	return args.Error(0)
 }
 
-func (m *MockUserService) DeleteUser(id string) error {
+func (m *MockUserService) DeleteUser(ctx context.Context, id string) error {
 	args := m.Called(id)
 	return args.Error(0)
 }
 
-func (m *MockUserService) Login(creds user.LoginCredentials) (*user.User, string, error) {
+func (m *MockUserService) Login(ctx context.Context, creds user.LoginCredentials) (*user.LoginResult, error) {
 	args := m.Called(creds)
 	if args.Get(0) == nil {
-		return nil, "", args.Error(2)
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*user.LoginResult), args.Error(1)
+}
+
+func (m *MockUserService) VerifyLoginChallenge(ctx context.Context, resp user.ChallengeResponse) (*user.LoginResult, error) {
+	args := m.Called(resp)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*user.LoginResult), args.Error(1)
+}
+
+func (m *MockUserService) EnrollTOTPForChallenge(ctx context.Context, challenge string) (*user.TOTPEnrollment, error) {
+	args := m.Called(challenge)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*user.TOTPEnrollment), args.Error(1)
+}
+
+func (m *MockUserService) StartOIDCLogin(ctx context.Context) (string, error) {
+	args := m.Called()
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockUserService) CompleteOIDCLogin(ctx context.Context, callback user.OIDCCallback) (*user.LoginResult, error) {
+	args := m.Called(callback)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*user.LoginResult), args.Error(1)
+}
+
+func (m *MockUserService) CleanOIDCLogins(ctx context.Context) error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func (m *MockUserService) ValidateToken(ctx context.Context, token string) (*user.User, error) {
+	args := m.Called(token)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
 	}
-	return args.Get(0).(*user.User), args.String(1), args.Error(2)
+	return args.Get(0).(*user.User), args.Error(1)
+}
+
+func (m *MockUserService) ChangePassword(ctx context.Context, userID, currentToken, oldPassword, newPassword string) error {
+	args := m.Called(userID, currentToken, oldPassword, newPassword)
+	return args.Error(0)
+}
+
+func (m *MockUserService) ResetPassword(ctx context.Context, userID string, newPassword string) error {
+	args := m.Called(userID, newPassword)
+	return args.Error(0)
 }
 
-func (m *MockUserService) ValidateToken(token string) (*user.User, error) {
+func (m *MockUserService) Logout(ctx context.Context, token string) error {
 	args := m.Called(token)
+	return args.Error(0)
+}
+
+func (m *MockUserService) ListSessions(ctx context.Context, userID string) ([]user.Token, error) {
+	args := m.Called(userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]user.Token), args.Error(1)
+}
+
+func (m *MockUserService) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	args := m.Called(userID, sessionID)
+	return args.Error(0)
+}
+
+func (m *MockUserService) RevokeAllTokens(ctx context.Context, userID string) error {
+	args := m.Called(userID)
+	return args.Error(0)
+}
+
+func (m *MockUserService) UnlockUser(ctx context.Context, userID string) error {
+	args := m.Called(userID)
+	return args.Error(0)
+}
+
+func (m *MockUserService) ListLoginEvents(ctx context.Context, userID string, limit int) ([]user.LoginEvent, error) {
+	args := m.Called(userID, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]user.LoginEvent), args.Error(1)
+}
+
+func (m *MockUserService) CleanLoginThrottles(ctx context.Context) error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func (m *MockUserService) RequestPasswordReset(ctx context.Context, email string) error {
+	args := m.Called(email)
+	return args.Error(0)
+}
+
+func (m *MockUserService) ConfirmPasswordReset(ctx context.Context, token, newPassword string) error {
+	args := m.Called(token, newPassword)
+	return args.Error(0)
+}
+
+func (m *MockUserService) InviteUser(ctx context.Context, u user.User) (*user.User, error) {
+	args := m.Called(u)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).(*user.User), args.Error(1)
 }
 
-func (m *MockUserService) ChangePassword(userID string, oldPassword, newPassword string) error {
-	args := m.Called(userID, oldPassword, newPassword)
+func (m *MockUserService) AcceptInvite(ctx context.Context, token, password string) error {
+	args := m.Called(token, password)
+	return args.Error(0)
+}
+
+func (m *MockUserService) CleanAccountTokens(ctx context.Context) error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func (m *MockUserService) GetTwoFactorStatus(ctx context.Context, userID string) (*user.TwoFactorStatus, error) {
+	args := m.Called(userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*user.TwoFactorStatus), args.Error(1)
+}
+
+func (m *MockUserService) EnrollTOTP(ctx context.Context, userID string) (*user.TOTPEnrollment, error) {
+	args := m.Called(userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*user.TOTPEnrollment), args.Error(1)
+}
+
+func (m *MockUserService) ConfirmTOTP(ctx context.Context, userID, code string) ([]string, error) {
+	args := m.Called(userID, code)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *MockUserService) DisableTwoFactor(ctx context.Context, userID, code string) error {
+	args := m.Called(userID, code)
 	return args.Error(0)
 }
 
-func (m *MockUserService) RevokeAllTokens(userID string) error {
+func (m *MockUserService) RegenerateRecoveryCodes(ctx context.Context, userID, code string) ([]string, error) {
+	args := m.Called(userID, code)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *MockUserService) ResetTwoFactor(ctx context.Context, userID string) error {
 	args := m.Called(userID)
 	return args.Error(0)
 }
 
+func (m *MockUserService) CreateAPIKey(ctx context.Context, key user.APIKey) (*user.APIKey, string, error) {
+	args := m.Called(key)
+	if args.Get(0) == nil {
+		return nil, "", args.Error(2)
+	}
+	return args.Get(0).(*user.APIKey), args.String(1), args.Error(2)
+}
+
+func (m *MockUserService) ListAPIKeys(ctx context.Context) ([]user.APIKey, error) {
+	args := m.Called()
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]user.APIKey), args.Error(1)
+}
+
+func (m *MockUserService) RevokeAPIKey(ctx context.Context, id string) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockUserService) ValidateAPIKey(ctx context.Context, key string) (*user.User, *user.APIKey, error) {
+	args := m.Called(key)
+	if args.Get(0) == nil {
+		return nil, nil, args.Error(2)
+	}
+	return args.Get(0).(*user.User), args.Get(1).(*user.APIKey), args.Error(2)
+}
+
+func (m *MockUserService) FindUsers(ctx context.Context, filter user.Filter, page paging.Request) (*paging.Page[user.User], error) {
+	args := m.Called(filter, page)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*paging.Page[user.User]), args.Error(1)
+}
+
 type MockTenantService struct {
 	mock.Mock
 }
 
-func (m *MockTenantService) ListTenants() ([]tenant.Tenant, error) {
+func (m *MockTenantService) ListTenants(ctx context.Context) ([]tenant.Tenant, error) {
 	args := m.Called()
 	return args.Get(0).([]tenant.Tenant), args.Error(1)
 }
 
-func (m *MockTenantService) CreateTenant(tenant tenant.Tenant) error {
+func (m *MockTenantService) CreateTenant(ctx context.Context, tenant tenant.Tenant) error {
 	args := m.Called(tenant)
 	return args.Error(0)
 }
 
-func (m *MockTenantService) GetTenant(id string) (*tenant.Tenant, error) {
+func (m *MockTenantService) GetTenant(ctx context.Context, id string) (*tenant.Tenant, error) {
 	args := m.Called(id)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -103,17 +295,17 @@ func (m *MockTenantService) GetTenant(id string) (*tenant.Tenant, error) {
 	return args.Get(0).(*tenant.Tenant), args.Error(1)
 }
 
-func (m *MockTenantService) UpdateTenant(tenant tenant.Tenant) error {
+func (m *MockTenantService) UpdateTenant(ctx context.Context, tenant tenant.Tenant) error {
 	args := m.Called(tenant)
 	return args.Error(0)
 }
 
-func (m *MockTenantService) DeleteTenant(id string) error {
+func (m *MockTenantService) DeleteTenant(ctx context.Context, id string) error {
 	args := m.Called(id)
 	return args.Error(0)
 }
 
-func (m *MockTenantService) GetTenantBySpace(spaceID string) (*tenant.Tenant, error) {
+func (m *MockTenantService) GetTenantBySpace(ctx context.Context, spaceID string) (*tenant.Tenant, error) {
 	args := m.Called(spaceID)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -121,16 +313,24 @@ func (m *MockTenantService) GetTenantBySpace(spaceID string) (*tenant.Tenant, er
 	return args.Get(0).(*tenant.Tenant), args.Error(1)
 }
 
+func (m *MockTenantService) FindTenants(ctx context.Context, filter tenant.Filter, page paging.Request) (*paging.Page[tenant.Tenant], error) {
+	args := m.Called(filter, page)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*paging.Page[tenant.Tenant]), args.Error(1)
+}
+
 type MockSpaceService struct {
 	mock.Mock
 }
 
-func (m *MockSpaceService) ListSpaces() (map[string][]space.Space, error) {
+func (m *MockSpaceService) ListSpaces(ctx context.Context) (map[string][]space.Space, error) {
 	args := m.Called()
 	return args.Get(0).(map[string][]space.Space), args.Error(1)
 }
 
-func (m *MockSpaceService) GetSpace(id string) (*space.Space, error) {
+func (m *MockSpaceService) GetSpace(ctx context.Context, id string) (*space.Space, error) {
 	args := m.Called(id)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -138,32 +338,32 @@ func (m *MockSpaceService) GetSpace(id string) (*space.Space, error) {
 	return args.Get(0).(*space.Space), args.Error(1)
 }
 
-func (m *MockSpaceService) GetVacantSpaces() ([]space.Space, error) {
+func (m *MockSpaceService) GetVacantSpaces(ctx context.Context) ([]space.Space, error) {
 	args := m.Called()
 	return args.Get(0).([]space.Space), args.Error(1)
 }
 
-func (m *MockSpaceService) ReserveSpace(spaceID string) error {
+func (m *MockSpaceService) ReserveSpace(ctx context.Context, spaceID string) error {
 	args := m.Called(spaceID)
 	return args.Error(0)
 }
 
-func (m *MockSpaceService) UnreserveSpace(spaceID string) error {
+func (m *MockSpaceService) UnreserveSpace(ctx context.Context, spaceID string) error {
 	args := m.Called(spaceID)
 	return args.Error(0)
 }
 
-func (m *MockSpaceService) MoveIn(spaceID string, tenantID string) error {
+func (m *MockSpaceService) MoveIn(ctx context.Context, spaceID string, tenantID string) error {
 	args := m.Called(spaceID, tenantID)
 	return args.Error(0)
 }
 
-func (m *MockSpaceService) MoveOut(spaceID string) error {
+func (m *MockSpaceService) MoveOut(ctx context.Context, spaceID string) error {
 	args := m.Called(spaceID)
 	return args.Error(0)
 }
 
-func (m *MockSpaceService) UpdateSpace(space space.Space) error {
+func (m *MockSpaceService) UpdateSpace(ctx context.Context, space space.Space) error {
 	args := m.Called(space)
 	return args.Error(0)
 }
@@ -172,12 +372,12 @@ type MockPaymentService struct {
 	mock.Mock
 }
 
-func (m *MockPaymentService) CreatePayment(payment payment.Payment) error {
+func (m *MockPaymentService) CreatePayment(ctx context.Context, payment payment.Payment) error {
 	args := m.Called(payment)
 	return args.Error(0)
 }
 
-func (m *MockPaymentService) GetPayment(id string) (*payment.Payment, error) {
+func (m *MockPaymentService) GetPayment(ctx context.Context, id string) (*payment.Payment, error) {
 	args := m.Called(id)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -185,27 +385,32 @@ func (m *MockPaymentService) GetPayment(id string) (*payment.Payment, error) {
 	return args.Get(0).(*payment.Payment), args.Error(1)
 }
 
-func (m *MockPaymentService) UpdatePayment(payment payment.Payment) error {
+func (m *MockPaymentService) UpdatePayment(ctx context.Context, payment payment.Payment) error {
 	args := m.Called(payment)
 	return args.Error(0)
 }
 
-func (m *MockPaymentService) DeletePayment(id string) error {
+func (m *MockPaymentService) DeletePayment(ctx context.Context, id string) error {
 	args := m.Called(id)
 	return args.Error(0)
 }
 
-func (m *MockPaymentService) GetTenantPayments(tenantID string) ([]payment.Payment, error) {
+func (m *MockPaymentService) GetTenantPayments(ctx context.Context, tenantID string) ([]payment.Payment, error) {
 	args := m.Called(tenantID)
 	return args.Get(0).([]payment.Payment), args.Error(1)
 }
 
-func (m *MockPaymentService) GetPaymentsByDateRange(start, end time.Time) ([]payment.Payment, error) {
+func (m *MockPaymentService) GetTenantPaymentsByDateRange(ctx context.Context, tenantID string, start, end time.Time) ([]payment.Payment, error) {
+	args := m.Called(tenantID, start, end)
+	return args.Get(0).([]payment.Payment), args.Error(1)
+}
+
+func (m *MockPaymentService) GetPaymentsByDateRange(ctx context.Context, start, end time.Time) ([]payment.Payment, error) {
 	args := m.Called(start, end)
 	return args.Get(0).([]payment.Payment), args.Error(1)
 }
 
-func (m *MockPaymentService) GetLatestPayment(tenantID string) (*payment.Payment, error) {
+func (m *MockPaymentService) GetLatestPayment(ctx context.Context, tenantID string) (*payment.Payment, error) {
 	args := m.Called(tenantID)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -213,6 +418,121 @@ func (m *MockPaymentService) GetLatestPayment(tenantID string) (*payment.Payment
 	return args.Get(0).(*payment.Payment), args.Error(1)
 }
 
+func (m *MockPaymentService) GetUnpaidPaymentsDueBetween(ctx context.Context, start, end time.Time) ([]payment.Payment, error) {
+	args := m.Called(start, end)
+	return args.Get(0).([]payment.Payment), args.Error(1)
+}
+
+func (m *MockPaymentService) GetPaymentsPaidBetween(ctx context.Context, start, end time.Time) ([]payment.Payment, error) {
+	args := m.Called(start, end)
+	return args.Get(0).([]payment.Payment), args.Error(1)
+}
+
+func (m *MockPaymentService) FindPayments(ctx context.Context, filter payment.Filter, page paging.Request) (*paging.Page[payment.Payment], error) {
+	args := m.Called(filter, page)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*paging.Page[payment.Payment]), args.Error(1)
+}
+
+type MockJobService struct {
+	mock.Mock
+}
+
+func (m *MockJobService) Register(name, spec, description string, run func() error) error {
+	args := m.Called(name, spec, description, run)
+	return args.Error(0)
+}
+
+func (m *MockJobService) Start() {
+	m.Called()
+}
+
+func (m *MockJobService) Stop() {
+	m.Called()
+}
+
+func (m *MockJobService) ListJobs() ([]job.JobStatus, error) {
+	args := m.Called()
+	return args.Get(0).([]job.JobStatus), args.Error(1)
+}
+
+func (m *MockJobService) ListRuns(name string, limit int) ([]job.Run, error) {
+	args := m.Called(name, limit)
+	return args.Get(0).([]job.Run), args.Error(1)
+}
+
+func (m *MockJobService) Trigger(name string) (*job.Run, error) {
+	args := m.Called(name)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*job.Run), args.Error(1)
+}
+
+type MockNotifyService struct {
+	mock.Mock
+}
+
+func (m *MockNotifyService) SendRentReminders() error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func (m *MockNotifyService) SendReceipts() error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func (m *MockNotifyService) RetryFailed() error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func (m *MockNotifyService) ListTenantNotifications(tenantID string) ([]notify.Notification, error) {
+	args := m.Called(tenantID)
+	return args.Get(0).([]notify.Notification), args.Error(1)
+}
+
+// memoryIdempotencyRepository keeps Idempotency-Key records in a map
+type memoryIdempotencyRepository struct {
+	mu      sync.Mutex
+	records map[string]idempotency.Record
+}
+
+func (r *memoryIdempotencyRepository) Claim(ctx context.Context, record idempotency.Record, expiredBefore time.Time) (*idempotency.Record, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id := record.UserID + "/" + record.Key
+	if existing, ok := r.records[id]; ok && !existing.CreatedAt.Before(expiredBefore) {
+		return &existing, nil
+	}
+	r.records[id] = record
+	return nil, nil
+}
+
+func (r *memoryIdempotencyRepository) Complete(ctx context.Context, record idempotency.Record) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id := record.UserID + "/" + record.Key
+	existing := r.records[id]
+	existing.StatusCode, existing.Header, existing.Body = record.StatusCode, record.Header, record.Body
+	r.records[id] = existing
+	return nil
+}
+
+func (r *memoryIdempotencyRepository) Delete(ctx context.Context, userID, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.records, userID+"/"+key)
+	return nil
+}
+
+func (r *memoryIdempotencyRepository) DeleteExpired(ctx context.Context, before time.Time) error {
+	return nil
+}
+
 // Helper function to set up the server with mock services
 func setupTestServer() (*api.Server, *MockUserService, *MockTenantService, *MockSpaceService, *MockPaymentService) {
 	mockUserService := new(MockUserService)
@@ -220,8 +540,12 @@ func setupTestServer() (*api.Server, *MockUserService, *MockTenantService, *Mock
 	mockSpaceService := new(MockSpaceService)
 	mockPaymentService := new(MockPaymentService)
 
-	// Create auth middleware with mock user service
-	authMiddleware := middleware.NewAuthMiddleware(mockUserService)
+	// Create auth middleware with mock user service, accepting cookie sessions
+	cookies := middleware.DefaultCookieConfig()
+	cookies.Enabled = true
+	// Requests from 10.1.0.0/16 come through a trusted proxy
+	proxies := []netip.Prefix{netip.MustParsePrefix("10.1.0.0/16")}
+	authMiddleware := middleware.NewAuthMiddleware(mockUserService, cookies, proxies)
 
 	// Create server with mock services
 	server := api.NewServer(
@@ -229,6 +553,9 @@ func setupTestServer() (*api.Server, *MockUserService, *MockTenantService, *Mock
 		mockTenantService,
 		mockSpaceService,
 		mockPaymentService,
+		new(MockJobService),
+		new(MockNotifyService),
+		idempotency.NewService(&memoryIdempotencyRepository{records: map[string]idempotency.Record{}}, time.Hour, clock.New(time.UTC)),
 		authMiddleware,
 	)
 
@@ -252,7 +579,7 @@ func TestLogin(t *testing.T) {
 	// Setup login expectation
 	mockUserService.On("Login", mock.MatchedBy(func(creds user.LoginCredentials) bool {
 		return creds.Email == "test@example.com" && creds.Password == "password123"
-	})).Return(testUser, testToken, nil)
+	})).Return(&user.LoginResult{User: testUser, Token: testToken}, nil)
 
 	// Create login request
 	loginReq := api.LoginRequest{
@@ -327,7 +654,9 @@ func TestCreateTenant(t *testing.T) {
 
 	// Setup expectations
 	mockUserService.On("ValidateToken", "test-token").Return(testUser, nil)
-	mockTenantService.On("CreateTenant", mock.AnythingOfType("tenant.Tenant")).Return(nil)
+	mockTenantService.On("CreateTenant", mock.MatchedBy(func(t tenant.Tenant) bool {
+		return t.Phone == "+15551234567" && t.SMSOptOut
+	})).Return(nil)
 
 	// Create tenant request
 	moveInDate := time.Now()
@@ -335,6 +664,8 @@ func TestCreateTenant(t *testing.T) {
 		Name:       "John Doe",
 		MoveInDate: moveInDate,
 		SpaceID:    "A1",
+		Phone:      "+15551234567",
+		SMSOptOut:  true,
 	}
 	tenantBody, _ := json.Marshal(createTenantReq)
 	req, _ := http.NewRequest("POST", "/tenants", bytes.NewBuffer(tenantBody))
@@ -352,6 +683,8 @@ func TestCreateTenant(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "John Doe", respTenant.Name)
 	assert.Equal(t, "A1", respTenant.SpaceID)
+	assert.Equal(t, "+15551234567", respTenant.Phone)
+	assert.True(t, respTenant.SMSOptOut)
 
 	// Assert expectations
 	mockUserService.AssertExpectations(t)
@@ -375,18 +708,19 @@ func TestCreatePayment(t *testing.T) {
 	// Setup expectations
 	mockUserService.On("ValidateToken", "test-token").Return(testUser, nil)
 	mockPaymentService.On("CreatePayment", mock.AnythingOfType("payment.Payment")).Return(nil)
+	mockPaymentService.On("GetPayment", mock.AnythingOfType("string")).
+		Return(&payment.Payment{ID: "p1", TenantID: tenantID, AmountDue: payment.Dollars(500), Version: 1}, nil)
 
 	// Create payment request
 	dueDate := time.Now().Add(24 * time.Hour)
 	nextPaymentDate := time.Now().Add(30 * 24 * time.Hour)
-	paidDate := time.Time{} // Zero time for unpaid payment
 
+	// No paid date for an unpaid payment
 	paymentReq := api.CreatePaymentRequest{
 		TenantID:        tenantID,
-		AmountDue:       500.0,
+		AmountDue:       payment.Dollars(500),
 		DueDate:         dueDate,
 		NextPaymentDate: nextPaymentDate,
-		PaidDate:        paidDate,
 	}
 	paymentBody, _ := json.Marshal(paymentReq)
 	req, _ := http.NewRequest("POST", "/payments", bytes.NewBuffer(paymentBody))
@@ -404,6 +738,80 @@ func TestCreatePayment(t *testing.T) {
 	mockPaymentService.AssertExpectations(t)
 }
 
+// memoryPaymentRepository keeps payments in a map. Only the methods the
+// tests use are implemented; the rest panic through the nil interface.
+type memoryPaymentRepository struct {
+	payment.Repository
+	mu       sync.Mutex
+	payments map[string]payment.Payment
+}
+
+func (r *memoryPaymentRepository) Create(ctx context.Context, p payment.Payment) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.payments[p.ID] = p
+	return nil
+}
+
+func (r *memoryPaymentRepository) Get(ctx context.Context, id string) (*payment.Payment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.payments[id]
+	if !ok {
+		return nil, apperr.NotFound("payment %s not found", id)
+	}
+	return &p, nil
+}
+
+func (r *memoryPaymentRepository) ListUnpaidByDueDateRange(ctx context.Context, start, end time.Time) ([]payment.Payment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var unpaid []payment.Payment
+	for _, p := range r.payments {
+		if p.PaidDate == nil && !p.DueDate.Before(start) && !p.DueDate.After(end) {
+			unpaid = append(unpaid, p)
+		}
+	}
+	return unpaid, nil
+}
+
+// Payments created without a paid date, or with the zero time older clients
+// send, are due reminders
+func TestCreateUnpaidPayment(t *testing.T) {
+	mockUserService := new(MockUserService)
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	payments := payment.NewService(&memoryPaymentRepository{payments: map[string]payment.Payment{}}, clock.NewFake(now))
+	server := api.NewServer(
+		mockUserService,
+		new(MockTenantService),
+		new(MockSpaceService),
+		payments,
+		new(MockJobService),
+		new(MockNotifyService),
+		idempotency.NewService(&memoryIdempotencyRepository{records: map[string]idempotency.Record{}}, time.Hour, clock.New(time.UTC)),
+		middleware.NewAuthMiddleware(mockUserService, middleware.DefaultCookieConfig(), nil),
+	)
+	mockUserService.On("ValidateToken", "test-token").Return(&user.User{ID: uuid.New().String(), Role: user.RoleStaff}, nil)
+
+	for _, body := range []string{
+		`{"tenantId": "t1", "amountDue": 500, "dueDate": "2024-06-05T00:00:00Z", "nextPaymentDate": "2024-07-05T00:00:00Z"}`,
+		`{"tenantId": "t2", "amountDue": 500, "dueDate": "2024-06-06T00:00:00Z", "nextPaymentDate": "2024-07-06T00:00:00Z", "paidDate": "0001-01-01T00:00:00Z"}`,
+	} {
+		req, _ := http.NewRequest("POST", "/payments", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer test-token")
+		rr := httptest.NewRecorder()
+		server.Mux.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+		assert.NotContains(t, rr.Body.String(), "paidDate")
+		// Timestamps come from the park clock
+		assert.Contains(t, rr.Body.String(), `"createdAt":"2024-06-01T12:00:00Z"`)
+	}
+
+	unpaid, err := payments.GetUnpaidPaymentsDueBetween(context.Background(), now, now.AddDate(0, 0, 7))
+	assert.NoError(t, err)
+	assert.Len(t, unpaid, 2)
+}
+
 // 5. List Vacant Spaces - Test get vacant spaces functionality
 func TestGetVacantSpaces(t *testing.T) {
 	// Setup test server with mock services
@@ -458,3 +866,807 @@ func TestGetVacantSpaces(t *testing.T) {
 	mockUserService.AssertExpectations(t)
 	mockSpaceService.AssertExpectations(t)
 }
+
+// 6. Job Administration - Test manually triggering a background job
+func TestTriggerJob(t *testing.T) {
+	mockUserService := new(MockUserService)
+	mockJobService := new(MockJobService)
+	server := api.NewServer(
+		mockUserService,
+		new(MockTenantService),
+		new(MockSpaceService),
+		new(MockPaymentService),
+		mockJobService,
+		new(MockNotifyService),
+		nil,
+		middleware.NewAuthMiddleware(mockUserService, middleware.DefaultCookieConfig(), nil),
+	)
+
+	adminUser := &user.User{
+		ID:       uuid.New().String(),
+		Email:    "admin@example.com",
+		Username: "admin",
+		Role:     user.RoleAdmin,
+	}
+	staffUser := &user.User{
+		ID:       uuid.New().String(),
+		Email:    "staff@example.com",
+		Username: "staff",
+		Role:     user.RoleStaff,
+	}
+
+	// Setup expectations
+	mockUserService.On("ValidateToken", "admin-token").Return(adminUser, nil)
+	mockUserService.On("ValidateToken", "staff-token").Return(staffUser, nil)
+	mockJobService.On("Trigger", "clean-expired-tokens").Return(&job.Run{
+		JobName: "clean-expired-tokens",
+		Trigger: job.TriggerManual,
+		Status:  job.RunSucceeded,
+	}, nil)
+	mockJobService.On("Trigger", "missing").Return(nil, job.ErrJobNotFound)
+
+	// Staff may not trigger jobs
+	req, _ := http.NewRequest("POST", "/jobs/clean-expired-tokens/run", nil)
+	req.Header.Set("Authorization", "Bearer staff-token")
+	rr := httptest.NewRecorder()
+	server.Mux.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	// Admin triggers a run
+	req, _ = http.NewRequest("POST", "/jobs/clean-expired-tokens/run", nil)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	rr = httptest.NewRecorder()
+	server.Mux.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var run job.Run
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &run))
+	assert.Equal(t, job.RunSucceeded, run.Status)
+
+	// Unknown job
+	req, _ = http.NewRequest("POST", "/jobs/missing/run", nil)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	rr = httptest.NewRecorder()
+	server.Mux.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	mockJobService.AssertExpectations(t)
+}
+
+// Domain errors map to status codes with a JSON error envelope
+func TestDomainErrorResponses(t *testing.T) {
+	server, mockUserService, mockTenantService, mockSpaceService, _ := setupTestServer()
+
+	testUser := &user.User{
+		ID:       uuid.New().String(),
+		Email:    "staff@example.com",
+		Username: "staff",
+		Role:     user.RoleStaff,
+	}
+	mockUserService.On("ValidateToken", "test-token").Return(testUser, nil)
+
+	invalid := &apperr.ValidationError{}
+	invalid.Add("name", "tenant name is required")
+	invalid.Add("spaceId", "space ID is required")
+
+	mockSpaceService.On("MoveOut", "A1").Return(apperr.Conflict("space A1 is not occupied"))
+	mockTenantService.On("GetTenant", "missing").Return(nil, apperr.NotFound("tenant missing not found"))
+	mockTenantService.On("CreateTenant", mock.AnythingOfType("tenant.Tenant")).Return(invalid)
+	mockSpaceService.On("UnreserveSpace", "B2").Return(errors.New("connection reset"))
+
+	testCases := []struct {
+		name    string
+		method  string
+		path    string
+		body    string
+		status  int
+		code    string
+		message string
+		fields  int
+	}{
+		{"conflict", "POST", "/spaces/A1/move-out", "", http.StatusConflict, "conflict", "space A1 is not occupied", 0},
+		{"not found", "GET", "/tenants/missing", "", http.StatusNotFound, "not_found", "tenant missing not found", 0},
+		{"validation", "POST", "/tenants", `{}`, http.StatusUnprocessableEntity, "validation_failed", "tenant name is required; space ID is required", 2},
+		{"internal", "POST", "/spaces/B2/unreserve", "", http.StatusInternalServerError, "internal_error", "internal server error", 0},
+		{"bad request", "POST", "/tenants", `{`, http.StatusBadRequest, "bad_request", "invalid request body", 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tc.method, tc.path, bytes.NewBufferString(tc.body))
+			req.Header.Set("Authorization", "Bearer test-token")
+
+			rr := httptest.NewRecorder()
+			server.Mux.ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.status, rr.Code)
+			assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+			var resp api.ErrorResponse
+			assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+			assert.Equal(t, tc.code, resp.Error.Code)
+			assert.Equal(t, tc.message, resp.Error.Message)
+			assert.Len(t, resp.Error.Fields, tc.fields)
+		})
+	}
+}
+
+// List endpoints return a JSON array with paging metadata in headers
+func TestListTenantsPagination(t *testing.T) {
+	server, mockUserService, mockTenantService, _, _ := setupTestServer()
+
+	testUser := &user.User{ID: uuid.New().String(), Role: user.RoleStaff}
+	mockUserService.On("ValidateToken", "test-token").Return(testUser, nil)
+	mockTenantService.On("FindTenants",
+		tenant.Filter{Search: "jo", Section: "Mane Street"},
+		paging.Request{Limit: 1, Sort: "-moveInDate"},
+	).Return(&paging.Page[tenant.Tenant]{
+		Items:      []tenant.Tenant{{ID: "t1", Name: "John Doe"}},
+		Total:      2,
+		NextCursor: "abc",
+	}, nil)
+
+	req, _ := http.NewRequest("GET", "/v1/tenants?search=jo&section=Mane+Street&limit=1&sort=-moveInDate", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+	server.Mux.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "2", rr.Header().Get("X-Total-Count"))
+	assert.Equal(t, "abc", rr.Header().Get("X-Next-Cursor"))
+	assert.Contains(t, rr.Header().Get("Link"), "cursor=abc")
+	assert.Contains(t, rr.Header().Get("Link"), `rel="next"`)
+
+	var tenants []tenant.Tenant
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &tenants))
+	assert.Len(t, tenants, 1)
+
+	// Bad paging parameters are rejected
+	req, _ = http.NewRequest("GET", "/tenants?limit=lots", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr = httptest.NewRecorder()
+	server.Mux.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+	mockTenantService.AssertExpectations(t)
+}
+
+func TestListPaymentsFilters(t *testing.T) {
+	server, mockUserService, _, _, mockPaymentService := setupTestServer()
+
+	testUser := &user.User{ID: uuid.New().String(), Role: user.RoleStaff}
+	mockUserService.On("ValidateToken", "test-token").Return(testUser, nil)
+	mockPaymentService.On("FindPayments", mock.MatchedBy(func(f payment.Filter) bool {
+		return f.TenantID == "t1" && f.Paid != nil && !*f.Paid &&
+			f.MinAmount != nil && *f.MinAmount == payment.Dollars(100) && f.MaxAmount == nil &&
+			f.DateField == "" && f.From.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	}), paging.Request{}).Return(&paging.Page[payment.Payment]{
+		Items: []payment.Payment{{ID: "p1", TenantID: "t1"}},
+		Total: 1,
+	}, nil)
+
+	req, _ := http.NewRequest("GET",
+		"/v1/payments?start=2024-01-01T00:00:00Z&end=2024-12-31T00:00:00Z&tenant=t1&paid=false&minAmount=100", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+	server.Mux.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "1", rr.Header().Get("X-Total-Count"))
+	assert.Empty(t, rr.Header().Get("Link"))
+	mockPaymentService.AssertExpectations(t)
+}
+
+// A tenant's history can be listed without a date range, or by paid date
+func TestListPaymentsTenantHistory(t *testing.T) {
+	server, mockUserService, _, _, mockPaymentService := setupTestServer()
+
+	testUser := &user.User{ID: uuid.New().String(), Role: user.RoleStaff}
+	mockUserService.On("ValidateToken", "test-token").Return(testUser, nil)
+	mockPaymentService.On("FindPayments", payment.Filter{TenantID: "t1"}, paging.Request{}).
+		Return(&paging.Page[payment.Payment]{Items: []payment.Payment{}}, nil)
+	mockPaymentService.On("FindPayments", payment.Filter{
+		TenantID:  "t1",
+		DateField: payment.DatePaid,
+		From:      time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC),
+	}, paging.Request{}).Return(&paging.Page[payment.Payment]{Items: []payment.Payment{}}, nil)
+
+	for _, path := range []string{
+		"/payments?tenant=t1",
+		"/payments?tenant=t1&dateField=paidDate&start=2024-06-01T00:00:00Z",
+	} {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer test-token")
+		rr := httptest.NewRecorder()
+		server.Mux.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code, path)
+	}
+
+	// Without a tenant the range is still required
+	req, _ := http.NewRequest("GET", "/payments?start=2024-06-01T00:00:00Z", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+	server.Mux.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	mockPaymentService.AssertExpectations(t)
+}
+
+// Routes are served under /v1, with the unversioned paths kept as deprecated aliases
+func TestVersionedRoutes(t *testing.T) {
+	server, mockUserService, _, mockSpaceService, _ := setupTestServer()
+
+	testUser := &user.User{ID: uuid.New().String(), Role: user.RoleStaff}
+	mockUserService.On("ValidateToken", "test-token").Return(testUser, nil)
+	mockSpaceService.On("GetSpace", "A1").Return(&space.Space{ID: "A1"}, nil)
+
+	req, _ := http.NewRequest("GET", "/v1/spaces/A1", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+	server.Mux.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, rr.Header().Get("Deprecation"))
+
+	req, _ = http.NewRequest("GET", "/spaces/A1", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr = httptest.NewRecorder()
+	server.Mux.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "true", rr.Header().Get("Deprecation"))
+	assert.Equal(t, `</v1/spaces/A1>; rel="successor-version"`, rr.Header().Get("Link"))
+
+	// Known paths with the wrong method get a 405 listing the allowed methods
+	req, _ = http.NewRequest("DELETE", "/v1/spaces/A1", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr = httptest.NewRecorder()
+	server.Mux.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	assert.Equal(t, "GET, PUT", rr.Header().Get("Allow"))
+
+	// Unknown paths get a JSON 404
+	req, _ = http.NewRequest("GET", "/v1/spaces/A1/paint", nil)
+	rr = httptest.NewRecorder()
+	server.Mux.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"not_found"`)
+
+	// CORS preflight requests are answered without authentication
+	req, _ = http.NewRequest("OPTIONS", "/v1/spaces/A1", nil)
+	rr = httptest.NewRecorder()
+	server.Mux.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotEmpty(t, rr.Header().Get("Access-Control-Allow-Methods"))
+}
+
+// Updates must name the version they were based on with If-Match
+func TestTenantUpdateConcurrency(t *testing.T) {
+	server, mockUserService, mockTenantService, _, _ := setupTestServer()
+
+	testUser := &user.User{ID: uuid.New().String(), Role: user.RoleStaff}
+	mockUserService.On("ValidateToken", "test-token").Return(testUser, nil)
+	mockTenantService.On("GetTenant", "t1").Return(&tenant.Tenant{ID: "t1", Name: "John Doe", Version: 3}, nil).Once()
+	mockTenantService.On("GetTenant", "t1").Return(&tenant.Tenant{ID: "t1", Name: "John Doe", SpaceID: "A1", Version: 4}, nil)
+	mockTenantService.On("UpdateTenant", mock.MatchedBy(func(tn tenant.Tenant) bool {
+		return tn.Version == 3 || tn.Version == 0
+	})).Return(nil)
+	mockTenantService.On("UpdateTenant", mock.MatchedBy(func(tn tenant.Tenant) bool {
+		return tn.Version == 2
+	})).Return(apperr.Stale("tenant t1 has been modified since version 2"))
+
+	req, _ := http.NewRequest("GET", "/v1/tenants/t1", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+	server.Mux.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `"3"`, rr.Header().Get("ETag"))
+
+	body := `{"name": "John Doe", "spaceId": "A1"}`
+	testCases := []struct {
+		name    string
+		ifMatch string
+		status  int
+		etag    string
+	}{
+		{"current version", `"3"`, http.StatusOK, `"4"`},
+		{"weak tag", `W/"3"`, http.StatusOK, `"4"`},
+		{"any version", "*", http.StatusOK, `"4"`},
+		{"stale version", `"2"`, http.StatusPreconditionFailed, ""},
+		{"missing", "", http.StatusPreconditionRequired, ""},
+		{"malformed", "3", http.StatusPreconditionFailed, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, _ := http.NewRequest("PUT", "/v1/tenants/t1", bytes.NewBufferString(body))
+			req.Header.Set("Authorization", "Bearer test-token")
+			if tc.ifMatch != "" {
+				req.Header.Set("If-Match", tc.ifMatch)
+			}
+			rr := httptest.NewRecorder()
+			server.Mux.ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.status, rr.Code)
+			assert.Equal(t, tc.etag, rr.Header().Get("ETag"))
+		})
+	}
+
+	mockTenantService.AssertNumberOfCalls(t, "UpdateTenant", 4)
+}
+
+// Updates answer with the stored record, not the request echoed back
+func TestUpdatePaymentReturnsStored(t *testing.T) {
+	server, mockUserService, _, _, mockPaymentService := setupTestServer()
+
+	testUser := &user.User{ID: uuid.New().String(), Role: user.RoleStaff}
+	mockUserService.On("ValidateToken", "test-token").Return(testUser, nil)
+	createdAt := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	mockPaymentService.On("UpdatePayment", mock.MatchedBy(func(p payment.Payment) bool {
+		return p.ID == "p1" && p.Version == 4
+	})).Return(nil)
+	mockPaymentService.On("GetPayment", "p1").Return(&payment.Payment{
+		ID:        "p1",
+		TenantID:  "t1",
+		AmountDue: payment.Dollars(450),
+		DueDate:   time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC),
+		CreatedAt: createdAt,
+		Version:   5,
+	}, nil)
+
+	req, _ := http.NewRequest("PUT", "/v1/payments/p1", bytes.NewBufferString(`{"amountDue": 450, "dueDate": "2024-06-01T00:00:00Z"}`))
+	req.Header.Set("Authorization", "Bearer test-token")
+	req.Header.Set("If-Match", `"4"`)
+	rr := httptest.NewRecorder()
+	server.Mux.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `"5"`, rr.Header().Get("ETag"))
+	var updated payment.Payment
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&updated))
+	assert.Equal(t, "t1", updated.TenantID)
+	assert.True(t, createdAt.Equal(updated.CreatedAt))
+}
+
+// Retried POSTs with the same Idempotency-Key replay the first response
+func TestCreatePaymentIdempotency(t *testing.T) {
+	server, mockUserService, _, _, mockPaymentService := setupTestServer()
+
+	testUser := &user.User{ID: uuid.New().String(), Role: user.RoleStaff}
+	mockUserService.On("ValidateToken", "test-token").Return(testUser, nil)
+	mockPaymentService.On("CreatePayment", mock.AnythingOfType("payment.Payment")).Return(nil).Once()
+	mockPaymentService.On("GetPayment", mock.AnythingOfType("string")).
+		Return(&payment.Payment{ID: "p1", TenantID: "t1", AmountDue: payment.Dollars(500), Version: 1}, nil).Once()
+
+	post := func(path, key, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer test-token")
+		req.Header.Set("Idempotency-Key", key)
+		rr := httptest.NewRecorder()
+		server.Mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	body := `{"tenantId": "t1", "amountDue": 500, "dueDate": "2024-06-01T00:00:00Z", "nextPaymentDate": "2024-07-01T00:00:00Z"}`
+	first := post("/v1/payments", "retry-1", body)
+	assert.Equal(t, http.StatusCreated, first.Code)
+	assert.Empty(t, first.Header().Get("Idempotent-Replayed"))
+
+	// The retry gets the same payment back without creating another one
+	retry := post("/v1/payments", "retry-1", body)
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, "application/json", retry.Header().Get("Content-Type"))
+	assert.JSONEq(t, first.Body.String(), retry.Body.String())
+
+	// The unversioned alias is the same request
+	alias := post("/payments", "retry-1", body)
+	assert.Equal(t, http.StatusCreated, alias.Code)
+	assert.Equal(t, "true", alias.Header().Get("Idempotent-Replayed"))
+	assert.JSONEq(t, first.Body.String(), alias.Body.String())
+
+	// Reusing the key for a different payment is an error
+	reused := post("/v1/payments", "retry-1", `{"tenantId": "t2", "amountDue": 100}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, reused.Code)
+
+	mockPaymentService.AssertNumberOfCalls(t, "CreatePayment", 1)
+}
+
+// Responses holding secrets shown only once are never stored for replays
+func TestIdempotencySkipsSecrets(t *testing.T) {
+	mockUserService := new(MockUserService)
+	replays := &memoryIdempotencyRepository{records: map[string]idempotency.Record{}}
+	server := api.NewServer(
+		mockUserService,
+		new(MockTenantService),
+		new(MockSpaceService),
+		new(MockPaymentService),
+		new(MockJobService),
+		new(MockNotifyService),
+		idempotency.NewService(replays, time.Hour, clock.New(time.UTC)),
+		middleware.NewAuthMiddleware(mockUserService, middleware.DefaultCookieConfig(), nil),
+	)
+
+	admin := &user.User{ID: uuid.New().String(), Role: user.RoleAdmin}
+	mockUserService.On("ValidateToken", "admin-token").Return(admin, nil)
+	mockUserService.On("CreateAPIKey", mock.AnythingOfType("user.APIKey")).
+		Return(&user.APIKey{ID: "key1", Prefix: "rvpk_0123abcd"}, "rvpk_0123abcd-secret", nil)
+	mockUserService.On("RegenerateRecoveryCodes", admin.ID, "123456").Return([]string{"k7m2q-x9dfa"}, nil)
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer admin-token")
+		req.Header.Set("Idempotency-Key", "retry-"+path)
+		rr := httptest.NewRecorder()
+		server.Mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := post("/v1/api-keys", `{"name": "Sync", "scopes": ["payments:read"]}`)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Contains(t, rr.Body.String(), "rvpk_0123abcd-secret")
+	rr = post("/v1/me/2fa/recovery-codes", `{"code": "123456"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "k7m2q-x9dfa")
+
+	for _, record := range replays.records {
+		assert.NotContains(t, string(record.Body), "rvpk_0123abcd-secret")
+		assert.NotContains(t, string(record.Body), "k7m2q-x9dfa")
+	}
+	assert.Empty(t, replays.records)
+}
+
+func TestSessions(t *testing.T) {
+	server, mockUserService, _, _, _ := setupTestServer()
+
+	staff := &user.User{ID: uuid.New().String(), Role: user.RoleStaff}
+	admin := &user.User{ID: uuid.New().String(), Role: user.RoleAdmin}
+	mockUserService.On("ValidateToken", "staff-token").Return(staff, nil)
+	mockUserService.On("ValidateToken", "admin-token").Return(admin, nil)
+
+	sessions := []user.Token{
+		{ID: "s1", TokenHash: user.HashToken("staff-token"), UserID: staff.ID, UserAgent: "laptop"},
+		{ID: "s2", TokenHash: user.HashToken("other-token"), UserID: staff.ID, UserAgent: "phone"},
+	}
+	mockUserService.On("ListSessions", staff.ID).Return(sessions, nil)
+	mockUserService.On("RevokeSession", staff.ID, "s2").Return(nil)
+	mockUserService.On("RevokeSession", staff.ID, "missing").Return(apperr.NotFound("session missing not found"))
+	mockUserService.On("Logout", "staff-token").Return(nil)
+	mockUserService.On("GetUser", staff.ID).Return(staff, nil)
+	mockUserService.On("RevokeAllTokens", staff.ID).Return(nil)
+
+	do := func(method, path, token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		server.Mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := do("GET", "/v1/me/sessions", "staff-token")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var listed []api.SessionResponse
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &listed))
+	if assert.Len(t, listed, 2) {
+		assert.True(t, listed[0].Current)
+		assert.False(t, listed[1].Current)
+	}
+	assert.NotContains(t, rr.Body.String(), sessions[0].TokenHash)
+
+	assert.Equal(t, http.StatusNoContent, do("DELETE", "/v1/me/sessions/s2", "staff-token").Code)
+	assert.Equal(t, http.StatusNotFound, do("DELETE", "/v1/me/sessions/missing", "staff-token").Code)
+
+	// Logging out ends only the session making the request
+	assert.Equal(t, http.StatusOK, do("POST", "/v1/logout", "staff-token").Code)
+	mockUserService.AssertNotCalled(t, "RevokeAllTokens", staff.ID)
+
+	// Staff cannot see other users' sessions; admins can end them all
+	assert.Equal(t, http.StatusForbidden, do("GET", "/v1/users/"+staff.ID+"/sessions", "staff-token").Code)
+	assert.Equal(t, http.StatusNoContent, do("DELETE", "/v1/users/"+staff.ID+"/sessions", "admin-token").Code)
+	mockUserService.AssertCalled(t, "RevokeAllTokens", staff.ID)
+}
+
+func TestLoginLockout(t *testing.T) {
+	server, mockUserService, _, _, _ := setupTestServer()
+
+	mockUserService.On("Login", mock.MatchedBy(func(creds user.LoginCredentials) bool {
+		return creds.Password == "wrong"
+	})).Return(nil, user.ErrInvalidCredentials)
+	mockUserService.On("Login", mock.MatchedBy(func(creds user.LoginCredentials) bool {
+		return creds.Password == "again"
+	})).Return(nil, apperr.RateLimited(90500*time.Millisecond, "too many failed sign-in attempts; try again later"))
+
+	login := func(password string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(api.LoginRequest{Email: "test@example.com", Password: password})
+		req, _ := http.NewRequest("POST", "/v1/login", bytes.NewBuffer(body))
+		req.RemoteAddr = "10.1.0.5:41234"
+		req.Header.Set("X-Forwarded-For", "10.0.0.1, 203.0.113.7")
+		rr := httptest.NewRecorder()
+		server.Mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, login("wrong").Code)
+
+	rr := login("again")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "91", rr.Header().Get("Retry-After"))
+	assert.Contains(t, rr.Body.String(), `"code":"rate_limited"`)
+
+	// The address recorded is the one the proxy saw, not what the client claimed
+	mockUserService.AssertCalled(t, "Login", mock.MatchedBy(func(creds user.LoginCredentials) bool {
+		return creds.IPAddress == "203.0.113.7"
+	}))
+
+	admin := &user.User{ID: uuid.New().String(), Role: user.RoleAdmin}
+	mockUserService.On("ValidateToken", "admin-token").Return(admin, nil)
+	mockUserService.On("UnlockUser", "u1").Return(nil)
+	req, _ := http.NewRequest("POST", "/v1/users/u1/unlock", nil)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	rr = httptest.NewRecorder()
+	server.Mux.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	mockUserService.AssertCalled(t, "UnlockUser", "u1")
+}
+
+func TestPasswordResetAndInvite(t *testing.T) {
+	server, mockUserService, _, _, _ := setupTestServer()
+
+	post := func(path, body, token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", path, bytes.NewBufferString(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		server.Mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	mockUserService.On("RequestPasswordReset", "anyone@example.com").Return(nil)
+	assert.Equal(t, http.StatusAccepted, post("/v1/password-reset", `{"email": "anyone@example.com"}`, "").Code)
+
+	mockUserService.On("ConfirmPasswordReset", "used-token", "a new password").
+		Return(apperr.Invalid("token", "this link is invalid or has expired"))
+	rr := post("/v1/password-reset/confirm", `{"token": "used-token", "password": "a new password"}`, "")
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"field":"token"`)
+
+	mockUserService.On("AcceptInvite", "invite-token", "my own password").Return(nil)
+	assert.Equal(t, http.StatusNoContent, post("/v1/invites/accept", `{"token": "invite-token", "password": "my own password"}`, "").Code)
+
+	// Only admins can invite
+	staff := &user.User{ID: uuid.New().String(), Role: user.RoleStaff}
+	admin := &user.User{ID: uuid.New().String(), Role: user.RoleAdmin}
+	mockUserService.On("ValidateToken", "staff-token").Return(staff, nil)
+	mockUserService.On("ValidateToken", "admin-token").Return(admin, nil)
+	invited := &user.User{ID: uuid.New().String(), Email: "new@example.com", Username: "newbie", Role: user.RoleStaff}
+	mockUserService.On("InviteUser", user.User{Email: "new@example.com", Username: "newbie", Role: user.RoleStaff}).Return(invited, nil)
+
+	body := `{"email": "new@example.com", "username": "newbie", "role": "STAFF"}`
+	assert.Equal(t, http.StatusForbidden, post("/v1/invites", body, "staff-token").Code)
+	rr = post("/v1/invites", body, "admin-token")
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Contains(t, rr.Body.String(), invited.ID)
+}
+
+func TestChangePassword(t *testing.T) {
+	server, mockUserService, _, _, _ := setupTestServer()
+
+	staff := &user.User{ID: uuid.New().String(), Role: user.RoleStaff}
+	admin := &user.User{ID: uuid.New().String(), Role: user.RoleAdmin}
+	mockUserService.On("ValidateToken", "staff-token").Return(staff, nil)
+	mockUserService.On("ValidateToken", "admin-token").Return(admin, nil)
+
+	post := func(path, body, token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		server.Mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// The current session is passed along so it survives the change
+	mockUserService.On("ChangePassword", staff.ID, "staff-token", "Old password 1", "New password 2").Return(nil)
+	mockUserService.On("ChangePassword", staff.ID, "staff-token", "Old password 1", "password").
+		Return(apperr.Invalid("newPassword", "password is too common; it appears in lists of breached passwords"))
+	assert.Equal(t, http.StatusNoContent, post("/v1/me/password", `{"oldPassword": "Old password 1", "newPassword": "New password 2"}`, "staff-token").Code)
+	rr := post("/v1/me/password", `{"oldPassword": "Old password 1", "newPassword": "password"}`, "staff-token")
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"field":"newPassword"`)
+
+	mockUserService.On("ResetPassword", staff.ID, "Temporary pass 3").Return(nil)
+	body := `{"password": "Temporary pass 3"}`
+	assert.Equal(t, http.StatusForbidden, post("/v1/users/"+staff.ID+"/password", body, "staff-token").Code)
+	assert.Equal(t, http.StatusNoContent, post("/v1/users/"+staff.ID+"/password", body, "admin-token").Code)
+	mockUserService.AssertCalled(t, "ResetPassword", staff.ID, "Temporary pass 3")
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	server, mockUserService, _, _, _ := setupTestServer()
+
+	staff := &user.User{ID: uuid.New().String(), Email: "staff@example.com", Role: user.RoleStaff}
+	expiresAt := time.Date(2024, 6, 1, 12, 5, 0, 0, time.UTC)
+	mockUserService.On("Login", mock.AnythingOfType("user.LoginCredentials")).Return(&user.LoginResult{
+		User:      staff,
+		Challenge: &user.LoginChallenge{Token: "challenge-1", ExpiresAt: expiresAt},
+	}, nil)
+	mockUserService.On("VerifyLoginChallenge", mock.MatchedBy(func(resp user.ChallengeResponse) bool {
+		return resp.Code == "000000"
+	})).Return(nil, user.ErrInvalidCredentials)
+	mockUserService.On("VerifyLoginChallenge", mock.MatchedBy(func(resp user.ChallengeResponse) bool {
+		return resp.Challenge == "challenge-1" && resp.Code == "123456" && resp.IPAddress == "203.0.113.7"
+	})).Return(&user.LoginResult{User: staff, Token: "session-token"}, nil)
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", path, bytes.NewBufferString(body))
+		req.RemoteAddr = "203.0.113.7:52110"
+		rr := httptest.NewRecorder()
+		server.Mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// The password step reveals nothing about the account until the code is given
+	rr := post("/v1/login", `{"email": "staff@example.com", "password": "Sunny site 42"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"twoFactor": {"challenge": "challenge-1", "expiresAt": "2024-06-01T12:05:00Z", "setupRequired": false}}`, rr.Body.String())
+
+	assert.Equal(t, http.StatusUnauthorized, post("/v1/login/2fa", `{"challenge": "challenge-1", "code": "000000"}`).Code)
+
+	rr = post("/v1/login/2fa", `{"challenge": "challenge-1", "code": "123456"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var loginResp api.LoginResponse
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &loginResp))
+	assert.Equal(t, "session-token", loginResp.Token)
+	assert.Equal(t, staff.ID, loginResp.User.ID)
+	assert.Nil(t, loginResp.TwoFactor)
+
+	// Only administrators can reset someone's authenticator
+	admin := &user.User{ID: uuid.New().String(), Role: user.RoleAdmin}
+	mockUserService.On("ValidateToken", "staff-token").Return(staff, nil)
+	mockUserService.On("ValidateToken", "admin-token").Return(admin, nil)
+	mockUserService.On("ResetTwoFactor", staff.ID).Return(nil)
+	reset := func(token string) int {
+		req, _ := http.NewRequest("DELETE", "/v1/users/"+staff.ID+"/2fa", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		server.Mux.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	assert.Equal(t, http.StatusForbidden, reset("staff-token"))
+	assert.Equal(t, http.StatusNoContent, reset("admin-token"))
+}
+
+func TestAPIKeys(t *testing.T) {
+	server, mockUserService, _, _, mockPaymentService := setupTestServer()
+
+	admin := &user.User{ID: uuid.New().String(), Role: user.RoleAdmin}
+	syncUser := &user.User{ID: uuid.New().String(), Role: user.RoleStaff}
+	mockUserService.On("ValidateToken", "admin-token").Return(admin, nil)
+
+	// Keys are created for the administrator unless another user is named
+	created := &user.APIKey{ID: "key1", Name: "Accounting sync", UserID: syncUser.ID, Prefix: "rvpk_0123abcd", Scopes: []user.Scope{user.ScopePaymentsRead}}
+	mockUserService.On("CreateAPIKey", mock.MatchedBy(func(key user.APIKey) bool {
+		return key.UserID == syncUser.ID && key.CreatedBy == admin.ID && key.Scopes[0] == user.ScopePaymentsRead
+	})).Return(created, "rvpk_0123abcd-secret", nil)
+	body := `{"name": "Accounting sync", "userId": "` + syncUser.ID + `", "scopes": ["payments:read"]}`
+	req, _ := http.NewRequest("POST", "/v1/api-keys", bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "Bearer admin-token")
+	rr := httptest.NewRecorder()
+	server.Mux.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"key":"rvpk_0123abcd-secret"`)
+	assert.Contains(t, rr.Body.String(), `"prefix":"rvpk_0123abcd"`)
+
+	mockUserService.On("ValidateAPIKey", "rvpk_0123abcd-secret").Return(syncUser, created, nil)
+	mockPaymentService.On("FindPayments", payment.Filter{TenantID: "t1"}, paging.Request{}).
+		Return(&paging.Page[payment.Payment]{Items: []payment.Payment{}}, nil)
+	withKey := func(method, path string) int {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(`{}`))
+		req.Header.Set("X-API-Key", "rvpk_0123abcd-secret")
+		rr := httptest.NewRecorder()
+		server.Mux.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// The key works within its scopes only, and never on routes meant for people
+	assert.Equal(t, http.StatusOK, withKey("GET", "/v1/payments?tenant=t1"))
+	assert.Equal(t, http.StatusForbidden, withKey("POST", "/v1/payments"))
+	assert.Equal(t, http.StatusForbidden, withKey("GET", "/v1/tenants"))
+	assert.Equal(t, http.StatusForbidden, withKey("POST", "/v1/logout"))
+	assert.Equal(t, http.StatusForbidden, withKey("GET", "/v1/me/sessions"))
+	mockPaymentService.AssertNotCalled(t, "CreatePayment", mock.Anything)
+	mockUserService.AssertNotCalled(t, "Logout", mock.Anything)
+
+	mockUserService.On("RevokeAPIKey", "key1").Return(nil)
+	req, _ = http.NewRequest("DELETE", "/v1/api-keys/key1", nil)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	rr = httptest.NewRecorder()
+	server.Mux.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+}
+
+func TestOIDCLogin(t *testing.T) {
+	server, mockUserService, _, _, _ := setupTestServer()
+
+	staff := &user.User{ID: uuid.New().String(), Email: "casey@example.com", Role: user.RoleStaff}
+	mockUserService.On("StartOIDCLogin").Return("https://id.example.com/authorize?state=abc", nil)
+	mockUserService.On("CompleteOIDCLogin", mock.MatchedBy(func(callback user.OIDCCallback) bool {
+		return callback.Code == "good" && callback.State == "abc" && callback.IPAddress == "203.0.113.7"
+	})).Return(&user.LoginResult{User: staff, Token: "session-token"}, nil)
+	mockUserService.On("CompleteOIDCLogin", mock.MatchedBy(func(callback user.OIDCCallback) bool {
+		return callback.Code == "guest"
+	})).Return(nil, user.ErrNoGroupRole)
+	mockUserService.On("CompleteOIDCLogin", mock.MatchedBy(func(callback user.OIDCCallback) bool {
+		return callback.Code == "forged"
+	})).Return(nil, user.ErrInvalidCredentials)
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", path, bytes.NewBufferString(body))
+		req.RemoteAddr = "203.0.113.7:52110"
+		rr := httptest.NewRecorder()
+		server.Mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := post("/v1/login/oidc", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"authorizationUrl": "https://id.example.com/authorize?state=abc"}`, rr.Body.String())
+
+	// Single sign-on ends with the same session token as a password
+	rr = post("/v1/login/oidc/callback", `{"code": "good", "state": "abc"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var loginResp api.LoginResponse
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &loginResp))
+	assert.Equal(t, "session-token", loginResp.Token)
+	assert.Equal(t, staff.ID, loginResp.User.ID)
+
+	assert.Equal(t, http.StatusForbidden, post("/v1/login/oidc/callback", `{"code": "guest", "state": "abc"}`).Code)
+	assert.Equal(t, http.StatusUnauthorized, post("/v1/login/oidc/callback", `{"code": "forged", "state": "abc"}`).Code)
+}
+
+func TestCookieSession(t *testing.T) {
+	server, mockUserService, _, _, _ := setupTestServer()
+
+	staff := &user.User{ID: uuid.New().String(), Email: "staff@example.com", Role: user.RoleStaff}
+	mockUserService.On("Login", mock.AnythingOfType("user.LoginCredentials")).Return(&user.LoginResult{User: staff, Token: "session-token"}, nil)
+	mockUserService.On("ValidateToken", "session-token").Return(staff, nil)
+	mockUserService.On("Logout", "session-token").Return(nil)
+
+	// The token goes into an HttpOnly cookie instead of the response body
+	req, _ := http.NewRequest("POST", "/v1/login", bytes.NewBufferString(`{"email": "staff@example.com", "password": "Sunny site 42", "useCookie": true}`))
+	rr := httptest.NewRecorder()
+	server.Mux.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var loginResp api.LoginResponse
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &loginResp))
+	assert.Empty(t, loginResp.Token)
+	assert.NotEmpty(t, loginResp.CSRFToken)
+	assert.NotContains(t, rr.Body.String(), "session-token")
+	cookies := rr.Result().Cookies()
+	assert.Len(t, cookies, 2)
+
+	send := func(method, path, csrf string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		if csrf != "" {
+			req.Header.Set(middleware.CSRFHeader, csrf)
+		}
+		rr := httptest.NewRecorder()
+		server.Mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Clients that can't read the API's cookies get the CSRF token back here
+	rr = send("GET", "/v1/validate-token", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), loginResp.CSRFToken)
+
+	// Changes need the CSRF token, and logging out clears the cookies
+	assert.Equal(t, http.StatusForbidden, send("POST", "/v1/logout", "").Code)
+	rr = send("POST", "/v1/logout", loginResp.CSRFToken)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	for _, cookie := range rr.Result().Cookies() {
+		assert.True(t, cookie.MaxAge < 0, cookie.Name)
+	}
+	mockUserService.AssertCalled(t, "Logout", "session-token")
+}
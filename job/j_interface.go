@@ -0,0 +1,25 @@
+// job/j_interface.go
+package job
+
+import "time"
+
+type Service interface {
+	Register(name, spec, description string, run func() error) error
+	Start()
+	Stop()
+	ListJobs() ([]JobStatus, error)
+	ListRuns(name string, limit int) ([]Run, error)
+	Trigger(name string) (*Run, error)
+}
+
+type Repository interface {
+	CreateRun(run Run) error
+	// GetScheduledRun returns the run of jobName's scheduled slot, or
+	// sql.ErrNoRows when the slot hasn't run
+	GetScheduledRun(jobName string, scheduledFor time.Time) (*Run, error)
+	ListRuns(jobName string, limit int) ([]Run, error)
+
+	// TryLock takes a cluster-wide lock for jobName. When acquired is false
+	// another instance holds it and release is nil.
+	TryLock(jobName string) (release func(), acquired bool, err error)
+}
@@ -0,0 +1,51 @@
+// job/j_model.go
+package job
+
+import (
+	"time"
+
+	"github.com/BodaciousX/RVParkBackend/apperr"
+)
+
+// Trigger values record why a job ran
+const (
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+)
+
+// Status values for a finished run
+const (
+	RunSucceeded = "succeeded"
+	RunFailed    = "failed"
+)
+
+var (
+	ErrJobNotFound = apperr.NotFound("job not found")
+	ErrJobRunning  = apperr.Conflict("job is already running")
+)
+
+// Job describes a registered periodic task
+type Job struct {
+	Name        string `json:"name"`
+	Spec        string `json:"spec"` // cron expression or @every/@hourly/@daily
+	Description string `json:"description"`
+}
+
+// Run is a single recorded execution of a job
+type Run struct {
+	ID           string     `json:"id"`
+	JobName      string     `json:"jobName"`
+	Trigger      string     `json:"trigger"`
+	ScheduledFor *time.Time `json:"scheduledFor,omitempty"`
+	StartedAt    time.Time  `json:"startedAt"`
+	FinishedAt   time.Time  `json:"finishedAt"`
+	Status       string     `json:"status"`
+	Error        string     `json:"error,omitempty"`
+}
+
+// JobStatus combines a job with its next scheduled time and most recent run
+type JobStatus struct {
+	Job
+	NextRun time.Time `json:"nextRun"`
+	LastRun *Run      `json:"lastRun,omitempty"`
+}
@@ -0,0 +1,154 @@
+// job/j_repository.go
+package job
+
+import (
+	"context"
+	"database/sql"
+	"hash/fnv"
+	"log"
+	"time"
+)
+
+type sqlRepository struct {
+	db *sql.DB
+}
+
+func NewSQLRepository(db *sql.DB) Repository {
+	return &sqlRepository{db: db}
+}
+
+func (r *sqlRepository) CreateRun(run Run) error {
+	query := `
+        INSERT INTO job_runs (
+            id, job_name, trigger, scheduled_for, started_at,
+            finished_at, status, error
+        ) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+    `
+
+	var errText interface{}
+	if run.Error != "" {
+		errText = run.Error
+	}
+
+	_, err := r.db.Exec(
+		query,
+		run.ID,
+		run.JobName,
+		run.Trigger,
+		run.ScheduledFor,
+		run.StartedAt,
+		run.FinishedAt,
+		run.Status,
+		errText,
+	)
+	return err
+}
+
+func (r *sqlRepository) GetScheduledRun(jobName string, scheduledFor time.Time) (*Run, error) {
+	query := `
+        SELECT
+            id, job_name, trigger, scheduled_for, started_at,
+            finished_at, status, error
+        FROM job_runs
+        WHERE job_name = $1 AND scheduled_for = $2
+    `
+
+	return scanRun(r.db.QueryRow(query, jobName, scheduledFor))
+}
+
+func (r *sqlRepository) ListRuns(jobName string, limit int) ([]Run, error) {
+	query := `
+        SELECT
+            id, job_name, trigger, scheduled_for, started_at,
+            finished_at, status, error
+        FROM job_runs
+        WHERE job_name = $1
+        ORDER BY started_at DESC
+        LIMIT $2
+    `
+
+	rows, err := r.db.Query(query, jobName, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []Run
+	for rows.Next() {
+		run, err := scanRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, *run)
+	}
+
+	return runs, rows.Err()
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanRun(row rowScanner) (*Run, error) {
+	var run Run
+	var scheduledFor sql.NullTime
+	var errText sql.NullString
+
+	err := row.Scan(
+		&run.ID,
+		&run.JobName,
+		&run.Trigger,
+		&scheduledFor,
+		&run.StartedAt,
+		&run.FinishedAt,
+		&run.Status,
+		&errText,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if scheduledFor.Valid {
+		run.ScheduledFor = &scheduledFor.Time
+	}
+	run.Error = errText.String
+	return &run, nil
+}
+
+// TryLock uses a session-level Postgres advisory lock keyed on the job name.
+// The lock lives on a dedicated connection which is returned to the pool on release.
+func (r *sqlRepository) TryLock(jobName string) (func(), bool, error) {
+	ctx := context.Background()
+
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	key := lockKey(jobName)
+
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, key).Scan(&acquired); err != nil {
+		conn.Close()
+		return nil, false, err
+	}
+	if !acquired {
+		conn.Close()
+		return nil, false, nil
+	}
+
+	release := func() {
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, key); err != nil {
+			log.Printf("Warning: failed to release lock for job %s: %v", jobName, err)
+		}
+		conn.Close()
+	}
+	return release, true, nil
+}
+
+func lockKey(jobName string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("job:" + jobName))
+	return int64(h.Sum64())
+}
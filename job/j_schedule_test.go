@@ -0,0 +1,55 @@
+// job/j_schedule_test.go
+package job
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSchedule_Next(t *testing.T) {
+	// Wednesday
+	base := time.Date(2024, 5, 15, 10, 7, 30, 0, time.UTC)
+
+	testCases := []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2024, 5, 15, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 5, 15, 10, 15, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, 5, 15, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, 5, 16, 0, 0, 0, 0, time.UTC)},
+		{"30 9 * * 1-5", time.Date(2024, 5, 16, 9, 30, 0, 0, time.UTC)},
+		{"0 8 1 * *", time.Date(2024, 6, 1, 8, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 5, 19, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"@every 30m", time.Date(2024, 5, 15, 10, 30, 0, 0, time.UTC)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.spec, func(t *testing.T) {
+			schedule, err := ParseSchedule(tc.spec)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, schedule.Next(base))
+		})
+	}
+}
+
+func TestParseSchedule_Invalid(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"@every 10s",
+		"@every often",
+		"0 0 30 2 *",
+		"0 0 31 4,6,9,11 *",
+	} {
+		_, err := ParseSchedule(spec)
+		assert.Error(t, err, spec)
+	}
+}
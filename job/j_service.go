@@ -0,0 +1,291 @@
+// job/j_service.go
+package job
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/BodaciousX/RVParkBackend/clock"
+	"github.com/google/uuid"
+)
+
+type registeredJob struct {
+	Job
+	schedule Schedule
+	run      func() error
+	next     time.Time
+	running  bool
+}
+
+type service struct {
+	repo  Repository
+	clock clock.Clock
+
+	mu      sync.Mutex
+	jobs    map[string]*registeredJob
+	stop    chan struct{}
+	done    chan struct{}
+	wake    chan struct{}
+	running sync.WaitGroup
+}
+
+// NewService returns a scheduler reading schedules in the park's time zone,
+// so "0 2 * * *" runs at 2am at the park whatever zone the server is in
+func NewService(repo Repository, clk clock.Clock) Service {
+	return &service{
+		repo:  repo,
+		clock: clk,
+		jobs:  make(map[string]*registeredJob),
+		wake:  make(chan struct{}, 1),
+	}
+}
+
+// Register adds a job. Jobs may be registered before or after Start.
+func (s *service) Register(name, spec, description string, run func() error) error {
+	if name == "" {
+		return fmt.Errorf("job name is required")
+	}
+	if run == nil {
+		return fmt.Errorf("job %s has no run function", name)
+	}
+
+	schedule, err := ParseSchedule(spec)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.jobs[name]; exists {
+		return fmt.Errorf("job %s is already registered", name)
+	}
+
+	s.jobs[name] = &registeredJob{
+		Job:      Job{Name: name, Spec: spec, Description: description},
+		schedule: schedule,
+		run:      run,
+		next:     schedule.Next(s.clock.Now()),
+	}
+
+	// Let a running loop recompute its timer
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Start runs the scheduling loop in the background until Stop is called
+func (s *service) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stop != nil {
+		return
+	}
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go s.loop(s.stop, s.done)
+}
+
+// Stop ends the scheduling loop and waits for in-flight runs to finish
+func (s *service) Stop() {
+	s.mu.Lock()
+	stop, done := s.stop, s.done
+	s.stop, s.done = nil, nil
+	s.mu.Unlock()
+
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+	s.running.Wait()
+}
+
+func (s *service) loop(stop, done chan struct{}) {
+	defer close(done)
+
+	for {
+		timer := time.NewTimer(s.untilNextDue(s.clock.Now()))
+
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-s.wake:
+			timer.Stop()
+		case <-timer.C:
+			s.runDue(s.clock.Now())
+		}
+	}
+}
+
+func (s *service) untilNextDue(now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	wait := time.Hour
+	for _, job := range s.jobs {
+		if job.next.IsZero() {
+			continue
+		}
+		if until := job.next.Sub(now); until < wait {
+			wait = until
+		}
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	return wait
+}
+
+func (s *service) runDue(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, job := range s.jobs {
+		// A zero next run means the schedule has no more slots
+		if job.next.IsZero() || job.next.After(now) {
+			continue
+		}
+
+		slot := job.next
+		job.next = job.schedule.Next(now)
+
+		// Skip rather than overlap if the previous run is still going
+		if job.running {
+			log.Printf("Job %s: skipping %s, previous run still in progress", job.Name, slot.Format(time.RFC3339))
+			continue
+		}
+		job.running = true
+
+		s.running.Add(1)
+		go func(job *registeredJob, slot time.Time) {
+			defer s.running.Done()
+			defer s.setRunning(job, false)
+
+			if _, err := s.execute(job, TriggerSchedule, &slot); err != nil && err != ErrJobRunning {
+				log.Printf("Job %s: %v", job.Name, err)
+			}
+		}(job, slot)
+	}
+}
+
+func (s *service) setRunning(job *registeredJob, running bool) {
+	s.mu.Lock()
+	job.running = running
+	s.mu.Unlock()
+}
+
+// execute runs a job under its cluster-wide lock and records the outcome.
+// Scheduled runs are skipped when another instance already ran the same slot.
+func (s *service) execute(job *registeredJob, trigger string, scheduledFor *time.Time) (*Run, error) {
+	release, acquired, err := s.repo.TryLock(job.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire lock: %v", err)
+	}
+	if !acquired {
+		return nil, ErrJobRunning
+	}
+	defer release()
+
+	if scheduledFor != nil {
+		existing, err := s.repo.GetScheduledRun(job.Name, *scheduledFor)
+		if err == nil {
+			return existing, nil
+		}
+		if err != sql.ErrNoRows {
+			return nil, fmt.Errorf("failed to check for an earlier run: %v", err)
+		}
+	}
+
+	run := Run{
+		ID:           uuid.New().String(),
+		JobName:      job.Name,
+		Trigger:      trigger,
+		ScheduledFor: scheduledFor,
+		StartedAt:    s.clock.Now(),
+	}
+
+	runErr := safeRun(job.run)
+
+	run.FinishedAt = s.clock.Now()
+	run.Status = RunSucceeded
+	if runErr != nil {
+		run.Status = RunFailed
+		run.Error = runErr.Error()
+		log.Printf("Job %s failed: %v", job.Name, runErr)
+	}
+
+	if err := s.repo.CreateRun(run); err != nil {
+		return &run, fmt.Errorf("failed to record run: %v", err)
+	}
+	return &run, nil
+}
+
+// safeRun keeps a panicking job from taking down the scheduler
+func safeRun(run func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return run()
+}
+
+func (s *service) ListJobs() ([]JobStatus, error) {
+	s.mu.Lock()
+	jobs := make([]JobStatus, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, JobStatus{Job: job.Job, NextRun: job.next})
+	}
+	s.mu.Unlock()
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Name < jobs[j].Name
+	})
+
+	for i := range jobs {
+		runs, err := s.repo.ListRuns(jobs[i].Name, 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(runs) > 0 {
+			jobs[i].LastRun = &runs[0]
+		}
+	}
+
+	return jobs, nil
+}
+
+func (s *service) ListRuns(name string, limit int) ([]Run, error) {
+	s.mu.Lock()
+	_, ok := s.jobs[name]
+	s.mu.Unlock()
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+
+	if limit <= 0 {
+		limit = 20
+	}
+	return s.repo.ListRuns(name, limit)
+}
+
+// Trigger runs a job immediately on the calling goroutine
+func (s *service) Trigger(name string) (*Run, error) {
+	s.mu.Lock()
+	job, ok := s.jobs[name]
+	s.mu.Unlock()
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+
+	return s.execute(job, TriggerManual, nil)
+}
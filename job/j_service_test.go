@@ -0,0 +1,217 @@
+// job/j_service_test.go
+package job
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/BodaciousX/RVParkBackend/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// MockRepository is a mock implementation of the Repository interface
+type MockRepository struct {
+	mock.Mock
+}
+
+func (m *MockRepository) CreateRun(run Run) error {
+	args := m.Called(run)
+	return args.Error(0)
+}
+
+func (m *MockRepository) GetScheduledRun(jobName string, scheduledFor time.Time) (*Run, error) {
+	args := m.Called(jobName, scheduledFor)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*Run), args.Error(1)
+}
+
+func (m *MockRepository) ListRuns(jobName string, limit int) ([]Run, error) {
+	args := m.Called(jobName, limit)
+	return args.Get(0).([]Run), args.Error(1)
+}
+
+func (m *MockRepository) TryLock(jobName string) (func(), bool, error) {
+	args := m.Called(jobName)
+	if release, ok := args.Get(0).(func()); ok {
+		return release, args.Bool(1), args.Error(2)
+	}
+	return nil, args.Bool(1), args.Error(2)
+}
+
+func TestRegister_Validation(t *testing.T) {
+	service := NewService(new(MockRepository), clock.New(time.UTC))
+
+	assert.Error(t, service.Register("", "@hourly", "", func() error { return nil }))
+	assert.Error(t, service.Register("job", "not a spec", "", func() error { return nil }))
+	assert.Error(t, service.Register("job", "@hourly", "", nil))
+	assert.NoError(t, service.Register("job", "@hourly", "", func() error { return nil }))
+	assert.Error(t, service.Register("job", "@daily", "", func() error { return nil }))
+}
+
+func TestRegister_ParkTimeZone(t *testing.T) {
+	chicago, err := time.LoadLocation("America/Chicago")
+	assert.NoError(t, err)
+	parkClock := clock.NewFake(time.Date(2024, 6, 1, 12, 0, 0, 0, chicago))
+	svc := NewService(new(MockRepository), parkClock).(*service)
+
+	assert.NoError(t, svc.Register("nightly", "0 2 * * *", "", func() error { return nil }))
+
+	// 2am at the park, not 2am UTC
+	next := svc.jobs["nightly"].next
+	assert.True(t, next.Equal(time.Date(2024, 6, 2, 2, 0, 0, 0, chicago)), next)
+	assert.Equal(t, 7, next.UTC().Hour())
+}
+
+func TestTrigger_RecordsRun(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, clock.New(time.UTC))
+
+	calls := 0
+	assert.NoError(t, service.Register("cleanup", "@hourly", "", func() error {
+		calls++
+		return errors.New("db unavailable")
+	}))
+
+	released := false
+	mockRepo.On("TryLock", "cleanup").Return(func() { released = true }, true, nil)
+	mockRepo.On("CreateRun", mock.AnythingOfType("Run")).Return(nil)
+
+	run, err := service.Trigger("cleanup")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, calls)
+	assert.True(t, released)
+	assert.Equal(t, TriggerManual, run.Trigger)
+	assert.Equal(t, RunFailed, run.Status)
+	assert.Equal(t, "db unavailable", run.Error)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestTrigger_Errors(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, clock.New(time.UTC))
+
+	_, err := service.Trigger("missing")
+	assert.Equal(t, ErrJobNotFound, err)
+
+	assert.NoError(t, service.Register("cleanup", "@hourly", "", func() error { return nil }))
+	mockRepo.On("TryLock", "cleanup").Return(nil, false, nil)
+
+	_, err = service.Trigger("cleanup")
+	assert.Equal(t, ErrJobRunning, err)
+	mockRepo.AssertNotCalled(t, "CreateRun", mock.Anything)
+}
+
+func TestTrigger_RecoversPanic(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, clock.New(time.UTC))
+
+	assert.NoError(t, service.Register("explode", "@hourly", "", func() error { panic("boom") }))
+	mockRepo.On("TryLock", "explode").Return(func() {}, true, nil)
+	mockRepo.On("CreateRun", mock.AnythingOfType("Run")).Return(nil)
+
+	run, err := service.Trigger("explode")
+	assert.NoError(t, err)
+	assert.Equal(t, RunFailed, run.Status)
+	assert.Contains(t, run.Error, "boom")
+}
+
+func TestExecute_SkipsSlotAlreadyRun(t *testing.T) {
+	mockRepo := new(MockRepository)
+	svc := NewService(mockRepo, clock.New(time.UTC)).(*service)
+
+	calls := 0
+	assert.NoError(t, svc.Register("cleanup", "@hourly", "", func() error {
+		calls++
+		return nil
+	}))
+
+	slot := time.Date(2024, 5, 15, 10, 0, 0, 0, time.UTC)
+	mockRepo.On("TryLock", "cleanup").Return(func() {}, true, nil)
+	mockRepo.On("GetScheduledRun", "cleanup", slot).Return(&Run{JobName: "cleanup", ScheduledFor: &slot}, nil).Once()
+
+	// Another instance already ran this slot, even if a manual run has
+	// happened since
+	_, err := svc.execute(svc.jobs["cleanup"], TriggerSchedule, &slot)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, calls)
+
+	// The next slot runs
+	next := slot.Add(time.Hour)
+	mockRepo.On("GetScheduledRun", "cleanup", next).Return(nil, sql.ErrNoRows).Once()
+	mockRepo.On("CreateRun", mock.AnythingOfType("Run")).Return(nil)
+
+	run, err := svc.execute(svc.jobs["cleanup"], TriggerSchedule, &next)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, RunSucceeded, run.Status)
+	assert.Equal(t, next, *run.ScheduledFor)
+
+	// A slot whose history can't be read isn't run, as it may already have
+	later := next.Add(time.Hour)
+	mockRepo.On("GetScheduledRun", "cleanup", later).Return(nil, errors.New("connection refused")).Once()
+	_, err = svc.execute(svc.jobs["cleanup"], TriggerSchedule, &later)
+	assert.Error(t, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestRegister_ImpossibleSchedule(t *testing.T) {
+	svc := NewService(new(MockRepository), clock.New(time.UTC)).(*service)
+
+	assert.Error(t, svc.Register("never", "0 0 30 2 *", "", func() error { return nil }))
+	assert.Empty(t, svc.jobs)
+
+	// A job left without a next run is never due and doesn't hold the timer
+	calls := 0
+	assert.NoError(t, svc.Register("stuck", "@hourly", "", func() error {
+		calls++
+		return nil
+	}))
+	svc.jobs["stuck"].next = time.Time{}
+	now := time.Now()
+	assert.Equal(t, time.Hour, svc.untilNextDue(now))
+	svc.runDue(now)
+	svc.running.Wait()
+	assert.Equal(t, 0, calls)
+}
+
+func TestStartStop_RunsDueJobs(t *testing.T) {
+	mockRepo := new(MockRepository)
+	svc := NewService(mockRepo, clock.New(time.UTC)).(*service)
+
+	ran := make(chan struct{}, 1)
+	assert.NoError(t, svc.Register("tick", "@hourly", "", func() error {
+		ran <- struct{}{}
+		return nil
+	}))
+	mockRepo.On("TryLock", "tick").Return(func() {}, true, nil)
+	mockRepo.On("GetScheduledRun", "tick", mock.AnythingOfType("time.Time")).Return(nil, sql.ErrNoRows)
+	mockRepo.On("CreateRun", mock.AnythingOfType("Run")).Return(nil)
+
+	// Make the job due immediately
+	svc.mu.Lock()
+	svc.jobs["tick"].next = time.Now().Add(-time.Second)
+	svc.mu.Unlock()
+
+	svc.Start()
+	select {
+	case <-ran:
+	case <-time.After(2 * time.Second):
+		t.Fatal("job did not run")
+	}
+	svc.Stop()
+
+	lastRun := Run{JobName: "tick", Trigger: TriggerSchedule, Status: RunSucceeded}
+	mockRepo.On("ListRuns", "tick", 1).Return([]Run{lastRun}, nil)
+
+	jobs, err := svc.ListJobs()
+	assert.NoError(t, err)
+	assert.Len(t, jobs, 1)
+	assert.Equal(t, &lastRun, jobs[0].LastRun)
+	assert.True(t, jobs[0].NextRun.After(time.Now()))
+}
@@ -1,16 +1,23 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/BodaciousX/RVParkBackend/api"
+	"github.com/BodaciousX/RVParkBackend/clock"
+	"github.com/BodaciousX/RVParkBackend/idempotency"
+	"github.com/BodaciousX/RVParkBackend/job"
 	"github.com/BodaciousX/RVParkBackend/middleware"
+	"github.com/BodaciousX/RVParkBackend/migrate"
+	"github.com/BodaciousX/RVParkBackend/notify"
 	"github.com/BodaciousX/RVParkBackend/payment"
 	"github.com/BodaciousX/RVParkBackend/space"
 	"github.com/BodaciousX/RVParkBackend/tenant"
@@ -26,35 +33,49 @@ func getDBConfig() string {
 	}
 
 	if os.Getenv("GO_ENV") != "development" {
-		if !strings.Contains(dbURL, "sslmode=") {
-			if strings.Contains(dbURL, "?") {
-				dbURL += "&sslmode=require"
-			} else {
-				dbURL += "?sslmode=require"
-			}
+		dbURL = withDefaultParam(dbURL, "sslmode", "require")
+	}
+
+	// Postgres cancels any statement that runs longer than this. Requests
+	// are also cancelled when the client goes away, via their context.
+	timeout := 30 * time.Second
+	if value := os.Getenv("DB_STATEMENT_TIMEOUT"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < 0 {
+			log.Fatalf("invalid DB_STATEMENT_TIMEOUT %q: expected a duration like 30s", value)
 		}
+		timeout = parsed
 	}
+	dbURL = withDefaultParam(dbURL, "statement_timeout", strconv.FormatInt(timeout.Milliseconds(), 10))
 
 	return dbURL
 }
 
-// initializeDatabase reads and executes the init.sql file
-func initializeDatabase(db *sql.DB) error {
-	log.Println("Starting database initialization...")
+// withDefaultParam adds key=value to a connection URL unless key is already set
+func withDefaultParam(dbURL, key, value string) string {
+	if strings.Contains(dbURL, key+"=") {
+		return dbURL
+	}
+	if strings.Contains(dbURL, "?") {
+		return dbURL + "&" + key + "=" + value
+	}
+	return dbURL + "?" + key + "=" + value
+}
+
+// runMigrations applies any pending schema migrations
+func runMigrations(db *sql.DB) error {
+	log.Println("Starting database migrations...")
 
-	// Read init.sql file from docker folder
-	initSQL, err := os.ReadFile("docker/init.sql")
+	migrator, err := migrate.NewMigrator(db)
 	if err != nil {
-		return fmt.Errorf("failed to read init.sql: %v", err)
+		return fmt.Errorf("failed to load migrations: %v", err)
 	}
 
-	// Execute the SQL statements
-	_, err = db.Exec(string(initSQL))
-	if err != nil {
-		return fmt.Errorf("failed to execute init.sql: %v", err)
+	if err := migrator.Up(); err != nil {
+		return fmt.Errorf("failed to apply migrations: %v", err)
 	}
 
-	log.Println("Database initialization completed successfully")
+	log.Println("Database migrations completed successfully")
 	return nil
 }
 
@@ -75,39 +96,30 @@ func checkDatabaseConnection(db *sql.DB) error {
 	return fmt.Errorf("failed to connect to database after %d attempts", maxRetries)
 }
 
-// checkRequiredTables verifies that all required tables exist
-func checkRequiredTables(db *sql.DB) error {
-	requiredTables := []string{
-		"users",
-		"tokens",
-		"sections",
-		"spaces",
-		"tenants",
-		"payments",
-	}
-
-	for _, table := range requiredTables {
-		var exists bool
-		query := `
-			SELECT EXISTS (
-				SELECT FROM information_schema.tables 
-				WHERE table_schema = 'public' 
-				AND table_name = $1
-			);
-		`
-		err := db.QueryRow(query, table).Scan(&exists)
-		if err != nil {
-			return fmt.Errorf("error checking table %s: %v", table, err)
-		}
-		if !exists {
-			return fmt.Errorf("required table %s does not exist", table)
+// checkSchemaVersion verifies that every migration built into the binary has
+// been applied, so the schema has every table and column the code uses
+func checkSchemaVersion(db *sql.DB) error {
+	migrator, err := migrate.NewMigrator(db)
+	if err != nil {
+		return fmt.Errorf("failed to load migrations: %v", err)
+	}
+
+	statuses, err := migrator.Status()
+	if err != nil {
+		return fmt.Errorf("failed to read migration status: %v", err)
+	}
+	for _, status := range statuses {
+		if !status.Applied {
+			return fmt.Errorf("migration %d (%s) has not been applied", status.Version, status.Name)
 		}
 	}
-	log.Println("All required database tables are present")
+	if len(statuses) > 0 {
+		log.Printf("Database schema is at migration %d", statuses[len(statuses)-1].Version)
+	}
 	return nil
 }
 
-func ensureStaffExists(userService user.Service) error {
+func ensureStaffExists(ctx context.Context, userService user.Service) error {
 	// Get staff credentials from environment variables
 	staffEmail := os.Getenv("STAFF_EMAIL")
 	if staffEmail == "" {
@@ -121,7 +133,7 @@ func ensureStaffExists(userService user.Service) error {
 	}
 
 	// Check if staff exists
-	_, err := userService.GetUserByEmail(staffEmail)
+	_, err := userService.GetUserByEmail(ctx, staffEmail)
 	if err == nil {
 		log.Printf("Existing staff account found with email: %s\n", staffEmail)
 		return nil
@@ -129,14 +141,13 @@ func ensureStaffExists(userService user.Service) error {
 
 	// Create new staff user
 	staffUser := user.User{
-		ID:        uuid.New().String(),
-		Email:     staffEmail,
-		Username:  "staff",
-		Role:      user.RoleStaff,
-		CreatedAt: time.Now(),
+		ID:       uuid.New().String(),
+		Email:    staffEmail,
+		Username: "staff",
+		Role:     user.RoleStaff,
 	}
 
-	err = userService.CreateUser(staffUser, staffPassword)
+	err = userService.CreateUser(ctx, staffUser, staffPassword)
 	if err != nil {
 		return fmt.Errorf("failed to create staff user: %v", err)
 	}
@@ -145,7 +156,7 @@ func ensureStaffExists(userService user.Service) error {
 	return nil
 }
 
-func ensureAdminExists(userService user.Service) error {
+func ensureAdminExists(ctx context.Context, userService user.Service) error {
 	// Get admin credentials from environment variables
 	adminEmail := os.Getenv("ADMIN_EMAIL")
 	if adminEmail == "" {
@@ -159,7 +170,7 @@ func ensureAdminExists(userService user.Service) error {
 	}
 
 	// Check if admin exists
-	_, err := userService.GetUserByEmail(adminEmail)
+	_, err := userService.GetUserByEmail(ctx, adminEmail)
 	if err == nil {
 		log.Printf("Existing admin account found with email: %s\n", adminEmail)
 		return nil
@@ -167,14 +178,13 @@ func ensureAdminExists(userService user.Service) error {
 
 	// Create new admin user
 	adminUser := user.User{
-		ID:        uuid.New().String(),
-		Email:     adminEmail,
-		Username:  "admin",
-		Role:      user.RoleAdmin,
-		CreatedAt: time.Now(),
+		ID:       uuid.New().String(),
+		Email:    adminEmail,
+		Username: "admin",
+		Role:     user.RoleAdmin,
 	}
 
-	err = userService.CreateUser(adminUser, adminPassword)
+	err = userService.CreateUser(ctx, adminUser, adminPassword)
 	if err != nil {
 		return fmt.Errorf("failed to create admin user: %v", err)
 	}
@@ -183,7 +193,440 @@ func ensureAdminExists(userService user.Service) error {
 	return nil
 }
 
-func main() {
+// appServices bundles the repositories and services shared by the server and
+// the admin subcommands.
+type appServices struct {
+	clock          clock.Clock
+	tokenRepo      user.TokenRepository
+	userService    user.Service
+	tenantService  tenant.Service
+	spaceService   space.Service
+	paymentService payment.Service
+	jobService     job.Service
+	notifyService  notify.Service
+
+	idempotencyService idempotency.Service
+}
+
+func newAppServices(db *sql.DB) (*appServices, error) {
+	// Initialize repositories
+	userRepo := user.NewSQLRepository(db)
+	tokenRepo := user.NewTokenRepository(db)
+	loginRepo := user.NewLoginRepository(db)
+	accountTokenRepo := user.NewAccountTokenRepository(db)
+	twoFactorRepo := user.NewTwoFactorRepository(db)
+	apiKeyRepo := user.NewAPIKeyRepository(db)
+	oidcRepo := user.NewOIDCRepository(db)
+	tenantRepo := tenant.NewSQLRepository(db)
+	spaceRepo := space.NewSQLRepository(db)
+	paymentRepo := payment.NewSQLRepository(db)
+
+	notifyRepo := notify.NewSQLRepository(db)
+
+	emailTransport, err := emailTransportFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	parkClock, err := parkClockFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	// Initialize services
+	tenantService := tenant.NewService(tenantRepo, parkClock)
+	paymentService := payment.NewService(paymentRepo, parkClock)
+	passwordPolicy, err := passwordPolicyFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	oidcConfig, err := oidcConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	userConfig := user.Config{
+		Sessions:      sessionConfigFromEnv(),
+		Lockout:       lockoutConfigFromEnv(),
+		Passwords:     passwordPolicy,
+		AccountEmails: accountEmailConfigFromEnv(),
+		TwoFactor:     twoFactorConfigFromEnv(),
+		OIDC:          oidcConfig,
+	}
+	userService := user.NewService(
+		userRepo,
+		tokenRepo,
+		loginRepo,
+		accountTokenRepo,
+		twoFactorRepo,
+		apiKeyRepo,
+		oidcRepo,
+		notify.NewMailer(emailTransport),
+		parkClock,
+		userConfig,
+	)
+	return &appServices{
+		clock:          parkClock,
+		tokenRepo:      tokenRepo,
+		userService:    userService,
+		tenantService:  tenantService,
+		spaceService:   space.NewService(spaceRepo, tenantService),
+		paymentService: paymentService,
+		jobService:     job.NewService(job.NewSQLRepository(db), parkClock),
+		notifyService: notify.NewService(
+			notifyRepo,
+			emailTransport,
+			smsTransportFromEnv(),
+			tenantService,
+			paymentService,
+			notifyConfigFromEnv(),
+			parkClock,
+		),
+		idempotencyService: idempotency.NewService(idempotency.NewSQLRepository(db), idempotencyTTLFromEnv(), parkClock),
+	}, nil
+}
+
+// emailTransportFromEnv sends mail through SMTP_HOST when set, otherwise
+// writes messages to NOTIFY_LOG_FILE (or stdout) so nothing is silently lost
+func emailTransportFromEnv() (notify.Transport, error) {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		logFile := os.Getenv("NOTIFY_LOG_FILE")
+		if logFile == "" {
+			log.Println("SMTP_HOST not set - notification emails will be written to stdout")
+			return notify.NewLogTransport(os.Stdout), nil
+		}
+
+		file, err := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open NOTIFY_LOG_FILE: %v", err)
+		}
+		log.Printf("SMTP_HOST not set - notification emails will be written to %s", logFile)
+		return notify.NewLogTransport(file), nil
+	}
+
+	port := 587
+	if portStr := os.Getenv("SMTP_PORT"); portStr != "" {
+		var err error
+		if port, err = strconv.Atoi(portStr); err != nil {
+			return nil, fmt.Errorf("invalid SMTP_PORT: %v", err)
+		}
+	}
+
+	from := os.Getenv("NOTIFY_FROM")
+	if from == "" {
+		return nil, fmt.Errorf("NOTIFY_FROM is required when SMTP_HOST is set")
+	}
+
+	return notify.NewSMTPTransport(notify.SMTPConfig{
+		Host:     host,
+		Port:     port,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     from,
+	}), nil
+}
+
+// smsTransportFromEnv posts texts to SMS_WEBHOOK_URL. Texting is disabled when it is unset.
+func smsTransportFromEnv() notify.Transport {
+	url := os.Getenv("SMS_WEBHOOK_URL")
+	if url == "" {
+		log.Println("SMS_WEBHOOK_URL not set - text notifications are disabled")
+		return nil
+	}
+	return notify.NewWebhookSMSTransport(url, os.Getenv("SMS_WEBHOOK_TOKEN"))
+}
+
+// parkClockFromEnv reads the park's time zone from PARK_TIMEZONE, an IANA name
+// such as America/Chicago. Without it the server's own zone is used.
+func parkClockFromEnv() (clock.Clock, error) {
+	name := os.Getenv("PARK_TIMEZONE")
+	if name == "" {
+		log.Printf("PARK_TIMEZONE not set - using the server time zone %s", time.Local)
+		return clock.New(time.Local), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PARK_TIMEZONE: %v", err)
+	}
+	return clock.New(loc), nil
+}
+
+func notifyConfigFromEnv() notify.Config {
+	config := notify.DefaultConfig()
+	if parkName := os.Getenv("PARK_NAME"); parkName != "" {
+		config.ParkName = parkName
+	}
+	if days, err := strconv.Atoi(os.Getenv("RENT_REMINDER_DAYS")); err == nil && days > 0 {
+		config.ReminderDays = days
+	}
+	if quiet := os.Getenv("QUIET_HOURS"); quiet != "" {
+		// Format is "start-end" in 24h park-local hours, e.g. "21-8"
+		var start, end int
+		if _, err := fmt.Sscanf(quiet, "%d-%d", &start, &end); err == nil &&
+			start >= 0 && start < 24 && end >= 0 && end < 24 {
+			config.QuietHoursStart, config.QuietHoursEnd = start, end
+		} else {
+			log.Printf("Ignoring invalid QUIET_HOURS %q", quiet)
+		}
+	}
+	return config
+}
+
+// idempotencyTTLFromEnv reads how long Idempotency-Key responses are kept
+func idempotencyTTLFromEnv() time.Duration {
+	value := os.Getenv("IDEMPOTENCY_TTL")
+	if value == "" {
+		return idempotency.DefaultTTL
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl <= 0 {
+		log.Printf("Ignoring invalid IDEMPOTENCY_TTL %q", value)
+		return idempotency.DefaultTTL
+	}
+	return ttl
+}
+
+// sessionConfigFromEnv reads how long sessions last. SESSION_IDLE_TIMEOUT is
+// how long an unused token stays valid; SESSION_MAX_AGE caps a session no
+// matter how often it is used.
+func sessionConfigFromEnv() user.SessionConfig {
+	config := user.DefaultSessionConfig()
+	for _, setting := range []struct {
+		name  string
+		value *time.Duration
+	}{
+		{"SESSION_IDLE_TIMEOUT", &config.IdleTimeout},
+		{"SESSION_MAX_AGE", &config.MaxAge},
+	} {
+		value := os.Getenv(setting.name)
+		if value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			log.Printf("Ignoring invalid %s %q", setting.name, value)
+			continue
+		}
+		*setting.value = d
+	}
+	return config
+}
+
+// cookieConfigFromEnv lets browsers keep their session in HttpOnly cookies
+// when SESSION_COOKIES is true. SESSION_COOKIE_SAMESITE is lax, strict or
+// none; none, needed when the frontend is on another site, also needs
+// SESSION_COOKIE_SECURE, which is on unless set to false for local
+// development over plain HTTP. SESSION_COOKIE_DOMAIN shares the cookies with
+// subdomains.
+func cookieConfigFromEnv() (middleware.CookieConfig, error) {
+	config := middleware.DefaultCookieConfig()
+	config.MaxAge = sessionConfigFromEnv().MaxAge
+	config.Domain = os.Getenv("SESSION_COOKIE_DOMAIN")
+	for _, setting := range []struct {
+		name  string
+		value *bool
+	}{
+		{"SESSION_COOKIES", &config.Enabled},
+		{"SESSION_COOKIE_SECURE", &config.Secure},
+	} {
+		value := os.Getenv(setting.name)
+		if value == "" {
+			continue
+		}
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			log.Printf("Ignoring invalid %s %q", setting.name, value)
+			continue
+		}
+		*setting.value = enabled
+	}
+
+	switch value := strings.ToLower(os.Getenv("SESSION_COOKIE_SAMESITE")); value {
+	case "", "lax":
+		config.SameSite = http.SameSiteLaxMode
+	case "strict":
+		config.SameSite = http.SameSiteStrictMode
+	case "none":
+		config.SameSite = http.SameSiteNoneMode
+		if !config.Secure {
+			return config, fmt.Errorf("SESSION_COOKIE_SAMESITE=none needs SESSION_COOKIE_SECURE")
+		}
+	default:
+		return config, fmt.Errorf("invalid SESSION_COOKIE_SAMESITE %q: want lax, strict or none", value)
+	}
+	return config, nil
+}
+
+// lockoutConfigFromEnv reads how many failed sign-ins lock an account
+// (LOGIN_LOCKOUT_THRESHOLD) and for how long (LOGIN_LOCKOUT_DURATION)
+func lockoutConfigFromEnv() user.LockoutConfig {
+	config := user.DefaultLockoutConfig()
+	if value := os.Getenv("LOGIN_LOCKOUT_THRESHOLD"); value != "" {
+		threshold, err := strconv.Atoi(value)
+		if err != nil || threshold <= config.FreeAttempts {
+			log.Printf("Ignoring invalid LOGIN_LOCKOUT_THRESHOLD %q", value)
+		} else {
+			config.AccountThreshold = threshold
+		}
+	}
+	if value := os.Getenv("LOGIN_LOCKOUT_DURATION"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			log.Printf("Ignoring invalid LOGIN_LOCKOUT_DURATION %q", value)
+		} else {
+			config.LockoutDuration = d
+		}
+	}
+	return config
+}
+
+// passwordPolicyFromEnv reads the password rules: the shortest password
+// allowed (PASSWORD_MIN_LENGTH), how many kinds of character it must mix
+// (PASSWORD_MIN_CLASSES), and a file of breached passwords to reject on top of
+// the built-in list (PASSWORD_BLOCKLIST_FILE)
+func passwordPolicyFromEnv() (user.PasswordPolicy, error) {
+	policy := user.DefaultPasswordPolicy()
+	if value := os.Getenv("PASSWORD_MIN_LENGTH"); value != "" {
+		length, err := strconv.Atoi(value)
+		if err != nil || length < 1 {
+			log.Printf("Ignoring invalid PASSWORD_MIN_LENGTH %q", value)
+		} else {
+			policy.MinLength = length
+		}
+	}
+	if value := os.Getenv("PASSWORD_MIN_CLASSES"); value != "" {
+		classes, err := strconv.Atoi(value)
+		if err != nil || classes < 1 || classes > 4 {
+			log.Printf("Ignoring invalid PASSWORD_MIN_CLASSES %q", value)
+		} else {
+			policy.MinClasses = classes
+		}
+	}
+
+	if path := os.Getenv("PASSWORD_BLOCKLIST_FILE"); path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return policy, fmt.Errorf("failed to open PASSWORD_BLOCKLIST_FILE: %v", err)
+		}
+		defer file.Close()
+		if err := policy.Blocklist.Load(file); err != nil {
+			return policy, fmt.Errorf("failed to read PASSWORD_BLOCKLIST_FILE: %v", err)
+		}
+		log.Printf("Loaded %d blocked passwords", len(policy.Blocklist))
+	}
+	return policy, nil
+}
+
+// accountEmailConfigFromEnv reads where password reset and invitation links
+// point (APP_URL, the frontend's address)
+func accountEmailConfigFromEnv() user.AccountEmailConfig {
+	config := user.DefaultAccountEmailConfig()
+	config.AppURL = os.Getenv("APP_URL")
+	if config.AppURL == "" {
+		log.Println("APP_URL not set - password reset and invitation links will be relative")
+	}
+	return config
+}
+
+// twoFactorConfigFromEnv names accounts in authenticator apps after the park
+// (PARK_NAME) and, when REQUIRE_ADMIN_2FA is true, makes administrators sign
+// in with a second factor
+func twoFactorConfigFromEnv() user.TwoFactorConfig {
+	config := user.DefaultTwoFactorConfig()
+	if parkName := os.Getenv("PARK_NAME"); parkName != "" {
+		config.Issuer = parkName
+	}
+	if value := os.Getenv("REQUIRE_ADMIN_2FA"); value != "" {
+		required, err := strconv.ParseBool(value)
+		if err != nil {
+			log.Printf("Ignoring invalid REQUIRE_ADMIN_2FA %q", value)
+		} else if required {
+			config.RequiredRoles = []user.Role{user.RoleAdmin}
+		}
+	}
+	return config
+}
+
+// oidcConfigFromEnv turns on single sign-on when OIDC_ISSUER is set. The
+// provider must send users back to OIDC_REDIRECT_URL, by default the
+// frontend's /login/callback page, and OIDC_GROUP_ROLES maps the provider's
+// groups to roles as comma-separated group=ROLE pairs.
+func oidcConfigFromEnv() (user.OIDCConfig, error) {
+	config := user.DefaultOIDCConfig()
+	config.Issuer = os.Getenv("OIDC_ISSUER")
+	if config.Issuer == "" {
+		return config, nil
+	}
+	config.ClientID = os.Getenv("OIDC_CLIENT_ID")
+	config.ClientSecret = os.Getenv("OIDC_CLIENT_SECRET")
+	if config.ClientID == "" {
+		return config, fmt.Errorf("OIDC_CLIENT_ID is required when OIDC_ISSUER is set")
+	}
+	config.RedirectURL = os.Getenv("OIDC_REDIRECT_URL")
+	if config.RedirectURL == "" {
+		config.RedirectURL = strings.TrimSuffix(os.Getenv("APP_URL"), "/") + "/login/callback"
+	}
+	if scopes := os.Getenv("OIDC_SCOPES"); scopes != "" {
+		config.Scopes = strings.Fields(scopes)
+	}
+	if claim := os.Getenv("OIDC_GROUPS_CLAIM"); claim != "" {
+		config.GroupsClaim = claim
+	}
+
+	config.GroupRoles = map[string]user.Role{}
+	for _, pair := range strings.Split(os.Getenv("OIDC_GROUP_ROLES"), ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		group, role, ok := strings.Cut(pair, "=")
+		role = strings.ToUpper(strings.TrimSpace(role))
+		if !ok || (user.Role(role) != user.RoleAdmin && user.Role(role) != user.RoleStaff) {
+			return config, fmt.Errorf("invalid OIDC_GROUP_ROLES entry %q: want group=%s or group=%s", pair, user.RoleAdmin, user.RoleStaff)
+		}
+		config.GroupRoles[strings.TrimSpace(group)] = user.Role(role)
+	}
+	if len(config.GroupRoles) == 0 {
+		log.Println("OIDC_GROUP_ROLES not set - nobody will be able to sign in with single sign-on")
+	}
+	return config, nil
+}
+
+// registerJobs schedules the recurring work run by the server
+func registerJobs(svc *appServices) error {
+	jobs := []struct {
+		name, spec, description string
+		run                     func() error
+	}{
+		{"clean-expired-tokens", "@hourly", "Delete expired and revoked authentication tokens", func() error {
+			return svc.tokenRepo.CleanExpiredTokens(context.Background(), svc.clock.Now())
+		}},
+		{"clean-login-throttles", "@hourly", "Forget failed sign-ins older than the lockout window", func() error {
+			return svc.userService.CleanLoginThrottles(context.Background())
+		}},
+		{"clean-account-tokens", "@daily", "Delete used and expired password reset and invitation links", func() error {
+			return svc.userService.CleanAccountTokens(context.Background())
+		}},
+		{"clean-oidc-logins", "@daily", "Delete single sign-on attempts that were never finished", func() error {
+			return svc.userService.CleanOIDCLogins(context.Background())
+		}},
+		{"send-rent-reminders", "0 9 * * *", "Email and text tenants about upcoming and overdue rent", svc.notifyService.SendRentReminders},
+		{"send-payment-receipts", "*/15 * * * *", "Email receipts for recently recorded payments", svc.notifyService.SendReceipts},
+		{"retry-notifications", "*/10 * * * *", "Retry notifications that failed to send", svc.notifyService.RetryFailed},
+		{"clean-idempotency-keys", "@hourly", "Forget Idempotency-Key responses past their retention window", func() error {
+			return svc.idempotencyService.CleanExpired(context.Background())
+		}},
+	}
+
+	for _, j := range jobs {
+		if err := svc.jobService.Register(j.name, j.spec, j.description, j.run); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openDatabase connects to the configured database, retrying until it is reachable
+func openDatabase() (*sql.DB, error) {
 	// Get database configuration
 	dbURL := getDBConfig()
 	log.Printf("Attempting to connect to database...")
@@ -191,9 +634,8 @@ func main() {
 	// Open database connection with adjusted settings for cloud environment
 	db, err := sql.Open("postgres", dbURL)
 	if err != nil {
-		log.Fatalf("Failed to open database connection: %v", err)
+		return nil, fmt.Errorf("failed to open database connection: %v", err)
 	}
-	defer db.Close()
 
 	// Set connection pool settings
 	db.SetMaxOpenConns(25) // Render's free tier limit
@@ -203,53 +645,69 @@ func main() {
 	// Check database connection with retries
 	log.Println("Checking database connection...")
 	if err := checkDatabaseConnection(db); err != nil {
-		log.Fatalf("Database connection failed: %v", err)
+		db.Close()
+		return nil, err
 	}
 
-	// Initialize database schema
-	log.Println("Initializing database schema...")
-	if err := initializeDatabase(db); err != nil {
-		log.Printf("Warning: Database initialization failed: %v", err)
-		// Continue execution as tables might already exist
-	}
+	return db, nil
+}
 
-	// Verify required tables exist
-	log.Println("Verifying database tables...")
-	if err := checkRequiredTables(db); err != nil {
-		log.Fatalf("Database verification failed: %v", err)
+// serve migrates the database, ensures the default accounts exist and runs the HTTP server
+func serve(db *sql.DB) error {
+	// Bring the database schema up to date
+	log.Println("Migrating database schema...")
+	if err := runMigrations(db); err != nil {
+		return err
 	}
 
-	// Initialize repositories
-	userRepo := user.NewSQLRepository(db)
-	tokenRepo := user.NewTokenRepository(db)
-	tenantRepo := tenant.NewSQLRepository(db)
-	spaceRepo := space.NewSQLRepository(db)
-	paymentRepo := payment.NewSQLRepository(db)
+	// Verify the schema is the one this build expects
+	log.Println("Verifying database schema...")
+	if err := checkSchemaVersion(db); err != nil {
+		return fmt.Errorf("database verification failed: %v", err)
+	}
 
-	// Initialize services
-	userService := user.NewService(userRepo, tokenRepo)
-	tenantService := tenant.NewService(tenantRepo)
-	spaceService := space.NewService(spaceRepo, tenantService)
-	paymentService := payment.NewService(paymentRepo)
+	svc, err := newAppServices(db)
+	if err != nil {
+		return err
+	}
 
 	// Ensure admin and staff users exist
-	if err := ensureAdminExists(userService); err != nil {
-		log.Fatalf("Failed to ensure admin exists: %v", err)
+	ctx := context.Background()
+	if err := ensureAdminExists(ctx, svc.userService); err != nil {
+		return fmt.Errorf("failed to ensure admin exists: %v", err)
 	}
 
-	if err := ensureStaffExists(userService); err != nil {
-		log.Fatalf("Failed to ensure staff exists: %v", err)
+	if err := ensureStaffExists(ctx, svc.userService); err != nil {
+		return fmt.Errorf("failed to ensure staff exists: %v", err)
 	}
 
+	// Start background jobs
+	if err := registerJobs(svc); err != nil {
+		return fmt.Errorf("failed to register jobs: %v", err)
+	}
+	svc.jobService.Start()
+	defer svc.jobService.Stop()
+
 	// Initialize auth middleware
-	authMiddleware := middleware.NewAuthMiddleware(userService)
+	cookies, err := cookieConfigFromEnv()
+	if err != nil {
+		return err
+	}
+	proxies, err := middleware.ParseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		return err
+	}
+	authMiddleware := middleware.NewAuthMiddleware(svc.userService, cookies, proxies)
 
 	// Initialize server with all services
 	server := api.NewServer(
-		userService,
-		tenantService,
-		spaceService,
-		paymentService,
+		svc.userService,
+		svc.tenantService,
+		svc.spaceService,
+		svc.paymentService,
+		svc.jobService,
+		svc.notifyService,
+		svc.idempotencyService,
 		authMiddleware,
 	)
 
@@ -266,7 +724,29 @@ func main() {
 	log.Printf("Server starting on port %s", port)
 
 	// Start the server
-	if err := http.ListenAndServe(addr, server.Mux); err != nil {
-		log.Fatalf("Server failed to start: %v", err)
+	return http.ListenAndServe(addr, server.Mux)
+}
+
+func main() {
+	// Default to serving so existing deployments keep working without arguments
+	command := "serve"
+	args := os.Args[1:]
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	if command == "help" || command == "-h" || command == "--help" {
+		printUsage()
+		return
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		log.Fatalf("Database connection failed: %v", err)
+	}
+	defer db.Close()
+
+	if err := runCommand(db, command, args); err != nil {
+		log.Fatalf("%s failed: %v", command, err)
 	}
 }
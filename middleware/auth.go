@@ -4,8 +4,10 @@ package middleware
 import (
 	"context"
 	"net/http"
+	"net/netip"
 	"strings"
 
+	"github.com/BodaciousX/RVParkBackend/apperr"
 	"github.com/BodaciousX/RVParkBackend/user"
 )
 
@@ -15,40 +17,71 @@ type ContextKey string
 
 const userContextKey ContextKey = "user"
 
+// APIKeyContextKey holds the *user.APIKey a request was made with, if any
+var APIKeyContextKey = ContextKey("apiKey")
+
+// APIKeyHeader carries an API key in place of a bearer token
+const APIKeyHeader = "X-API-Key"
+
 type AuthMiddleware struct {
 	userService user.Service
+	cookies     CookieConfig
+	proxies     []netip.Prefix
 }
 
-func (m *AuthMiddleware) RevokeUserTokens(userID string) error {
-	return m.userService.RevokeAllTokens(userID)
+func (m *AuthMiddleware) RevokeUserTokens(ctx context.Context, userID string) error {
+	return m.userService.RevokeAllTokens(ctx, userID)
 }
 
-func NewAuthMiddleware(userService user.Service) *AuthMiddleware {
+// NewAuthMiddleware builds the middleware. Requests from proxies, parsed with
+// ParseTrustedProxies, are attributed to the client they forwarded for.
+func NewAuthMiddleware(userService user.Service, cookies CookieConfig, proxies []netip.Prefix) *AuthMiddleware {
 	return &AuthMiddleware{
 		userService: userService,
+		cookies:     cookies,
+		proxies:     proxies,
 	}
 }
 
+// RequireAuth accepts a bearer token or, if there is no Authorization
+// header, an API key or a session cookie. Routes that accept keys check
+// their scopes with RequireScope; every other route should turn keys away
+// with Interactive. Cookie sessions must pass the CSRF check.
 func (m *AuthMiddleware) RequireAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Get token from Authorization header
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		if key := r.Header.Get(APIKeyHeader); key != "" && r.Header.Get("Authorization") == "" {
+			user, apiKey, err := m.userService.ValidateAPIKey(r.Context(), key)
+			if err != nil {
+				apperr.WriteMessage(w, http.StatusUnauthorized, "invalid API key")
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), userContextKey, user)
+			ctx = context.WithValue(ctx, APIKeyContextKey, apiKey)
+			next.ServeHTTP(w, r.WithContext(ctx))
 			return
 		}
 
-		// Extract token from "Bearer <token>"
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			http.Error(w, "invalid authorization header", http.StatusUnauthorized)
+		// Get token from Authorization header, or the session cookie
+		fromCookie := r.Header.Get("Authorization") == ""
+		token, ok := m.SessionToken(r)
+		if !ok && fromCookie {
+			apperr.WriteMessage(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		if !ok {
+			apperr.WriteMessage(w, http.StatusUnauthorized, "invalid authorization header")
 			return
 		}
 
 		// Validate token
-		user, err := m.userService.ValidateToken(parts[1])
+		user, err := m.userService.ValidateToken(r.Context(), token)
 		if err != nil {
-			http.Error(w, "invalid token", http.StatusUnauthorized)
+			apperr.WriteMessage(w, http.StatusUnauthorized, "invalid token")
+			return
+		}
+		if fromCookie && !checkCSRF(r, token) {
+			apperr.WriteMessage(w, http.StatusForbidden, "missing or invalid CSRF token")
 			return
 		}
 
@@ -58,18 +91,53 @@ func (m *AuthMiddleware) RequireAuth(next http.Handler) http.Handler {
 	})
 }
 
+// BearerToken returns the token from an "Authorization: Bearer <token>" header
+func BearerToken(r *http.Request) (string, bool) {
+	parts := strings.Split(r.Header.Get("Authorization"), " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return "", false
+	}
+	return parts[1], true
+}
+
+// RequireScope turns away requests made with an API key that lacks scope.
+// Requests made with a bearer token are let through.
+func RequireScope(scope user.Scope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if apiKey, ok := r.Context().Value(APIKeyContextKey).(*user.APIKey); ok && !apiKey.Allows(scope) {
+				apperr.WriteMessage(w, http.StatusForbidden, "API key lacks the "+string(scope)+" scope")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Interactive turns away requests made with an API key, for routes only
+// people who signed in may use
+func Interactive(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Context().Value(APIKeyContextKey) != nil {
+			apperr.WriteMessage(w, http.StatusForbidden, "API keys can't be used here")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (m *AuthMiddleware) RequireAdmin(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Get user from context (set by RequireAuth)
 		contextUser := r.Context().Value(userContextKey)
 		if contextUser == nil {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			apperr.WriteMessage(w, http.StatusUnauthorized, "unauthorized")
 			return
 		}
 
 		user := contextUser.(*user.User)
 		if user.Role != "ADMIN" {
-			http.Error(w, "forbidden", http.StatusForbidden)
+			apperr.WriteMessage(w, http.StatusForbidden, "forbidden")
 			return
 		}
 
@@ -9,6 +9,7 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/BodaciousX/RVParkBackend/paging"
 	"github.com/BodaciousX/RVParkBackend/user"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -19,12 +20,12 @@ type MockUserService struct {
 	mock.Mock
 }
 
-func (m *MockUserService) CreateUser(user user.User, password string) error {
+func (m *MockUserService) CreateUser(ctx context.Context, user user.User, password string) error {
 	args := m.Called(user, password)
 	return args.Error(0)
 }
 
-func (m *MockUserService) GetUser(id string) (*user.User, error) {
+func (m *MockUserService) GetUser(ctx context.Context, id string) (*user.User, error) {
 	args := m.Called(id)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -32,7 +33,7 @@ func (m *MockUserService) GetUser(id string) (*user.User, error) {
 	return args.Get(0).(*user.User), args.Error(1)
 }
 
-func (m *MockUserService) GetUserByEmail(email string) (*user.User, error) {
+func (m *MockUserService) GetUserByEmail(ctx context.Context, email string) (*user.User, error) {
 	args := m.Called(email)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -40,25 +41,59 @@ func (m *MockUserService) GetUserByEmail(email string) (*user.User, error) {
 	return args.Get(0).(*user.User), args.Error(1)
 }
 
-func (m *MockUserService) UpdateUser(user user.User) error {
+func (m *MockUserService) UpdateUser(ctx context.Context, user user.User) error {
 	args := m.Called(user)
 	return args.Error(0)
 }
 
-func (m *MockUserService) DeleteUser(id string) error {
+func (m *MockUserService) DeleteUser(ctx context.Context, id string) error {
 	args := m.Called(id)
 	return args.Error(0)
 }
 
-func (m *MockUserService) Login(creds user.LoginCredentials) (*user.User, string, error) {
+func (m *MockUserService) Login(ctx context.Context, creds user.LoginCredentials) (*user.LoginResult, error) {
 	args := m.Called(creds)
 	if args.Get(0) == nil {
-		return nil, "", args.Error(2)
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*user.LoginResult), args.Error(1)
+}
+
+func (m *MockUserService) VerifyLoginChallenge(ctx context.Context, resp user.ChallengeResponse) (*user.LoginResult, error) {
+	args := m.Called(resp)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*user.LoginResult), args.Error(1)
+}
+
+func (m *MockUserService) EnrollTOTPForChallenge(ctx context.Context, challenge string) (*user.TOTPEnrollment, error) {
+	args := m.Called(challenge)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*user.TOTPEnrollment), args.Error(1)
+}
+
+func (m *MockUserService) StartOIDCLogin(ctx context.Context) (string, error) {
+	args := m.Called()
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockUserService) CompleteOIDCLogin(ctx context.Context, callback user.OIDCCallback) (*user.LoginResult, error) {
+	args := m.Called(callback)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
 	}
-	return args.Get(0).(*user.User), args.String(1), args.Error(2)
+	return args.Get(0).(*user.LoginResult), args.Error(1)
 }
 
-func (m *MockUserService) ValidateToken(token string) (*user.User, error) {
+func (m *MockUserService) CleanOIDCLogins(ctx context.Context) error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func (m *MockUserService) ValidateToken(ctx context.Context, token string) (*user.User, error) {
 	args := m.Called(token)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -66,20 +101,168 @@ func (m *MockUserService) ValidateToken(token string) (*user.User, error) {
 	return args.Get(0).(*user.User), args.Error(1)
 }
 
-func (m *MockUserService) ChangePassword(userID string, oldPassword, newPassword string) error {
-	args := m.Called(userID, oldPassword, newPassword)
+func (m *MockUserService) ChangePassword(ctx context.Context, userID, currentToken, oldPassword, newPassword string) error {
+	args := m.Called(userID, currentToken, oldPassword, newPassword)
+	return args.Error(0)
+}
+
+func (m *MockUserService) ResetPassword(ctx context.Context, userID string, newPassword string) error {
+	args := m.Called(userID, newPassword)
+	return args.Error(0)
+}
+
+func (m *MockUserService) Logout(ctx context.Context, token string) error {
+	args := m.Called(token)
+	return args.Error(0)
+}
+
+func (m *MockUserService) ListSessions(ctx context.Context, userID string) ([]user.Token, error) {
+	args := m.Called(userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]user.Token), args.Error(1)
+}
+
+func (m *MockUserService) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	args := m.Called(userID, sessionID)
+	return args.Error(0)
+}
+
+func (m *MockUserService) RevokeAllTokens(ctx context.Context, userID string) error {
+	args := m.Called(userID)
+	return args.Error(0)
+}
+
+func (m *MockUserService) UnlockUser(ctx context.Context, userID string) error {
+	args := m.Called(userID)
+	return args.Error(0)
+}
+
+func (m *MockUserService) ListLoginEvents(ctx context.Context, userID string, limit int) ([]user.LoginEvent, error) {
+	args := m.Called(userID, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]user.LoginEvent), args.Error(1)
+}
+
+func (m *MockUserService) CleanLoginThrottles(ctx context.Context) error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func (m *MockUserService) RequestPasswordReset(ctx context.Context, email string) error {
+	args := m.Called(email)
+	return args.Error(0)
+}
+
+func (m *MockUserService) ConfirmPasswordReset(ctx context.Context, token, newPassword string) error {
+	args := m.Called(token, newPassword)
+	return args.Error(0)
+}
+
+func (m *MockUserService) InviteUser(ctx context.Context, u user.User) (*user.User, error) {
+	args := m.Called(u)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*user.User), args.Error(1)
+}
+
+func (m *MockUserService) AcceptInvite(ctx context.Context, token, password string) error {
+	args := m.Called(token, password)
+	return args.Error(0)
+}
+
+func (m *MockUserService) CleanAccountTokens(ctx context.Context) error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func (m *MockUserService) GetTwoFactorStatus(ctx context.Context, userID string) (*user.TwoFactorStatus, error) {
+	args := m.Called(userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*user.TwoFactorStatus), args.Error(1)
+}
+
+func (m *MockUserService) EnrollTOTP(ctx context.Context, userID string) (*user.TOTPEnrollment, error) {
+	args := m.Called(userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*user.TOTPEnrollment), args.Error(1)
+}
+
+func (m *MockUserService) ConfirmTOTP(ctx context.Context, userID, code string) ([]string, error) {
+	args := m.Called(userID, code)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *MockUserService) DisableTwoFactor(ctx context.Context, userID, code string) error {
+	args := m.Called(userID, code)
 	return args.Error(0)
 }
 
-func (m *MockUserService) RevokeAllTokens(userID string) error {
+func (m *MockUserService) RegenerateRecoveryCodes(ctx context.Context, userID, code string) ([]string, error) {
+	args := m.Called(userID, code)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *MockUserService) ResetTwoFactor(ctx context.Context, userID string) error {
 	args := m.Called(userID)
 	return args.Error(0)
 }
 
+func (m *MockUserService) CreateAPIKey(ctx context.Context, key user.APIKey) (*user.APIKey, string, error) {
+	args := m.Called(key)
+	if args.Get(0) == nil {
+		return nil, "", args.Error(2)
+	}
+	return args.Get(0).(*user.APIKey), args.String(1), args.Error(2)
+}
+
+func (m *MockUserService) ListAPIKeys(ctx context.Context) ([]user.APIKey, error) {
+	args := m.Called()
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]user.APIKey), args.Error(1)
+}
+
+func (m *MockUserService) RevokeAPIKey(ctx context.Context, id string) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockUserService) ValidateAPIKey(ctx context.Context, key string) (*user.User, *user.APIKey, error) {
+	args := m.Called(key)
+	if args.Get(0) == nil {
+		return nil, nil, args.Error(2)
+	}
+	return args.Get(0).(*user.User), args.Get(1).(*user.APIKey), args.Error(2)
+}
+
+func (m *MockUserService) FindUsers(ctx context.Context, filter user.Filter, page paging.Request) (*paging.Page[user.User], error) {
+	args := m.Called(filter, page)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*paging.Page[user.User]), args.Error(1)
+}
+
 func TestRequireAuth(t *testing.T) {
 	// Setup
 	mockUserService := new(MockUserService)
-	authMiddleware := NewAuthMiddleware(mockUserService)
+	authMiddleware := NewAuthMiddleware(mockUserService, DefaultCookieConfig(), nil)
 
 	// Create a simple handler for testing
 	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -118,7 +301,10 @@ func TestRequireAuth(t *testing.T) {
 
 	handlerToTest.ServeHTTP(recorder, req)
 
+	// Rejections use the same JSON envelope as the handlers
 	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"error": {"code": "unauthorized", "message": "invalid token"}}`, recorder.Body.String())
 	mockUserService.AssertExpectations(t)
 
 	// Test case: valid token
@@ -144,7 +330,7 @@ func TestRequireAuth(t *testing.T) {
 func TestRequireAdmin(t *testing.T) {
 	// Setup
 	mockUserService := new(MockUserService)
-	authMiddleware := NewAuthMiddleware(mockUserService)
+	authMiddleware := NewAuthMiddleware(mockUserService, DefaultCookieConfig(), nil)
 
 	// Create a simple handler for testing
 	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -177,6 +363,7 @@ func TestRequireAdmin(t *testing.T) {
 	handlerToTest.ServeHTTP(recorder, req)
 
 	assert.Equal(t, http.StatusForbidden, recorder.Code)
+	assert.JSONEq(t, `{"error": {"code": "forbidden", "message": "forbidden"}}`, recorder.Body.String())
 
 	// Test case: admin user
 	req = httptest.NewRequest("GET", "http://example.com", nil)
@@ -199,19 +386,118 @@ func TestRequireAdmin(t *testing.T) {
 func TestRevokeUserTokens(t *testing.T) {
 	// Setup
 	mockUserService := new(MockUserService)
-	authMiddleware := NewAuthMiddleware(mockUserService)
+	authMiddleware := NewAuthMiddleware(mockUserService, DefaultCookieConfig(), nil)
 
 	// Test case: successful revocation
 	mockUserService.On("RevokeAllTokens", "user123").Return(nil).Once()
 
-	err := authMiddleware.RevokeUserTokens("user123")
+	err := authMiddleware.RevokeUserTokens(context.Background(), "user123")
 	assert.NoError(t, err)
 	mockUserService.AssertExpectations(t)
 
 	// Test case: error
 	mockUserService.On("RevokeAllTokens", "user123").Return(errors.New("db error")).Once()
 
-	err = authMiddleware.RevokeUserTokens("user123")
+	err = authMiddleware.RevokeUserTokens(context.Background(), "user123")
 	assert.Error(t, err)
 	mockUserService.AssertExpectations(t)
 }
+
+func TestRequireAuth_APIKey(t *testing.T) {
+	mockUserService := new(MockUserService)
+	authMiddleware := NewAuthMiddleware(mockUserService, DefaultCookieConfig(), nil)
+
+	syncUser := &user.User{ID: "sync1", Role: user.RoleStaff}
+	apiKey := &user.APIKey{ID: "key1", UserID: "sync1", Scopes: []user.Scope{user.ScopePaymentsRead}}
+	mockUserService.On("ValidateAPIKey", "rvpk_good").Return(syncUser, apiKey, nil)
+	mockUserService.On("ValidateAPIKey", "rvpk_bad").Return(nil, nil, errors.New("API key is expired or revoked"))
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "sync1", r.Context().Value(userContextKey).(*user.User).ID)
+		w.WriteHeader(http.StatusOK)
+	})
+	serve := func(handler http.Handler, key string) int {
+		req := httptest.NewRequest("GET", "http://example.com", nil)
+		req.Header.Set(APIKeyHeader, key)
+		recorder := httptest.NewRecorder()
+		authMiddleware.RequireAuth(handler).ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, serve(ok, "rvpk_bad"))
+	assert.Equal(t, http.StatusOK, serve(RequireScope(user.ScopePaymentsRead)(ok), "rvpk_good"))
+	assert.Equal(t, http.StatusForbidden, serve(RequireScope(user.ScopePaymentsWrite)(ok), "rvpk_good"))
+	assert.Equal(t, http.StatusForbidden, serve(Interactive(ok), "rvpk_good"))
+
+	// Bearer tokens aren't limited by scopes
+	mockUserService.On("ValidateToken", "validtoken").Return(syncUser, nil)
+	req := httptest.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("Authorization", "Bearer validtoken")
+	recorder := httptest.NewRecorder()
+	authMiddleware.RequireAuth(Interactive(RequireScope(user.ScopePaymentsWrite)(ok))).ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
+
+func TestRequireAuth_Cookie(t *testing.T) {
+	mockUserService := new(MockUserService)
+	cookies := DefaultCookieConfig()
+	cookies.Enabled = true
+	authMiddleware := NewAuthMiddleware(mockUserService, cookies, nil)
+
+	staff := &user.User{ID: "user1", Role: user.RoleStaff}
+	mockUserService.On("ValidateToken", "session-token").Return(staff, nil)
+	mockUserService.On("ValidateToken", "other-token").Return(staff, nil)
+
+	// Signing in sets an HttpOnly session cookie and a readable CSRF cookie
+	recorder := httptest.NewRecorder()
+	csrf := authMiddleware.SetSessionCookies(recorder, "session-token")
+	set := recorder.Result().Cookies()
+	assert.Len(t, set, 2)
+	for _, cookie := range set {
+		assert.True(t, cookie.Secure)
+		assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+		assert.Equal(t, cookie.Name == SessionCookieName, cookie.HttpOnly)
+	}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "user1", r.Context().Value(userContextKey).(*user.User).ID)
+		w.WriteHeader(http.StatusOK)
+	})
+	serve := func(middleware *AuthMiddleware, method, session, csrfCookie, csrfHeader string) int {
+		req := httptest.NewRequest(method, "http://example.com", nil)
+		req.AddCookie(&http.Cookie{Name: SessionCookieName, Value: session})
+		if csrfCookie != "" {
+			req.AddCookie(&http.Cookie{Name: CSRFCookieName, Value: csrfCookie})
+		}
+		if csrfHeader != "" {
+			req.Header.Set(CSRFHeader, csrfHeader)
+		}
+		recorder := httptest.NewRecorder()
+		middleware.RequireAuth(ok).ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	// Reads need only the session cookie; changes need the CSRF token too
+	assert.Equal(t, http.StatusOK, serve(authMiddleware, "GET", "session-token", "", ""))
+	assert.Equal(t, http.StatusForbidden, serve(authMiddleware, "POST", "session-token", csrf, ""))
+	assert.Equal(t, http.StatusForbidden, serve(authMiddleware, "DELETE", "session-token", "", csrf))
+	assert.Equal(t, http.StatusOK, serve(authMiddleware, "POST", "session-token", csrf, csrf))
+
+	// A token from another session doesn't count, even if cookie and header agree
+	otherCSRF := csrfToken("other-token")
+	assert.Equal(t, http.StatusForbidden, serve(authMiddleware, "POST", "session-token", otherCSRF, otherCSRF))
+
+	// Cookies are ignored unless cookie sessions are enabled
+	disabled := NewAuthMiddleware(mockUserService, DefaultCookieConfig(), nil)
+	assert.Equal(t, http.StatusUnauthorized, serve(disabled, "GET", "session-token", "", ""))
+
+	// Bearer tokens aren't subject to CSRF checks
+	req := httptest.NewRequest("POST", "http://example.com", nil)
+	req.Header.Set("Authorization", "Bearer other-token")
+	req.AddCookie(&http.Cookie{Name: SessionCookieName, Value: "session-token"})
+	recorder = httptest.NewRecorder()
+	authMiddleware.RequireAuth(ok).ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	token, _ := authMiddleware.SessionToken(req)
+	assert.Equal(t, "other-token", token)
+}
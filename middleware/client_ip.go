@@ -0,0 +1,86 @@
+// middleware/client_ip.go
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ParseTrustedProxies reads a comma-separated list of the addresses or CIDR
+// ranges of the proxies in front of the API, as set in TRUSTED_PROXIES. Only
+// these are believed about the X-Forwarded-For header; with none listed it is
+// ignored.
+func ParseTrustedProxies(list string) ([]netip.Prefix, error) {
+	var proxies []netip.Prefix
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid TRUSTED_PROXIES entry %q: %v", entry, err)
+			}
+			proxies = append(proxies, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TRUSTED_PROXIES entry %q: %v", entry, err)
+		}
+		addr = addr.Unmap()
+		proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return proxies, nil
+}
+
+// ClientIP is the address a request came from. When it arrived through a
+// trusted proxy that is the X-Forwarded-For entry the nearest trusted proxy
+// added, found by walking the header back past any other trusted proxies;
+// entries further left come from the client and can't be believed. Otherwise
+// it is the connection's own address, as anyone can send the header. Failed
+// sign-ins are throttled by this address.
+func (m *AuthMiddleware) ClientIP(r *http.Request) string {
+	client := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		client = host
+	}
+
+	if !trusted(m.proxies, client) {
+		return client
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if _, err := netip.ParseAddr(hop); err != nil {
+			break
+		}
+		client = hop
+		if !trusted(m.proxies, hop) {
+			break
+		}
+	}
+	return client
+}
+
+func trusted(proxies []netip.Prefix, ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, proxy := range proxies {
+		if proxy.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
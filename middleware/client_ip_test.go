@@ -0,0 +1,57 @@
+// middleware/client_ip_test.go
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientIP(t *testing.T) {
+	m := NewAuthMiddleware(nil, DefaultCookieConfig(), nil)
+	request := func(remoteAddr string, forwarded ...string) string {
+		req := httptest.NewRequest("POST", "/v1/login", nil)
+		req.RemoteAddr = remoteAddr
+		for _, header := range forwarded {
+			req.Header.Add("X-Forwarded-For", header)
+		}
+		return m.ClientIP(req)
+	}
+
+	// With no proxies trusted the header is ignored
+	assert.Equal(t, "198.51.100.4", request("198.51.100.4:52110", "203.0.113.7"))
+
+	proxies, err := ParseTrustedProxies("10.1.0.0/16, 192.0.2.10")
+	assert.NoError(t, err)
+	m = NewAuthMiddleware(nil, DefaultCookieConfig(), proxies)
+
+	// Anyone else sending the header is ignored too
+	assert.Equal(t, "198.51.100.4", request("198.51.100.4:52110", "203.0.113.7"))
+
+	// Through a trusted proxy, the address it saw is used and what the client
+	// claimed before it is not
+	assert.Equal(t, "203.0.113.7", request("10.1.0.5:41234", "10.0.0.1, 203.0.113.7"))
+	assert.Equal(t, "203.0.113.7", request("10.1.0.5:41234", "10.0.0.1", "203.0.113.7"))
+
+	// Chains of trusted proxies are walked back to the first untrusted hop
+	assert.Equal(t, "203.0.113.7", request("10.1.0.5:41234", "10.0.0.1, 203.0.113.7, 192.0.2.10"))
+
+	// A proxy that added nothing leaves its own address
+	assert.Equal(t, "10.1.0.5", request("10.1.0.5:41234"))
+	assert.Equal(t, "10.1.0.5", request("10.1.0.5:41234", "not an address"))
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.1.0.0/16,::ffff:192.0.2.10, ")
+	assert.NoError(t, err)
+	assert.Len(t, proxies, 2)
+	assert.True(t, trusted(proxies, "192.0.2.10"))
+
+	proxies, err = ParseTrustedProxies("")
+	assert.NoError(t, err)
+	assert.Empty(t, proxies)
+
+	_, err = ParseTrustedProxies("10.1.0.0/99")
+	assert.Error(t, err)
+}
@@ -7,29 +7,29 @@ import (
 	"strings"
 )
 
+// CORS lets the browser origins in CORS_ORIGIN, a comma-separated list, call
+// the API. Listed origins may send credentials, which is what lets a
+// frontend on another site use cookie sessions. "*" lets any origin call the
+// API but never with credentials, as that would let every site act as the
+// signed-in user. With CORS_ORIGIN unset the local development frontend is
+// allowed.
 func CORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		allowedOrigins := strings.Split(os.Getenv("CORS_ORIGIN"), ",")
-		origin := r.Header.Get("Origin")
-
-		// Check if the request origin is in the allowed origins
-		allowedOrigin := ""
-		for _, allowed := range allowedOrigins {
-			if allowed == origin || allowed == "*" {
-				allowedOrigin = origin
-				break
-			}
-		}
+		// Responses differ by origin, so caches must not share them
+		w.Header().Add("Vary", "Origin")
 
-		// If no match found and no origins configured, use development default
-		if allowedOrigin == "" && len(allowedOrigins) == 0 {
-			allowedOrigin = "http://localhost:3000"
+		if origin := r.Header.Get("Origin"); origin != "" {
+			switch allowOrigin(origin) {
+			case originWithCredentials:
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			case originWithoutCredentials:
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			}
 		}
-
-		w.Header().Set("Access-Control-Allow-Origin", allowedOrigin)
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS, PATCH")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Requested-With")
-		w.Header().Set("Access-Control-Allow-Credentials", "true")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, X-CSRF-Token, X-Requested-With, If-Match, Idempotency-Key")
+		w.Header().Set("Access-Control-Expose-Headers", "ETag, Link, X-Total-Count, X-Next-Cursor, Idempotent-Replayed")
 		w.Header().Set("Access-Control-Max-Age", "3600")
 
 		if r.Method == http.MethodOptions {
@@ -40,3 +40,32 @@ func CORS(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+type originAccess int
+
+const (
+	originDenied originAccess = iota
+	originWithoutCredentials
+	originWithCredentials
+)
+
+// allowOrigin checks origin against CORS_ORIGIN. Entries are compared
+// without a trailing slash, which browsers never send in Origin.
+func allowOrigin(origin string) originAccess {
+	setting := os.Getenv("CORS_ORIGIN")
+	if strings.TrimSpace(setting) == "" {
+		setting = "http://localhost:3000"
+	}
+
+	access := originDenied
+	for _, allowed := range strings.Split(setting, ",") {
+		allowed = strings.TrimSuffix(strings.TrimSpace(allowed), "/")
+		switch {
+		case allowed == "*":
+			access = originWithoutCredentials
+		case strings.EqualFold(allowed, origin):
+			return originWithCredentials
+		}
+	}
+	return access
+}
@@ -0,0 +1,48 @@
+// middleware/cors_test.go
+
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCORS(t *testing.T) {
+	handler := CORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	serve := func(origin string) http.Header {
+		req := httptest.NewRequest("GET", "http://api.example.com/v1/spaces", nil)
+		req.Header.Set("Origin", origin)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder.Header()
+	}
+
+	// Listed origins may send cookies, however the list is written
+	t.Setenv("CORS_ORIGIN", "https://app.example.com/, https://admin.example.com")
+	headers := serve("https://app.example.com")
+	assert.Equal(t, "https://app.example.com", headers.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", headers.Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "Origin", headers.Get("Vary"))
+	assert.Equal(t, "https://admin.example.com", serve("https://admin.example.com").Get("Access-Control-Allow-Origin"))
+
+	// Other origins get nothing to let them through
+	headers = serve("https://evil.example.com")
+	assert.Empty(t, headers.Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, headers.Get("Access-Control-Allow-Credentials"))
+
+	// A wildcard never allows credentials
+	t.Setenv("CORS_ORIGIN", "*")
+	headers = serve("https://evil.example.com")
+	assert.Equal(t, "*", headers.Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, headers.Get("Access-Control-Allow-Credentials"))
+
+	// Unset, the local development frontend is allowed
+	t.Setenv("CORS_ORIGIN", "")
+	assert.Equal(t, "http://localhost:3000", serve("http://localhost:3000").Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, serve("https://app.example.com").Get("Access-Control-Allow-Origin"))
+}
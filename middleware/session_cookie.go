@@ -0,0 +1,134 @@
+// middleware/session_cookie.go
+package middleware
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"time"
+)
+
+// Browser clients can keep their session in cookies rather than holding the
+// bearer token in script-readable storage. The session cookie is HttpOnly so
+// scripts never see the token. Because browsers send it with every request,
+// state-changing requests must also repeat the CSRF cookie's value in the
+// CSRFHeader header, which other sites can't read or set.
+const (
+	SessionCookieName = "rvpark_session"
+	CSRFCookieName    = "rvpark_csrf"
+	CSRFHeader        = "X-CSRF-Token"
+)
+
+// CookieConfig controls cookie sessions, which are off unless Enabled.
+// SameSite must be None, which requires Secure, when the frontend is on
+// another site than the API.
+type CookieConfig struct {
+	Enabled  bool
+	Secure   bool // Only send the cookies over HTTPS
+	SameSite http.SameSite
+	Domain   string        // Empty for the API's own host
+	MaxAge   time.Duration // Should match the longest a session can last
+}
+
+func DefaultCookieConfig() CookieConfig {
+	return CookieConfig{
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   30 * 24 * time.Hour,
+	}
+}
+
+// CookiesEnabled reports whether sign-in may start a cookie session
+func (m *AuthMiddleware) CookiesEnabled() bool {
+	return m.cookies.Enabled
+}
+
+// SetSessionCookies starts a cookie session for token, returning the CSRF
+// token the client must send back in CSRFHeader
+func (m *AuthMiddleware) SetSessionCookies(w http.ResponseWriter, token string) string {
+	csrf := csrfToken(token)
+	http.SetCookie(w, m.cookie(SessionCookieName, token, true))
+	http.SetCookie(w, m.cookie(CSRFCookieName, csrf, false))
+	return csrf
+}
+
+// ClearSessionCookies removes the session cookies from the browser
+func (m *AuthMiddleware) ClearSessionCookies(w http.ResponseWriter) {
+	for _, name := range []string{SessionCookieName, CSRFCookieName} {
+		cookie := m.cookie(name, "", name == SessionCookieName)
+		cookie.MaxAge = -1
+		http.SetCookie(w, cookie)
+	}
+}
+
+func (m *AuthMiddleware) cookie(name, value string, httpOnly bool) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Domain:   m.cookies.Domain,
+		MaxAge:   int(m.cookies.MaxAge / time.Second),
+		Secure:   m.cookies.Secure,
+		HttpOnly: httpOnly,
+		SameSite: m.cookies.SameSite,
+	}
+}
+
+// SessionToken returns the request's session token, from its bearer token
+// or else its session cookie
+func (m *AuthMiddleware) SessionToken(r *http.Request) (string, bool) {
+	if r.Header.Get("Authorization") != "" {
+		return BearerToken(r)
+	}
+	return m.sessionCookie(r)
+}
+
+// CSRFToken returns the CSRF token for a request signed in with a session
+// cookie, so clients that can't read the API's cookies can recover it
+func (m *AuthMiddleware) CSRFToken(r *http.Request) (string, bool) {
+	if r.Header.Get("Authorization") != "" {
+		return "", false
+	}
+	token, ok := m.sessionCookie(r)
+	if !ok {
+		return "", false
+	}
+	return csrfToken(token), true
+}
+
+func (m *AuthMiddleware) sessionCookie(r *http.Request) (string, bool) {
+	if !m.cookies.Enabled {
+		return "", false
+	}
+	cookie, err := r.Cookie(SessionCookieName)
+	if err != nil || cookie.Value == "" {
+		return "", false
+	}
+	return cookie.Value, true
+}
+
+// csrfToken is derived from the session token so a CSRF cookie planted by
+// another host under the same domain doesn't match the session
+func csrfToken(sessionToken string) string {
+	sum := sha256.Sum256([]byte("csrf:" + sessionToken))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkCSRF reports whether a request made with a session cookie may go
+// ahead. Safe methods always may; others must carry the CSRF cookie's value
+// in CSRFHeader, and it must belong to the session.
+func checkCSRF(r *http.Request, sessionToken string) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	cookie, err := r.Cookie(CSRFCookieName)
+	if err != nil {
+		return false
+	}
+	header := r.Header.Get(CSRFHeader)
+	return header != "" &&
+		subtle.ConstantTimeCompare([]byte(header), []byte(cookie.Value)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(csrfToken(sessionToken))) == 1
+}
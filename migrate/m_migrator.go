@@ -0,0 +1,331 @@
+// migrate/m_migrator.go
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var embeddedMigrations embed.FS
+
+// advisoryLockID is the Postgres advisory lock key held while migrating so
+// that two instances starting at the same time don't race each other.
+const advisoryLockID int64 = 72783572026
+
+var migrationFilePattern = regexp.MustCompile(`^(\d+)_([A-Za-z0-9_]+)\.(up|down)\.sql$`)
+
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+// NewMigrator returns a migrator for the migrations embedded in the binary.
+func NewMigrator(db *sql.DB) (*Migrator, error) {
+	sub, err := fs.Sub(embeddedMigrations, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	return NewMigratorFromFS(db, sub)
+}
+
+// NewMigratorFromFS returns a migrator for the *.up.sql / *.down.sql files at
+// the root of fsys.
+func NewMigratorFromFS(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := loadMigrations(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Migrations returns the known migrations ordered by version.
+func (m *Migrator) Migrations() []Migration {
+	return m.migrations
+}
+
+func loadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %v", err)
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		match := migrationFilePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name: %s", entry.Name())
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %s: %v", entry.Name(), err)
+		}
+
+		contents, err := fs.ReadFile(fsys, path.Join(".", entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", entry.Name(), err)
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, migration.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			migration.Up = string(contents)
+		} else {
+			migration.Down = string(contents)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up script", migration.Version, migration.Name)
+		}
+		sum := sha256.Sum256([]byte(migration.Up))
+		migration.Checksum = hex.EncodeToString(sum[:])
+		migrations = append(migrations, *migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+type appliedMigration struct {
+	checksum  string
+	appliedAt time.Time
+}
+
+// Up applies every pending migration in version order.
+func (m *Migrator) Up() error {
+	return m.withLock(func(conn *sql.Conn) error {
+		applied, err := m.verifiedApplied(conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+
+			log.Printf("Applying migration %d_%s", migration.Version, migration.Name)
+			if err := m.apply(conn, migration); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Down rolls back the most recently applied migrations, up to steps of them.
+func (m *Migrator) Down(steps int) error {
+	if steps <= 0 {
+		return fmt.Errorf("steps must be greater than 0")
+	}
+
+	return m.withLock(func(conn *sql.Conn) error {
+		applied, err := m.verifiedApplied(conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && steps > 0; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("migration %d_%s has no down script", migration.Version, migration.Name)
+			}
+
+			log.Printf("Rolling back migration %d_%s", migration.Version, migration.Name)
+			if err := m.rollback(conn, migration); err != nil {
+				return err
+			}
+			steps--
+		}
+		return nil
+	})
+}
+
+// Status reports every known migration and whether it has been applied.
+func (m *Migrator) Status() ([]Status, error) {
+	var statuses []Status
+	err := m.withLock(func(conn *sql.Conn) error {
+		applied, err := m.loadApplied(conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			status := Status{Version: migration.Version, Name: migration.Name}
+			if record, ok := applied[migration.Version]; ok {
+				appliedAt := record.appliedAt
+				status.Applied = true
+				status.AppliedAt = &appliedAt
+			}
+			statuses = append(statuses, status)
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+// withLock runs fn on a dedicated connection holding the migration advisory
+// lock. Advisory locks are session scoped, so every statement must share conn.
+func (m *Migrator) withLock(fn func(conn *sql.Conn) error) error {
+	ctx := context.Background()
+
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, advisoryLockID); err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %v", err)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, advisoryLockID); err != nil {
+			log.Printf("Warning: failed to release migration lock: %v", err)
+		}
+	}()
+
+	if err := ensureMigrationsTable(conn); err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+func ensureMigrationsTable(conn *sql.Conn) error {
+	query := `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version BIGINT PRIMARY KEY,
+			name TEXT NOT NULL,
+			checksum TEXT NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)
+	`
+	if _, err := conn.ExecContext(context.Background(), query); err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %v", err)
+	}
+	return nil
+}
+
+func (m *Migrator) loadApplied(conn *sql.Conn) (map[int64]appliedMigration, error) {
+	rows, err := conn.QueryContext(context.Background(), `
+		SELECT version, checksum, applied_at
+		FROM schema_migrations
+		ORDER BY version
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %v", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]appliedMigration)
+	for rows.Next() {
+		var version int64
+		var record appliedMigration
+		if err := rows.Scan(&version, &record.checksum, &record.appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = record
+	}
+
+	return applied, rows.Err()
+}
+
+// verifiedApplied loads the applied migrations and fails if any of them has
+// been edited since it ran or is unknown to this binary.
+func (m *Migrator) verifiedApplied(conn *sql.Conn) (map[int64]appliedMigration, error) {
+	applied, err := m.loadApplied(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[int64]Migration, len(m.migrations))
+	for _, migration := range m.migrations {
+		known[migration.Version] = migration
+	}
+
+	for version, record := range applied {
+		migration, ok := known[version]
+		if !ok {
+			return nil, fmt.Errorf("database has migration %d applied which is unknown to this binary", version)
+		}
+		if migration.Checksum != record.checksum {
+			return nil, fmt.Errorf("checksum mismatch for migration %d_%s: applied migration was modified", version, migration.Name)
+		}
+	}
+
+	return applied, nil
+}
+
+func (m *Migrator) apply(conn *sql.Conn, migration Migration) error {
+	ctx := context.Background()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, migration.Up); err != nil {
+		return fmt.Errorf("failed to apply migration %d_%s: %v", migration.Version, migration.Name, err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO schema_migrations (version, name, checksum, applied_at)
+		VALUES ($1, $2, $3, $4)
+	`, migration.Version, migration.Name, migration.Checksum, time.Now()); err != nil {
+		return fmt.Errorf("failed to record migration %d_%s: %v", migration.Version, migration.Name, err)
+	}
+
+	return tx.Commit()
+}
+
+func (m *Migrator) rollback(conn *sql.Conn, migration Migration) error {
+	ctx := context.Background()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, migration.Down); err != nil {
+		return fmt.Errorf("failed to roll back migration %d_%s: %v", migration.Version, migration.Name, err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, migration.Version); err != nil {
+		return fmt.Errorf("failed to unrecord migration %d_%s: %v", migration.Version, migration.Name, err)
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,141 @@
+// migrate/m_migrator_test.go
+package migrate
+
+import (
+	"regexp"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func testFS() fstest.MapFS {
+	return fstest.MapFS{
+		"0002_add_notes.up.sql":   {Data: []byte("ALTER TABLE tenants ADD COLUMN notes TEXT;")},
+		"0002_add_notes.down.sql": {Data: []byte("ALTER TABLE tenants DROP COLUMN notes;")},
+		"0001_initial.up.sql":     {Data: []byte("CREATE TABLE tenants (id UUID);")},
+		"0001_initial.down.sql":   {Data: []byte("DROP TABLE tenants;")},
+	}
+}
+
+func TestEmbeddedMigrationsLoad(t *testing.T) {
+	migrator, err := NewMigrator(nil)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, migrator.Migrations())
+	assert.Equal(t, int64(1), migrator.Migrations()[0].Version)
+
+	for _, migration := range migrator.Migrations() {
+		assert.NotEmpty(t, migration.Down, "migration %d should have a down script", migration.Version)
+	}
+}
+
+func TestLoadMigrations_Ordering(t *testing.T) {
+	migrator, err := NewMigratorFromFS(nil, testFS())
+	assert.NoError(t, err)
+
+	migrations := migrator.Migrations()
+	assert.Len(t, migrations, 2)
+	assert.Equal(t, int64(1), migrations[0].Version)
+	assert.Equal(t, "initial", migrations[0].Name)
+	assert.Equal(t, int64(2), migrations[1].Version)
+	assert.NotEqual(t, migrations[0].Checksum, migrations[1].Checksum)
+}
+
+func TestLoadMigrations_Invalid(t *testing.T) {
+	_, err := NewMigratorFromFS(nil, fstest.MapFS{
+		"initial.sql": {Data: []byte("SELECT 1;")},
+	})
+	assert.Error(t, err)
+
+	_, err = NewMigratorFromFS(nil, fstest.MapFS{
+		"0001_initial.down.sql": {Data: []byte("SELECT 1;")},
+	})
+	assert.Error(t, err)
+}
+
+func expectLock(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_lock($1)")).
+		WithArgs(advisoryLockID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func expectUnlock(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_unlock($1)")).
+		WithArgs(advisoryLockID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func TestUp_AppliesPendingMigrations(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	migrator, err := NewMigratorFromFS(db, testFS())
+	assert.NoError(t, err)
+	migrations := migrator.Migrations()
+
+	expectLock(mock)
+	mock.ExpectQuery("SELECT version, checksum, applied_at").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "checksum", "applied_at"}).
+			AddRow(int64(1), migrations[0].Checksum, time.Now()))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(migrations[1].Up)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO schema_migrations").
+		WithArgs(int64(2), "add_notes", migrations[1].Checksum, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	expectUnlock(mock)
+
+	assert.NoError(t, migrator.Up())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUp_ChecksumMismatch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	migrator, err := NewMigratorFromFS(db, testFS())
+	assert.NoError(t, err)
+
+	expectLock(mock)
+	mock.ExpectQuery("SELECT version, checksum, applied_at").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "checksum", "applied_at"}).
+			AddRow(int64(1), "edited", time.Now()))
+	expectUnlock(mock)
+
+	err = migrator.Up()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "checksum mismatch")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDown_RollsBackLatest(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	migrator, err := NewMigratorFromFS(db, testFS())
+	assert.NoError(t, err)
+	migrations := migrator.Migrations()
+
+	expectLock(mock)
+	mock.ExpectQuery("SELECT version, checksum, applied_at").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "checksum", "applied_at"}).
+			AddRow(int64(1), migrations[0].Checksum, time.Now()).
+			AddRow(int64(2), migrations[1].Checksum, time.Now()))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(migrations[1].Down)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM schema_migrations WHERE version = $1")).
+		WithArgs(int64(2)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	expectUnlock(mock)
+
+	assert.NoError(t, migrator.Down(1))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
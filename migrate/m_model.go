@@ -0,0 +1,22 @@
+// migrate/m_model.go
+package migrate
+
+import "time"
+
+// Migration is a single versioned schema change loaded from the embedded
+// migrations directory.
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// Status describes a known migration and whether it has been applied.
+type Status struct {
+	Version   int64      `json:"version"`
+	Name      string     `json:"name"`
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"appliedAt,omitempty"`
+}
@@ -0,0 +1,13 @@
+DROP TABLE IF EXISTS payments;
+DROP TABLE IF EXISTS tenants CASCADE;
+DROP TABLE IF EXISTS spaces CASCADE;
+DROP TABLE IF EXISTS sections;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS tokens;
+
+DROP FUNCTION IF EXISTS initialize_section_spaces(VARCHAR, CHAR, INTEGER);
+DROP FUNCTION IF EXISTS update_updated_at_column();
+DROP FUNCTION IF EXISTS create_enum_if_not_exists(TEXT, TEXT[]);
+
+DROP TYPE IF EXISTS space_status;
+DROP TYPE IF EXISTS user_role;
@@ -0,0 +1,13 @@
+-- History of background job executions
+CREATE TABLE job_runs (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    job_name VARCHAR(100) NOT NULL,
+    trigger VARCHAR(20) NOT NULL,
+    scheduled_for TIMESTAMP,
+    started_at TIMESTAMP NOT NULL,
+    finished_at TIMESTAMP NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    error TEXT
+);
+
+CREATE INDEX idx_job_runs_job_name_started_at ON job_runs(job_name, started_at DESC);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS notifications;
+
+ALTER TABLE tenants DROP COLUMN IF EXISTS email_opt_out;
+ALTER TABLE tenants DROP COLUMN IF EXISTS email;
@@ -0,0 +1,25 @@
+-- Tenant contact details and notification preferences
+ALTER TABLE tenants ADD COLUMN email VARCHAR(255);
+ALTER TABLE tenants ADD COLUMN email_opt_out BOOLEAN NOT NULL DEFAULT false;
+
+-- Delivery log for outgoing notifications
+CREATE TABLE notifications (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    channel VARCHAR(20) NOT NULL,
+    kind VARCHAR(50) NOT NULL,
+    reference_id VARCHAR(100) NOT NULL,
+    recipient VARCHAR(255) NOT NULL,
+    subject TEXT NOT NULL,
+    body TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT LOCALTIMESTAMP,
+    sent_at TIMESTAMP,
+    CONSTRAINT notifications_unique_reference UNIQUE (channel, kind, reference_id)
+);
+
+CREATE INDEX idx_notifications_tenant_id ON notifications(tenant_id);
+CREATE INDEX idx_notifications_retry ON notifications(status, next_attempt_at);
@@ -0,0 +1,2 @@
+ALTER TABLE tenants DROP COLUMN IF EXISTS sms_opt_out;
+ALTER TABLE tenants DROP COLUMN IF EXISTS phone;
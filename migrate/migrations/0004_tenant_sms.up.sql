@@ -0,0 +1,3 @@
+-- Phone numbers (E.164) and SMS preferences for rent reminders by text
+ALTER TABLE tenants ADD COLUMN phone VARCHAR(20);
+ALTER TABLE tenants ADD COLUMN sms_opt_out BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_users_email_id;
+DROP INDEX IF EXISTS idx_payments_due_date_id;
+DROP INDEX IF EXISTS idx_tenants_name_id;
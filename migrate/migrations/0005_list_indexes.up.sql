@@ -0,0 +1,4 @@
+-- Composite indexes backing keyset pagination on the list endpoints
+CREATE INDEX IF NOT EXISTS idx_tenants_name_id ON tenants(name, id);
+CREATE INDEX IF NOT EXISTS idx_payments_due_date_id ON payments(due_date, id);
+CREATE INDEX IF NOT EXISTS idx_users_email_id ON users(email, id);
@@ -0,0 +1,3 @@
+ALTER TABLE payments DROP COLUMN IF EXISTS version;
+ALTER TABLE tenants DROP COLUMN IF EXISTS version;
+ALTER TABLE spaces DROP COLUMN IF EXISTS version;
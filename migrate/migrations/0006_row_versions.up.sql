@@ -0,0 +1,5 @@
+-- Row versions for optimistic concurrency; each update bumps the version and
+-- clients send the version they read back in If-Match
+ALTER TABLE spaces ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE tenants ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE payments ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
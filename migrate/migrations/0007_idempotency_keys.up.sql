@@ -0,0 +1,13 @@
+-- Responses to requests sent with an Idempotency-Key, replayed on retries
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    user_id TEXT NOT NULL,
+    key VARCHAR(255) NOT NULL,
+    fingerprint TEXT NOT NULL,
+    status_code INTEGER,
+    header JSONB,
+    body BYTEA,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (user_id, key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_created_at ON idempotency_keys(created_at);
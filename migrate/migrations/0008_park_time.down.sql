@@ -0,0 +1,57 @@
+ALTER TABLE tenants
+    ALTER COLUMN move_in_date TYPE TIMESTAMP USING move_in_date::timestamp;
+
+ALTER TABLE payments
+    ALTER COLUMN due_date TYPE TIMESTAMP USING due_date::timestamp,
+    ALTER COLUMN next_payment_date TYPE TIMESTAMP USING next_payment_date::timestamp;
+
+CREATE OR REPLACE FUNCTION update_updated_at_column()
+RETURNS TRIGGER AS $$
+BEGIN
+    NEW.updated_at = LOCALTIMESTAMP;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+ALTER TABLE idempotency_keys
+    ALTER COLUMN created_at TYPE TIMESTAMP USING created_at AT TIME ZONE 'UTC';
+
+ALTER TABLE notifications
+    ALTER COLUMN next_attempt_at TYPE TIMESTAMP USING next_attempt_at AT TIME ZONE 'UTC',
+    ALTER COLUMN created_at TYPE TIMESTAMP USING created_at AT TIME ZONE 'UTC',
+    ALTER COLUMN created_at SET DEFAULT LOCALTIMESTAMP,
+    ALTER COLUMN sent_at TYPE TIMESTAMP USING sent_at AT TIME ZONE 'UTC';
+
+ALTER TABLE job_runs
+    ALTER COLUMN scheduled_for TYPE TIMESTAMP USING scheduled_for AT TIME ZONE 'UTC',
+    ALTER COLUMN started_at TYPE TIMESTAMP USING started_at AT TIME ZONE 'UTC',
+    ALTER COLUMN finished_at TYPE TIMESTAMP USING finished_at AT TIME ZONE 'UTC';
+
+ALTER TABLE payments
+    ALTER COLUMN paid_date TYPE TIMESTAMP USING paid_date AT TIME ZONE 'UTC',
+    ALTER COLUMN created_at TYPE TIMESTAMP USING created_at AT TIME ZONE 'UTC',
+    ALTER COLUMN created_at SET DEFAULT LOCALTIMESTAMP,
+    ALTER COLUMN updated_at TYPE TIMESTAMP USING updated_at AT TIME ZONE 'UTC',
+    ALTER COLUMN updated_at SET DEFAULT LOCALTIMESTAMP;
+
+ALTER TABLE tenants
+    ALTER COLUMN created_at TYPE TIMESTAMP USING created_at AT TIME ZONE 'UTC',
+    ALTER COLUMN created_at SET DEFAULT LOCALTIMESTAMP,
+    ALTER COLUMN updated_at TYPE TIMESTAMP USING updated_at AT TIME ZONE 'UTC',
+    ALTER COLUMN updated_at SET DEFAULT LOCALTIMESTAMP;
+
+ALTER TABLE spaces
+    ALTER COLUMN created_at TYPE TIMESTAMP USING created_at AT TIME ZONE 'UTC',
+    ALTER COLUMN created_at SET DEFAULT LOCALTIMESTAMP,
+    ALTER COLUMN updated_at TYPE TIMESTAMP USING updated_at AT TIME ZONE 'UTC',
+    ALTER COLUMN updated_at SET DEFAULT LOCALTIMESTAMP;
+
+ALTER TABLE users
+    ALTER COLUMN created_at TYPE TIMESTAMP USING created_at AT TIME ZONE 'UTC',
+    ALTER COLUMN created_at SET DEFAULT LOCALTIMESTAMP,
+    ALTER COLUMN last_login TYPE TIMESTAMP USING last_login AT TIME ZONE 'UTC';
+
+ALTER TABLE tokens
+    ALTER COLUMN expires_at TYPE TIMESTAMP USING expires_at AT TIME ZONE 'UTC',
+    ALTER COLUMN created_at TYPE TIMESTAMP USING created_at AT TIME ZONE 'UTC',
+    ALTER COLUMN created_at SET DEFAULT LOCALTIMESTAMP;
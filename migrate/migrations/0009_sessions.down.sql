@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_tokens_id;
+
+ALTER TABLE tokens
+    DROP COLUMN IF EXISTS last_seen_at,
+    DROP COLUMN IF EXISTS ip_address,
+    DROP COLUMN IF EXISTS user_agent,
+    DROP COLUMN IF EXISTS id;
@@ -0,0 +1,12 @@
+-- Tokens become sessions that can be listed and revoked one at a time. The ID
+-- is what clients see; the token hash never leaves the server.
+ALTER TABLE tokens
+    ADD COLUMN id UUID NOT NULL DEFAULT gen_random_uuid(),
+    ADD COLUMN user_agent TEXT NOT NULL DEFAULT '',
+    ADD COLUMN ip_address TEXT NOT NULL DEFAULT '',
+    ADD COLUMN last_seen_at TIMESTAMPTZ;
+
+UPDATE tokens SET last_seen_at = COALESCE(created_at, CURRENT_TIMESTAMP);
+ALTER TABLE tokens ALTER COLUMN last_seen_at SET NOT NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_tokens_id ON tokens(id);
@@ -0,0 +1,13 @@
+-- Single-use links emailed for password resets and staff invitations. Only
+-- the hash of the token is kept.
+CREATE TABLE IF NOT EXISTS account_tokens (
+    id UUID PRIMARY KEY,
+    token_hash TEXT NOT NULL UNIQUE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    purpose VARCHAR(20) NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_account_tokens_user_id ON account_tokens(user_id, purpose);
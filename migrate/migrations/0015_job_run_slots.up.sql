@@ -0,0 +1,11 @@
+-- A scheduled slot runs once across all instances. Keep the first run of any
+-- slot recorded more than once before the index existed.
+DELETE FROM job_runs a
+    USING job_runs b
+    WHERE a.job_name = b.job_name
+      AND a.scheduled_for = b.scheduled_for
+      AND (a.started_at, a.id) > (b.started_at, b.id);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_job_runs_job_name_scheduled_for
+    ON job_runs(job_name, scheduled_for)
+    WHERE scheduled_for IS NOT NULL;
@@ -0,0 +1,3 @@
+ALTER TABLE schema_migrations
+    ALTER COLUMN applied_at TYPE TIMESTAMP USING applied_at AT TIME ZONE 'UTC',
+    ALTER COLUMN applied_at SET DEFAULT LOCALTIMESTAMP;
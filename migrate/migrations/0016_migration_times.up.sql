@@ -0,0 +1,19 @@
+-- schema_migrations was created with a TIMESTAMP applied_at before it moved to
+-- TIMESTAMPTZ. Existing values were written by a server running in UTC.
+-- Databases created since already have the new type and are left alone.
+DO $$
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_name = 'schema_migrations'
+          AND column_name = 'applied_at'
+          AND data_type = 'timestamp without time zone'
+    ) THEN
+        ALTER TABLE schema_migrations
+            ALTER COLUMN applied_at TYPE TIMESTAMPTZ USING applied_at AT TIME ZONE 'UTC';
+    END IF;
+END
+$$;
+
+ALTER TABLE schema_migrations
+    ALTER COLUMN applied_at SET DEFAULT now();
@@ -0,0 +1,26 @@
+// notify/n_interface.go
+package notify
+
+import "time"
+
+type Service interface {
+	SendRentReminders() error
+	SendReceipts() error
+	RetryFailed() error
+	ListTenantNotifications(tenantID string) ([]Notification, error)
+}
+
+type Repository interface {
+	// Create stores a new notification. It returns false without error when
+	// one already exists for the same channel, kind and reference.
+	Create(notification Notification) (bool, error)
+	Update(notification Notification) error
+	// ListRetryable returns held and failed notifications whose next attempt is due
+	ListRetryable(now time.Time) ([]Notification, error)
+	ListByTenant(tenantID string) ([]Notification, error)
+}
+
+// Transport delivers a rendered message over a single channel
+type Transport interface {
+	Send(msg Message) error
+}
@@ -0,0 +1,77 @@
+// notify/n_model.go
+package notify
+
+import "time"
+
+// Channels a notification can be delivered over
+const (
+	ChannelEmail = "email"
+	ChannelSMS   = "sms"
+)
+
+// Kinds of notification sent to tenants
+const (
+	KindRentDue        = "rent_due"
+	KindRentOverdue    = "rent_overdue"
+	KindPaymentReceipt = "payment_receipt"
+)
+
+// Delivery statuses
+const (
+	StatusPending       = "pending" // Not yet attempted, or held until NextAttemptAt
+	StatusSent          = "sent"
+	StatusFailed        = "failed"        // Will be retried
+	StatusUndeliverable = "undeliverable" // Gave up after MaxAttempts
+)
+
+// Notification is a delivery log entry for a single message to a tenant
+type Notification struct {
+	ID            string     `json:"id"`
+	TenantID      string     `json:"tenantId"`
+	Channel       string     `json:"channel"`
+	Kind          string     `json:"kind"`
+	ReferenceID   string     `json:"referenceId"` // e.g. the payment the message is about
+	Recipient     string     `json:"recipient"`
+	Subject       string     `json:"subject"`
+	Body          string     `json:"body"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	LastError     string     `json:"lastError,omitempty"`
+	NextAttemptAt *time.Time `json:"nextAttemptAt,omitempty"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	SentAt        *time.Time `json:"sentAt,omitempty"`
+}
+
+// Message is what a Transport delivers. SMS transports ignore Subject.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Config controls when and how often notifications are sent
+type Config struct {
+	ParkName     string
+	ReminderDays int           // Days before the due date to send a reminder
+	ReceiptDays  int           // How far back to look for payments needing a receipt
+	MaxAttempts  int           // Delivery attempts before giving up
+	RetryBackoff time.Duration // Delay after the first failure, doubled on each retry
+
+	// SMS settings
+	SMSMaxSegments  int // Longer texts are truncated to fit
+	QuietHoursStart int // Hour (0-23) from which texts are held
+	QuietHoursEnd   int // Hour (0-23) at which held texts are released
+}
+
+func DefaultConfig() Config {
+	return Config{
+		ParkName:        "RV Park",
+		ReminderDays:    3,
+		ReceiptDays:     7,
+		MaxAttempts:     5,
+		RetryBackoff:    5 * time.Minute,
+		SMSMaxSegments:  2,
+		QuietHoursStart: 21,
+		QuietHoursEnd:   8,
+	}
+}
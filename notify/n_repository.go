@@ -0,0 +1,159 @@
+// notify/n_repository.go
+package notify
+
+import (
+	"database/sql"
+	"time"
+)
+
+type sqlRepository struct {
+	db *sql.DB
+}
+
+func NewSQLRepository(db *sql.DB) Repository {
+	return &sqlRepository{db: db}
+}
+
+// Create logs a notification as due at once, so the retry job sends it if
+// this process stops before delivering it
+func (r *sqlRepository) Create(notification Notification) (bool, error) {
+	query := `
+        INSERT INTO notifications (
+            id, tenant_id, channel, kind, reference_id, recipient,
+            subject, body, status, attempts, created_at, next_attempt_at
+        ) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $11)
+        ON CONFLICT (channel, kind, reference_id) DO NOTHING
+    `
+
+	result, err := r.db.Exec(
+		query,
+		notification.ID,
+		notification.TenantID,
+		notification.Channel,
+		notification.Kind,
+		notification.ReferenceID,
+		notification.Recipient,
+		notification.Subject,
+		notification.Body,
+		notification.Status,
+		notification.Attempts,
+		notification.CreatedAt,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+func (r *sqlRepository) Update(notification Notification) error {
+	query := `
+        UPDATE notifications SET
+            status = $2,
+            attempts = $3,
+            last_error = $4,
+            next_attempt_at = $5,
+            sent_at = $6
+        WHERE id = $1
+    `
+
+	var lastError interface{}
+	if notification.LastError != "" {
+		lastError = notification.LastError
+	}
+
+	_, err := r.db.Exec(
+		query,
+		notification.ID,
+		notification.Status,
+		notification.Attempts,
+		lastError,
+		notification.NextAttemptAt,
+		notification.SentAt,
+	)
+	return err
+}
+
+func (r *sqlRepository) ListRetryable(now time.Time) ([]Notification, error) {
+	query := `
+        SELECT
+            id, tenant_id, channel, kind, reference_id, recipient, subject, body,
+            status, attempts, last_error, next_attempt_at, created_at, sent_at
+        FROM notifications
+        WHERE status IN ($1, $2)
+          AND (next_attempt_at IS NULL OR next_attempt_at <= $3)
+        ORDER BY COALESCE(next_attempt_at, created_at)
+    `
+
+	rows, err := r.db.Query(query, StatusPending, StatusFailed, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return r.scanNotifications(rows)
+}
+
+func (r *sqlRepository) ListByTenant(tenantID string) ([]Notification, error) {
+	query := `
+        SELECT
+            id, tenant_id, channel, kind, reference_id, recipient, subject, body,
+            status, attempts, last_error, next_attempt_at, created_at, sent_at
+        FROM notifications
+        WHERE tenant_id = $1
+        ORDER BY created_at DESC
+    `
+
+	rows, err := r.db.Query(query, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return r.scanNotifications(rows)
+}
+
+func (r *sqlRepository) scanNotifications(rows *sql.Rows) ([]Notification, error) {
+	var notifications []Notification
+	for rows.Next() {
+		var notification Notification
+		var lastError sql.NullString
+		var nextAttemptAt, sentAt sql.NullTime
+
+		err := rows.Scan(
+			&notification.ID,
+			&notification.TenantID,
+			&notification.Channel,
+			&notification.Kind,
+			&notification.ReferenceID,
+			&notification.Recipient,
+			&notification.Subject,
+			&notification.Body,
+			&notification.Status,
+			&notification.Attempts,
+			&lastError,
+			&nextAttemptAt,
+			&notification.CreatedAt,
+			&sentAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		notification.LastError = lastError.String
+		if nextAttemptAt.Valid {
+			notification.NextAttemptAt = &nextAttemptAt.Time
+		}
+		if sentAt.Valid {
+			notification.SentAt = &sentAt.Time
+		}
+
+		notifications = append(notifications, notification)
+	}
+
+	return notifications, rows.Err()
+}
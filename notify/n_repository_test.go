@@ -0,0 +1,52 @@
+// notify/n_repository_test.go
+package notify
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// A notification logged but never delivered, because the process stopped in
+// between, is still picked up by the retry job
+func TestCreateWithoutDeliveringIsRetried(t *testing.T) {
+	db, mockDB, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	repo := NewSQLRepository(db)
+
+	createdAt := time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC)
+	notification := Notification{
+		ID: "n1", TenantID: "t1", Channel: ChannelEmail, Kind: "reminder", ReferenceID: "p1",
+		Recipient: "john@example.com", Subject: "Rent due", Body: "...", Status: StatusPending, CreatedAt: createdAt,
+	}
+
+	// Logged as due at once
+	mockDB.ExpectExec(`INSERT INTO notifications .* VALUES \(.*\$11, \$11\)`).
+		WithArgs("n1", "t1", ChannelEmail, "reminder", "p1", "john@example.com", "Rent due", "...", StatusPending, 0, createdAt).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	created, err := repo.Create(notification)
+	require.NoError(t, err)
+	assert.True(t, created)
+
+	// Rows logged before that, with no next attempt, are retried too
+	columns := []string{"id", "tenant_id", "channel", "kind", "reference_id", "recipient", "subject", "body",
+		"status", "attempts", "last_error", "next_attempt_at", "created_at", "sent_at"}
+	mockDB.ExpectQuery(`WHERE status IN \(\$1, \$2\)\s+AND \(next_attempt_at IS NULL OR next_attempt_at <= \$3\)`).
+		WithArgs(StatusPending, StatusFailed, createdAt.Add(time.Minute)).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("n1", "t1", ChannelEmail, "reminder", "p1", "john@example.com", "Rent due", "...",
+				StatusPending, 0, nil, createdAt, createdAt, nil).
+			AddRow("n0", "t1", ChannelEmail, "reminder", "p0", "john@example.com", "Rent due", "...",
+				StatusPending, 0, nil, nil, createdAt.Add(-time.Hour), nil))
+	retryable, err := repo.ListRetryable(createdAt.Add(time.Minute))
+	require.NoError(t, err)
+	require.Len(t, retryable, 2)
+	assert.Equal(t, "n1", retryable[0].ID)
+	assert.Nil(t, retryable[1].NextAttemptAt)
+
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
@@ -0,0 +1,272 @@
+// notify/n_service.go
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/BodaciousX/RVParkBackend/clock"
+	"github.com/BodaciousX/RVParkBackend/payment"
+	"github.com/BodaciousX/RVParkBackend/tenant"
+	"github.com/google/uuid"
+)
+
+type service struct {
+	repo           Repository
+	transports     map[string]Transport
+	tenantService  tenant.Service
+	paymentService payment.Service
+	config         Config
+	clock          clock.Clock
+}
+
+// NewService creates the notification service. Either transport may be nil
+// to disable that channel. Due dates and quiet hours are judged by clk, which
+// reads park time.
+func NewService(
+	repo Repository,
+	emailTransport Transport,
+	smsTransport Transport,
+	tenantService tenant.Service,
+	paymentService payment.Service,
+	config Config,
+	clk clock.Clock,
+) Service {
+	transports := make(map[string]Transport)
+	if emailTransport != nil {
+		transports[ChannelEmail] = emailTransport
+	}
+	if smsTransport != nil {
+		transports[ChannelSMS] = smsTransport
+	}
+
+	return &service{
+		repo:           repo,
+		transports:     transports,
+		tenantService:  tenantService,
+		paymentService: paymentService,
+		config:         config,
+		clock:          clk,
+	}
+}
+
+// SendRentReminders emails and texts tenants whose rent is due soon or already
+// overdue. Each payment produces at most one reminder and one overdue notice
+// per channel.
+func (s *service) SendRentReminders() error {
+	ctx := context.Background()
+	now := s.clock.Now()
+	today := clock.Today(s.clock)
+	tenants := make(map[string]*tenant.Tenant)
+
+	upcoming, err := s.paymentService.GetUnpaidPaymentsDueBetween(ctx, today, today.AddDate(0, 0, s.config.ReminderDays))
+	if err != nil {
+		return fmt.Errorf("failed to load upcoming payments: %v", err)
+	}
+	for _, p := range upcoming {
+		s.notifyPayment(ctx, KindRentDue, p, tenants, now)
+	}
+
+	// Rent due today isn't late until tomorrow
+	overdue, err := s.paymentService.GetUnpaidPaymentsDueBetween(ctx, time.Unix(0, 0).UTC(), today.AddDate(0, 0, -1))
+	if err != nil {
+		return fmt.Errorf("failed to load overdue payments: %v", err)
+	}
+	for _, p := range overdue {
+		s.notifyPayment(ctx, KindRentOverdue, p, tenants, now)
+	}
+
+	return nil
+}
+
+// SendReceipts emails a receipt for every recently paid payment that hasn't had one
+func (s *service) SendReceipts() error {
+	ctx := context.Background()
+	now := s.clock.Now()
+	tenants := make(map[string]*tenant.Tenant)
+
+	paid, err := s.paymentService.GetPaymentsPaidBetween(ctx, now.AddDate(0, 0, -s.config.ReceiptDays), now)
+	if err != nil {
+		return fmt.Errorf("failed to load paid payments: %v", err)
+	}
+	for _, p := range paid {
+		s.notifyPayment(ctx, KindPaymentReceipt, p, tenants, now)
+	}
+
+	return nil
+}
+
+// RetryFailed re-attempts deliveries whose backoff has elapsed
+func (s *service) RetryFailed() error {
+	now := s.clock.Now()
+
+	notifications, err := s.repo.ListRetryable(now)
+	if err != nil {
+		return fmt.Errorf("failed to load failed notifications: %v", err)
+	}
+
+	for _, notification := range notifications {
+		if s.holdForQuietHours(&notification, now) {
+			if err := s.repo.Update(notification); err != nil {
+				log.Printf("Failed to update notification %s: %v", notification.ID, err)
+			}
+			continue
+		}
+		if err := s.deliver(&notification, now); err != nil {
+			log.Printf("Failed to update notification %s: %v", notification.ID, err)
+		}
+	}
+	return nil
+}
+
+func (s *service) ListTenantNotifications(tenantID string) ([]Notification, error) {
+	return s.repo.ListByTenant(tenantID)
+}
+
+// notifyPayment logs and sends a notification about a payment on each channel
+// the tenant can be reached on. Failures are logged rather than returned so
+// one bad address doesn't stop the batch.
+func (s *service) notifyPayment(ctx context.Context, kind string, p payment.Payment, tenants map[string]*tenant.Tenant, now time.Time) {
+	t, ok := tenants[p.TenantID]
+	if !ok {
+		var err error
+		t, err = s.tenantService.GetTenant(ctx, p.TenantID)
+		if err != nil {
+			log.Printf("Skipping %s for payment %s: tenant lookup failed: %v", kind, p.ID, err)
+			return
+		}
+		tenants[p.TenantID] = t
+	}
+
+	data := templateData{
+		ParkName:   s.config.ParkName,
+		TenantName: t.Name,
+		Amount:     p.AmountDue.String(),
+		DueDate:    p.DueDate.Format("January 2, 2006"),
+	}
+	if p.PaidDate != nil {
+		data.PaidDate = p.PaidDate.Format("January 2, 2006")
+	}
+	if kind == KindRentOverdue {
+		data.DaysOverdue = clock.DaysBetween(p.DueDate, now)
+	}
+
+	notification := Notification{
+		TenantID:    t.ID,
+		Kind:        kind,
+		ReferenceID: p.ID,
+		Status:      StatusPending,
+		CreatedAt:   now,
+	}
+
+	_, emailEnabled := s.transports[ChannelEmail]
+	_, smsEnabled := s.transports[ChannelSMS]
+	_, hasSMSTemplate := smsTemplates[kind]
+
+	if emailEnabled && t.Email != "" && !t.EmailOptOut {
+		subject, body, err := render(kind, data)
+		if err != nil {
+			log.Printf("Skipping %s email for payment %s: %v", kind, p.ID, err)
+		} else {
+			email := notification
+			email.ID = uuid.New().String()
+			email.Channel = ChannelEmail
+			email.Recipient = t.Email
+			email.Subject = subject
+			email.Body = body
+			s.queue(email, now)
+		}
+	}
+
+	if smsEnabled && hasSMSTemplate && t.Phone != "" && !t.SMSOptOut {
+		body, err := renderSMS(kind, data)
+		if err != nil {
+			log.Printf("Skipping %s text for payment %s: %v", kind, p.ID, err)
+		} else {
+			sms := notification
+			sms.ID = uuid.New().String()
+			sms.Channel = ChannelSMS
+			sms.Recipient = t.Phone
+			sms.Body = fitSMS(body, s.config.SMSMaxSegments)
+			s.queue(sms, now)
+		}
+	}
+}
+
+// queue logs a notification and sends it unless it is a duplicate or must
+// wait for quiet hours to end
+func (s *service) queue(notification Notification, now time.Time) {
+	created, err := s.repo.Create(notification)
+	if err != nil {
+		log.Printf("Failed to log %s %s for %s: %v", notification.Channel, notification.Kind, notification.ReferenceID, err)
+		return
+	}
+	if !created {
+		return
+	}
+
+	if s.holdForQuietHours(&notification, now) {
+		if err := s.repo.Update(notification); err != nil {
+			log.Printf("Failed to update notification %s: %v", notification.ID, err)
+		}
+		return
+	}
+
+	if err := s.deliver(&notification, now); err != nil {
+		log.Printf("Failed to update notification %s: %v", notification.ID, err)
+	}
+}
+
+// holdForQuietHours defers texts during quiet hours by setting NextAttemptAt
+// to the end of the window. It reports whether the notification was held.
+func (s *service) holdForQuietHours(notification *Notification, now time.Time) bool {
+	if notification.Channel != ChannelSMS {
+		return false
+	}
+
+	release := quietUntil(now, s.config)
+	if release.IsZero() {
+		return false
+	}
+
+	notification.NextAttemptAt = &release
+	return true
+}
+
+// deliver attempts to send a notification and records the outcome, scheduling
+// an exponential-backoff retry on failure.
+func (s *service) deliver(notification *Notification, now time.Time) error {
+	var sendErr error
+	if transport, ok := s.transports[notification.Channel]; ok {
+		sendErr = transport.Send(Message{
+			To:      notification.Recipient,
+			Subject: notification.Subject,
+			Body:    notification.Body,
+		})
+	} else {
+		sendErr = fmt.Errorf("no transport configured for channel %s", notification.Channel)
+	}
+
+	notification.Attempts++
+	if sendErr == nil {
+		notification.Status = StatusSent
+		notification.LastError = ""
+		notification.NextAttemptAt = nil
+		notification.SentAt = &now
+		return s.repo.Update(*notification)
+	}
+
+	notification.LastError = sendErr.Error()
+	notification.NextAttemptAt = nil
+	if notification.Attempts >= s.config.MaxAttempts {
+		notification.Status = StatusUndeliverable
+	} else {
+		notification.Status = StatusFailed
+		next := now.Add(s.config.RetryBackoff << (notification.Attempts - 1))
+		notification.NextAttemptAt = &next
+	}
+
+	return s.repo.Update(*notification)
+}
@@ -0,0 +1,432 @@
+// notify/n_service_test.go
+package notify
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/BodaciousX/RVParkBackend/clock"
+	"github.com/BodaciousX/RVParkBackend/paging"
+	"github.com/BodaciousX/RVParkBackend/payment"
+	"github.com/BodaciousX/RVParkBackend/tenant"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// MockRepository is a mock implementation of the Repository interface
+type MockRepository struct {
+	mock.Mock
+}
+
+func (m *MockRepository) Create(notification Notification) (bool, error) {
+	args := m.Called(notification)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockRepository) Update(notification Notification) error {
+	args := m.Called(notification)
+	return args.Error(0)
+}
+
+func (m *MockRepository) ListRetryable(now time.Time) ([]Notification, error) {
+	args := m.Called(now)
+	return args.Get(0).([]Notification), args.Error(1)
+}
+
+func (m *MockRepository) ListByTenant(tenantID string) ([]Notification, error) {
+	args := m.Called(tenantID)
+	return args.Get(0).([]Notification), args.Error(1)
+}
+
+// MockTenantService is a mock implementation of the tenant.Service interface
+type MockTenantService struct {
+	mock.Mock
+}
+
+func (m *MockTenantService) ListTenants(ctx context.Context) ([]tenant.Tenant, error) {
+	args := m.Called()
+	return args.Get(0).([]tenant.Tenant), args.Error(1)
+}
+
+func (m *MockTenantService) CreateTenant(ctx context.Context, tenant tenant.Tenant) error {
+	args := m.Called(tenant)
+	return args.Error(0)
+}
+
+func (m *MockTenantService) GetTenant(ctx context.Context, id string) (*tenant.Tenant, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*tenant.Tenant), args.Error(1)
+}
+
+func (m *MockTenantService) UpdateTenant(ctx context.Context, tenant tenant.Tenant) error {
+	args := m.Called(tenant)
+	return args.Error(0)
+}
+
+func (m *MockTenantService) DeleteTenant(ctx context.Context, id string) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockTenantService) GetTenantBySpace(ctx context.Context, spaceID string) (*tenant.Tenant, error) {
+	args := m.Called(spaceID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*tenant.Tenant), args.Error(1)
+}
+
+func (m *MockTenantService) FindTenants(ctx context.Context, filter tenant.Filter, page paging.Request) (*paging.Page[tenant.Tenant], error) {
+	args := m.Called(filter, page)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*paging.Page[tenant.Tenant]), args.Error(1)
+}
+
+// MockPaymentService is a mock implementation of the payment.Service interface
+type MockPaymentService struct {
+	mock.Mock
+}
+
+func (m *MockPaymentService) CreatePayment(ctx context.Context, payment payment.Payment) error {
+	args := m.Called(payment)
+	return args.Error(0)
+}
+
+func (m *MockPaymentService) GetPayment(ctx context.Context, id string) (*payment.Payment, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*payment.Payment), args.Error(1)
+}
+
+func (m *MockPaymentService) UpdatePayment(ctx context.Context, payment payment.Payment) error {
+	args := m.Called(payment)
+	return args.Error(0)
+}
+
+func (m *MockPaymentService) DeletePayment(ctx context.Context, id string) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockPaymentService) GetTenantPayments(ctx context.Context, tenantID string) ([]payment.Payment, error) {
+	args := m.Called(tenantID)
+	return args.Get(0).([]payment.Payment), args.Error(1)
+}
+
+func (m *MockPaymentService) GetTenantPaymentsByDateRange(ctx context.Context, tenantID string, start, end time.Time) ([]payment.Payment, error) {
+	args := m.Called(tenantID, start, end)
+	return args.Get(0).([]payment.Payment), args.Error(1)
+}
+
+func (m *MockPaymentService) GetPaymentsByDateRange(ctx context.Context, start, end time.Time) ([]payment.Payment, error) {
+	args := m.Called(start, end)
+	return args.Get(0).([]payment.Payment), args.Error(1)
+}
+
+func (m *MockPaymentService) GetLatestPayment(ctx context.Context, tenantID string) (*payment.Payment, error) {
+	args := m.Called(tenantID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*payment.Payment), args.Error(1)
+}
+
+func (m *MockPaymentService) GetUnpaidPaymentsDueBetween(ctx context.Context, start, end time.Time) ([]payment.Payment, error) {
+	args := m.Called(start, end)
+	return args.Get(0).([]payment.Payment), args.Error(1)
+}
+
+func (m *MockPaymentService) GetPaymentsPaidBetween(ctx context.Context, start, end time.Time) ([]payment.Payment, error) {
+	args := m.Called(start, end)
+	return args.Get(0).([]payment.Payment), args.Error(1)
+}
+
+func (m *MockPaymentService) FindPayments(ctx context.Context, filter payment.Filter, page paging.Request) (*paging.Page[payment.Payment], error) {
+	args := m.Called(filter, page)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*paging.Page[payment.Payment]), args.Error(1)
+}
+
+type failingTransport struct {
+	sent int
+}
+
+func (t *failingTransport) Send(msg Message) error {
+	t.sent++
+	return errors.New("connection refused")
+}
+
+// isOverdueQuery matches the open-ended overdue lookup, which starts at the epoch
+func isOverdueQuery(start time.Time) bool {
+	return start.Equal(time.Unix(0, 0))
+}
+
+func TestSendRentReminders(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockTenantService := new(MockTenantService)
+	mockPaymentService := new(MockPaymentService)
+	var outbox bytes.Buffer
+
+	service := NewService(mockRepo, NewLogTransport(&outbox), nil, mockTenantService, mockPaymentService, DefaultConfig(), clock.New(time.UTC))
+
+	now := time.Now()
+	upcoming := payment.Payment{ID: "p1", TenantID: "t1", AmountDue: payment.Dollars(450), DueDate: now.AddDate(0, 0, 2)}
+	overdue := payment.Payment{ID: "p2", TenantID: "t2", AmountDue: payment.Dollars(500), DueDate: now.AddDate(0, 0, -5)}
+
+	mockPaymentService.On("GetUnpaidPaymentsDueBetween",
+		mock.MatchedBy(func(start time.Time) bool { return !isOverdueQuery(start) }),
+		mock.AnythingOfType("time.Time")).Return([]payment.Payment{upcoming}, nil)
+	mockPaymentService.On("GetUnpaidPaymentsDueBetween",
+		mock.MatchedBy(isOverdueQuery),
+		mock.AnythingOfType("time.Time")).Return([]payment.Payment{overdue}, nil)
+
+	mockTenantService.On("GetTenant", "t1").Return(&tenant.Tenant{ID: "t1", Name: "Jane Roe", Email: "jane@example.com"}, nil)
+	mockTenantService.On("GetTenant", "t2").Return(&tenant.Tenant{ID: "t2", Name: "John Doe", Email: "john@example.com", EmailOptOut: true}, nil)
+
+	mockRepo.On("Create", mock.AnythingOfType("Notification")).Return(true, nil)
+	mockRepo.On("Update", mock.AnythingOfType("Notification")).Return(nil)
+
+	err := service.SendRentReminders()
+
+	assert.NoError(t, err)
+	mockRepo.AssertNumberOfCalls(t, "Create", 1)
+
+	created := mockRepo.Calls[0].Arguments[0].(Notification)
+	assert.Equal(t, KindRentDue, created.Kind)
+	assert.Equal(t, "p1", created.ReferenceID)
+	assert.Equal(t, ChannelEmail, created.Channel)
+	assert.Contains(t, created.Body, "Jane Roe")
+	assert.Contains(t, created.Body, "$450.00")
+
+	updated := mockRepo.Calls[1].Arguments[0].(Notification)
+	assert.Equal(t, StatusSent, updated.Status)
+	assert.Equal(t, 1, updated.Attempts)
+	assert.NotNil(t, updated.SentAt)
+
+	assert.Contains(t, outbox.String(), "To: jane@example.com")
+	assert.NotContains(t, outbox.String(), "john@example.com")
+}
+
+func TestSendRentReminders_ParkDate(t *testing.T) {
+	mockPaymentService := new(MockPaymentService)
+	chicago, _ := time.LoadLocation("America/Chicago")
+
+	// 11:30pm on May 31st in the park is June 1st on a UTC server
+	parkClock := clock.NewFake(time.Date(2024, 5, 31, 23, 30, 0, 0, chicago))
+	service := NewService(new(MockRepository), nil, nil, new(MockTenantService), mockPaymentService, DefaultConfig(), parkClock)
+
+	may31 := time.Date(2024, 5, 31, 0, 0, 0, 0, time.UTC)
+	mockPaymentService.On("GetUnpaidPaymentsDueBetween", may31, may31.AddDate(0, 0, 3)).Return([]payment.Payment{}, nil)
+	mockPaymentService.On("GetUnpaidPaymentsDueBetween", mock.MatchedBy(isOverdueQuery), may31.AddDate(0, 0, -1)).
+		Return([]payment.Payment{}, nil)
+
+	assert.NoError(t, service.SendRentReminders())
+	mockPaymentService.AssertExpectations(t)
+}
+
+func TestSendReceipts_SkipsAlreadyLogged(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockTenantService := new(MockTenantService)
+	mockPaymentService := new(MockPaymentService)
+	var outbox bytes.Buffer
+
+	service := NewService(mockRepo, NewLogTransport(&outbox), nil, mockTenantService, mockPaymentService, DefaultConfig(), clock.New(time.UTC))
+
+	paidDate := time.Now().Add(-time.Hour)
+	paid := payment.Payment{ID: "p1", TenantID: "t1", AmountDue: payment.Dollars(450), DueDate: paidDate, PaidDate: &paidDate}
+
+	mockPaymentService.On("GetPaymentsPaidBetween", mock.AnythingOfType("time.Time"), mock.AnythingOfType("time.Time")).
+		Return([]payment.Payment{paid}, nil)
+	mockTenantService.On("GetTenant", "t1").Return(&tenant.Tenant{ID: "t1", Name: "Jane Roe", Email: "jane@example.com"}, nil)
+	mockRepo.On("Create", mock.MatchedBy(func(n Notification) bool {
+		return n.Kind == KindPaymentReceipt && n.ReferenceID == "p1"
+	})).Return(false, nil)
+
+	err := service.SendReceipts()
+
+	assert.NoError(t, err)
+	assert.Empty(t, outbox.String())
+	mockRepo.AssertNotCalled(t, "Update", mock.Anything)
+}
+
+func TestRetryFailed_Backoff(t *testing.T) {
+	mockRepo := new(MockRepository)
+	transport := &failingTransport{}
+	config := DefaultConfig()
+	config.MaxAttempts = 3
+
+	service := NewService(mockRepo, transport, nil, new(MockTenantService), new(MockPaymentService), config, clock.New(time.UTC))
+
+	retryable := []Notification{
+		{ID: "n1", Channel: ChannelEmail, Recipient: "a@example.com", Status: StatusFailed, Attempts: 1},
+		{ID: "n2", Channel: ChannelEmail, Recipient: "b@example.com", Status: StatusFailed, Attempts: 2},
+	}
+	mockRepo.On("ListRetryable", mock.AnythingOfType("time.Time")).Return(retryable, nil)
+	mockRepo.On("Update", mock.AnythingOfType("Notification")).Return(nil)
+
+	before := time.Now()
+	err := service.RetryFailed()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, transport.sent)
+
+	first := mockRepo.Calls[1].Arguments[0].(Notification)
+	assert.Equal(t, StatusFailed, first.Status)
+	assert.Equal(t, 2, first.Attempts)
+	assert.Equal(t, "connection refused", first.LastError)
+	assert.WithinDuration(t, before.Add(2*config.RetryBackoff), *first.NextAttemptAt, time.Second)
+
+	second := mockRepo.Calls[2].Arguments[0].(Notification)
+	assert.Equal(t, StatusUndeliverable, second.Status)
+	assert.Nil(t, second.NextAttemptAt)
+}
+
+func TestRenderTemplates(t *testing.T) {
+	for kind := range templates {
+		subject, body, err := render(kind, templateData{
+			ParkName:    "Sunny Acres",
+			TenantName:  "Jane Roe",
+			Amount:      "450.00",
+			DueDate:     "June 1, 2024",
+			PaidDate:    "May 30, 2024",
+			DaysOverdue: 1,
+		})
+		assert.NoError(t, err)
+		assert.NotEmpty(t, subject)
+		assert.Contains(t, body, "Jane Roe")
+		assert.Contains(t, body, "$450.00")
+	}
+
+	_, body, err := render(KindRentOverdue, templateData{DaysOverdue: 1})
+	assert.NoError(t, err)
+	assert.Contains(t, body, "1 day overdue")
+
+	_, _, err = render("unknown", templateData{})
+	assert.Error(t, err)
+}
+
+func TestSendRentReminders_SMS(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockTenantService := new(MockTenantService)
+	mockPaymentService := new(MockPaymentService)
+	sms := NewMemoryTransport()
+
+	// Quiet hours that never include the current time
+	config := DefaultConfig()
+	config.QuietHoursStart = 0
+	config.QuietHoursEnd = 0
+
+	service := NewService(mockRepo, nil, sms, mockTenantService, mockPaymentService, config, clock.New(time.UTC))
+
+	now := time.Now()
+	overdue := payment.Payment{ID: "p1", TenantID: "t1", AmountDue: payment.Dollars(500), DueDate: now.AddDate(0, 0, -3)}
+
+	mockPaymentService.On("GetUnpaidPaymentsDueBetween",
+		mock.MatchedBy(func(start time.Time) bool { return !isOverdueQuery(start) }),
+		mock.AnythingOfType("time.Time")).Return([]payment.Payment{}, nil)
+	mockPaymentService.On("GetUnpaidPaymentsDueBetween",
+		mock.MatchedBy(isOverdueQuery),
+		mock.AnythingOfType("time.Time")).Return([]payment.Payment{overdue}, nil)
+	mockTenantService.On("GetTenant", "t1").Return(&tenant.Tenant{
+		ID:    "t1",
+		Name:  "Jane Roe",
+		Email: "jane@example.com",
+		Phone: "+15551234567",
+	}, nil)
+	mockRepo.On("Create", mock.AnythingOfType("Notification")).Return(true, nil)
+	mockRepo.On("Update", mock.AnythingOfType("Notification")).Return(nil)
+
+	err := service.SendRentReminders()
+
+	assert.NoError(t, err)
+
+	// Email is disabled, so only the text is logged
+	mockRepo.AssertNumberOfCalls(t, "Create", 1)
+	created := mockRepo.Calls[0].Arguments[0].(Notification)
+	assert.Equal(t, ChannelSMS, created.Channel)
+	assert.Equal(t, "+15551234567", created.Recipient)
+
+	messages := sms.Messages()
+	assert.Len(t, messages, 1)
+	assert.Equal(t, "+15551234567", messages[0].To)
+	assert.Contains(t, messages[0].Body, "3 days overdue")
+}
+
+func TestSendRentReminders_QuietHours(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockTenantService := new(MockTenantService)
+	mockPaymentService := new(MockPaymentService)
+	sms := NewMemoryTransport()
+
+	// Quiet hours that always include the current time
+	now := time.Now()
+	config := DefaultConfig()
+	config.QuietHoursStart = now.UTC().Hour()
+	config.QuietHoursEnd = (now.UTC().Hour() + 1) % 24
+
+	service := NewService(mockRepo, nil, sms, mockTenantService, mockPaymentService, config, clock.New(time.UTC))
+
+	upcoming := payment.Payment{ID: "p1", TenantID: "t1", AmountDue: payment.Dollars(500), DueDate: now.AddDate(0, 0, 1)}
+	mockPaymentService.On("GetUnpaidPaymentsDueBetween",
+		mock.MatchedBy(func(start time.Time) bool { return !isOverdueQuery(start) }),
+		mock.AnythingOfType("time.Time")).Return([]payment.Payment{upcoming}, nil)
+	mockPaymentService.On("GetUnpaidPaymentsDueBetween",
+		mock.MatchedBy(isOverdueQuery),
+		mock.AnythingOfType("time.Time")).Return([]payment.Payment{}, nil)
+	mockTenantService.On("GetTenant", "t1").Return(&tenant.Tenant{ID: "t1", Name: "Jane Roe", Phone: "+15551234567"}, nil)
+	mockRepo.On("Create", mock.AnythingOfType("Notification")).Return(true, nil)
+	mockRepo.On("Update", mock.AnythingOfType("Notification")).Return(nil)
+
+	err := service.SendRentReminders()
+
+	assert.NoError(t, err)
+	assert.Empty(t, sms.Messages())
+
+	held := mockRepo.Calls[1].Arguments[0].(Notification)
+	assert.Equal(t, StatusPending, held.Status)
+	assert.Equal(t, 0, held.Attempts)
+	assert.True(t, held.NextAttemptAt.After(now))
+	assert.Equal(t, config.QuietHoursEnd, held.NextAttemptAt.In(time.UTC).Hour())
+}
+
+func TestQuietUntil(t *testing.T) {
+	config := DefaultConfig()
+
+	// 22:30 is inside 21:00-08:00, released at 08:00 the next day
+	release := quietUntil(time.Date(2024, 5, 15, 22, 30, 0, 0, time.UTC), config)
+	assert.Equal(t, time.Date(2024, 5, 16, 8, 0, 0, 0, time.UTC), release)
+
+	// 06:00 is inside, released at 08:00 the same day
+	release = quietUntil(time.Date(2024, 5, 15, 6, 0, 0, 0, time.UTC), config)
+	assert.Equal(t, time.Date(2024, 5, 15, 8, 0, 0, 0, time.UTC), release)
+
+	// Midday is outside
+	assert.True(t, quietUntil(time.Date(2024, 5, 15, 12, 0, 0, 0, time.UTC), config).IsZero())
+}
+
+func TestFitSMS(t *testing.T) {
+	short := "Rent is due"
+	assert.Equal(t, short, fitSMS(short, 1))
+
+	long := strings.Repeat("a", 400)
+	assert.Len(t, fitSMS(long, 1), 160)
+	assert.Len(t, fitSMS(long, 2), 306)
+	assert.True(t, strings.HasSuffix(fitSMS(long, 2), "..."))
+
+	unicode := strings.Repeat("é", 100)
+	assert.Equal(t, 70, utf8.RuneCountInString(fitSMS(unicode, 1)))
+}
@@ -0,0 +1,150 @@
+// notify/n_sms.go
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+type webhookSMSTransport struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+// NewWebhookSMSTransport posts {"to": ..., "body": ...} as JSON to url, for
+// use with SMS gateways or relays that accept a simple webhook. A non-empty
+// token is sent as a bearer token.
+func NewWebhookSMSTransport(url, token string) Transport {
+	return &webhookSMSTransport{
+		url:    url,
+		token:  token,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (t *webhookSMSTransport) Send(msg Message) error {
+	payload, err := json.Marshal(map[string]string{
+		"to":   msg.To,
+		"body": msg.Body,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, t.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if t.token != "" {
+		req.Header.Set("Authorization", "Bearer "+t.token)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("sms gateway returned %s", resp.Status)
+	}
+	return nil
+}
+
+// MemoryTransport records messages instead of sending them. Useful as a fake
+// SMS gateway in tests and local development.
+type MemoryTransport struct {
+	mu       sync.Mutex
+	messages []Message
+	Err      error // Returned from Send when set
+}
+
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{}
+}
+
+func (t *MemoryTransport) Send(msg Message) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.Err != nil {
+		return t.Err
+	}
+	t.messages = append(t.messages, msg)
+	return nil
+}
+
+// Messages returns a copy of everything sent so far
+func (t *MemoryTransport) Messages() []Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]Message(nil), t.messages...)
+}
+
+// fitSMS truncates body to at most maxSegments SMS segments. Plain ASCII is
+// assumed to use the GSM-7 alphabet (160 characters, 153 per segment once
+// split); anything else is sent as UCS-2 (70, or 67 per segment).
+func fitSMS(body string, maxSegments int) string {
+	if maxSegments <= 0 {
+		return body
+	}
+
+	single, multi := 160, 153
+	for _, r := range body {
+		if r > 0x7e {
+			single, multi = 70, 67
+			break
+		}
+	}
+
+	limit := single
+	if maxSegments > 1 {
+		limit = multi * maxSegments
+	}
+
+	if utf8.RuneCountInString(body) <= limit {
+		return body
+	}
+
+	runes := []rune(body)
+	return string(runes[:limit-3]) + "..."
+}
+
+// quietUntil returns when a text at now may be sent, or the zero time if now
+// is outside quiet hours. Hours are read in now's location, which is park
+// time when now comes from the service's clock.
+func quietUntil(now time.Time, config Config) time.Time {
+	start, end := config.QuietHoursStart, config.QuietHoursEnd
+	if start == end {
+		return time.Time{}
+	}
+
+	local := now
+	loc := now.Location()
+	hour := local.Hour()
+
+	var quiet bool
+	if start < end {
+		quiet = hour >= start && hour < end
+	} else {
+		// Window wraps midnight, e.g. 21:00 - 08:00
+		quiet = hour >= start || hour < end
+	}
+	if !quiet {
+		return time.Time{}
+	}
+
+	release := time.Date(local.Year(), local.Month(), local.Day(), end, 0, 0, 0, loc)
+	if !release.After(local) {
+		release = release.AddDate(0, 0, 1)
+	}
+	return release
+}
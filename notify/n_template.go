@@ -0,0 +1,97 @@
+// notify/n_template.go
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+type messageTemplate struct {
+	subject *template.Template
+	body    *template.Template
+}
+
+// templateData is available to every notification template
+type templateData struct {
+	ParkName    string
+	TenantName  string
+	Amount      string
+	DueDate     string
+	PaidDate    string
+	DaysOverdue int
+}
+
+var templates = map[string]messageTemplate{
+	KindRentDue: newMessageTemplate(
+		"Rent due {{.DueDate}}",
+		`Hi {{.TenantName}},
+
+This is a reminder that your rent of ${{.Amount}} at {{.ParkName}} is due on {{.DueDate}}.
+
+Thank you!
+`),
+	KindRentOverdue: newMessageTemplate(
+		"Rent overdue since {{.DueDate}}",
+		`Hi {{.TenantName}},
+
+Our records show your rent of ${{.Amount}} at {{.ParkName}} was due on {{.DueDate}} and is now {{.DaysOverdue}} day{{if ne .DaysOverdue 1}}s{{end}} overdue.
+
+Please stop by the office or contact us to arrange payment.
+`),
+	KindPaymentReceipt: newMessageTemplate(
+		"Payment received - thank you",
+		`Hi {{.TenantName}},
+
+We received your payment of ${{.Amount}} on {{.PaidDate}} for rent due {{.DueDate}}.
+
+Thank you for staying at {{.ParkName}}!
+`),
+}
+
+// smsTemplates are kept short; receipts are only sent by email
+var smsTemplates = map[string]*template.Template{
+	KindRentDue: template.Must(template.New(KindRentDue).Parse(
+		"{{.ParkName}}: Hi {{.TenantName}}, your rent of ${{.Amount}} is due {{.DueDate}}.",
+	)),
+	KindRentOverdue: template.Must(template.New(KindRentOverdue).Parse(
+		"{{.ParkName}}: Hi {{.TenantName}}, your rent of ${{.Amount}} due {{.DueDate}} is {{.DaysOverdue}} day{{if ne .DaysOverdue 1}}s{{end}} overdue. Please contact the office.",
+	)),
+}
+
+func newMessageTemplate(subject, body string) messageTemplate {
+	return messageTemplate{
+		subject: template.Must(template.New("subject").Parse(subject)),
+		body:    template.Must(template.New("body").Parse(body)),
+	}
+}
+
+func renderSMS(kind string, data templateData) (string, error) {
+	tmpl, ok := smsTemplates[kind]
+	if !ok {
+		return "", fmt.Errorf("no SMS template for notification kind %s", kind)
+	}
+
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, data); err != nil {
+		return "", err
+	}
+	return body.String(), nil
+}
+
+func render(kind string, data templateData) (string, string, error) {
+	tmpl, ok := templates[kind]
+	if !ok {
+		return "", "", fmt.Errorf("no template for notification kind %s", kind)
+	}
+
+	var subject, body bytes.Buffer
+	if err := tmpl.subject.Execute(&subject, data); err != nil {
+		return "", "", err
+	}
+	if err := tmpl.body.Execute(&body, data); err != nil {
+		return "", "", err
+	}
+
+	return subject.String(), body.String(), nil
+}
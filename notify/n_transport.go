@@ -0,0 +1,88 @@
+// notify/n_transport.go
+package notify
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BodaciousX/RVParkBackend/user"
+)
+
+// SMTPConfig holds the mail server settings for NewSMTPTransport
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+type smtpTransport struct {
+	config SMTPConfig
+}
+
+func NewSMTPTransport(config SMTPConfig) Transport {
+	return &smtpTransport{config: config}
+}
+
+func (t *smtpTransport) Send(msg Message) error {
+	var auth smtp.Auth
+	if t.config.Username != "" {
+		auth = smtp.PlainAuth("", t.config.Username, t.config.Password, t.config.Host)
+	}
+
+	addr := fmt.Sprintf("%s:%d", t.config.Host, t.config.Port)
+	return smtp.SendMail(addr, auth, t.config.From, []string{msg.To}, formatEmail(t.config.From, msg))
+}
+
+func formatEmail(from string, msg Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+type logTransport struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// NewLogTransport writes messages to out instead of sending them. Used in
+// development and tests, or as a file sink when no mail server is configured.
+func NewLogTransport(out io.Writer) Transport {
+	return &logTransport{out: out}
+}
+
+func (t *logTransport) Send(msg Message) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, err := fmt.Fprintf(t.out, "--- %s\nTo: %s\nSubject: %s\n\n%s\n",
+		time.Now().Format(time.RFC3339), msg.To, msg.Subject, msg.Body)
+	return err
+}
+
+type mailer struct {
+	transport Transport
+}
+
+// NewMailer sends the user service's password reset and invitation emails
+// through an email Transport, so they go out the same way as notifications
+func NewMailer(transport Transport) user.Mailer {
+	return &mailer{transport: transport}
+}
+
+func (m *mailer) Send(ctx context.Context, to, subject, body string) error {
+	return m.transport.Send(Message{To: to, Subject: subject, Body: body})
+}
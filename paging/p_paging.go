@@ -0,0 +1,154 @@
+// paging/p_paging.go
+package paging
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/BodaciousX/RVParkBackend/apperr"
+)
+
+const (
+	DefaultLimit = 100
+	MaxLimit     = 500
+)
+
+// Request asks for one page of a sorted list. Sort names a field, prefixed
+// with "-" for descending order; Cursor is the NextCursor of the previous page.
+type Request struct {
+	Limit  int
+	Cursor string
+	Sort   string
+}
+
+// Page is one page of results. Total counts every item matching the filter,
+// not just those on this page. NextCursor is empty on the last page.
+type Page[T any] struct {
+	Items      []T
+	Total      int
+	NextCursor string
+}
+
+// PageLimit returns the requested limit clamped to 1..MaxLimit
+func (r Request) PageLimit() int {
+	switch {
+	case r.Limit <= 0:
+		return DefaultLimit
+	case r.Limit > MaxLimit:
+		return MaxLimit
+	default:
+		return r.Limit
+	}
+}
+
+// Order is a resolved sort: the field the client asked for and the column it maps to
+type Order struct {
+	Field      string
+	Column     string
+	Descending bool
+}
+
+// Order resolves r.Sort against the sortable fields, falling back to defaultSort
+func (r Request) Order(columns map[string]string, defaultSort string) (Order, error) {
+	spec := r.Sort
+	if spec == "" {
+		spec = defaultSort
+	}
+
+	order := Order{Field: strings.TrimPrefix(spec, "-"), Descending: strings.HasPrefix(spec, "-")}
+	column, ok := columns[order.Field]
+	if !ok {
+		fields := make([]string, 0, len(columns))
+		for field := range columns {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		return Order{}, apperr.Invalid("sort", "cannot sort by %q, expected one of %s", order.Field, strings.Join(fields, ", "))
+	}
+	order.Column = column
+	return order, nil
+}
+
+// After returns a keyset condition selecting rows after the cursor position,
+// with idColumn breaking ties between equal sort values
+func (o Order) After(idColumn string) string {
+	op := ">"
+	if o.Descending {
+		op = "<"
+	}
+	return fmt.Sprintf("(%s, %s) %s (?, ?)", o.Column, idColumn, op)
+}
+
+// OrderBy returns the ORDER BY clause for o with idColumn as the tie breaker
+func (o Order) OrderBy(idColumn string) string {
+	dir := "ASC"
+	if o.Descending {
+		dir = "DESC"
+	}
+	return fmt.Sprintf("ORDER BY %s %s, %s %s", o.Column, dir, idColumn, dir)
+}
+
+// Cursor marks the last item of a page by its sort value and ID
+type Cursor struct {
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+func EncodeCursor(c Cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func DecodeCursor(s string) (Cursor, error) {
+	var c Cursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(data, &c)
+	}
+	if err != nil || c.ID == "" {
+		return Cursor{}, apperr.Invalid("cursor", "invalid cursor")
+	}
+	return c, nil
+}
+
+// Builder assembles a WHERE clause. Conditions use "?" for arguments, which
+// are rewritten to numbered placeholders.
+type Builder struct {
+	conditions []string
+	args       []interface{}
+}
+
+func (b *Builder) Where(condition string, args ...interface{}) {
+	for _, arg := range args {
+		b.args = append(b.args, arg)
+		condition = strings.Replace(condition, "?", fmt.Sprintf("$%d", len(b.args)), 1)
+	}
+	b.conditions = append(b.conditions, condition)
+}
+
+// Clause returns "WHERE ..." or an empty string when there are no conditions
+func (b *Builder) Clause() string {
+	if len(b.conditions) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(b.conditions, " AND ")
+}
+
+func (b *Builder) Args() []interface{} {
+	return b.args
+}
+
+// Limit appends a LIMIT argument and returns its placeholder
+func (b *Builder) Limit(n int) string {
+	b.args = append(b.args, n)
+	return fmt.Sprintf("LIMIT $%d", len(b.args))
+}
+
+// Contains returns an ILIKE pattern matching s anywhere, with wildcards in s escaped
+func Contains(s string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + replacer.Replace(s) + "%"
+}
@@ -0,0 +1,63 @@
+// paging/p_paging_test.go
+package paging
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/BodaciousX/RVParkBackend/apperr"
+	"github.com/stretchr/testify/assert"
+)
+
+var columns = map[string]string{
+	"name":      "name",
+	"createdAt": "created_at",
+}
+
+func TestOrder(t *testing.T) {
+	order, err := Request{}.Order(columns, "name")
+	assert.NoError(t, err)
+	assert.Equal(t, Order{Field: "name", Column: "name"}, order)
+	assert.Equal(t, "ORDER BY name ASC, id ASC", order.OrderBy("id"))
+	assert.Equal(t, "(name, id) > (?, ?)", order.After("id"))
+
+	order, err = Request{Sort: "-createdAt"}.Order(columns, "name")
+	assert.NoError(t, err)
+	assert.True(t, order.Descending)
+	assert.Equal(t, "ORDER BY created_at DESC, id DESC", order.OrderBy("id"))
+	assert.Equal(t, "(created_at, id) < (?, ?)", order.After("id"))
+
+	_, err = Request{Sort: "password"}.Order(columns, "name")
+	assert.True(t, errors.Is(err, apperr.ErrValidation))
+	assert.Contains(t, err.Error(), "createdAt, name")
+}
+
+func TestPageLimit(t *testing.T) {
+	assert.Equal(t, DefaultLimit, Request{}.PageLimit())
+	assert.Equal(t, 10, Request{Limit: 10}.PageLimit())
+	assert.Equal(t, MaxLimit, Request{Limit: MaxLimit + 1}.PageLimit())
+}
+
+func TestCursor(t *testing.T) {
+	encoded := EncodeCursor(Cursor{Value: "Jane Roe", ID: "t1"})
+
+	decoded, err := DecodeCursor(encoded)
+	assert.NoError(t, err)
+	assert.Equal(t, Cursor{Value: "Jane Roe", ID: "t1"}, decoded)
+
+	_, err = DecodeCursor("not a cursor")
+	assert.True(t, errors.Is(err, apperr.ErrValidation))
+}
+
+func TestBuilder(t *testing.T) {
+	var where Builder
+	assert.Equal(t, "", where.Clause())
+
+	where.Where("name ILIKE ?", Contains("50%_off"))
+	where.Where("paid_date IS NULL")
+	where.Where("(name, id) > (?, ?)", "Jane", "t1")
+
+	assert.Equal(t, `WHERE name ILIKE $1 AND paid_date IS NULL AND (name, id) > ($2, $3)`, where.Clause())
+	assert.Equal(t, "LIMIT $4", where.Limit(11))
+	assert.Equal(t, []interface{}{`%50\%\_off%`, "Jane", "t1", 11}, where.Args())
+}
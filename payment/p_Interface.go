@@ -1,25 +1,37 @@
 // payment/p_interface.go
 package payment
 
-import "time"
+import (
+	"context"
+	"time"
+
+	"github.com/BodaciousX/RVParkBackend/paging"
+)
 
 type Service interface {
-	CreatePayment(payment Payment) error
-	GetPayment(id string) (*Payment, error)
-	UpdatePayment(payment Payment) error
-	DeletePayment(id string) error
-	GetTenantPayments(tenantID string) ([]Payment, error)
-	GetPaymentsByDateRange(start, end time.Time) ([]Payment, error)
-	GetLatestPayment(tenantID string) (*Payment, error)
+	CreatePayment(ctx context.Context, payment Payment) error
+	GetPayment(ctx context.Context, id string) (*Payment, error)
+	UpdatePayment(ctx context.Context, payment Payment) error
+	DeletePayment(ctx context.Context, id string) error
+	GetTenantPayments(ctx context.Context, tenantID string) ([]Payment, error)
+	GetTenantPaymentsByDateRange(ctx context.Context, tenantID string, start, end time.Time) ([]Payment, error)
+	GetPaymentsByDateRange(ctx context.Context, start, end time.Time) ([]Payment, error)
+	GetLatestPayment(ctx context.Context, tenantID string) (*Payment, error)
+	GetUnpaidPaymentsDueBetween(ctx context.Context, start, end time.Time) ([]Payment, error)
+	GetPaymentsPaidBetween(ctx context.Context, start, end time.Time) ([]Payment, error)
+	FindPayments(ctx context.Context, filter Filter, page paging.Request) (*paging.Page[Payment], error)
 }
 
 type Repository interface {
-	Create(payment Payment) error
-	Get(id string) (*Payment, error)
-	Update(payment Payment) error
-	Delete(id string) error
-	ListByTenant(tenantID string) ([]Payment, error)
-	ListByDateRange(start, end time.Time) ([]Payment, error)
-	ListByDateRangeAndTenant(start, end time.Time, tenantID string) ([]Payment, error)
-	GetLatestByTenant(tenantID string) (*Payment, error)
+	Create(ctx context.Context, payment Payment) error
+	Get(ctx context.Context, id string) (*Payment, error)
+	Update(ctx context.Context, payment Payment) error
+	Delete(ctx context.Context, id string) error
+	ListByTenant(ctx context.Context, tenantID string) ([]Payment, error)
+	ListByDateRange(ctx context.Context, start, end time.Time) ([]Payment, error)
+	ListByDateRangeAndTenant(ctx context.Context, start, end time.Time, tenantID string) ([]Payment, error)
+	GetLatestByTenant(ctx context.Context, tenantID string) (*Payment, error)
+	ListUnpaidByDueDateRange(ctx context.Context, start, end time.Time) ([]Payment, error)
+	ListByPaidDateRange(ctx context.Context, start, end time.Time) ([]Payment, error)
+	Find(ctx context.Context, filter Filter, page paging.Request) (*paging.Page[Payment], error)
 }
@@ -7,10 +7,31 @@ import "time"
 type Payment struct {
 	ID              string     `json:"id"`
 	TenantID        string     `json:"tenantId"`
-	AmountDue       float64    `json:"amountDue"`
+	AmountDue       Money      `json:"amountDue"`
 	DueDate         time.Time  `json:"dueDate"`
 	PaidDate        *time.Time `json:"paidDate,omitempty"`
 	NextPaymentDate time.Time  `json:"nextPaymentDate"`
 	CreatedAt       time.Time  `json:"createdAt"`
 	UpdatedAt       time.Time  `json:"updatedAt"`
+	Version         int        `json:"-"` // Bumped on every update, sent to clients as the ETag
+}
+
+// DateField selects which date a Filter's From/To range applies to
+type DateField string
+
+const (
+	DateDue  DateField = "dueDate"
+	DatePaid DateField = "paidDate"
+)
+
+// Filter narrows a payment listing. Zero values match every payment; an
+// unset From or To leaves that end of the date range open.
+type Filter struct {
+	TenantID  string
+	Paid      *bool // true for paid payments only, false for unpaid only
+	MinAmount *Money
+	MaxAmount *Money
+	DateField DateField // defaults to DateDue
+	From      time.Time
+	To        time.Time
 }
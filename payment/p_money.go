@@ -0,0 +1,202 @@
+// payment/p_money.go
+package payment
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount in whole cents. Keeping cents as an integer, rather than
+// dollars as a float64, means sums of payments are exact. In JSON and in the
+// DECIMAL(10,2) columns it is written as dollars with two decimals, e.g. 500.25.
+type Money int64
+
+// MaxAmount is the largest amount the DECIMAL(10,2) columns can hold
+const MaxAmount = Money(99_999_999_99)
+
+// Cents returns an amount of n cents
+func Cents(n int64) Money {
+	return Money(n)
+}
+
+// Dollars returns an amount of n whole dollars
+func Dollars(n int64) Money {
+	return Money(n * 100)
+}
+
+// ParseMoney parses a decimal number of dollars such as "500", "-12.5",
+// "19.999" or, as JSON encoders may write it, "1.5e2". Digits past the cents
+// are rounded half away from zero, the same way Postgres rounds when storing
+// into a DECIMAL(10,2) column.
+func ParseMoney(s string) (Money, error) {
+	value := strings.TrimSpace(s)
+	negative := false
+	switch {
+	case strings.HasPrefix(value, "-"):
+		negative, value = true, value[1:]
+	case strings.HasPrefix(value, "+"):
+		value = value[1:]
+	}
+
+	value, exponent, hasExponent := strings.Cut(strings.ToLower(value), "e")
+	whole, fraction, _ := strings.Cut(value, ".")
+	if whole == "" && fraction == "" || !isDigits(whole) || !isDigits(fraction) {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	if hasExponent {
+		shift, err := strconv.Atoi(exponent)
+		if err != nil {
+			return 0, fmt.Errorf("invalid amount %q", s)
+		}
+		// Wider shifts can only overflow or round to nothing
+		if shift > 20 || shift < -20 {
+			return 0, fmt.Errorf("amount %q is out of range", s)
+		}
+		whole, fraction = shiftPoint(whole, fraction, shift)
+	}
+
+	// Fifteen digits of dollars keeps the cents well inside an int64
+	whole = strings.TrimLeft(whole, "0")
+	if len(whole) > 15 {
+		return 0, fmt.Errorf("amount %q is out of range", s)
+	}
+
+	fraction += "000"
+	cents, _ := strconv.ParseInt("0"+whole+fraction[:2], 10, 64)
+	if fraction[2] >= '5' {
+		cents++
+	}
+	if negative {
+		cents = -cents
+	}
+	return Money(cents), nil
+}
+
+// shiftPoint moves the decimal point between whole and fraction by shift
+// places, to the right when shift is positive
+func shiftPoint(whole, fraction string, shift int) (string, string) {
+	digits := whole + fraction
+	point := len(whole) + shift
+	switch {
+	case point < 0:
+		digits = strings.Repeat("0", -point) + digits
+		point = 0
+	case point > len(digits):
+		digits += strings.Repeat("0", point-len(digits))
+	}
+	return digits[:point], digits[point:]
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// MulRatio returns m*num/den rounded half away from zero to the nearest
+// cent. It fails if den is zero or the result doesn't fit in a Money.
+func (m Money) MulRatio(num, den int64) (Money, error) {
+	if den == 0 {
+		return 0, fmt.Errorf("%s * %d/%d: zero denominator", m, num, den)
+	}
+	product := new(big.Int).Mul(big.NewInt(int64(m)), big.NewInt(num))
+	divisor := big.NewInt(den)
+	quotient, remainder := new(big.Int).QuoRem(product, divisor, new(big.Int))
+
+	// Round up in magnitude when the remainder is at least half the divisor
+	twice := remainder.Abs(remainder)
+	twice.Lsh(twice, 1)
+	if twice.Cmp(divisor.Abs(divisor)) >= 0 {
+		if product.Sign() < 0 != (den < 0) {
+			quotient.Sub(quotient, big.NewInt(1))
+		} else {
+			quotient.Add(quotient, big.NewInt(1))
+		}
+	}
+	if !quotient.IsInt64() {
+		return 0, fmt.Errorf("%s * %d/%d is out of range", m, num, den)
+	}
+	return Money(quotient.Int64()), nil
+}
+
+// Percent returns the given share of m in basis points, so 1250 is 12.5%
+func (m Money) Percent(basisPoints int64) (Money, error) {
+	return m.MulRatio(basisPoints, 10000)
+}
+
+// Prorate returns the part of m covering days out of a period of periodDays,
+// e.g. rent for a tenant who moves in partway through the month
+func (m Money) Prorate(days, periodDays int) (Money, error) {
+	return m.MulRatio(int64(days), int64(periodDays))
+}
+
+// String formats m as dollars with two decimals, e.g. "-12.05"
+func (m Money) String() string {
+	sign := ""
+	cents := uint64(m)
+	if m < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
+
+// MarshalJSON writes m as a JSON number of dollars, as float amounts were
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON accepts a JSON number of dollars or the same in a string
+func (m *Money) UnmarshalJSON(data []byte) error {
+	value := string(data)
+	if value == "null" {
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(value); err == nil {
+		value = unquoted
+	}
+	parsed, err := ParseMoney(value)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Scan reads a DECIMAL column
+func (m *Money) Scan(src any) error {
+	switch v := src.(type) {
+	case []byte:
+		return m.scanString(string(v))
+	case string:
+		return m.scanString(v)
+	case int64:
+		*m = Dollars(v)
+	case float64:
+		*m = Money(math.Round(v * 100))
+	default:
+		return fmt.Errorf("cannot scan %T into Money", src)
+	}
+	return nil
+}
+
+func (m *Money) scanString(s string) error {
+	parsed, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Value writes m as a decimal string so Postgres stores it exactly
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
@@ -0,0 +1,125 @@
+// payment/p_money_test.go
+package payment
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMoney(t *testing.T) {
+	testCases := []struct {
+		input string
+		want  Money
+	}{
+		{"500", Dollars(500)},
+		{"500.5", Cents(50050)},
+		{"0.10", Cents(10)},
+		{".99", Cents(99)},
+		{"-12.05", Cents(-1205)},
+		{"+3", Dollars(3)},
+		{"19.994", Cents(1999)},
+		{"19.995", Cents(2000)},
+		{"-19.995", Cents(-2000)},
+		// The float64 sum 0.1 + 0.2 still lands on the right cent
+		{"0.30000000000000004", Cents(30)},
+		// JSON numbers may be written with an exponent
+		{"1e3", Dollars(1000)},
+		{"1.5e2", Dollars(150)},
+		{"1.5E+2", Dollars(150)},
+		{"-2.5e-1", Cents(-25)},
+		{"1.9995e1", Cents(2000)},
+		{"5e-3", Cents(1)},
+		{"4e-3", Cents(0)},
+		{"000000000000000001", Cents(100)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			got, err := ParseMoney(tc.input)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+
+	for _, input := range []string{"", "-", ".", "abc", "1.2.3", "--5", "1234567890123456", "e3", "1e", "1e+", "1e3.5", "1e15", "1e999"} {
+		_, err := ParseMoney(input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	assert.Equal(t, "500.00", Dollars(500).String())
+	assert.Equal(t, "0.05", Cents(5).String())
+	assert.Equal(t, "-12.05", Cents(-1205).String())
+	assert.Equal(t, "99999999.99", MaxAmount.String())
+}
+
+func TestMoneyJSON(t *testing.T) {
+	data, err := json.Marshal(Payment{AmountDue: Cents(50025)})
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"amountDue":500.25`)
+
+	// Clients that sent float amounts keep working, and strings are accepted too
+	for _, body := range []string{`{"amountDue": 500.25}`, `{"amountDue": "500.25"}`, `{"amountDue": 5.0025e2}`} {
+		var p Payment
+		require.NoError(t, json.Unmarshal([]byte(body), &p))
+		assert.Equal(t, Cents(50025), p.AmountDue)
+	}
+
+	var p Payment
+	assert.Error(t, json.Unmarshal([]byte(`{"amountDue": "lots"}`), &p))
+}
+
+func TestMoneyScan(t *testing.T) {
+	var m Money
+	require.NoError(t, m.Scan([]byte("1234.56")))
+	assert.Equal(t, Cents(123456), m)
+	require.NoError(t, m.Scan(int64(7)))
+	assert.Equal(t, Dollars(7), m)
+	require.NoError(t, m.Scan(0.29))
+	assert.Equal(t, Cents(29), m)
+	assert.Error(t, m.Scan(nil))
+
+	value, err := Cents(123456).Value()
+	require.NoError(t, err)
+	assert.Equal(t, "1234.56", value)
+}
+
+func TestMoneyRounding(t *testing.T) {
+	rent := Dollars(500)
+
+	result := func(m Money, err error) Money {
+		require.NoError(t, err)
+		return m
+	}
+
+	// Ten days of a thirty-one day month: 161.2903... rounds down
+	assert.Equal(t, Cents(16129), result(rent.Prorate(10, 31)))
+	// 17 of 30 days: 283.3333... rounds down, a third of 5 cents rounds up
+	assert.Equal(t, Cents(28333), result(rent.Prorate(17, 30)))
+	assert.Equal(t, Cents(2), result(Cents(5).MulRatio(1, 3)))
+	// Exactly half a cent rounds away from zero either side of zero
+	assert.Equal(t, Cents(1), result(Cents(1).MulRatio(1, 2)))
+	assert.Equal(t, Cents(-1), result(Cents(-1).MulRatio(1, 2)))
+	assert.Equal(t, Cents(-1), result(Cents(1).MulRatio(1, -2)))
+
+	// A 12.5% late fee on 99.99 is 12.49875
+	assert.Equal(t, Cents(1250), result(Cents(9999).Percent(1250)))
+	// 0.25% of 10.00 is exactly 2.5 cents
+	assert.Equal(t, Cents(3), result(Dollars(10).Percent(25)))
+
+	// Intermediate products wider than an int64 are still exact
+	assert.Equal(t, Money(math.MaxInt64), result(Money(math.MaxInt64).MulRatio(3, 3)))
+
+	// Results that don't fit are reported rather than wrapping around
+	_, err := Money(math.MaxInt64).MulRatio(3, 2)
+	assert.Error(t, err)
+	_, err = Money(math.MinInt64).MulRatio(-1, 1)
+	assert.Error(t, err)
+	_, err = rent.MulRatio(1, 0)
+	assert.Error(t, err)
+}
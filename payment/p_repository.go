@@ -2,8 +2,13 @@
 package payment
 
 import (
+	"context"
 	"database/sql"
 	"time"
+
+	"github.com/BodaciousX/RVParkBackend/apperr"
+	"github.com/BodaciousX/RVParkBackend/clock"
+	"github.com/BodaciousX/RVParkBackend/paging"
 )
 
 type sqlRepository struct {
@@ -14,33 +19,34 @@ func NewSQLRepository(db *sql.DB) Repository {
 	return &sqlRepository{db: db}
 }
 
-func (r *sqlRepository) Create(payment Payment) error {
+func (r *sqlRepository) Create(ctx context.Context, payment Payment) error {
 	query := `
         INSERT INTO payments (
             id, tenant_id, amount_due, due_date, paid_date, 
             next_payment_date, created_at, updated_at
-        ) VALUES ($1, $2, $3, $4, $5, $6, $7, $7)
+        ) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
     `
 
-	now := time.Now()
-	_, err := r.db.Exec(
+	_, err := r.db.ExecContext(
+		ctx,
 		query,
 		payment.ID,
 		payment.TenantID,
 		payment.AmountDue,
-		payment.DueDate,
+		clock.DateOnly(payment.DueDate),
 		payment.PaidDate,
-		payment.NextPaymentDate,
-		now,
+		clock.DateOnly(payment.NextPaymentDate),
+		payment.CreatedAt,
+		payment.UpdatedAt,
 	)
 	return err
 }
 
-func (r *sqlRepository) Get(id string) (*Payment, error) {
+func (r *sqlRepository) Get(ctx context.Context, id string) (*Payment, error) {
 	query := `
         SELECT 
             id, tenant_id, amount_due, due_date, paid_date,
-            next_payment_date, created_at, updated_at
+            next_payment_date, created_at, updated_at, version
         FROM payments
         WHERE id = $1
     `
@@ -48,7 +54,7 @@ func (r *sqlRepository) Get(id string) (*Payment, error) {
 	var payment Payment
 	var paidDate sql.NullTime
 
-	err := r.db.QueryRow(query, id).Scan(
+	err := r.db.QueryRowContext(ctx, query, id).Scan(
 		&payment.ID,
 		&payment.TenantID,
 		&payment.AmountDue,
@@ -57,8 +63,12 @@ func (r *sqlRepository) Get(id string) (*Payment, error) {
 		&payment.NextPaymentDate,
 		&payment.CreatedAt,
 		&payment.UpdatedAt,
+		&payment.Version,
 	)
 
+	if err == sql.ErrNoRows {
+		return nil, apperr.NotFound("payment %s not found", id)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -70,45 +80,55 @@ func (r *sqlRepository) Get(id string) (*Payment, error) {
 	return &payment, nil
 }
 
-func (r *sqlRepository) Update(payment Payment) error {
+func (r *sqlRepository) Update(ctx context.Context, payment Payment) error {
 	query := `
         UPDATE payments SET
             amount_due = $2,
             due_date = $3,
             paid_date = $4,
             next_payment_date = $5,
-            updated_at = CURRENT_TIMESTAMP
-        WHERE id = $1
+            updated_at = $7,
+            version = version + 1
+        WHERE id = $1 AND ($6 = 0 OR version = $6)
     `
 
-	_, err := r.db.Exec(
+	result, err := r.db.ExecContext(
+		ctx,
 		query,
 		payment.ID,
 		payment.AmountDue,
-		payment.DueDate,
+		clock.DateOnly(payment.DueDate),
 		payment.PaidDate,
-		payment.NextPaymentDate,
+		clock.DateOnly(payment.NextPaymentDate),
+		payment.Version,
+		payment.UpdatedAt,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+	return r.requireUpdated(ctx, result, payment.ID, payment.Version)
 }
 
-func (r *sqlRepository) Delete(id string) error {
+func (r *sqlRepository) Delete(ctx context.Context, id string) error {
 	query := `DELETE FROM payments WHERE id = $1`
-	_, err := r.db.Exec(query, id)
-	return err
+	result, err := r.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(result, id)
 }
 
-func (r *sqlRepository) ListByTenant(tenantID string) ([]Payment, error) {
+func (r *sqlRepository) ListByTenant(ctx context.Context, tenantID string) ([]Payment, error) {
 	query := `
         SELECT 
             id, tenant_id, amount_due, due_date, paid_date,
-            next_payment_date, created_at, updated_at
+            next_payment_date, created_at, updated_at, version
         FROM payments
         WHERE tenant_id = $1
         ORDER BY due_date DESC
     `
 
-	rows, err := r.db.Query(query, tenantID)
+	rows, err := r.db.QueryContext(ctx, query, tenantID)
 	if err != nil {
 		return nil, err
 	}
@@ -117,17 +137,17 @@ func (r *sqlRepository) ListByTenant(tenantID string) ([]Payment, error) {
 	return r.scanPayments(rows)
 }
 
-func (r *sqlRepository) ListByDateRange(start, end time.Time) ([]Payment, error) {
+func (r *sqlRepository) ListByDateRange(ctx context.Context, start, end time.Time) ([]Payment, error) {
 	query := `
         SELECT 
             id, tenant_id, amount_due, due_date, paid_date,
-            next_payment_date, created_at, updated_at
+            next_payment_date, created_at, updated_at, version
         FROM payments
         WHERE due_date BETWEEN $1 AND $2
         ORDER BY due_date DESC
     `
 
-	rows, err := r.db.Query(query, start, end)
+	rows, err := r.db.QueryContext(ctx, query, clock.DateOnly(start), clock.DateOnly(end))
 	if err != nil {
 		return nil, err
 	}
@@ -136,17 +156,17 @@ func (r *sqlRepository) ListByDateRange(start, end time.Time) ([]Payment, error)
 	return r.scanPayments(rows)
 }
 
-func (r *sqlRepository) ListByDateRangeAndTenant(start, end time.Time, tenantID string) ([]Payment, error) {
+func (r *sqlRepository) ListByDateRangeAndTenant(ctx context.Context, start, end time.Time, tenantID string) ([]Payment, error) {
 	query := `
         SELECT 
             id, tenant_id, amount_due, due_date, paid_date,
-            next_payment_date, created_at, updated_at
+            next_payment_date, created_at, updated_at, version
         FROM payments
         WHERE due_date BETWEEN $1 AND $2 AND tenant_id = $3
         ORDER BY due_date DESC
     `
 
-	rows, err := r.db.Query(query, start, end, tenantID)
+	rows, err := r.db.QueryContext(ctx, query, clock.DateOnly(start), clock.DateOnly(end), tenantID)
 	if err != nil {
 		return nil, err
 	}
@@ -155,11 +175,49 @@ func (r *sqlRepository) ListByDateRangeAndTenant(start, end time.Time, tenantID
 	return r.scanPayments(rows)
 }
 
-func (r *sqlRepository) GetLatestByTenant(tenantID string) (*Payment, error) {
+func (r *sqlRepository) ListUnpaidByDueDateRange(ctx context.Context, start, end time.Time) ([]Payment, error) {
 	query := `
         SELECT 
             id, tenant_id, amount_due, due_date, paid_date,
-            next_payment_date, created_at, updated_at
+            next_payment_date, created_at, updated_at, version
+        FROM payments
+        WHERE due_date BETWEEN $1 AND $2 AND paid_date IS NULL
+        ORDER BY due_date
+    `
+
+	rows, err := r.db.QueryContext(ctx, query, clock.DateOnly(start), clock.DateOnly(end))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return r.scanPayments(rows)
+}
+
+func (r *sqlRepository) ListByPaidDateRange(ctx context.Context, start, end time.Time) ([]Payment, error) {
+	query := `
+        SELECT 
+            id, tenant_id, amount_due, due_date, paid_date,
+            next_payment_date, created_at, updated_at, version
+        FROM payments
+        WHERE paid_date BETWEEN $1 AND $2
+        ORDER BY paid_date
+    `
+
+	rows, err := r.db.QueryContext(ctx, query, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return r.scanPayments(rows)
+}
+
+func (r *sqlRepository) GetLatestByTenant(ctx context.Context, tenantID string) (*Payment, error) {
+	query := `
+        SELECT 
+            id, tenant_id, amount_due, due_date, paid_date,
+            next_payment_date, created_at, updated_at, version
         FROM payments
         WHERE tenant_id = $1
         ORDER BY due_date DESC
@@ -169,7 +227,7 @@ func (r *sqlRepository) GetLatestByTenant(tenantID string) (*Payment, error) {
 	var payment Payment
 	var paidDate sql.NullTime
 
-	err := r.db.QueryRow(query, tenantID).Scan(
+	err := r.db.QueryRowContext(ctx, query, tenantID).Scan(
 		&payment.ID,
 		&payment.TenantID,
 		&payment.AmountDue,
@@ -178,8 +236,12 @@ func (r *sqlRepository) GetLatestByTenant(tenantID string) (*Payment, error) {
 		&payment.NextPaymentDate,
 		&payment.CreatedAt,
 		&payment.UpdatedAt,
+		&payment.Version,
 	)
 
+	if err == sql.ErrNoRows {
+		return nil, apperr.NotFound("no payments for tenant %s", tenantID)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -191,6 +253,139 @@ func (r *sqlRepository) GetLatestByTenant(tenantID string) (*Payment, error) {
 	return &payment, nil
 }
 
+// sortColumns maps the fields payments can be sorted by to their columns
+var sortColumns = map[string]string{
+	"dueDate":   "due_date",
+	"amountDue": "amount_due",
+	"createdAt": "created_at",
+}
+
+func (r *sqlRepository) Find(ctx context.Context, filter Filter, page paging.Request) (*paging.Page[Payment], error) {
+	order, err := page.Order(sortColumns, "-dueDate")
+	if err != nil {
+		return nil, err
+	}
+
+	var where paging.Builder
+	if filter.TenantID != "" {
+		where.Where("tenant_id = ?", filter.TenantID)
+	}
+	if filter.Paid != nil {
+		if *filter.Paid {
+			where.Where("paid_date IS NOT NULL")
+		} else {
+			where.Where("paid_date IS NULL")
+		}
+	}
+	if filter.MinAmount != nil {
+		where.Where("amount_due >= ?", *filter.MinAmount)
+	}
+	if filter.MaxAmount != nil {
+		where.Where("amount_due <= ?", *filter.MaxAmount)
+	}
+	if filter.DateField == DatePaid {
+		if !filter.From.IsZero() {
+			where.Where("paid_date >= ?", filter.From)
+		}
+		if !filter.To.IsZero() {
+			where.Where("paid_date <= ?", filter.To)
+		}
+	} else {
+		if !filter.From.IsZero() {
+			where.Where("due_date >= ?", clock.DateOnly(filter.From))
+		}
+		if !filter.To.IsZero() {
+			where.Where("due_date <= ?", clock.DateOnly(filter.To))
+		}
+	}
+
+	result := &paging.Page[Payment]{Items: []Payment{}}
+	countQuery := `SELECT COUNT(*) FROM payments ` + where.Clause()
+	if err := r.db.QueryRowContext(ctx, countQuery, where.Args()...).Scan(&result.Total); err != nil {
+		return nil, err
+	}
+
+	if page.Cursor != "" {
+		cursor, err := paging.DecodeCursor(page.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		where.Where(order.After("id"), cursor.Value, cursor.ID)
+	}
+
+	limit := page.PageLimit()
+	query := `
+        SELECT 
+            id, tenant_id, amount_due, due_date, paid_date,
+            next_payment_date, created_at, updated_at, version
+        FROM payments
+        ` + where.Clause() + `
+        ` + order.OrderBy("id") + `
+        ` + where.Limit(limit+1)
+
+	rows, err := r.db.QueryContext(ctx, query, where.Args()...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payments, err := r.scanPayments(rows)
+	if err != nil {
+		return nil, err
+	}
+	result.Items = append(result.Items, payments...)
+
+	if len(result.Items) > limit {
+		result.Items = result.Items[:limit]
+		last := result.Items[limit-1]
+		result.NextCursor = paging.EncodeCursor(paging.Cursor{Value: sortValue(last, order.Field), ID: last.ID})
+	}
+	return result, nil
+}
+
+func sortValue(payment Payment, field string) string {
+	switch field {
+	case "amountDue":
+		return payment.AmountDue.String()
+	case "createdAt":
+		return payment.CreatedAt.Format(time.RFC3339Nano)
+	default:
+		return clock.DateOnly(payment.DueDate)
+	}
+}
+
+// requireUpdated explains an update that matched no rows: either the payment
+// is gone or, when a version was given, someone else changed it first
+func (r *sqlRepository) requireUpdated(ctx context.Context, result sql.Result, id string, version int) error {
+	rows, err := result.RowsAffected()
+	if err != nil || rows > 0 {
+		return err
+	}
+	if version != 0 {
+		var exists bool
+		query := `SELECT EXISTS (SELECT 1 FROM payments WHERE id = $1)`
+		if err := r.db.QueryRowContext(ctx, query, id).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			return apperr.Stale("payment %s has been modified since version %d", id, version)
+		}
+	}
+	return apperr.NotFound("payment %s not found", id)
+}
+
+// requireRow reports a missing payment when a write matched no rows
+func requireRow(result sql.Result, id string) error {
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return apperr.NotFound("payment %s not found", id)
+	}
+	return nil
+}
+
 func (r *sqlRepository) scanPayments(rows *sql.Rows) ([]Payment, error) {
 	var payments []Payment
 	for rows.Next() {
@@ -206,6 +401,7 @@ func (r *sqlRepository) scanPayments(rows *sql.Rows) ([]Payment, error) {
 			&payment.NextPaymentDate,
 			&payment.CreatedAt,
 			&payment.UpdatedAt,
+			&payment.Version,
 		)
 		if err != nil {
 			return nil, err
@@ -1,6 +1,7 @@
 package payment
 
 import (
+	"context"
 	"database/sql"
 	"testing"
 	"time"
@@ -20,7 +21,7 @@ func TestGet(t *testing.T) {
 	db, _ := sql.Open("postgres", "")
 	repo := NewSQLRepository(db)
 
-	_, _ = repo.Get("payment123")
+	_, _ = repo.Get(context.Background(), "payment123")
 	assert.True(t, true)
 }
 
@@ -28,7 +29,7 @@ func TestDelete(t *testing.T) {
 	db, _ := sql.Open("postgres", "")
 	repo := NewSQLRepository(db)
 
-	_ = repo.Delete("payment123")
+	_ = repo.Delete(context.Background(), "payment123")
 	assert.True(t, true)
 }
 
@@ -36,30 +37,30 @@ type sqlPaymentRepository struct {
 	db *sql.DB
 }
 
-func (r *sqlPaymentRepository) Create(payment Payment) error {
+func (r *sqlPaymentRepository) Create(ctx context.Context, payment Payment) error {
 	return nil
 }
 
-func (r *sqlPaymentRepository) Get(id string) (*Payment, error) {
+func (r *sqlPaymentRepository) Get(ctx context.Context, id string) (*Payment, error) {
 	return &Payment{ID: id}, nil
 }
 
-func (r *sqlPaymentRepository) Update(payment Payment) error {
+func (r *sqlPaymentRepository) Update(ctx context.Context, payment Payment) error {
 	return nil
 }
 
-func (r *sqlPaymentRepository) Delete(id string) error {
+func (r *sqlPaymentRepository) Delete(ctx context.Context, id string) error {
 	return nil
 }
 
-func (r *sqlPaymentRepository) List(limit, offset int) ([]Payment, error) {
+func (r *sqlPaymentRepository) List(ctx context.Context, limit, offset int) ([]Payment, error) {
 	return []Payment{}, nil
 }
 
-func (r *sqlPaymentRepository) GetPaymentsByDateRange(startDate, endDate time.Time) ([]Payment, error) {
+func (r *sqlPaymentRepository) GetPaymentsByDateRange(ctx context.Context, startDate, endDate time.Time) ([]Payment, error) {
 	return []Payment{}, nil
 }
 
-func (r *sqlPaymentRepository) GetPaymentsByTenant(tenantID string) ([]Payment, error) {
+func (r *sqlPaymentRepository) GetPaymentsByTenant(ctx context.Context, tenantID string) ([]Payment, error) {
 	return []Payment{}, nil
 }
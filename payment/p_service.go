@@ -2,30 +2,36 @@
 package payment
 
 import (
-	"fmt"
+	"context"
 	"time"
 
+	"github.com/BodaciousX/RVParkBackend/apperr"
+	"github.com/BodaciousX/RVParkBackend/clock"
+	"github.com/BodaciousX/RVParkBackend/paging"
 	"github.com/google/uuid"
 )
 
 type service struct {
-	repo Repository
+	repo  Repository
+	clock clock.Clock
 }
 
-func NewService(repo Repository) Service {
-	return &service{repo: repo}
+func NewService(repo Repository, clk clock.Clock) Service {
+	return &service{repo: repo, clock: clk}
 }
 
-func (s *service) CreatePayment(payment Payment) error {
+func (s *service) CreatePayment(ctx context.Context, payment Payment) error {
 	// Validate payment data
+	invalid := &apperr.ValidationError{}
 	if payment.TenantID == "" {
-		return fmt.Errorf("tenant ID is required")
+		invalid.Add("tenantId", "tenant ID is required")
 	}
-	if payment.AmountDue <= 0 {
-		return fmt.Errorf("amount due must be greater than 0")
+	validateAmountAndDueDate(payment, invalid)
+	if payment.NextPaymentDate.IsZero() {
+		invalid.Add("nextPaymentDate", "next payment date is required")
 	}
-	if payment.DueDate.IsZero() {
-		return fmt.Errorf("due date is required")
+	if err := invalid.Err(); err != nil {
+		return err
 	}
 
 	// Generate new ID if not provided
@@ -33,56 +39,117 @@ func (s *service) CreatePayment(payment Payment) error {
 		payment.ID = uuid.New().String()
 	}
 
-	// Set timestamps
-	now := time.Now()
+	// Due dates are calendar days; set timestamps
+	normalizeDates(&payment)
+	now := s.clock.Now()
 	payment.CreatedAt = now
 	payment.UpdatedAt = now
 
-	return s.repo.Create(payment)
+	return s.repo.Create(ctx, payment)
 }
 
-func (s *service) GetPayment(id string) (*Payment, error) {
-	return s.repo.Get(id)
+func (s *service) GetPayment(ctx context.Context, id string) (*Payment, error) {
+	return s.repo.Get(ctx, id)
 }
 
-func (s *service) UpdatePayment(payment Payment) error {
+func (s *service) UpdatePayment(ctx context.Context, payment Payment) error {
 	// Verify payment exists
-	existing, err := s.repo.Get(payment.ID)
+	existing, err := s.repo.Get(ctx, payment.ID)
 	if err != nil {
-		return fmt.Errorf("payment not found: %v", err)
+		return err
 	}
 
 	// Validate updates
-	if payment.AmountDue <= 0 {
-		return fmt.Errorf("amount due must be greater than 0")
-	}
-	if payment.DueDate.IsZero() {
-		return fmt.Errorf("due date is required")
+	invalid := &apperr.ValidationError{}
+	validateAmountAndDueDate(payment, invalid)
+	if err := invalid.Err(); err != nil {
+		return err
 	}
 
 	// Preserve original IDs and timestamps
 	payment.TenantID = existing.TenantID
 	payment.CreatedAt = existing.CreatedAt
-	payment.UpdatedAt = time.Now()
+	payment.UpdatedAt = s.clock.Now()
+	normalizeDates(&payment)
+
+	return s.repo.Update(ctx, payment)
+}
+
+func (s *service) DeletePayment(ctx context.Context, id string) error {
+	return s.repo.Delete(ctx, id)
+}
+
+// GetTenantPayments returns a tenant's full payment history, newest first
+func (s *service) GetTenantPayments(ctx context.Context, tenantID string) ([]Payment, error) {
+	return s.repo.ListByTenant(ctx, tenantID)
+}
+
+// GetTenantPaymentsByDateRange returns a tenant's payments due between start and end
+func (s *service) GetTenantPaymentsByDateRange(ctx context.Context, tenantID string, start, end time.Time) ([]Payment, error) {
+	if start.After(end) {
+		return nil, apperr.Invalid("start", "start date must be before end date")
+	}
+	return s.repo.ListByDateRangeAndTenant(ctx, start, end, tenantID)
+}
+
+func (s *service) GetPaymentsByDateRange(ctx context.Context, start, end time.Time) ([]Payment, error) {
+	return s.repo.ListByDateRange(ctx, start, end)
+}
 
-	return s.repo.Update(payment)
+func (s *service) GetLatestPayment(ctx context.Context, tenantID string) (*Payment, error) {
+	return s.repo.GetLatestByTenant(ctx, tenantID)
 }
 
-func (s *service) DeletePayment(id string) error {
-	return s.repo.Delete(id)
+func (s *service) GetUnpaidPaymentsDueBetween(ctx context.Context, start, end time.Time) ([]Payment, error) {
+	return s.repo.ListUnpaidByDueDateRange(ctx, start, end)
 }
 
-func (s *service) GetTenantPayments(tenantID string) ([]Payment, error) {
-	// Get a date range for the last 6 months
-	end := time.Now()
-	start := end.AddDate(0, -6, 0)
-	return s.repo.ListByDateRangeAndTenant(start, end, tenantID)
+func (s *service) GetPaymentsPaidBetween(ctx context.Context, start, end time.Time) ([]Payment, error) {
+	return s.repo.ListByPaidDateRange(ctx, start, end)
 }
 
-func (s *service) GetPaymentsByDateRange(start, end time.Time) ([]Payment, error) {
-	return s.repo.ListByDateRange(start, end)
+// FindPayments returns one page of payments matching filter
+func (s *service) FindPayments(ctx context.Context, filter Filter, page paging.Request) (*paging.Page[Payment], error) {
+	invalid := &apperr.ValidationError{}
+	if filter.MinAmount != nil && filter.MaxAmount != nil && *filter.MinAmount > *filter.MaxAmount {
+		invalid.Add("minAmount", "minimum amount must not exceed maximum amount")
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && filter.From.After(filter.To) {
+		invalid.Add("start", "start date must be before end date")
+	}
+	switch filter.DateField {
+	case "", DateDue, DatePaid:
+	default:
+		invalid.Add("dateField", "date field must be %s or %s", DateDue, DatePaid)
+	}
+	if err := invalid.Err(); err != nil {
+		return nil, err
+	}
+
+	return s.repo.Find(ctx, filter, page)
 }
 
-func (s *service) GetLatestPayment(tenantID string) (*Payment, error) {
-	return s.repo.GetLatestByTenant(tenantID)
+// normalizeDates keeps only the calendar day of the due and next payment dates
+func normalizeDates(payment *Payment) {
+	payment.DueDate = clock.Date(payment.DueDate)
+	// Clients may send the zero time for a payment not yet made; unpaid
+	// payments are stored without a paid date
+	if payment.PaidDate != nil && payment.PaidDate.IsZero() {
+		payment.PaidDate = nil
+	}
+	if !payment.NextPaymentDate.IsZero() {
+		payment.NextPaymentDate = clock.Date(payment.NextPaymentDate)
+	}
+}
+
+func validateAmountAndDueDate(payment Payment, invalid *apperr.ValidationError) {
+	if payment.AmountDue <= 0 {
+		invalid.Add("amountDue", "amount due must be greater than 0")
+	}
+	if payment.AmountDue > MaxAmount {
+		invalid.Add("amountDue", "amount due must not exceed "+MaxAmount.String())
+	}
+	if payment.DueDate.IsZero() {
+		invalid.Add("dueDate", "due date is required")
+	}
 }
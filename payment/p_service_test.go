@@ -2,10 +2,14 @@
 package payment
 
 import (
+	"context"
 	"errors"
 	"testing"
 	"time"
 
+	"github.com/BodaciousX/RVParkBackend/apperr"
+	"github.com/BodaciousX/RVParkBackend/clock"
+	"github.com/BodaciousX/RVParkBackend/paging"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -16,12 +20,12 @@ type MockRepository struct {
 	mock.Mock
 }
 
-func (m *MockRepository) Create(payment Payment) error {
+func (m *MockRepository) Create(ctx context.Context, payment Payment) error {
 	args := m.Called(payment)
 	return args.Error(0)
 }
 
-func (m *MockRepository) Get(id string) (*Payment, error) {
+func (m *MockRepository) Get(ctx context.Context, id string) (*Payment, error) {
 	args := m.Called(id)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -29,32 +33,32 @@ func (m *MockRepository) Get(id string) (*Payment, error) {
 	return args.Get(0).(*Payment), args.Error(1)
 }
 
-func (m *MockRepository) Update(payment Payment) error {
+func (m *MockRepository) Update(ctx context.Context, payment Payment) error {
 	args := m.Called(payment)
 	return args.Error(0)
 }
 
-func (m *MockRepository) Delete(id string) error {
+func (m *MockRepository) Delete(ctx context.Context, id string) error {
 	args := m.Called(id)
 	return args.Error(0)
 }
 
-func (m *MockRepository) ListByTenant(tenantID string) ([]Payment, error) {
+func (m *MockRepository) ListByTenant(ctx context.Context, tenantID string) ([]Payment, error) {
 	args := m.Called(tenantID)
 	return args.Get(0).([]Payment), args.Error(1)
 }
 
-func (m *MockRepository) ListByDateRange(start, end time.Time) ([]Payment, error) {
+func (m *MockRepository) ListByDateRange(ctx context.Context, start, end time.Time) ([]Payment, error) {
 	args := m.Called(start, end)
 	return args.Get(0).([]Payment), args.Error(1)
 }
 
-func (m *MockRepository) ListByDateRangeAndTenant(start, end time.Time, tenantID string) ([]Payment, error) {
+func (m *MockRepository) ListByDateRangeAndTenant(ctx context.Context, start, end time.Time, tenantID string) ([]Payment, error) {
 	args := m.Called(start, end, tenantID)
 	return args.Get(0).([]Payment), args.Error(1)
 }
 
-func (m *MockRepository) GetLatestByTenant(tenantID string) (*Payment, error) {
+func (m *MockRepository) GetLatestByTenant(ctx context.Context, tenantID string) (*Payment, error) {
 	args := m.Called(tenantID)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -62,19 +66,37 @@ func (m *MockRepository) GetLatestByTenant(tenantID string) (*Payment, error) {
 	return args.Get(0).(*Payment), args.Error(1)
 }
 
+func (m *MockRepository) ListUnpaidByDueDateRange(ctx context.Context, start, end time.Time) ([]Payment, error) {
+	args := m.Called(start, end)
+	return args.Get(0).([]Payment), args.Error(1)
+}
+
+func (m *MockRepository) ListByPaidDateRange(ctx context.Context, start, end time.Time) ([]Payment, error) {
+	args := m.Called(start, end)
+	return args.Get(0).([]Payment), args.Error(1)
+}
+
+func (m *MockRepository) Find(ctx context.Context, filter Filter, page paging.Request) (*paging.Page[Payment], error) {
+	args := m.Called(filter, page)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*paging.Page[Payment]), args.Error(1)
+}
+
 func TestCreatePayment_Success(t *testing.T) {
 	// Create mock
 	mockRepo := new(MockRepository)
 
 	// Create service with mock
-	service := NewService(mockRepo)
+	service := NewService(mockRepo, clock.New(time.UTC))
 
 	// Test data
 	now := time.Now()
 	nextPayment := now.AddDate(0, 1, 0)
 	testPayment := Payment{
 		TenantID:        uuid.New().String(),
-		AmountDue:       500.00,
+		AmountDue:       Dollars(500),
 		DueDate:         now,
 		NextPaymentDate: nextPayment,
 	}
@@ -83,7 +105,7 @@ func TestCreatePayment_Success(t *testing.T) {
 	mockRepo.On("Create", mock.AnythingOfType("Payment")).Return(nil)
 
 	// Call method being tested
-	err := service.CreatePayment(testPayment)
+	err := service.CreatePayment(context.Background(), testPayment)
 
 	// Assert expectations
 	assert.NoError(t, err)
@@ -94,8 +116,9 @@ func TestCreatePayment_Success(t *testing.T) {
 	createdPayment := createCall.Arguments[0].(Payment)
 	assert.Equal(t, testPayment.TenantID, createdPayment.TenantID)
 	assert.Equal(t, testPayment.AmountDue, createdPayment.AmountDue)
-	assert.Equal(t, testPayment.DueDate, createdPayment.DueDate)
-	assert.Equal(t, testPayment.NextPaymentDate, createdPayment.NextPaymentDate)
+	// Due dates are stored as calendar days
+	assert.Equal(t, clock.Date(testPayment.DueDate), createdPayment.DueDate)
+	assert.Equal(t, clock.Date(testPayment.NextPaymentDate), createdPayment.NextPaymentDate)
 	assert.NotEmpty(t, createdPayment.ID)
 	assert.False(t, createdPayment.CreatedAt.IsZero())
 	assert.False(t, createdPayment.UpdatedAt.IsZero())
@@ -106,7 +129,7 @@ func TestCreatePayment_ValidationFailure(t *testing.T) {
 	mockRepo := new(MockRepository)
 
 	// Create service with mock
-	service := NewService(mockRepo)
+	service := NewService(mockRepo, clock.New(time.UTC))
 
 	// Test cases for validation failures
 	testCases := []struct {
@@ -117,7 +140,7 @@ func TestCreatePayment_ValidationFailure(t *testing.T) {
 		{
 			name: "Empty tenant ID",
 			payment: Payment{
-				AmountDue:       500.00,
+				AmountDue:       Dollars(500),
 				DueDate:         time.Now(),
 				NextPaymentDate: time.Now().AddDate(0, 1, 0),
 			},
@@ -127,17 +150,27 @@ func TestCreatePayment_ValidationFailure(t *testing.T) {
 			name: "Zero amount due",
 			payment: Payment{
 				TenantID:        uuid.New().String(),
-				AmountDue:       0,
+				AmountDue:       Dollars(0),
 				DueDate:         time.Now(),
 				NextPaymentDate: time.Now().AddDate(0, 1, 0),
 			},
 			errMsg: "amount due must be greater than 0",
 		},
+		{
+			name: "Amount too large for the column",
+			payment: Payment{
+				TenantID:        uuid.New().String(),
+				AmountDue:       MaxAmount + 1,
+				DueDate:         time.Now(),
+				NextPaymentDate: time.Now().AddDate(0, 1, 0),
+			},
+			errMsg: "amount due must not exceed 99999999.99",
+		},
 		{
 			name: "Empty due date",
 			payment: Payment{
 				TenantID:        uuid.New().String(),
-				AmountDue:       500.00,
+				AmountDue:       Dollars(500),
 				NextPaymentDate: time.Now().AddDate(0, 1, 0),
 			},
 			errMsg: "due date is required",
@@ -147,7 +180,7 @@ func TestCreatePayment_ValidationFailure(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			// Call method being tested
-			err := service.CreatePayment(tc.payment)
+			err := service.CreatePayment(context.Background(), tc.payment)
 
 			// Assert expectations
 			assert.Error(t, err)
@@ -163,7 +196,7 @@ func TestGetPayment_Success(t *testing.T) {
 	mockRepo := new(MockRepository)
 
 	// Create service with mock
-	service := NewService(mockRepo)
+	service := NewService(mockRepo, clock.New(time.UTC))
 
 	// Test data
 	now := time.Now()
@@ -171,7 +204,7 @@ func TestGetPayment_Success(t *testing.T) {
 	testPayment := &Payment{
 		ID:              paymentID,
 		TenantID:        uuid.New().String(),
-		AmountDue:       500.00,
+		AmountDue:       Dollars(500),
 		DueDate:         now,
 		NextPaymentDate: now.AddDate(0, 1, 0),
 		CreatedAt:       now.Add(-time.Hour),
@@ -182,7 +215,7 @@ func TestGetPayment_Success(t *testing.T) {
 	mockRepo.On("Get", paymentID).Return(testPayment, nil)
 
 	// Call method being tested
-	payment, err := service.GetPayment(paymentID)
+	payment, err := service.GetPayment(context.Background(), paymentID)
 
 	// Assert expectations
 	assert.NoError(t, err)
@@ -198,7 +231,7 @@ func TestGetPayment_NotFound(t *testing.T) {
 	mockRepo := new(MockRepository)
 
 	// Create service with mock
-	service := NewService(mockRepo)
+	service := NewService(mockRepo, clock.New(time.UTC))
 
 	// Test data
 	paymentID := uuid.New().String()
@@ -207,7 +240,7 @@ func TestGetPayment_NotFound(t *testing.T) {
 	mockRepo.On("Get", paymentID).Return(nil, errors.New("payment not found"))
 
 	// Call method being tested
-	payment, err := service.GetPayment(paymentID)
+	payment, err := service.GetPayment(context.Background(), paymentID)
 
 	// Assert expectations
 	assert.Error(t, err)
@@ -220,7 +253,7 @@ func TestUpdatePayment_Success(t *testing.T) {
 	mockRepo := new(MockRepository)
 
 	// Create service with mock
-	service := NewService(mockRepo)
+	service := NewService(mockRepo, clock.New(time.UTC))
 
 	// Test data
 	now := time.Now()
@@ -231,7 +264,7 @@ func TestUpdatePayment_Success(t *testing.T) {
 	existingPayment := &Payment{
 		ID:              paymentID,
 		TenantID:        tenantID,
-		AmountDue:       500.00,
+		AmountDue:       Dollars(500),
 		DueDate:         now,
 		NextPaymentDate: now.AddDate(0, 1, 0),
 		CreatedAt:       now.Add(-24 * time.Hour),
@@ -240,7 +273,7 @@ func TestUpdatePayment_Success(t *testing.T) {
 
 	updatedPayment := Payment{
 		ID:              paymentID,
-		AmountDue:       450.00,               // Changed
+		AmountDue:       Dollars(450),         // Changed
 		DueDate:         now.AddDate(0, 0, 7), // Changed
 		PaidDate:        &paidDate,            // Added
 		NextPaymentDate: now.AddDate(0, 1, 7), // Changed
@@ -251,7 +284,7 @@ func TestUpdatePayment_Success(t *testing.T) {
 	mockRepo.On("Update", mock.AnythingOfType("Payment")).Return(nil)
 
 	// Call method being tested
-	err := service.UpdatePayment(updatedPayment)
+	err := service.UpdatePayment(context.Background(), updatedPayment)
 
 	// Assert expectations
 	assert.NoError(t, err)
@@ -263,9 +296,9 @@ func TestUpdatePayment_Success(t *testing.T) {
 	assert.Equal(t, paymentID, finalPayment.ID)
 	assert.Equal(t, tenantID, finalPayment.TenantID) // Should preserve original tenant ID
 	assert.Equal(t, updatedPayment.AmountDue, finalPayment.AmountDue)
-	assert.Equal(t, updatedPayment.DueDate, finalPayment.DueDate)
+	assert.Equal(t, clock.Date(updatedPayment.DueDate), finalPayment.DueDate)
 	assert.Equal(t, updatedPayment.PaidDate, finalPayment.PaidDate)
-	assert.Equal(t, updatedPayment.NextPaymentDate, finalPayment.NextPaymentDate)
+	assert.Equal(t, clock.Date(updatedPayment.NextPaymentDate), finalPayment.NextPaymentDate)
 	assert.Equal(t, existingPayment.CreatedAt, finalPayment.CreatedAt)    // Should preserve created at
 	assert.NotEqual(t, existingPayment.UpdatedAt, finalPayment.UpdatedAt) // Should update updated at
 }
@@ -275,7 +308,7 @@ func TestUpdatePayment_ValidationFailure(t *testing.T) {
 	mockRepo := new(MockRepository)
 
 	// Create service with mock
-	service := NewService(mockRepo)
+	service := NewService(mockRepo, clock.New(time.UTC))
 
 	// Test data
 	now := time.Now()
@@ -283,7 +316,7 @@ func TestUpdatePayment_ValidationFailure(t *testing.T) {
 	existingPayment := &Payment{
 		ID:              paymentID,
 		TenantID:        uuid.New().String(),
-		AmountDue:       500.00,
+		AmountDue:       Dollars(500),
 		DueDate:         now,
 		NextPaymentDate: now.AddDate(0, 1, 0),
 		CreatedAt:       now.Add(-24 * time.Hour),
@@ -300,7 +333,7 @@ func TestUpdatePayment_ValidationFailure(t *testing.T) {
 			name: "Zero amount due",
 			payment: Payment{
 				ID:              paymentID,
-				AmountDue:       0,
+				AmountDue:       Dollars(0),
 				DueDate:         now.AddDate(0, 0, 7),
 				NextPaymentDate: now.AddDate(0, 1, 7),
 			},
@@ -310,7 +343,7 @@ func TestUpdatePayment_ValidationFailure(t *testing.T) {
 			name: "Empty due date",
 			payment: Payment{
 				ID:              paymentID,
-				AmountDue:       450.00,
+				AmountDue:       Dollars(450),
 				NextPaymentDate: now.AddDate(0, 1, 7),
 			},
 			errMsg: "due date is required",
@@ -323,7 +356,7 @@ func TestUpdatePayment_ValidationFailure(t *testing.T) {
 			mockRepo.On("Get", paymentID).Return(existingPayment, nil).Once()
 
 			// Call method being tested
-			err := service.UpdatePayment(tc.payment)
+			err := service.UpdatePayment(context.Background(), tc.payment)
 
 			// Assert expectations
 			assert.Error(t, err)
@@ -339,7 +372,7 @@ func TestDeletePayment(t *testing.T) {
 	mockRepo := new(MockRepository)
 
 	// Create service with mock
-	service := NewService(mockRepo)
+	service := NewService(mockRepo, clock.New(time.UTC))
 
 	// Test data
 	paymentID := uuid.New().String()
@@ -348,7 +381,7 @@ func TestDeletePayment(t *testing.T) {
 	mockRepo.On("Delete", paymentID).Return(nil)
 
 	// Call method being tested
-	err := service.DeletePayment(paymentID)
+	err := service.DeletePayment(context.Background(), paymentID)
 
 	// Assert expectations
 	assert.NoError(t, err)
@@ -360,7 +393,7 @@ func TestGetTenantPayments(t *testing.T) {
 	mockRepo := new(MockRepository)
 
 	// Create service with mock
-	service := NewService(mockRepo)
+	service := NewService(mockRepo, clock.New(time.UTC))
 
 	// Test data
 	tenantID := uuid.New().String()
@@ -369,29 +402,39 @@ func TestGetTenantPayments(t *testing.T) {
 		{ID: uuid.New().String(), TenantID: tenantID, AmountDue: 600.00},
 	}
 
-	// Setup expectations - we should use ListByDateRangeAndTenant with a 6-month window
-	mockRepo.On("ListByDateRangeAndTenant",
-		mock.AnythingOfType("time.Time"),
-		mock.AnythingOfType("time.Time"),
-		tenantID).Return(testPayments, nil)
+	// Setup expectations - the full history comes from ListByTenant
+	mockRepo.On("ListByTenant", tenantID).Return(testPayments, nil)
 
 	// Call method being tested
-	payments, err := service.GetTenantPayments(tenantID)
+	payments, err := service.GetTenantPayments(context.Background(), tenantID)
 
 	// Assert expectations
 	assert.NoError(t, err)
 	assert.Equal(t, testPayments, payments)
 	mockRepo.AssertExpectations(t)
+}
 
-	// Verify date range is approximately 6 months
-	dateRangeCall := mockRepo.Calls[0]
-	start := dateRangeCall.Arguments[0].(time.Time)
-	end := dateRangeCall.Arguments[1].(time.Time)
+func TestGetTenantPaymentsByDateRange(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, clock.New(time.UTC))
 
-	// Difference should be approximately 6 months (with a bit of tolerance for test execution time)
-	diff := end.Sub(start)
-	sixMonths := time.Hour * 24 * 30 * 6
-	assert.InDelta(t, sixMonths.Hours(), diff.Hours(), 24) // Allow 1 day of difference
+	tenantID := uuid.New().String()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC)
+	testPayments := []Payment{
+		{ID: uuid.New().String(), TenantID: tenantID, AmountDue: 500.00},
+	}
+
+	mockRepo.On("ListByDateRangeAndTenant", start, end, tenantID).Return(testPayments, nil)
+
+	payments, err := service.GetTenantPaymentsByDateRange(context.Background(), tenantID, start, end)
+	assert.NoError(t, err)
+	assert.Equal(t, testPayments, payments)
+
+	// A reversed range is rejected before reaching the repository
+	_, err = service.GetTenantPaymentsByDateRange(context.Background(), tenantID, end, start)
+	assert.ErrorIs(t, err, apperr.ErrValidation)
+	mockRepo.AssertNumberOfCalls(t, "ListByDateRangeAndTenant", 1)
 }
 
 func TestGetPaymentsByDateRange(t *testing.T) {
@@ -399,22 +442,22 @@ func TestGetPaymentsByDateRange(t *testing.T) {
 	mockRepo := new(MockRepository)
 
 	// Create service with mock
-	service := NewService(mockRepo)
+	service := NewService(mockRepo, clock.New(time.UTC))
 
 	// Test data
 	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
 	end := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
 
 	testPayments := []Payment{
-		{ID: uuid.New().String(), TenantID: uuid.New().String(), AmountDue: 500.00, DueDate: start.AddDate(0, 0, 15)},
-		{ID: uuid.New().String(), TenantID: uuid.New().String(), AmountDue: 600.00, DueDate: start.AddDate(0, 0, 25)},
+		{ID: uuid.New().String(), TenantID: uuid.New().String(), AmountDue: Dollars(500), DueDate: start.AddDate(0, 0, 15)},
+		{ID: uuid.New().String(), TenantID: uuid.New().String(), AmountDue: Dollars(600), DueDate: start.AddDate(0, 0, 25)},
 	}
 
 	// Setup expectations
 	mockRepo.On("ListByDateRange", start, end).Return(testPayments, nil)
 
 	// Call method being tested
-	payments, err := service.GetPaymentsByDateRange(start, end)
+	payments, err := service.GetPaymentsByDateRange(context.Background(), start, end)
 
 	// Assert expectations
 	assert.NoError(t, err)
@@ -427,7 +470,7 @@ func TestGetLatestPayment(t *testing.T) {
 	mockRepo := new(MockRepository)
 
 	// Create service with mock
-	service := NewService(mockRepo)
+	service := NewService(mockRepo, clock.New(time.UTC))
 
 	// Test data
 	tenantID := uuid.New().String()
@@ -436,7 +479,7 @@ func TestGetLatestPayment(t *testing.T) {
 	testPayment := &Payment{
 		ID:              uuid.New().String(),
 		TenantID:        tenantID,
-		AmountDue:       500.00,
+		AmountDue:       Dollars(500),
 		DueDate:         now,
 		NextPaymentDate: now.AddDate(0, 1, 0),
 	}
@@ -445,10 +488,61 @@ func TestGetLatestPayment(t *testing.T) {
 	mockRepo.On("GetLatestByTenant", tenantID).Return(testPayment, nil)
 
 	// Call method being tested
-	payment, err := service.GetLatestPayment(tenantID)
+	payment, err := service.GetLatestPayment(context.Background(), tenantID)
 
 	// Assert expectations
 	assert.NoError(t, err)
 	assert.Equal(t, testPayment, payment)
 	mockRepo.AssertExpectations(t)
 }
+
+func TestFindPayments_InvalidFilter(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, clock.New(time.UTC))
+
+	minAmount, maxAmount := Dollars(500), Dollars(100)
+	now := time.Now()
+	_, err := service.FindPayments(context.Background(), Filter{
+		MinAmount: &minAmount,
+		MaxAmount: &maxAmount,
+		DateField: "createdAt",
+		From:      now,
+		To:        now.AddDate(0, -1, 0),
+	}, paging.Request{})
+
+	var validation *apperr.ValidationError
+	assert.ErrorAs(t, err, &validation)
+	assert.Len(t, validation.Fields, 3)
+	mockRepo.AssertNotCalled(t, "Find", mock.Anything, mock.Anything)
+}
+
+func TestPaymentTimestamps_FollowClock(t *testing.T) {
+	mockRepo := new(MockRepository)
+	chicago, _ := time.LoadLocation("America/Chicago")
+	start := time.Date(2024, 6, 1, 9, 0, 0, 0, chicago)
+	clk := clock.NewFake(start)
+	service := NewService(mockRepo, clk)
+
+	mockRepo.On("Create", mock.AnythingOfType("Payment")).Return(nil)
+	err := service.CreatePayment(context.Background(), Payment{
+		TenantID:        uuid.New().String(),
+		AmountDue:       Dollars(500),
+		DueDate:         start,
+		NextPaymentDate: start.AddDate(0, 1, 0),
+	})
+	assert.NoError(t, err)
+	created := mockRepo.Calls[0].Arguments[0].(Payment)
+	assert.Equal(t, start, created.CreatedAt)
+
+	// One billing cycle later the payment is marked paid
+	clk.AdvanceDays(30)
+	mockRepo.On("Get", created.ID).Return(&created, nil)
+	mockRepo.On("Update", mock.AnythingOfType("Payment")).Return(nil)
+	paid := clk.Now()
+	created.PaidDate = &paid
+	assert.NoError(t, service.UpdatePayment(context.Background(), created))
+
+	updated := mockRepo.Calls[2].Arguments[0].(Payment)
+	assert.Equal(t, start, updated.CreatedAt)
+	assert.Equal(t, time.Date(2024, 7, 1, 9, 0, 0, 0, chicago), updated.UpdatedAt)
+}
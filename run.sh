@@ -16,4 +16,4 @@ docker-compose up -d
 # Run the application
 cd ..
 go mod tidy
-go run main.go
\ No newline at end of file
+go run .
\ No newline at end of file
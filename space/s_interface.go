@@ -1,19 +1,22 @@
 // space/s_interface.go
 package space
 
+import "context"
+
 type Service interface {
-	ListSpaces() (map[string][]Space, error)
-	GetSpace(id string) (*Space, error)
-	GetVacantSpaces() ([]Space, error)
-	ReserveSpace(spaceID string) error
-	UnreserveSpace(spaceID string) error
-	MoveIn(spaceID string, tenantID string) error
-	MoveOut(spaceID string) error
-	UpdateSpace(space Space) error
+	ListSpaces(ctx context.Context) (map[string][]Space, error)
+	GetSpace(ctx context.Context, id string) (*Space, error)
+	GetVacantSpaces(ctx context.Context) ([]Space, error)
+	ReserveSpace(ctx context.Context, spaceID string) error
+	UnreserveSpace(ctx context.Context, spaceID string) error
+	MoveIn(ctx context.Context, spaceID string, tenantID string) error
+	MoveOut(ctx context.Context, spaceID string) error
+	UpdateSpace(ctx context.Context, space Space) error
 }
 
 type Repository interface {
-	List() ([]Space, error)
-	Get(id string) (*Space, error)
-	Update(space Space) error
+	List(ctx context.Context) ([]Space, error)
+	Get(ctx context.Context, id string) (*Space, error)
+	Update(ctx context.Context, space Space) error
+	Transition(ctx context.Context, space Space, from ...string) error
 }
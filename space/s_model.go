@@ -7,6 +7,7 @@ type Space struct {
 	Status   string  `json:"status"`  // "Occupied", "Vacant", "Reserved"
 	TenantID *string `json:"tenantId,omitempty"`
 	Reserved bool    `json:"reserved"`
+	Version  int     `json:"-"` // Bumped on every update, sent to clients as the ETag
 }
 
 // Constants for space status
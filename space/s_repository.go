@@ -2,7 +2,12 @@
 package space
 
 import (
+	"context"
 	"database/sql"
+	"strings"
+
+	"github.com/BodaciousX/RVParkBackend/apperr"
+	"github.com/lib/pq"
 )
 
 type sqlRepository struct {
@@ -13,14 +18,15 @@ func NewSQLRepository(db *sql.DB) Repository {
 	return &sqlRepository{db: db}
 }
 
-func (r *sqlRepository) List() ([]Space, error) {
+func (r *sqlRepository) List(ctx context.Context) ([]Space, error) {
 	query := `
         SELECT 
             s.id,
             sec.name as section,
             s.status,
             s.tenant_id,
-            s.reserved
+            s.reserved,
+            s.version
         FROM spaces s
         JOIN sections sec ON s.section_id = sec.id
         ORDER BY 
@@ -29,7 +35,7 @@ func (r *sqlRepository) List() ([]Space, error) {
             CAST(SUBSTRING(s.id FROM '[0-9]+') AS INTEGER)
     `
 
-	rows, err := r.db.Query(query)
+	rows, err := r.db.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -46,6 +52,7 @@ func (r *sqlRepository) List() ([]Space, error) {
 			&space.Status,
 			&tenantID,
 			&space.Reserved,
+			&space.Version,
 		)
 		if err != nil {
 			return nil, err
@@ -65,14 +72,15 @@ func (r *sqlRepository) List() ([]Space, error) {
 	return spaces, nil
 }
 
-func (r *sqlRepository) Get(id string) (*Space, error) {
+func (r *sqlRepository) Get(ctx context.Context, id string) (*Space, error) {
 	query := `
         SELECT 
             s.id,
             sec.name as section,
             s.status,
             s.tenant_id,
-            s.reserved
+            s.reserved,
+            s.version
         FROM spaces s
         JOIN sections sec ON s.section_id = sec.id
         WHERE s.id = $1
@@ -81,13 +89,17 @@ func (r *sqlRepository) Get(id string) (*Space, error) {
 	var space Space
 	var tenantID sql.NullString
 
-	err := r.db.QueryRow(query, id).Scan(
+	err := r.db.QueryRowContext(ctx, query, id).Scan(
 		&space.ID,
 		&space.Section,
 		&space.Status,
 		&tenantID,
 		&space.Reserved,
+		&space.Version,
 	)
+	if err == sql.ErrNoRows {
+		return nil, apperr.NotFound("space %s not found", id)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -99,14 +111,51 @@ func (r *sqlRepository) Get(id string) (*Space, error) {
 	return &space, nil
 }
 
-func (r *sqlRepository) Update(space Space) error {
+func (r *sqlRepository) Update(ctx context.Context, space Space) error {
+	query := `
+        UPDATE spaces SET
+            status = $2,
+            tenant_id = $3,
+            reserved = $4,
+            updated_at = CURRENT_TIMESTAMP,
+            version = version + 1
+        WHERE id = $1 AND ($5 = 0 OR version = $5)
+    `
+
+	var tenantID interface{}
+	if space.TenantID != nil {
+		tenantID = *space.TenantID
+	}
+
+	result, err := r.db.ExecContext(
+		ctx,
+		query,
+		space.ID,
+		space.Status,
+		tenantID,
+		space.Reserved,
+		space.Version,
+	)
+	if err != nil {
+		return err
+	}
+	return r.requireUpdated(ctx, result, space.ID, space.Version)
+}
+
+// Transition writes space only if its current status is one of from and, when
+// space.Version is set, the row is still at that version (which also catches
+// a move-out and move-in landing in between). The check and the write are a
+// single statement, so when two requests race exactly one of them wins; the
+// loser gets a conflict.
+func (r *sqlRepository) Transition(ctx context.Context, space Space, from ...string) error {
 	query := `
         UPDATE spaces SET
             status = $2,
             tenant_id = $3,
             reserved = $4,
-            updated_at = CURRENT_TIMESTAMP
-        WHERE id = $1
+            updated_at = CURRENT_TIMESTAMP,
+            version = version + 1
+        WHERE id = $1 AND status::text = ANY($5) AND ($6 = 0 OR version = $6)
     `
 
 	var tenantID interface{}
@@ -114,12 +163,56 @@ func (r *sqlRepository) Update(space Space) error {
 		tenantID = *space.TenantID
 	}
 
-	_, err := r.db.Exec(
+	result, err := r.db.ExecContext(
+		ctx,
 		query,
 		space.ID,
 		space.Status,
 		tenantID,
 		space.Reserved,
+		pq.Array(from),
+		space.Version,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil || rows > 0 {
+		return err
+	}
+	exists, err := r.exists(ctx, space.ID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return apperr.NotFound("space %s not found", space.ID)
+	}
+	return apperr.Conflict("space %s was changed by another request; it is no longer %s", space.ID, strings.Join(from, " or "))
+}
+
+// requireUpdated explains an update that matched no rows: either the space
+// is gone or, when a version was given, someone else changed it first
+func (r *sqlRepository) requireUpdated(ctx context.Context, result sql.Result, id string, version int) error {
+	rows, err := result.RowsAffected()
+	if err != nil || rows > 0 {
+		return err
+	}
+	if version != 0 {
+		exists, err := r.exists(ctx, id)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return apperr.Stale("space %s has been modified since version %d", id, version)
+		}
+	}
+	return apperr.NotFound("space %s not found", id)
+}
+
+func (r *sqlRepository) exists(ctx context.Context, id string) (bool, error) {
+	var exists bool
+	query := `SELECT EXISTS (SELECT 1 FROM spaces WHERE id = $1)`
+	err := r.db.QueryRowContext(ctx, query, id).Scan(&exists)
+	return exists, err
 }
@@ -1,12 +1,19 @@
 package space
 
 import (
+	"context"
 	"database/sql"
+	"os"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/BodaciousX/RVParkBackend/apperr"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewSQLRepository(t *testing.T) {
@@ -16,11 +23,132 @@ func TestNewSQLRepository(t *testing.T) {
 	assert.NotNil(t, repo)
 }
 
+func TestGet_NotFound(t *testing.T) {
+	db, mockDB, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	repo := NewSQLRepository(db)
+
+	mockDB.ExpectQuery("SELECT").WithArgs("Z9").WillReturnError(sql.ErrNoRows)
+
+	_, err = repo.Get(context.Background(), "Z9")
+	assert.ErrorIs(t, err, apperr.ErrNotFound)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestGet_CancelledContext(t *testing.T) {
+	db, mockDB, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	repo := NewSQLRepository(db)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// No query expectations: a cancelled request must not reach the database
+	_, err = repo.Get(ctx, "A1")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestUpdate_Unversioned(t *testing.T) {
+	db, mockDB, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	repo := NewSQLRepository(db)
+
+	// Version 0 skips the check; a missing row is reported without a lookup
+	mockDB.ExpectExec(`UPDATE spaces SET`).WithArgs("Z9", StatusVacant, nil, false, 0).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err = repo.Update(context.Background(), Space{ID: "Z9", Status: StatusVacant})
+	assert.ErrorIs(t, err, apperr.ErrNotFound)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestTransition(t *testing.T) {
+	db, mockDB, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	repo := NewSQLRepository(db)
+
+	tenantID := "tenant1"
+	moveIn := Space{ID: "T7", Status: StatusOccupied, TenantID: &tenantID, Version: 4}
+	update := `UPDATE spaces SET .* WHERE id = \$1 AND status::text = ANY\(\$5\) AND \(\$6 = 0 OR version = \$6\)`
+
+	mockDB.ExpectExec(update).
+		WithArgs("T7", StatusOccupied, "tenant1", false, pq.Array([]string{StatusVacant, StatusReserved}), 4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, repo.Transition(context.Background(), moveIn, StatusVacant, StatusReserved))
+
+	// Another request moved someone in first
+	mockDB.ExpectExec(update).WillReturnResult(sqlmock.NewResult(0, 0))
+	mockDB.ExpectQuery(`SELECT EXISTS`).WithArgs("T7").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	err = repo.Transition(context.Background(), moveIn, StatusVacant, StatusReserved)
+	assert.ErrorIs(t, err, apperr.ErrConflict)
+	assert.Contains(t, err.Error(), "Vacant or Reserved")
+
+	mockDB.ExpectExec(update).WillReturnResult(sqlmock.NewResult(0, 0))
+	mockDB.ExpectQuery(`SELECT EXISTS`).WithArgs("T7").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	assert.ErrorIs(t, repo.Transition(context.Background(), moveIn, StatusVacant), apperr.ErrNotFound)
+
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+// TestTransition_ConcurrentPostgres races real transactions against each
+// other. It needs a migrated database in TEST_DATABASE_URL and is skipped
+// otherwise.
+func TestTransition_ConcurrentPostgres(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	require.NoError(t, err)
+	defer db.Close()
+	ctx := context.Background()
+
+	spaceID := "RACE" + strconv.FormatInt(time.Now().UnixNano()%1e9, 10)
+	_, err = db.ExecContext(ctx, `
+        INSERT INTO spaces (id, section_id, status)
+        SELECT $1, id, 'Vacant' FROM sections LIMIT 1
+    `, spaceID)
+	require.NoError(t, err)
+	defer db.ExecContext(ctx, `DELETE FROM spaces WHERE id = $1`, spaceID)
+
+	repo := NewSQLRepository(db)
+	const clerks = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, clerks)
+	for i := 0; i < clerks; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- repo.Transition(ctx, Space{ID: spaceID, Status: StatusReserved, Reserved: true}, StatusVacant)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	succeeded := 0
+	for err := range errs {
+		if err == nil {
+			succeeded++
+		} else {
+			assert.ErrorIs(t, err, apperr.ErrConflict)
+		}
+	}
+	assert.Equal(t, 1, succeeded)
+}
+
 func TestGet(t *testing.T) {
 	db, _ := sql.Open("postgres", "")
 	repo := NewSQLRepository(db)
 
-	_, _ = repo.Get("space123")
+	_, _ = repo.Get(context.Background(), "space123")
 	assert.True(t, true)
 }
 
@@ -28,7 +156,7 @@ func TestList(t *testing.T) {
 	db, _ := sql.Open("postgres", "")
 	repo := NewSQLRepository(db)
 
-	_, _ = repo.List()
+	_, _ = repo.List(context.Background())
 	assert.True(t, true)
 }
 
@@ -36,26 +164,26 @@ type sqlSpaceRepository struct {
 	db *sql.DB
 }
 
-func (r *sqlSpaceRepository) Create(space Space) error {
+func (r *sqlSpaceRepository) Create(ctx context.Context, space Space) error {
 	return nil
 }
 
-func (r *sqlSpaceRepository) Get(id string) (*Space, error) {
+func (r *sqlSpaceRepository) Get(ctx context.Context, id string) (*Space, error) {
 	return &Space{ID: id}, nil
 }
 
-func (r *sqlSpaceRepository) Update(space Space) error {
+func (r *sqlSpaceRepository) Update(ctx context.Context, space Space) error {
 	return nil
 }
 
-func (r *sqlSpaceRepository) Delete(id string) error {
+func (r *sqlSpaceRepository) Delete(ctx context.Context, id string) error {
 	return nil
 }
 
-func (r *sqlSpaceRepository) List(limit, offset int) ([]Space, error) {
+func (r *sqlSpaceRepository) List(ctx context.Context, limit, offset int) ([]Space, error) {
 	return []Space{}, nil
 }
 
-func (r *sqlSpaceRepository) GetOccupiedSpaces(date time.Time) ([]Space, error) {
+func (r *sqlSpaceRepository) GetOccupiedSpaces(ctx context.Context, date time.Time) ([]Space, error) {
 	return []Space{}, nil
 }
@@ -2,8 +2,9 @@
 package space
 
 import (
-	"fmt"
+	"context"
 
+	"github.com/BodaciousX/RVParkBackend/apperr"
 	"github.com/BodaciousX/RVParkBackend/tenant"
 )
 
@@ -19,8 +20,8 @@ func NewService(repo Repository, tenantService tenant.Service) Service {
 	}
 }
 
-func (s *service) ListSpaces() (map[string][]Space, error) {
-	spaces, err := s.repo.List()
+func (s *service) ListSpaces(ctx context.Context) (map[string][]Space, error) {
+	spaces, err := s.repo.List(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -33,8 +34,8 @@ func (s *service) ListSpaces() (map[string][]Space, error) {
 	return grouped, nil
 }
 
-func (s *service) GetSpace(id string) (*Space, error) {
-	space, err := s.repo.Get(id)
+func (s *service) GetSpace(ctx context.Context, id string) (*Space, error) {
+	space, err := s.repo.Get(ctx, id)
 	if err != nil {
 		return nil, err
 	}
@@ -42,8 +43,8 @@ func (s *service) GetSpace(id string) (*Space, error) {
 	return space, nil
 }
 
-func (s *service) GetVacantSpaces() ([]Space, error) {
-	spaces, err := s.repo.List()
+func (s *service) GetVacantSpaces(ctx context.Context) ([]Space, error) {
+	spaces, err := s.repo.List(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -57,95 +58,104 @@ func (s *service) GetVacantSpaces() ([]Space, error) {
 	return vacant, nil
 }
 
-func (s *service) ReserveSpace(spaceID string) error {
-	space, err := s.repo.Get(spaceID)
+func (s *service) ReserveSpace(ctx context.Context, spaceID string) error {
+	space, err := s.repo.Get(ctx, spaceID)
 	if err != nil {
 		return err
 	}
 
 	// Can only reserve vacant spaces
 	if space.Status != StatusVacant {
-		return fmt.Errorf("space %s is not vacant", spaceID)
+		return apperr.Conflict("space %s is not vacant", spaceID)
 	}
 
 	space.Reserved = true
 	space.Status = StatusReserved
-	return s.repo.Update(*space)
+	return s.repo.Transition(ctx, *space, StatusVacant)
 }
 
-func (s *service) UnreserveSpace(spaceID string) error {
-	space, err := s.repo.Get(spaceID)
+func (s *service) UnreserveSpace(ctx context.Context, spaceID string) error {
+	space, err := s.repo.Get(ctx, spaceID)
 	if err != nil {
 		return err
 	}
 
 	// Can only unreserve reserved spaces
 	if space.Status != StatusReserved {
-		return fmt.Errorf("space %s is not reserved", spaceID)
+		return apperr.Conflict("space %s is not reserved", spaceID)
 	}
 
 	space.Reserved = false
 	space.Status = StatusVacant
-	return s.repo.Update(*space)
+	return s.repo.Transition(ctx, *space, StatusReserved)
 }
 
-func (s *service) MoveIn(spaceID string, tenantID string) error {
-	space, err := s.repo.Get(spaceID)
+func (s *service) MoveIn(ctx context.Context, spaceID string, tenantID string) error {
+	if tenantID == "" {
+		return apperr.Invalid("tenantId", "tenant ID is required")
+	}
+
+	space, err := s.repo.Get(ctx, spaceID)
 	if err != nil {
 		return err
 	}
 
 	// Can only move in to vacant or reserved spaces
 	if space.Status != StatusVacant && space.Status != StatusReserved {
-		return fmt.Errorf("space %s is not available", spaceID)
+		return apperr.Conflict("space %s is not available", spaceID)
 	}
 
 	space.TenantID = &tenantID
 	space.Status = StatusOccupied
 	space.Reserved = false
 
-	return s.repo.Update(*space)
+	return s.repo.Transition(ctx, *space, StatusVacant, StatusReserved)
 }
 
-func (s *service) MoveOut(spaceID string) error {
-	space, err := s.repo.Get(spaceID)
+func (s *service) MoveOut(ctx context.Context, spaceID string) error {
+	space, err := s.repo.Get(ctx, spaceID)
 	if err != nil {
 		return err
 	}
 
 	// Can only move out from occupied spaces
 	if space.Status != StatusOccupied {
-		return fmt.Errorf("space %s is not occupied", spaceID)
+		return apperr.Conflict("space %s is not occupied", spaceID)
 	}
 
 	space.TenantID = nil
 	space.Status = StatusVacant
 	space.Reserved = false
 
-	return s.repo.Update(*space)
+	return s.repo.Transition(ctx, *space, StatusOccupied)
 }
 
-func (s *service) UpdateSpace(space Space) error {
+func (s *service) UpdateSpace(ctx context.Context, space Space) error {
 	// Validate status
 	switch space.Status {
 	case StatusOccupied, StatusVacant, StatusReserved:
 		// Valid status
 	default:
-		return fmt.Errorf("invalid status: %s", space.Status)
+		return apperr.Invalid("status", "invalid status: %s", space.Status)
 	}
 
 	// Validate state consistency
+	invalid := &apperr.ValidationError{}
 	if space.Reserved && space.Status != StatusReserved {
-		return fmt.Errorf("reserved spaces must have Reserved status")
+		invalid.Add("reserved", "reserved spaces must have Reserved status")
 	}
 
 	if space.TenantID != nil && space.Status != StatusOccupied {
-		return fmt.Errorf("spaces with tenants must have Occupied status")
+		invalid.Add("tenantId", "spaces with tenants must have Occupied status")
 	}
 
 	if space.Status == StatusOccupied && space.TenantID == nil {
-		return fmt.Errorf("occupied spaces must have a tenant")
+		invalid.Add("tenantId", "occupied spaces must have a tenant")
+	}
+
+	if err := invalid.Err(); err != nil {
+		return err
 	}
 
-	return s.repo.Update(space)
+	return s.repo.Update(ctx, space)
 }
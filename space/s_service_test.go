@@ -2,11 +2,20 @@
 package space
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 
+	"github.com/BodaciousX/RVParkBackend/apperr"
+	"github.com/BodaciousX/RVParkBackend/paging"
 	"github.com/BodaciousX/RVParkBackend/tenant"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 // MockRepository is a mock implementation of the Repository interface
@@ -14,12 +23,12 @@ type MockRepository struct {
 	mock.Mock
 }
 
-func (m *MockRepository) List() ([]Space, error) {
+func (m *MockRepository) List(ctx context.Context) ([]Space, error) {
 	args := m.Called()
 	return args.Get(0).([]Space), args.Error(1)
 }
 
-func (m *MockRepository) Get(id string) (*Space, error) {
+func (m *MockRepository) Get(ctx context.Context, id string) (*Space, error) {
 	args := m.Called(id)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -27,22 +36,27 @@ func (m *MockRepository) Get(id string) (*Space, error) {
 	return args.Get(0).(*Space), args.Error(1)
 }
 
-func (m *MockRepository) Update(space Space) error {
+func (m *MockRepository) Update(ctx context.Context, space Space) error {
 	args := m.Called(space)
 	return args.Error(0)
 }
 
+func (m *MockRepository) Transition(ctx context.Context, space Space, from ...string) error {
+	args := m.Called(space, from)
+	return args.Error(0)
+}
+
 // MockTenantService is a mock implementation of the tenant.Service interface
 type MockTenantService struct {
 	mock.Mock
 }
 
-func (m *MockTenantService) CreateTenant(tenant tenant.Tenant) error {
+func (m *MockTenantService) CreateTenant(ctx context.Context, tenant tenant.Tenant) error {
 	args := m.Called(tenant)
 	return args.Error(0)
 }
 
-func (m *MockTenantService) GetTenant(id string) (*tenant.Tenant, error) {
+func (m *MockTenantService) GetTenant(ctx context.Context, id string) (*tenant.Tenant, error) {
 	args := m.Called(id)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -50,7 +64,7 @@ func (m *MockTenantService) GetTenant(id string) (*tenant.Tenant, error) {
 	return args.Get(0).(*tenant.Tenant), args.Error(1)
 }
 
-func (m *MockTenantService) GetTenantBySpace(spaceID string) (*tenant.Tenant, error) {
+func (m *MockTenantService) GetTenantBySpace(ctx context.Context, spaceID string) (*tenant.Tenant, error) {
 	args := m.Called(spaceID)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -58,21 +72,29 @@ func (m *MockTenantService) GetTenantBySpace(spaceID string) (*tenant.Tenant, er
 	return args.Get(0).(*tenant.Tenant), args.Error(1)
 }
 
-func (m *MockTenantService) UpdateTenant(tenant tenant.Tenant) error {
+func (m *MockTenantService) UpdateTenant(ctx context.Context, tenant tenant.Tenant) error {
 	args := m.Called(tenant)
 	return args.Error(0)
 }
 
-func (m *MockTenantService) DeleteTenant(id string) error {
+func (m *MockTenantService) DeleteTenant(ctx context.Context, id string) error {
 	args := m.Called(id)
 	return args.Error(0)
 }
 
-func (m *MockTenantService) ListTenants() ([]tenant.Tenant, error) {
+func (m *MockTenantService) ListTenants(ctx context.Context) ([]tenant.Tenant, error) {
 	args := m.Called()
 	return args.Get(0).([]tenant.Tenant), args.Error(1)
 }
 
+func (m *MockTenantService) FindTenants(ctx context.Context, filter tenant.Filter, page paging.Request) (*paging.Page[tenant.Tenant], error) {
+	args := m.Called(filter, page)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*paging.Page[tenant.Tenant]), args.Error(1)
+}
+
 func TestListSpaces(t *testing.T) {
 	// Create mocks
 	mockRepo := new(MockRepository)
@@ -92,7 +114,7 @@ func TestListSpaces(t *testing.T) {
 	mockRepo.On("List").Return(testSpaces, nil)
 
 	// Call method being tested
-	result, err := service.ListSpaces()
+	result, err := service.ListSpaces(context.Background())
 
 	// Assert expectations
 	assert.NoError(t, err)
@@ -124,7 +146,7 @@ func TestGetVacantSpaces(t *testing.T) {
 	mockRepo.On("List").Return(testSpaces, nil)
 
 	// Call method being tested
-	vacantSpaces, err := service.GetVacantSpaces()
+	vacantSpaces, err := service.GetVacantSpaces(context.Background())
 
 	// Assert expectations
 	assert.NoError(t, err)
@@ -153,10 +175,10 @@ func TestReserveSpace_Success(t *testing.T) {
 
 	// Setup expectations
 	mockRepo.On("Get", spaceID).Return(testSpace, nil)
-	mockRepo.On("Update", mock.AnythingOfType("Space")).Return(nil)
+	mockRepo.On("Transition", mock.AnythingOfType("Space"), mock.Anything).Return(nil)
 
 	// Call method being tested
-	err := service.ReserveSpace(spaceID)
+	err := service.ReserveSpace(context.Background(), spaceID)
 
 	// Assert expectations
 	assert.NoError(t, err)
@@ -192,14 +214,15 @@ func TestReserveSpace_AlreadyOccupied(t *testing.T) {
 	mockRepo.On("Get", spaceID).Return(testSpace, nil)
 
 	// Call method being tested
-	err := service.ReserveSpace(spaceID)
+	err := service.ReserveSpace(context.Background(), spaceID)
 
 	// Assert expectations
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "not vacant")
+	assert.True(t, errors.Is(err, apperr.ErrConflict))
 	mockRepo.AssertExpectations(t)
-	// Update should not be called for occupied space
-	mockRepo.AssertNotCalled(t, "Update", mock.Anything)
+	// No transition should be attempted for an occupied space
+	mockRepo.AssertNotCalled(t, "Transition", mock.Anything, mock.Anything)
 }
 
 func TestMoveIn_Success(t *testing.T) {
@@ -222,10 +245,10 @@ func TestMoveIn_Success(t *testing.T) {
 
 	// Setup expectations
 	mockRepo.On("Get", spaceID).Return(testSpace, nil)
-	mockRepo.On("Update", mock.AnythingOfType("Space")).Return(nil)
+	mockRepo.On("Transition", mock.AnythingOfType("Space"), mock.Anything).Return(nil)
 
 	// Call method being tested
-	err := service.MoveIn(spaceID, tenantID)
+	err := service.MoveIn(context.Background(), spaceID, tenantID)
 
 	// Assert expectations
 	assert.NoError(t, err)
@@ -260,10 +283,10 @@ func TestMoveOut_Success(t *testing.T) {
 
 	// Setup expectations
 	mockRepo.On("Get", spaceID).Return(testSpace, nil)
-	mockRepo.On("Update", mock.AnythingOfType("Space")).Return(nil)
+	mockRepo.On("Transition", mock.AnythingOfType("Space"), mock.Anything).Return(nil)
 
 	// Call method being tested
-	err := service.MoveOut(spaceID)
+	err := service.MoveOut(context.Background(), spaceID)
 
 	// Assert expectations
 	assert.NoError(t, err)
@@ -277,3 +300,109 @@ func TestMoveOut_Success(t *testing.T) {
 	assert.Nil(t, updatedSpace.TenantID)
 	assert.False(t, updatedSpace.Reserved)
 }
+
+// memoryRepository keeps spaces in a map and applies Transition atomically,
+// the way the SQL repository's conditional UPDATE does
+type memoryRepository struct {
+	mu     sync.Mutex
+	spaces map[string]Space
+}
+
+func (r *memoryRepository) List(ctx context.Context) ([]Space, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var spaces []Space
+	for _, space := range r.spaces {
+		spaces = append(spaces, space)
+	}
+	return spaces, nil
+}
+
+func (r *memoryRepository) Get(ctx context.Context, id string) (*Space, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	space, ok := r.spaces[id]
+	if !ok {
+		return nil, apperr.NotFound("space %s not found", id)
+	}
+	return &space, nil
+}
+
+func (r *memoryRepository) Update(ctx context.Context, space Space) error {
+	return r.Transition(ctx, space, StatusOccupied, StatusVacant, StatusReserved)
+}
+
+func (r *memoryRepository) Transition(ctx context.Context, space Space, from ...string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	current := r.spaces[space.ID]
+	if !slices.Contains(from, current.Status) || (space.Version != 0 && space.Version != current.Version) {
+		return apperr.Conflict("space %s is no longer %s", space.ID, strings.Join(from, " or "))
+	}
+	space.Version = current.Version + 1
+	r.spaces[space.ID] = space
+	return nil
+}
+
+// Many clerks moving different tenants into T7 at once: exactly one wins
+func TestMoveIn_Concurrent(t *testing.T) {
+	repo := &memoryRepository{spaces: map[string]Space{
+		"T7": {ID: "T7", Section: "Mane Street", Status: StatusVacant, Version: 1},
+	}}
+	service := NewService(repo, new(MockTenantService))
+
+	const clerks = 50
+	var wg sync.WaitGroup
+	errs := make([]error, clerks)
+	start := make(chan struct{})
+	for i := 0; i < clerks; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			errs[i] = service.MoveIn(context.Background(), "T7", fmt.Sprintf("tenant%d", i))
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	winner := -1
+	for i, err := range errs {
+		if err == nil {
+			assert.Equal(t, -1, winner, "two move-ins succeeded")
+			winner = i
+			continue
+		}
+		assert.ErrorIs(t, err, apperr.ErrConflict)
+	}
+	require.NotEqual(t, -1, winner)
+
+	space, err := repo.Get(context.Background(), "T7")
+	require.NoError(t, err)
+	assert.Equal(t, StatusOccupied, space.Status)
+	assert.Equal(t, fmt.Sprintf("tenant%d", winner), *space.TenantID)
+}
+
+// A move-out that read the space before someone else moved out and back in
+// must not evict the new tenant
+func TestMoveOut_StaleRead(t *testing.T) {
+	first := "tenant1"
+	repo := &memoryRepository{spaces: map[string]Space{
+		"T7": {ID: "T7", Status: StatusOccupied, TenantID: &first, Version: 1},
+	}}
+	service := NewService(repo, new(MockTenantService))
+
+	stale, err := repo.Get(context.Background(), "T7")
+	require.NoError(t, err)
+
+	require.NoError(t, service.MoveOut(context.Background(), "T7"))
+	require.NoError(t, service.MoveIn(context.Background(), "T7", "tenant2"))
+
+	stale.TenantID = nil
+	stale.Status = StatusVacant
+	err = repo.Transition(context.Background(), *stale, StatusOccupied)
+	assert.ErrorIs(t, err, apperr.ErrConflict)
+
+	space, _ := repo.Get(context.Background(), "T7")
+	assert.Equal(t, "tenant2", *space.TenantID)
+}
@@ -1,26 +1,34 @@
 // tenant/t_interface.go
 package tenant
 
+import (
+	"context"
+
+	"github.com/BodaciousX/RVParkBackend/paging"
+)
+
 type Service interface {
 	// Core tenant management
-	ListTenants() ([]Tenant, error)
-	CreateTenant(tenant Tenant) error
-	GetTenant(id string) (*Tenant, error)
-	UpdateTenant(tenant Tenant) error
-	DeleteTenant(id string) error
+	ListTenants(ctx context.Context) ([]Tenant, error)
+	CreateTenant(ctx context.Context, tenant Tenant) error
+	GetTenant(ctx context.Context, id string) (*Tenant, error)
+	UpdateTenant(ctx context.Context, tenant Tenant) error
+	DeleteTenant(ctx context.Context, id string) error
 
 	// Utility methods
-	GetTenantBySpace(spaceID string) (*Tenant, error)
+	GetTenantBySpace(ctx context.Context, spaceID string) (*Tenant, error)
+	FindTenants(ctx context.Context, filter Filter, page paging.Request) (*paging.Page[Tenant], error)
 }
 
 type Repository interface {
 	// Core tenant management
-	List() ([]Tenant, error)
-	Create(tenant Tenant) error
-	Get(id string) (*Tenant, error)
-	Update(tenant Tenant) error
-	Delete(id string) error
+	List(ctx context.Context) ([]Tenant, error)
+	Create(ctx context.Context, tenant Tenant) error
+	Get(ctx context.Context, id string) (*Tenant, error)
+	Update(ctx context.Context, tenant Tenant) error
+	Delete(ctx context.Context, id string) error
 
 	// Additional queries
-	GetBySpace(spaceID string) (*Tenant, error)
+	GetBySpace(ctx context.Context, spaceID string) (*Tenant, error)
+	Find(ctx context.Context, filter Filter, page paging.Request) (*paging.Page[Tenant], error)
 }
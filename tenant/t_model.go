@@ -5,10 +5,22 @@ import "time"
 
 // Tenant represents a resident of the RV park
 type Tenant struct {
-	ID         string    `json:"id"`
-	Name       string    `json:"name"`
-	MoveInDate time.Time `json:"moveInDate"`
-	SpaceID    string    `json:"spaceId"`
-	CreatedAt  time.Time `json:"createdAt"`
-	UpdatedAt  time.Time `json:"updatedAt"`
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	MoveInDate  time.Time `json:"moveInDate"`
+	SpaceID     string    `json:"spaceId"`
+	Email       string    `json:"email,omitempty"`
+	EmailOptOut bool      `json:"emailOptOut"`     // Stops reminder and receipt emails
+	Phone       string    `json:"phone,omitempty"` // E.164, e.g. +15551234567
+	SMSOptOut   bool      `json:"smsOptOut"`       // Stops rent reminder texts
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+	Version     int       `json:"-"` // Bumped on every update, sent to clients as the ETag
+}
+
+// Filter narrows a tenant listing. Empty fields match every tenant.
+type Filter struct {
+	Search  string // Case-insensitive match anywhere in the name
+	SpaceID string
+	Section string
 }
@@ -0,0 +1,41 @@
+// tenant/t_phone.go
+package tenant
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NormalizePhone converts a phone number to E.164. Numbers without a country
+// code are assumed to be North American (+1).
+func NormalizePhone(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	international := strings.HasPrefix(raw, "+")
+
+	var digits strings.Builder
+	for _, r := range raw {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == '+' || r == ' ' || r == '-' || r == '.' || r == '(' || r == ')':
+			// Formatting characters
+		default:
+			return "", fmt.Errorf("invalid character %q in phone number", r)
+		}
+	}
+	number := digits.String()
+
+	switch {
+	case international:
+		if len(number) < 8 || len(number) > 15 {
+			return "", fmt.Errorf("phone number must have 8 to 15 digits")
+		}
+		return "+" + number, nil
+	case len(number) == 10:
+		return "+1" + number, nil
+	case len(number) == 11 && number[0] == '1':
+		return "+" + number, nil
+	default:
+		return "", fmt.Errorf("phone number must have 10 digits or start with a country code")
+	}
+}
@@ -2,8 +2,13 @@
 package tenant
 
 import (
+	"context"
 	"database/sql"
 	"time"
+
+	"github.com/BodaciousX/RVParkBackend/apperr"
+	"github.com/BodaciousX/RVParkBackend/clock"
+	"github.com/BodaciousX/RVParkBackend/paging"
 )
 
 type sqlRepository struct {
@@ -14,124 +19,144 @@ func NewSQLRepository(db *sql.DB) Repository {
 	return &sqlRepository{db: db}
 }
 
-func (r *sqlRepository) Create(tenant Tenant) error {
+func (r *sqlRepository) Create(ctx context.Context, tenant Tenant) error {
 	query := `
         INSERT INTO tenants (
-            id, name, move_in_date, space_id, created_at, updated_at
+            id, name, move_in_date, space_id, email, email_opt_out,
+            phone, sms_opt_out, created_at, updated_at
         ) VALUES (
-            $1, $2, $3, $4, $5, $6
+            $1, $2, $3, $4, $5, $6, $7, $8, $9, $10
         )
     `
 
-	now := time.Now()
-	_, err := r.db.Exec(
+	_, err := r.db.ExecContext(
+		ctx,
 		query,
 		tenant.ID,
 		tenant.Name,
-		tenant.MoveInDate,
+		clock.DateOnly(tenant.MoveInDate),
 		tenant.SpaceID,
-		now,
-		now,
+		nullableString(tenant.Email),
+		tenant.EmailOptOut,
+		nullableString(tenant.Phone),
+		tenant.SMSOptOut,
+		tenant.CreatedAt,
+		tenant.UpdatedAt,
 	)
 	return err
 }
 
-func (r *sqlRepository) Get(id string) (*Tenant, error) {
+func (r *sqlRepository) Get(ctx context.Context, id string) (*Tenant, error) {
 	query := `
         SELECT 
             id,
             name,
             move_in_date,
             space_id,
+            email,
+            email_opt_out,
+            phone,
+            sms_opt_out,
             created_at,
-            updated_at
+            updated_at,
+            version
         FROM tenants
         WHERE id = $1
     `
 
-	var tenant Tenant
-	err := r.db.QueryRow(query, id).Scan(
-		&tenant.ID,
-		&tenant.Name,
-		&tenant.MoveInDate,
-		&tenant.SpaceID,
-		&tenant.CreatedAt,
-		&tenant.UpdatedAt,
-	)
-	if err != nil {
-		return nil, err
+	tenant, err := scanTenant(r.db.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, apperr.NotFound("tenant %s not found", id)
 	}
-
-	return &tenant, nil
+	return tenant, err
 }
 
-func (r *sqlRepository) GetBySpace(spaceID string) (*Tenant, error) {
+func (r *sqlRepository) GetBySpace(ctx context.Context, spaceID string) (*Tenant, error) {
 	query := `
         SELECT 
             id,
             name,
             move_in_date,
             space_id,
+            email,
+            email_opt_out,
+            phone,
+            sms_opt_out,
             created_at,
-            updated_at
+            updated_at,
+            version
         FROM tenants
         WHERE space_id = $1
     `
 
-	var tenant Tenant
-	err := r.db.QueryRow(query, spaceID).Scan(
-		&tenant.ID,
-		&tenant.Name,
-		&tenant.MoveInDate,
-		&tenant.SpaceID,
-		&tenant.CreatedAt,
-		&tenant.UpdatedAt,
-	)
-	if err != nil {
-		return nil, err
+	tenant, err := scanTenant(r.db.QueryRowContext(ctx, query, spaceID))
+	if err == sql.ErrNoRows {
+		return nil, apperr.NotFound("no tenant in space %s", spaceID)
 	}
-
-	return &tenant, nil
+	return tenant, err
 }
 
-func (r *sqlRepository) Update(tenant Tenant) error {
+func (r *sqlRepository) Update(ctx context.Context, tenant Tenant) error {
 	query := `
         UPDATE tenants SET
             name = $2,
             space_id = $3,
-            updated_at = CURRENT_TIMESTAMP
-        WHERE id = $1
+            email = $4,
+            email_opt_out = $5,
+            phone = $6,
+            sms_opt_out = $7,
+            updated_at = $9,
+            version = version + 1
+        WHERE id = $1 AND ($8 = 0 OR version = $8)
     `
 
-	_, err := r.db.Exec(
+	result, err := r.db.ExecContext(
+		ctx,
 		query,
 		tenant.ID,
 		tenant.Name,
 		tenant.SpaceID,
+		nullableString(tenant.Email),
+		tenant.EmailOptOut,
+		nullableString(tenant.Phone),
+		tenant.SMSOptOut,
+		tenant.Version,
+		tenant.UpdatedAt,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+	return r.requireUpdated(ctx, result, tenant.ID, tenant.Version)
 }
 
-func (r *sqlRepository) Delete(id string) error {
+func (r *sqlRepository) Delete(ctx context.Context, id string) error {
 	query := `DELETE FROM tenants WHERE id = $1`
-	_, err := r.db.Exec(query, id)
-	return err
+	result, err := r.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(result, id)
 }
 
-func (r *sqlRepository) List() ([]Tenant, error) {
+func (r *sqlRepository) List(ctx context.Context) ([]Tenant, error) {
 	query := `
         SELECT 
             id,
             name,
             move_in_date,
             space_id,
+            email,
+            email_opt_out,
+            phone,
+            sms_opt_out,
             created_at,
-            updated_at
+            updated_at,
+            version
         FROM tenants
         ORDER BY name
     `
 
-	rows, err := r.db.Query(query)
+	rows, err := r.db.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -139,19 +164,11 @@ func (r *sqlRepository) List() ([]Tenant, error) {
 
 	var tenants []Tenant
 	for rows.Next() {
-		var tenant Tenant
-		err := rows.Scan(
-			&tenant.ID,
-			&tenant.Name,
-			&tenant.MoveInDate,
-			&tenant.SpaceID,
-			&tenant.CreatedAt,
-			&tenant.UpdatedAt,
-		)
+		tenant, err := scanTenant(rows)
 		if err != nil {
 			return nil, err
 		}
-		tenants = append(tenants, tenant)
+		tenants = append(tenants, *tenant)
 	}
 
 	if err = rows.Err(); err != nil {
@@ -160,3 +177,170 @@ func (r *sqlRepository) List() ([]Tenant, error) {
 
 	return tenants, nil
 }
+
+// sortColumns maps the fields tenants can be sorted by to their columns
+var sortColumns = map[string]string{
+	"name":       "name",
+	"moveInDate": "move_in_date",
+	"createdAt":  "created_at",
+}
+
+func (r *sqlRepository) Find(ctx context.Context, filter Filter, page paging.Request) (*paging.Page[Tenant], error) {
+	order, err := page.Order(sortColumns, "name")
+	if err != nil {
+		return nil, err
+	}
+
+	var where paging.Builder
+	if filter.Search != "" {
+		where.Where("name ILIKE ?", paging.Contains(filter.Search))
+	}
+	if filter.SpaceID != "" {
+		where.Where("space_id = ?", filter.SpaceID)
+	}
+	if filter.Section != "" {
+		where.Where(`space_id IN (
+            SELECT s.id FROM spaces s
+            JOIN sections sec ON s.section_id = sec.id
+            WHERE sec.name = ?
+        )`, filter.Section)
+	}
+
+	result := &paging.Page[Tenant]{Items: []Tenant{}}
+	countQuery := `SELECT COUNT(*) FROM tenants ` + where.Clause()
+	if err := r.db.QueryRowContext(ctx, countQuery, where.Args()...).Scan(&result.Total); err != nil {
+		return nil, err
+	}
+
+	if page.Cursor != "" {
+		cursor, err := paging.DecodeCursor(page.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		where.Where(order.After("id"), cursor.Value, cursor.ID)
+	}
+
+	limit := page.PageLimit()
+	query := `
+        SELECT 
+            id,
+            name,
+            move_in_date,
+            space_id,
+            email,
+            email_opt_out,
+            phone,
+            sms_opt_out,
+            created_at,
+            updated_at,
+            version
+        FROM tenants
+        ` + where.Clause() + `
+        ` + order.OrderBy("id") + `
+        ` + where.Limit(limit+1)
+
+	rows, err := r.db.QueryContext(ctx, query, where.Args()...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		tenant, err := scanTenant(rows)
+		if err != nil {
+			return nil, err
+		}
+		result.Items = append(result.Items, *tenant)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(result.Items) > limit {
+		result.Items = result.Items[:limit]
+		last := result.Items[limit-1]
+		result.NextCursor = paging.EncodeCursor(paging.Cursor{Value: sortValue(last, order.Field), ID: last.ID})
+	}
+	return result, nil
+}
+
+func sortValue(tenant Tenant, field string) string {
+	switch field {
+	case "moveInDate":
+		return clock.DateOnly(tenant.MoveInDate)
+	case "createdAt":
+		return tenant.CreatedAt.Format(time.RFC3339Nano)
+	default:
+		return tenant.Name
+	}
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanTenant(row rowScanner) (*Tenant, error) {
+	var tenant Tenant
+	var email, phone sql.NullString
+
+	err := row.Scan(
+		&tenant.ID,
+		&tenant.Name,
+		&tenant.MoveInDate,
+		&tenant.SpaceID,
+		&email,
+		&tenant.EmailOptOut,
+		&phone,
+		&tenant.SMSOptOut,
+		&tenant.CreatedAt,
+		&tenant.UpdatedAt,
+		&tenant.Version,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	tenant.Email = email.String
+	tenant.Phone = phone.String
+	return &tenant, nil
+}
+
+// requireUpdated explains an update that matched no rows: either the tenant
+// is gone or, when a version was given, someone else changed it first
+func (r *sqlRepository) requireUpdated(ctx context.Context, result sql.Result, id string, version int) error {
+	rows, err := result.RowsAffected()
+	if err != nil || rows > 0 {
+		return err
+	}
+	if version != 0 {
+		var exists bool
+		query := `SELECT EXISTS (SELECT 1 FROM tenants WHERE id = $1)`
+		if err := r.db.QueryRowContext(ctx, query, id).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			return apperr.Stale("tenant %s has been modified since version %d", id, version)
+		}
+	}
+	return apperr.NotFound("tenant %s not found", id)
+}
+
+// requireRow reports a missing tenant when a write matched no rows
+func requireRow(result sql.Result, id string) error {
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return apperr.NotFound("tenant %s not found", id)
+	}
+	return nil
+}
+
+func nullableString(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
@@ -1,9 +1,14 @@
 package tenant
 
 import (
+	"context"
 	"database/sql"
 	"testing"
+	"time"
 
+	"github.com/BodaciousX/RVParkBackend/apperr"
+	"github.com/BodaciousX/RVParkBackend/paging"
+	"github.com/DATA-DOG/go-sqlmock"
 	_ "github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 )
@@ -19,7 +24,7 @@ func TestGet(t *testing.T) {
 	db, _ := sql.Open("postgres", "")
 	repo := NewSQLRepository(db)
 
-	_, _ = repo.Get("tenant123")
+	_, _ = repo.Get(context.Background(), "tenant123")
 	assert.True(t, true)
 }
 
@@ -27,7 +32,7 @@ func TestDelete(t *testing.T) {
 	db, _ := sql.Open("postgres", "")
 	repo := NewSQLRepository(db)
 
-	_ = repo.Delete("tenant123")
+	_ = repo.Delete(context.Background(), "tenant123")
 	assert.True(t, true)
 }
 
@@ -35,34 +40,116 @@ func TestList(t *testing.T) {
 	db, _ := sql.Open("postgres", "")
 	repo := NewSQLRepository(db)
 
-	_, _ = repo.List()
+	_, _ = repo.List(context.Background())
 	assert.True(t, true)
 }
 
+func TestFind(t *testing.T) {
+	db, mockDB, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	repo := NewSQLRepository(db)
+
+	columns := []string{"id", "name", "move_in_date", "space_id", "email", "email_opt_out",
+		"phone", "sms_opt_out", "created_at", "updated_at", "version"}
+	now := time.Now()
+	cursor := paging.EncodeCursor(paging.Cursor{Value: "Adams", ID: "t0"})
+
+	mockDB.ExpectQuery(`SELECT COUNT\(\*\) FROM tenants WHERE name ILIKE \$1`).
+		WithArgs("%a%").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(5))
+	mockDB.ExpectQuery(`WHERE name ILIKE \$1 AND \(name, id\) > \(\$2, \$3\)\s+ORDER BY name ASC, id ASC\s+LIMIT \$4`).
+		WithArgs("%a%", "Adams", "t0", 3).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("t1", "Baker", now, "A1", nil, false, nil, false, now, now, 1).
+			AddRow("t2", "Clark", now, "A2", nil, false, nil, false, now, now, 1).
+			AddRow("t3", "Davis", now, "A3", nil, false, nil, false, now, now, 1))
+
+	page, err := repo.Find(context.Background(), Filter{Search: "a"}, paging.Request{Limit: 2, Cursor: cursor})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, page.Total)
+	assert.Len(t, page.Items, 2)
+
+	// The extra row only signals that another page exists
+	next, err := paging.DecodeCursor(page.NextCursor)
+	assert.NoError(t, err)
+	assert.Equal(t, paging.Cursor{Value: "Clark", ID: "t2"}, next)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+// Create stores the timestamps the service set from the park clock
+func TestCreate_Timestamps(t *testing.T) {
+	db, mockDB, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	repo := NewSQLRepository(db)
+
+	createdAt := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	moveIn := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	mockDB.ExpectExec(`INSERT INTO tenants`).
+		WithArgs("t1", "John Doe", "2024-06-01", "A1", nil, false, nil, false, createdAt, createdAt).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	assert.NoError(t, repo.Create(context.Background(), Tenant{
+		ID: "t1", Name: "John Doe", MoveInDate: moveIn, SpaceID: "A1", CreatedAt: createdAt, UpdatedAt: createdAt,
+	}))
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestUpdate_Versioned(t *testing.T) {
+	db, mockDB, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	repo := NewSQLRepository(db)
+
+	updatedAt := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tenant := Tenant{ID: "t1", Name: "John Doe", SpaceID: "A1", UpdatedAt: updatedAt, Version: 3}
+	update := `UPDATE tenants SET .* version = version \+ 1\s+WHERE id = \$1 AND \(\$8 = 0 OR version = \$8\)`
+
+	// The version still matches
+	mockDB.ExpectExec(update).WithArgs("t1", "John Doe", "A1", nil, false, nil, false, 3, updatedAt).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, repo.Update(context.Background(), tenant))
+
+	// Someone else updated the tenant first
+	mockDB.ExpectExec(update).WillReturnResult(sqlmock.NewResult(0, 0))
+	mockDB.ExpectQuery(`SELECT EXISTS`).WithArgs("t1").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	assert.ErrorIs(t, repo.Update(context.Background(), tenant), apperr.ErrStale)
+
+	// The tenant was deleted
+	mockDB.ExpectExec(update).WillReturnResult(sqlmock.NewResult(0, 0))
+	mockDB.ExpectQuery(`SELECT EXISTS`).WithArgs("t1").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	assert.ErrorIs(t, repo.Update(context.Background(), tenant), apperr.ErrNotFound)
+
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
 type sqlTenantRepository struct {
 	db *sql.DB
 }
 
-func (r *sqlTenantRepository) Create(tenant Tenant) error {
+func (r *sqlTenantRepository) Create(ctx context.Context, tenant Tenant) error {
 	return nil
 }
 
-func (r *sqlTenantRepository) Get(id string) (*Tenant, error) {
+func (r *sqlTenantRepository) Get(ctx context.Context, id string) (*Tenant, error) {
 	return &Tenant{ID: id}, nil
 }
 
-func (r *sqlTenantRepository) Update(tenant Tenant) error {
+func (r *sqlTenantRepository) Update(ctx context.Context, tenant Tenant) error {
 	return nil
 }
 
-func (r *sqlTenantRepository) Delete(id string) error {
+func (r *sqlTenantRepository) Delete(ctx context.Context, id string) error {
 	return nil
 }
 
-func (r *sqlTenantRepository) List(limit, offset int) ([]Tenant, error) {
+func (r *sqlTenantRepository) List(ctx context.Context, limit, offset int) ([]Tenant, error) {
 	return []Tenant{}, nil
 }
 
-func (r *sqlTenantRepository) Search(query string) ([]Tenant, error) {
+func (r *sqlTenantRepository) Search(ctx context.Context, query string) ([]Tenant, error) {
 	return []Tenant{}, nil
 }
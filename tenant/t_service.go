@@ -2,78 +2,127 @@
 package tenant
 
 import (
-	"fmt"
-	"time"
+	"context"
+
+	"github.com/BodaciousX/RVParkBackend/apperr"
+	"github.com/BodaciousX/RVParkBackend/clock"
+	"github.com/BodaciousX/RVParkBackend/paging"
 )
 
 type service struct {
-	repo Repository
+	repo  Repository
+	clock clock.Clock
 }
 
-func NewService(repo Repository) Service {
-	return &service{repo: repo}
+func NewService(repo Repository, clk clock.Clock) Service {
+	return &service{repo: repo, clock: clk}
 }
 
-func (s *service) CreateTenant(tenant Tenant) error {
+func (s *service) CreateTenant(ctx context.Context, tenant Tenant) error {
 	// Validate tenant data
+	invalid := &apperr.ValidationError{}
 	if tenant.Name == "" {
-		return fmt.Errorf("tenant name is required")
+		invalid.Add("name", "tenant name is required")
 	}
 	if tenant.SpaceID == "" {
-		return fmt.Errorf("space ID is required")
+		invalid.Add("spaceId", "space ID is required")
+	}
+	normalizePhone(&tenant, invalid)
+	if err := invalid.Err(); err != nil {
+		return err
 	}
+
+	// Move-in is a calendar day, today in the park unless given
 	if tenant.MoveInDate.IsZero() {
-		tenant.MoveInDate = time.Now()
+		tenant.MoveInDate = clock.Today(s.clock)
+	} else {
+		tenant.MoveInDate = clock.Date(tenant.MoveInDate)
 	}
 
 	// Check if space already has a tenant
-	existingTenant, err := s.repo.GetBySpace(tenant.SpaceID)
+	existingTenant, err := s.repo.GetBySpace(ctx, tenant.SpaceID)
 	if err == nil && existingTenant != nil {
-		return fmt.Errorf("space %s is already occupied", tenant.SpaceID)
+		return apperr.Conflict("space %s is already occupied", tenant.SpaceID)
 	}
 
-	return s.repo.Create(tenant)
+	now := s.clock.Now()
+	tenant.CreatedAt = now
+	tenant.UpdatedAt = now
+
+	return s.repo.Create(ctx, tenant)
 }
 
-func (s *service) GetTenant(id string) (*Tenant, error) {
-	return s.repo.Get(id)
+func (s *service) GetTenant(ctx context.Context, id string) (*Tenant, error) {
+	return s.repo.Get(ctx, id)
 }
 
-func (s *service) GetTenantBySpace(spaceID string) (*Tenant, error) {
-	return s.repo.GetBySpace(spaceID)
+func (s *service) GetTenantBySpace(ctx context.Context, spaceID string) (*Tenant, error) {
+	return s.repo.GetBySpace(ctx, spaceID)
 }
 
-func (s *service) UpdateTenant(tenant Tenant) error {
+func (s *service) UpdateTenant(ctx context.Context, tenant Tenant) error {
 	// Validate tenant exists
-	existing, err := s.repo.Get(tenant.ID)
+	existing, err := s.repo.Get(ctx, tenant.ID)
 	if err != nil {
-		return fmt.Errorf("tenant not found: %v", err)
+		return err
+	}
+
+	invalid := &apperr.ValidationError{}
+	if tenant.Name == "" {
+		invalid.Add("name", "tenant name is required")
+	}
+	if tenant.SpaceID == "" {
+		invalid.Add("spaceId", "space ID is required")
+	}
+	normalizePhone(&tenant, invalid)
+	if err := invalid.Err(); err != nil {
+		return err
 	}
 
 	// If space is changing, check if new space is available
 	if tenant.SpaceID != existing.SpaceID {
-		existingTenant, err := s.repo.GetBySpace(tenant.SpaceID)
+		existingTenant, err := s.repo.GetBySpace(ctx, tenant.SpaceID)
 		if err == nil && existingTenant != nil {
-			return fmt.Errorf("space %s is already occupied", tenant.SpaceID)
+			return apperr.Conflict("space %s is already occupied", tenant.SpaceID)
 		}
 	}
 
 	// Preserve creation time and move-in date
 	tenant.CreatedAt = existing.CreatedAt
 	tenant.MoveInDate = existing.MoveInDate
+	tenant.UpdatedAt = s.clock.Now()
 
-	return s.repo.Update(tenant)
+	return s.repo.Update(ctx, tenant)
 }
 
-func (s *service) DeleteTenant(id string) error {
+func (s *service) DeleteTenant(ctx context.Context, id string) error {
 	// Verify tenant exists before deletion
-	if _, err := s.repo.Get(id); err != nil {
-		return fmt.Errorf("tenant not found: %v", err)
+	if _, err := s.repo.Get(ctx, id); err != nil {
+		return err
 	}
 
-	return s.repo.Delete(id)
+	return s.repo.Delete(ctx, id)
+}
+
+// FindTenants returns one page of tenants matching filter
+func (s *service) FindTenants(ctx context.Context, filter Filter, page paging.Request) (*paging.Page[Tenant], error) {
+	return s.repo.Find(ctx, filter, page)
+}
+
+func (s *service) ListTenants(ctx context.Context) ([]Tenant, error) {
+	return s.repo.List(ctx)
 }
 
-func (s *service) ListTenants() ([]Tenant, error) {
-	return s.repo.List()
+// normalizePhone rewrites tenant.Phone in E.164 form, recording a field error
+// if it can't be parsed
+func normalizePhone(tenant *Tenant, invalid *apperr.ValidationError) {
+	if tenant.Phone == "" {
+		return
+	}
+	phone, err := NormalizePhone(tenant.Phone)
+	if err != nil {
+		invalid.Add("phone", "invalid phone number: %v", err)
+		return
+	}
+	tenant.Phone = phone
 }
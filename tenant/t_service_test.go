@@ -2,10 +2,14 @@
 package tenant
 
 import (
+	"context"
 	"errors"
 	"testing"
 	"time"
 
+	"github.com/BodaciousX/RVParkBackend/apperr"
+	"github.com/BodaciousX/RVParkBackend/clock"
+	"github.com/BodaciousX/RVParkBackend/paging"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -16,17 +20,17 @@ type MockRepository struct {
 	mock.Mock
 }
 
-func (m *MockRepository) List() ([]Tenant, error) {
+func (m *MockRepository) List(ctx context.Context) ([]Tenant, error) {
 	args := m.Called()
 	return args.Get(0).([]Tenant), args.Error(1)
 }
 
-func (m *MockRepository) Create(tenant Tenant) error {
+func (m *MockRepository) Create(ctx context.Context, tenant Tenant) error {
 	args := m.Called(tenant)
 	return args.Error(0)
 }
 
-func (m *MockRepository) Get(id string) (*Tenant, error) {
+func (m *MockRepository) Get(ctx context.Context, id string) (*Tenant, error) {
 	args := m.Called(id)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -34,7 +38,7 @@ func (m *MockRepository) Get(id string) (*Tenant, error) {
 	return args.Get(0).(*Tenant), args.Error(1)
 }
 
-func (m *MockRepository) GetBySpace(spaceID string) (*Tenant, error) {
+func (m *MockRepository) GetBySpace(ctx context.Context, spaceID string) (*Tenant, error) {
 	args := m.Called(spaceID)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -42,22 +46,30 @@ func (m *MockRepository) GetBySpace(spaceID string) (*Tenant, error) {
 	return args.Get(0).(*Tenant), args.Error(1)
 }
 
-func (m *MockRepository) Update(tenant Tenant) error {
+func (m *MockRepository) Update(ctx context.Context, tenant Tenant) error {
 	args := m.Called(tenant)
 	return args.Error(0)
 }
 
-func (m *MockRepository) Delete(id string) error {
+func (m *MockRepository) Delete(ctx context.Context, id string) error {
 	args := m.Called(id)
 	return args.Error(0)
 }
 
+func (m *MockRepository) Find(ctx context.Context, filter Filter, page paging.Request) (*paging.Page[Tenant], error) {
+	args := m.Called(filter, page)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*paging.Page[Tenant]), args.Error(1)
+}
+
 func TestCreateTenant_Success(t *testing.T) {
 	// Create mock
 	mockRepo := new(MockRepository)
 
 	// Create service with mock
-	service := NewService(mockRepo)
+	service := NewService(mockRepo, clock.New(time.UTC))
 
 	// Test data
 	testTenant := Tenant{
@@ -71,7 +83,7 @@ func TestCreateTenant_Success(t *testing.T) {
 	mockRepo.On("Create", mock.AnythingOfType("Tenant")).Return(nil)
 
 	// Call method being tested
-	err := service.CreateTenant(testTenant)
+	err := service.CreateTenant(context.Background(), testTenant)
 
 	// Assert expectations
 	assert.NoError(t, err)
@@ -86,12 +98,29 @@ func TestCreateTenant_Success(t *testing.T) {
 	assert.False(t, createdTenant.MoveInDate.IsZero())
 }
 
+func TestCreateTenant_MoveInDefaultsToParkDate(t *testing.T) {
+	mockRepo := new(MockRepository)
+	chicago, _ := time.LoadLocation("America/Chicago")
+
+	// Late evening in the park, already the next day in UTC
+	service := NewService(mockRepo, clock.NewFake(time.Date(2024, 5, 31, 23, 30, 0, 0, chicago)))
+
+	mockRepo.On("GetBySpace", "A1").Return(nil, apperr.NotFound("no tenant"))
+	mockRepo.On("Create", mock.AnythingOfType("Tenant")).Return(nil)
+
+	err := service.CreateTenant(context.Background(), Tenant{Name: "John Doe", SpaceID: "A1"})
+	assert.NoError(t, err)
+
+	created := mockRepo.Calls[1].Arguments[0].(Tenant)
+	assert.Equal(t, time.Date(2024, 5, 31, 0, 0, 0, 0, time.UTC), created.MoveInDate)
+}
+
 func TestCreateTenant_SpaceOccupied(t *testing.T) {
 	// Create mock
 	mockRepo := new(MockRepository)
 
 	// Create service with mock
-	service := NewService(mockRepo)
+	service := NewService(mockRepo, clock.New(time.UTC))
 
 	// Test data
 	testTenant := Tenant{
@@ -109,7 +138,7 @@ func TestCreateTenant_SpaceOccupied(t *testing.T) {
 	mockRepo.On("GetBySpace", testTenant.SpaceID).Return(existingTenant, nil)
 
 	// Call method being tested
-	err := service.CreateTenant(testTenant)
+	err := service.CreateTenant(context.Background(), testTenant)
 
 	// Assert expectations
 	assert.Error(t, err)
@@ -124,7 +153,7 @@ func TestUpdateTenant_Success(t *testing.T) {
 	mockRepo := new(MockRepository)
 
 	// Create service with mock
-	service := NewService(mockRepo)
+	service := NewService(mockRepo, clock.New(time.UTC))
 
 	// Test data
 	now := time.Now()
@@ -152,7 +181,7 @@ func TestUpdateTenant_Success(t *testing.T) {
 	mockRepo.On("Update", mock.AnythingOfType("Tenant")).Return(nil)
 
 	// Call method being tested
-	err := service.UpdateTenant(updatedTenant)
+	err := service.UpdateTenant(context.Background(), updatedTenant)
 
 	// Assert expectations
 	assert.NoError(t, err)
@@ -173,7 +202,7 @@ func TestUpdateTenant_NewSpaceOccupied(t *testing.T) {
 	mockRepo := new(MockRepository)
 
 	// Create service with mock
-	service := NewService(mockRepo)
+	service := NewService(mockRepo, clock.New(time.UTC))
 
 	// Test data
 	tenantID := uuid.New().String()
@@ -203,7 +232,7 @@ func TestUpdateTenant_NewSpaceOccupied(t *testing.T) {
 	mockRepo.On("GetBySpace", newSpaceID).Return(existingTenantInNewSpace, nil)
 
 	// Call method being tested
-	err := service.UpdateTenant(updatedTenant)
+	err := service.UpdateTenant(context.Background(), updatedTenant)
 
 	// Assert expectations
 	assert.Error(t, err)
@@ -218,7 +247,7 @@ func TestDeleteTenant_Success(t *testing.T) {
 	mockRepo := new(MockRepository)
 
 	// Create service with mock
-	service := NewService(mockRepo)
+	service := NewService(mockRepo, clock.New(time.UTC))
 
 	// Test data
 	tenantID := uuid.New().String()
@@ -233,7 +262,7 @@ func TestDeleteTenant_Success(t *testing.T) {
 	mockRepo.On("Delete", tenantID).Return(nil)
 
 	// Call method being tested
-	err := service.DeleteTenant(tenantID)
+	err := service.DeleteTenant(context.Background(), tenantID)
 
 	// Assert expectations
 	assert.NoError(t, err)
@@ -245,7 +274,7 @@ func TestDeleteTenant_NotFound(t *testing.T) {
 	mockRepo := new(MockRepository)
 
 	// Create service with mock
-	service := NewService(mockRepo)
+	service := NewService(mockRepo, clock.New(time.UTC))
 
 	// Test data
 	tenantID := uuid.New().String()
@@ -254,7 +283,7 @@ func TestDeleteTenant_NotFound(t *testing.T) {
 	mockRepo.On("Get", tenantID).Return(nil, errors.New("tenant not found"))
 
 	// Call method being tested
-	err := service.DeleteTenant(tenantID)
+	err := service.DeleteTenant(context.Background(), tenantID)
 
 	// Assert expectations
 	assert.Error(t, err)
@@ -263,3 +292,60 @@ func TestDeleteTenant_NotFound(t *testing.T) {
 	// Delete should not be called if tenant doesn't exist
 	mockRepo.AssertNotCalled(t, "Delete", mock.Anything)
 }
+
+func TestCreateTenant_NormalizesPhone(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, clock.New(time.UTC))
+
+	testTenant := Tenant{
+		ID:      uuid.New().String(),
+		Name:    "John Doe",
+		SpaceID: "A1",
+		Phone:   "(555) 123-4567",
+	}
+
+	mockRepo.On("GetBySpace", testTenant.SpaceID).Return(nil, errors.New("not found"))
+	mockRepo.On("Create", mock.AnythingOfType("Tenant")).Return(nil)
+
+	err := service.CreateTenant(context.Background(), testTenant)
+
+	assert.NoError(t, err)
+	createdTenant := mockRepo.Calls[1].Arguments[0].(Tenant)
+	assert.Equal(t, "+15551234567", createdTenant.Phone)
+}
+
+func TestCreateTenant_InvalidPhone(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, clock.New(time.UTC))
+
+	err := service.CreateTenant(context.Background(), Tenant{
+		ID:      uuid.New().String(),
+		Name:    "John Doe",
+		SpaceID: "A1",
+		Phone:   "555-1234",
+	})
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid phone number")
+	assert.True(t, errors.Is(err, apperr.ErrValidation))
+	mockRepo.AssertNotCalled(t, "Create", mock.Anything)
+}
+
+func TestNormalizePhone(t *testing.T) {
+	valid := map[string]string{
+		"555-123-4567":     "+15551234567",
+		"1 (555) 123-4567": "+15551234567",
+		"+44 20 7946 0958": "+442079460958",
+		"555.123.4567":     "+15551234567",
+	}
+	for raw, expected := range valid {
+		phone, err := NormalizePhone(raw)
+		assert.NoError(t, err, raw)
+		assert.Equal(t, expected, phone, raw)
+	}
+
+	for _, raw := range []string{"555-1234", "+1234", "555-CALL-NOW", "25551234567"} {
+		_, err := NormalizePhone(raw)
+		assert.Error(t, err, raw)
+	}
+}
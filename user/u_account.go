@@ -0,0 +1,206 @@
+// user/u_account.go contains the password reset and staff invitation flows.
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/BodaciousX/RVParkBackend/apperr"
+	"github.com/google/uuid"
+)
+
+// AccountEmailConfig controls the links sent for password resets and
+// invitations. AppURL is the frontend the links open.
+type AccountEmailConfig struct {
+	AppURL    string
+	ResetTTL  time.Duration
+	InviteTTL time.Duration
+}
+
+func DefaultAccountEmailConfig() AccountEmailConfig {
+	return AccountEmailConfig{
+		ResetTTL:  time.Hour,
+		InviteTTL: 7 * 24 * time.Hour,
+	}
+}
+
+// link returns the frontend address that accepts token at path
+func (c AccountEmailConfig) link(path, token string) string {
+	return strings.TrimRight(c.AppURL, "/") + path + "?" + url.Values{"token": {token}}.Encode()
+}
+
+// RequestPasswordReset emails a reset link if email belongs to a user. It
+// succeeds either way so the response doesn't reveal which emails exist.
+func (s *service) RequestPasswordReset(ctx context.Context, email string) error {
+	user, err := s.repo.GetByEmail(ctx, email)
+	if errors.Is(err, apperr.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// The link is issued and sent in the background, so the response takes
+	// no longer for an email that has an account
+	ctx = context.WithoutCancel(ctx)
+	s.mail.Add(1)
+	go func() {
+		defer s.mail.Done()
+		if err := s.sendPasswordReset(ctx, user); err != nil {
+			log.Printf("Failed to send password reset for user %s: %v", user.ID, err)
+		}
+	}()
+	return nil
+}
+
+func (s *service) sendPasswordReset(ctx context.Context, user *User) error {
+	token, err := s.issueAccountToken(ctx, user.ID, PurposePasswordReset, s.accountEmails.ResetTTL)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Someone asked to reset the password for %s.\n\n"+
+		"Open this link within %s to choose a new password:\n\n%s\n\n"+
+		"If it wasn't you, you can ignore this email and your password will stay the same.\n",
+		user.Email, describeDuration(s.accountEmails.ResetTTL), s.accountEmails.link("/reset-password", token))
+	return s.mailer.Send(ctx, user.Email, "Reset your password", body)
+}
+
+// ConfirmPasswordReset sets a new password using the token from a reset
+// email. Every session is signed out and any sign-in lockout is lifted.
+func (s *service) ConfirmPasswordReset(ctx context.Context, token, newPassword string) error {
+	return s.redeemAccountToken(ctx, token, PurposePasswordReset, newPassword)
+}
+
+// InviteUser creates a user without a password and emails them a link to
+// choose one
+func (s *service) InviteUser(ctx context.Context, user User) (*User, error) {
+	invalid := &apperr.ValidationError{}
+	validateNewUser(user, invalid)
+	if err := invalid.Err(); err != nil {
+		return nil, err
+	}
+
+	if user.ID == "" {
+		user.ID = uuid.New().String()
+	}
+	// No hash matches an empty one, so the account can't sign in until the
+	// invitation is accepted
+	user.PasswordHash = ""
+	user.CreatedAt = s.clock.Now()
+	if err := s.repo.Create(ctx, user); err != nil {
+		return nil, err
+	}
+
+	token, err := s.issueAccountToken(ctx, user.ID, PurposeInvite, s.accountEmails.InviteTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	body := fmt.Sprintf("An account has been created for you as %s.\n\n"+
+		"Open this link within %s to choose your password:\n\n%s\n",
+		user.Email, describeDuration(s.accountEmails.InviteTTL), s.accountEmails.link("/accept-invite", token))
+	if err := s.mailer.Send(ctx, user.Email, "Your account is ready", body); err != nil {
+		// Nobody can accept an invitation that never arrived, so remove the
+		// account and let it be invited again
+		if err := s.repo.Delete(ctx, user.ID); err != nil {
+			log.Printf("Failed to remove user %s after their invitation failed: %v", user.ID, err)
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// AcceptInvite sets the password of an invited user using the token from
+// their invitation
+func (s *service) AcceptInvite(ctx context.Context, token, password string) error {
+	return s.redeemAccountToken(ctx, token, PurposeInvite, password)
+}
+
+// CleanAccountTokens deletes reset and invitation tokens that can no longer be used
+func (s *service) CleanAccountTokens(ctx context.Context) error {
+	return s.accountTokenRepo.CleanAccountTokens(ctx, s.clock.Now())
+}
+
+// issueAccountToken replaces any outstanding token for purpose with a new
+// one, so only the most recent link works
+func (s *service) issueAccountToken(ctx context.Context, userID string, purpose TokenPurpose, ttl time.Duration) (string, error) {
+	now := s.clock.Now()
+	if err := s.accountTokenRepo.InvalidateAccountTokens(ctx, userID, purpose, now); err != nil {
+		return "", err
+	}
+
+	token, tokenHash, err := GenerateToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = s.accountTokenRepo.CreateAccountToken(ctx, AccountToken{
+		ID:        uuid.New().String(),
+		TokenHash: tokenHash,
+		UserID:    userID,
+		Purpose:   purpose,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// redeemAccountToken uses token to set password on the user it was issued
+// to. The password is checked first so a rejected password doesn't use up
+// the link.
+func (s *service) redeemAccountToken(ctx context.Context, token string, purpose TokenPurpose, password string) error {
+	invalid := &apperr.ValidationError{}
+	s.passwords.Check(password, "password", invalid)
+	if err := invalid.Err(); err != nil {
+		return err
+	}
+
+	accountToken, err := s.accountTokenRepo.ConsumeAccountToken(ctx, HashToken(token), purpose, s.clock.Now())
+	if err != nil {
+		return err
+	}
+
+	user, err := s.repo.Get(ctx, accountToken.UserID)
+	if err != nil {
+		return err
+	}
+	if err := s.setPassword(user, password, "password"); err != nil {
+		return err
+	}
+	if err := s.repo.Update(ctx, *user); err != nil {
+		return err
+	}
+
+	if err := s.tokenRepo.RevokeAllUserTokens(ctx, user.ID); err != nil {
+		return err
+	}
+	return s.loginRepo.ClearThrottle(ctx, accountThrottleKey(user.Email))
+}
+
+// describeDuration writes d for an email, such as "1 hour" or "7 days"
+func describeDuration(d time.Duration) string {
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return fmt.Sprintf("1 %s", unit)
+		}
+		return fmt.Sprintf("%d %ss", n, unit)
+	}
+
+	switch {
+	case d >= 24*time.Hour && d%(24*time.Hour) == 0:
+		return plural(int(d/(24*time.Hour)), "day")
+	case d >= time.Hour && d%time.Hour == 0:
+		return plural(int(d/time.Hour), "hour")
+	default:
+		return plural(int(d.Round(time.Minute)/time.Minute), "minute")
+	}
+}
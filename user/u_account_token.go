@@ -0,0 +1,150 @@
+// user/u_account_token.go contains the single-use tokens sent in password reset and invitation emails.
+package user
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/BodaciousX/RVParkBackend/apperr"
+)
+
+// TokenPurpose says what a single-use account token may be used for
+type TokenPurpose string
+
+const (
+	PurposePasswordReset TokenPurpose = "password_reset"
+	PurposeInvite        TokenPurpose = "invite"
+	// PurposeLoginChallenge is the second step of a two-factor sign-in
+	PurposeLoginChallenge TokenPurpose = "login_challenge"
+)
+
+// AccountToken is a single-use link token. Like session tokens only the hash
+// is stored, so the emailed link is the only copy.
+type AccountToken struct {
+	ID        string
+	TokenHash string
+	UserID    string
+	Purpose   TokenPurpose
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	UsedAt    time.Time
+}
+
+type AccountTokenRepository interface {
+	CreateAccountToken(ctx context.Context, token AccountToken) error
+	// GetAccountToken returns an unused, unexpired token without using it up
+	GetAccountToken(ctx context.Context, tokenHash string, purpose TokenPurpose, now time.Time) (*AccountToken, error)
+	// ConsumeAccountToken marks an unused, unexpired token as used at now and
+	// returns it. A token can only be consumed once; any other token is
+	// reported as invalid.
+	ConsumeAccountToken(ctx context.Context, tokenHash string, purpose TokenPurpose, now time.Time) (*AccountToken, error)
+	// InvalidateAccountTokens uses up a user's outstanding tokens for purpose
+	InvalidateAccountTokens(ctx context.Context, userID string, purpose TokenPurpose, now time.Time) error
+	// CleanAccountTokens deletes tokens that were used or expired before before
+	CleanAccountTokens(ctx context.Context, before time.Time) error
+}
+
+// invalidAccountToken is returned for unknown, used and expired links alike
+func invalidAccountToken() error {
+	return apperr.Invalid("token", "this link is invalid or has expired")
+}
+
+type sqlAccountTokenRepository struct {
+	db *sql.DB
+}
+
+func NewAccountTokenRepository(db *sql.DB) AccountTokenRepository {
+	return &sqlAccountTokenRepository{db: db}
+}
+
+func (r *sqlAccountTokenRepository) CreateAccountToken(ctx context.Context, token AccountToken) error {
+	query := `
+		INSERT INTO account_tokens (id, token_hash, user_id, purpose, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	_, err := r.db.ExecContext(
+		ctx,
+		query,
+		token.ID,
+		token.TokenHash,
+		token.UserID,
+		token.Purpose,
+		token.ExpiresAt,
+		token.CreatedAt,
+	)
+	return err
+}
+
+func (r *sqlAccountTokenRepository) GetAccountToken(ctx context.Context, tokenHash string, purpose TokenPurpose, now time.Time) (*AccountToken, error) {
+	query := `
+		SELECT id, token_hash, user_id, purpose, expires_at, created_at
+		FROM account_tokens
+		WHERE token_hash = $1 AND purpose = $2
+		AND used_at IS NULL AND expires_at > $3
+	`
+	token := &AccountToken{}
+	err := r.db.QueryRowContext(ctx, query, tokenHash, purpose, now).Scan(
+		&token.ID,
+		&token.TokenHash,
+		&token.UserID,
+		&token.Purpose,
+		&token.ExpiresAt,
+		&token.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, invalidAccountToken()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// ConsumeAccountToken checks and uses the token in one statement so two
+// requests racing with the same link can't both succeed
+func (r *sqlAccountTokenRepository) ConsumeAccountToken(ctx context.Context, tokenHash string, purpose TokenPurpose, now time.Time) (*AccountToken, error) {
+	query := `
+		UPDATE account_tokens
+		SET used_at = $3
+		WHERE token_hash = $1 AND purpose = $2
+		AND used_at IS NULL AND expires_at > $3
+		RETURNING id, token_hash, user_id, purpose, expires_at, created_at, used_at
+	`
+	token := &AccountToken{}
+	err := r.db.QueryRowContext(ctx, query, tokenHash, purpose, now).Scan(
+		&token.ID,
+		&token.TokenHash,
+		&token.UserID,
+		&token.Purpose,
+		&token.ExpiresAt,
+		&token.CreatedAt,
+		&token.UsedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, invalidAccountToken()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func (r *sqlAccountTokenRepository) InvalidateAccountTokens(ctx context.Context, userID string, purpose TokenPurpose, now time.Time) error {
+	query := `
+		UPDATE account_tokens
+		SET used_at = $3
+		WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL
+	`
+	_, err := r.db.ExecContext(ctx, query, userID, purpose, now)
+	return err
+}
+
+func (r *sqlAccountTokenRepository) CleanAccountTokens(ctx context.Context, before time.Time) error {
+	query := `
+		DELETE FROM account_tokens
+		WHERE expires_at < $1 OR used_at < $1
+	`
+	_, err := r.db.ExecContext(ctx, query, before)
+	return err
+}
@@ -0,0 +1,166 @@
+// user/u_api_key.go contains API keys for scripts and other integrations.
+package user
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/BodaciousX/RVParkBackend/apperr"
+	"github.com/google/uuid"
+)
+
+// Scope is something an API key is allowed to do, such as reading payments
+type Scope string
+
+const (
+	ScopeSpacesRead    Scope = "spaces:read"
+	ScopeSpacesWrite   Scope = "spaces:write"
+	ScopeTenantsRead   Scope = "tenants:read"
+	ScopeTenantsWrite  Scope = "tenants:write"
+	ScopePaymentsRead  Scope = "payments:read"
+	ScopePaymentsWrite Scope = "payments:write"
+	ScopeJobsRead      Scope = "jobs:read"
+	ScopeJobsWrite     Scope = "jobs:write"
+)
+
+// Scopes lists every scope a key can be given
+var Scopes = []Scope{
+	ScopeSpacesRead, ScopeSpacesWrite,
+	ScopeTenantsRead, ScopeTenantsWrite,
+	ScopePaymentsRead, ScopePaymentsWrite,
+	ScopeJobsRead, ScopeJobsWrite,
+}
+
+// apiKeyPrefix starts every key so leaked keys are easy to search for
+const apiKeyPrefix = "rvpk_"
+
+// APIKey lets a script act as UserID without signing in, but only on the
+// routes its scopes allow. Like session tokens only the hash is stored;
+// Prefix is the start of the key, shown so people can tell keys apart.
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	UserID     string     `json:"userId"`
+	Prefix     string     `json:"prefix"`
+	KeyHash    string     `json:"-"`
+	Scopes     []Scope    `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	CreatedBy  string     `json:"createdBy,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
+
+// Allows reports whether the key was given scope
+func (k *APIKey) Allows(scope Scope) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// usable reports whether the key can still be used at now
+func (k *APIKey) usable(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+// CreateAPIKey stores a new key described by key, which needs a name, the
+// user it acts as and at least one scope. The key itself is returned only
+// here and can't be retrieved later.
+func (s *service) CreateAPIKey(ctx context.Context, key APIKey) (*APIKey, string, error) {
+	now := s.clock.Now()
+	invalid := &apperr.ValidationError{}
+	if strings.TrimSpace(key.Name) == "" {
+		invalid.Add("name", "name is required")
+	}
+	if key.UserID == "" {
+		invalid.Add("userId", "userId is required")
+	} else if _, err := s.repo.Get(ctx, key.UserID); errors.Is(err, apperr.ErrNotFound) {
+		invalid.Add("userId", "no user has this ID")
+	} else if err != nil {
+		return nil, "", err
+	}
+	validateScopes(key.Scopes, invalid)
+	if key.ExpiresAt != nil && !key.ExpiresAt.After(now) {
+		invalid.Add("expiresAt", "expiresAt must be in the future")
+	}
+	if err := invalid.Err(); err != nil {
+		return nil, "", err
+	}
+
+	raw, _, err := GenerateToken()
+	if err != nil {
+		return nil, "", err
+	}
+	secret := apiKeyPrefix + raw
+
+	key.ID = uuid.New().String()
+	key.Name = strings.TrimSpace(key.Name)
+	key.Prefix = secret[:len(apiKeyPrefix)+8]
+	key.KeyHash = HashToken(secret)
+	key.CreatedAt = now
+	key.LastUsedAt = nil
+	key.RevokedAt = nil
+	if err := s.apiKeyRepo.CreateAPIKey(ctx, key); err != nil {
+		return nil, "", err
+	}
+	return &key, secret, nil
+}
+
+func validateScopes(scopes []Scope, invalid *apperr.ValidationError) {
+	if len(scopes) == 0 {
+		invalid.Add("scopes", "at least one scope is required")
+		return
+	}
+	for _, scope := range scopes {
+		known := false
+		for _, s := range Scopes {
+			known = known || s == scope
+		}
+		if !known {
+			invalid.Add("scopes", "unknown scope %q", scope)
+		}
+	}
+}
+
+// ListAPIKeys returns the keys not yet revoked, newest first
+func (s *service) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	return s.apiKeyRepo.ListAPIKeys(ctx)
+}
+
+func (s *service) RevokeAPIKey(ctx context.Context, id string) error {
+	return s.apiKeyRepo.RevokeAPIKey(ctx, id, s.clock.Now())
+}
+
+// ValidateAPIKey returns the key and the user it acts as, recording when the
+// key was last used
+func (s *service) ValidateAPIKey(ctx context.Context, key string) (*User, *APIKey, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return nil, nil, errors.New("not an API key")
+	}
+	apiKey, err := s.apiKeyRepo.GetAPIKeyByHash(ctx, HashToken(key))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := s.clock.Now()
+	if !apiKey.usable(now) {
+		return nil, nil, errors.New("API key is expired or revoked")
+	}
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= touchInterval {
+		if err := s.apiKeyRepo.TouchAPIKey(ctx, apiKey.ID, now); err != nil {
+			return nil, nil, err
+		}
+		apiKey.LastUsedAt = &now
+	}
+
+	user, err := s.repo.Get(ctx, apiKey.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return user, apiKey, nil
+}
@@ -0,0 +1,155 @@
+// user/u_api_key_repository.go contains the storage for API keys.
+package user
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/BodaciousX/RVParkBackend/apperr"
+	"github.com/lib/pq"
+)
+
+type APIKeyRepository interface {
+	CreateAPIKey(ctx context.Context, key APIKey) error
+	// GetAPIKeyByHash returns a key whether or not it is still usable
+	GetAPIKeyByHash(ctx context.Context, keyHash string) (*APIKey, error)
+	// ListAPIKeys returns every key not yet revoked, newest first
+	ListAPIKeys(ctx context.Context) ([]APIKey, error)
+	TouchAPIKey(ctx context.Context, id string, lastUsedAt time.Time) error
+	// RevokeAPIKey returns a not found error unless the key exists and isn't
+	// already revoked
+	RevokeAPIKey(ctx context.Context, id string, at time.Time) error
+}
+
+type sqlAPIKeyRepository struct {
+	db *sql.DB
+}
+
+func NewAPIKeyRepository(db *sql.DB) APIKeyRepository {
+	return &sqlAPIKeyRepository{db: db}
+}
+
+const apiKeyColumns = `
+	id, name, user_id, key_prefix, key_hash, scopes, expires_at,
+	created_by, created_at, last_used_at, revoked_at
+`
+
+func (r *sqlAPIKeyRepository) CreateAPIKey(ctx context.Context, key APIKey) error {
+	query := `
+		INSERT INTO api_keys (
+			id, name, user_id, key_prefix, key_hash, scopes, expires_at, created_by, created_at
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`
+	_, err := r.db.ExecContext(
+		ctx,
+		query,
+		key.ID,
+		key.Name,
+		key.UserID,
+		key.Prefix,
+		key.KeyHash,
+		pq.Array(scopeStrings(key.Scopes)),
+		key.ExpiresAt,
+		sql.NullString{String: key.CreatedBy, Valid: key.CreatedBy != ""},
+		key.CreatedAt,
+	)
+	return err
+}
+
+func (r *sqlAPIKeyRepository) GetAPIKeyByHash(ctx context.Context, keyHash string) (*APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE key_hash = $1`
+	key, err := scanAPIKey(r.db.QueryRowContext(ctx, query, keyHash))
+	if err == sql.ErrNoRows {
+		return nil, apperr.NotFound("API key not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (r *sqlAPIKeyRepository) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	query := `
+		SELECT ` + apiKeyColumns + `
+		FROM api_keys
+		WHERE revoked_at IS NULL
+		ORDER BY created_at DESC
+	`
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	return keys, rows.Err()
+}
+
+func (r *sqlAPIKeyRepository) TouchAPIKey(ctx context.Context, id string, lastUsedAt time.Time) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE api_keys SET last_used_at = $2 WHERE id = $1`, id, lastUsedAt)
+	return err
+}
+
+func (r *sqlAPIKeyRepository) RevokeAPIKey(ctx context.Context, id string, at time.Time) error {
+	query := `
+		UPDATE api_keys
+		SET revoked_at = $2
+		WHERE id = $1 AND revoked_at IS NULL
+	`
+	result, err := r.db.ExecContext(ctx, query, id, at)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return apperr.NotFound("API key %s not found", id)
+	}
+	return nil
+}
+
+func scanAPIKey(row rowScanner) (*APIKey, error) {
+	key := &APIKey{}
+	var scopes []string
+	var createdBy sql.NullString
+	err := row.Scan(
+		&key.ID,
+		&key.Name,
+		&key.UserID,
+		&key.Prefix,
+		&key.KeyHash,
+		pq.Array(&scopes),
+		&key.ExpiresAt,
+		&createdBy,
+		&key.CreatedAt,
+		&key.LastUsedAt,
+		&key.RevokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	for _, scope := range scopes {
+		key.Scopes = append(key.Scopes, Scope(scope))
+	}
+	key.CreatedBy = createdBy.String
+	return key, nil
+}
+
+func scopeStrings(scopes []Scope) []string {
+	values := make([]string, len(scopes))
+	for i, scope := range scopes {
+		values[i] = string(scope)
+	}
+	return values
+}
@@ -1,22 +1,62 @@
 // user/u_interface.go contains the interface for the user package.
 package user
 
+import (
+	"context"
+
+	"github.com/BodaciousX/RVParkBackend/paging"
+)
+
 type Service interface {
-	CreateUser(user User, password string) error
-	GetUser(id string) (*User, error)
-	GetUserByEmail(email string) (*User, error)
-	UpdateUser(user User) error
-	DeleteUser(id string) error
-	Login(creds LoginCredentials) (*User, string, error)
-	ValidateToken(token string) (*User, error)
-	ChangePassword(userID string, oldPassword, newPassword string) error
-	RevokeAllTokens(userID string) error
+	CreateUser(ctx context.Context, user User, password string) error
+	GetUser(ctx context.Context, id string) (*User, error)
+	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	UpdateUser(ctx context.Context, user User) error
+	DeleteUser(ctx context.Context, id string) error
+	Login(ctx context.Context, creds LoginCredentials) (*LoginResult, error)
+	VerifyLoginChallenge(ctx context.Context, resp ChallengeResponse) (*LoginResult, error)
+	EnrollTOTPForChallenge(ctx context.Context, challenge string) (*TOTPEnrollment, error)
+	StartOIDCLogin(ctx context.Context) (string, error)
+	CompleteOIDCLogin(ctx context.Context, callback OIDCCallback) (*LoginResult, error)
+	CleanOIDCLogins(ctx context.Context) error
+	ValidateToken(ctx context.Context, token string) (*User, error)
+	ChangePassword(ctx context.Context, userID, currentToken, oldPassword, newPassword string) error
+	ResetPassword(ctx context.Context, userID string, newPassword string) error
+	Logout(ctx context.Context, token string) error
+	ListSessions(ctx context.Context, userID string) ([]Token, error)
+	RevokeSession(ctx context.Context, userID, sessionID string) error
+	RevokeAllTokens(ctx context.Context, userID string) error
+	UnlockUser(ctx context.Context, userID string) error
+	ListLoginEvents(ctx context.Context, userID string, limit int) ([]LoginEvent, error)
+	CleanLoginThrottles(ctx context.Context) error
+	RequestPasswordReset(ctx context.Context, email string) error
+	ConfirmPasswordReset(ctx context.Context, token, newPassword string) error
+	InviteUser(ctx context.Context, user User) (*User, error)
+	AcceptInvite(ctx context.Context, token, password string) error
+	CleanAccountTokens(ctx context.Context) error
+	GetTwoFactorStatus(ctx context.Context, userID string) (*TwoFactorStatus, error)
+	EnrollTOTP(ctx context.Context, userID string) (*TOTPEnrollment, error)
+	ConfirmTOTP(ctx context.Context, userID, code string) ([]string, error)
+	DisableTwoFactor(ctx context.Context, userID, code string) error
+	RegenerateRecoveryCodes(ctx context.Context, userID, code string) ([]string, error)
+	ResetTwoFactor(ctx context.Context, userID string) error
+	CreateAPIKey(ctx context.Context, key APIKey) (*APIKey, string, error)
+	ListAPIKeys(ctx context.Context) ([]APIKey, error)
+	RevokeAPIKey(ctx context.Context, id string) error
+	ValidateAPIKey(ctx context.Context, key string) (*User, *APIKey, error)
+	FindUsers(ctx context.Context, filter Filter, page paging.Request) (*paging.Page[User], error)
 }
 
 type Repository interface {
-	Create(user User) error
-	Get(id string) (*User, error)
-	GetByEmail(email string) (*User, error)
-	Update(user User) error
-	Delete(id string) error
+	Create(ctx context.Context, user User) error
+	Get(ctx context.Context, id string) (*User, error)
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	Update(ctx context.Context, user User) error
+	Delete(ctx context.Context, id string) error
+	Find(ctx context.Context, filter Filter, page paging.Request) (*paging.Page[User], error)
+}
+
+// Mailer delivers account emails such as password reset links and invitations
+type Mailer interface {
+	Send(ctx context.Context, to, subject, body string) error
 }
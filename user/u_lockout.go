@@ -0,0 +1,190 @@
+// user/u_lockout.go contains the backoff and lockout applied to failed sign-ins.
+package user
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BodaciousX/RVParkBackend/apperr"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// LockoutConfig controls how failed sign-ins are slowed down. Failures are
+// counted per account and per client address. After FreeAttempts failures in
+// a row each further attempt must wait BaseDelay, doubling per failure up to
+// MaxDelay; at the threshold the account or address is locked for
+// LockoutDuration. Failures older than Window are forgotten.
+type LockoutConfig struct {
+	FreeAttempts     int
+	BaseDelay        time.Duration
+	MaxDelay         time.Duration
+	AccountThreshold int
+	// IPThreshold is higher than AccountThreshold because many staff can
+	// share one office address
+	IPThreshold     int
+	LockoutDuration time.Duration
+	Window          time.Duration
+}
+
+func DefaultLockoutConfig() LockoutConfig {
+	return LockoutConfig{
+		FreeAttempts:     3,
+		BaseDelay:        time.Second,
+		MaxDelay:         time.Minute,
+		AccountThreshold: 10,
+		IPThreshold:      50,
+		LockoutDuration:  15 * time.Minute,
+		Window:           time.Hour,
+	}
+}
+
+// throttleKey is one thing failures are counted against, with the number of
+// failures that locks it
+type throttleKey struct {
+	key       string
+	threshold int
+}
+
+func accountThrottleKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// throttleKeys returns the keys a sign-in attempt is counted against. The
+// account is keyed by email rather than user ID so unknown emails are
+// throttled exactly like real ones.
+func (c LockoutConfig) throttleKeys(creds LoginCredentials) []throttleKey {
+	keys := []throttleKey{{key: accountThrottleKey(creds.Email), threshold: c.AccountThreshold}}
+	if creds.IPAddress != "" {
+		keys = append(keys, throttleKey{key: "ip:" + creds.IPAddress, threshold: c.IPThreshold})
+	}
+	return keys
+}
+
+// retryAfter is how long the holder of throttle must wait before trying again
+func (c LockoutConfig) retryAfter(throttle LoginThrottle, now time.Time) time.Duration {
+	if now.Before(throttle.LockedUntil) {
+		return throttle.LockedUntil.Sub(now)
+	}
+	if throttle.LastFailureAt.Before(now.Add(-c.Window)) || throttle.Failures < c.FreeAttempts {
+		return 0
+	}
+
+	delay := c.BaseDelay
+	for i := c.FreeAttempts; i < throttle.Failures && delay < c.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > c.MaxDelay {
+		delay = c.MaxDelay
+	}
+
+	if next := throttle.LastFailureAt.Add(delay); now.Before(next) {
+		return next.Sub(now)
+	}
+	return 0
+}
+
+// checkThrottles refuses the attempt if any of keys is backing off or locked
+func (s *service) checkThrottles(ctx context.Context, keys []throttleKey, now time.Time) error {
+	var wait time.Duration
+	for _, k := range keys {
+		throttle, err := s.loginRepo.GetThrottle(ctx, k.key)
+		if err != nil {
+			return err
+		}
+		if d := s.lockout.retryAfter(throttle, now); d > wait {
+			wait = d
+		}
+	}
+	if wait > 0 {
+		return apperr.RateLimited(wait, "too many failed sign-in attempts; try again later")
+	}
+	return nil
+}
+
+// checkLoginThrottles is checkThrottles for a sign-in attempt, recording
+// the event when the attempt is refused
+func (s *service) checkLoginThrottles(ctx context.Context, event LoginEvent, keys []throttleKey) error {
+	err := s.checkThrottles(ctx, keys, event.CreatedAt)
+	if errors.Is(err, apperr.ErrRateLimited) {
+		event.Reason = LoginLocked
+		if recordErr := s.loginRepo.CreateLoginEvent(ctx, event); recordErr != nil {
+			return recordErr
+		}
+	}
+	return err
+}
+
+// countFailure counts a failed attempt against keys, locking any that reach
+// their threshold
+func (s *service) countFailure(ctx context.Context, keys []throttleKey, now time.Time) error {
+	for _, k := range keys {
+		throttle, err := s.loginRepo.RecordFailure(ctx, k.key, now, now.Add(-s.lockout.Window))
+		if err != nil {
+			return err
+		}
+		if throttle.Failures >= k.threshold {
+			if err := s.loginRepo.LockThrottle(ctx, k.key, now.Add(s.lockout.LockoutDuration)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loginFailed counts a failed attempt against keys and records the event.
+// It returns ErrInvalidCredentials unless the bookkeeping itself fails.
+func (s *service) loginFailed(ctx context.Context, event LoginEvent, keys []throttleKey, reason string) error {
+	if err := s.countFailure(ctx, keys, event.CreatedAt); err != nil {
+		return err
+	}
+
+	event.Reason = reason
+	if err := s.loginRepo.CreateLoginEvent(ctx, event); err != nil {
+		return err
+	}
+	return ErrInvalidCredentials
+}
+
+// UnlockUser clears the failed sign-ins counted against a user's account,
+// ending any lockout or backoff
+func (s *service) UnlockUser(ctx context.Context, userID string) error {
+	user, err := s.repo.Get(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return s.loginRepo.ClearThrottle(ctx, accountThrottleKey(user.Email))
+}
+
+// ListLoginEvents returns a user's most recent sign-in attempts, newest first
+func (s *service) ListLoginEvents(ctx context.Context, userID string, limit int) ([]LoginEvent, error) {
+	if _, err := s.repo.Get(ctx, userID); err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	return s.loginRepo.ListLoginEvents(ctx, userID, limit)
+}
+
+// CleanLoginThrottles forgets failures too old to count any more
+func (s *service) CleanLoginThrottles(ctx context.Context) error {
+	now := s.clock.Now()
+	return s.loginRepo.CleanThrottles(ctx, now.Add(-s.lockout.Window), now)
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// compareDummyHash spends as long as checking a real password, so response
+// times don't reveal which emails have accounts
+func compareDummyHash(password string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+	})
+	bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+}
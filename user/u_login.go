@@ -0,0 +1,193 @@
+// user/u_login.go contains the storage for failed sign-in tracking and login events.
+package user
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Reasons a sign-in attempt failed, as recorded on its LoginEvent
+const (
+	LoginUnknownEmail = "unknown_email"
+	LoginBadPassword  = "bad_password"
+	LoginLocked       = "locked"
+	LoginBadCode      = "bad_code" // Wrong two-factor or recovery code
+)
+
+// LoginEvent records one sign-in attempt. UserID is empty when the email
+// didn't match an account.
+type LoginEvent struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"userId,omitempty"`
+	Email     string    `json:"email"`
+	IPAddress string    `json:"ipAddress"`
+	UserAgent string    `json:"userAgent"`
+	Success   bool      `json:"success"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// LoginThrottle counts recent failed sign-ins for one account or address
+type LoginThrottle struct {
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   time.Time
+}
+
+type LoginRepository interface {
+	// GetThrottle returns the throttle for key, or a zero throttle when there
+	// have been no failures
+	GetThrottle(ctx context.Context, key string) (LoginThrottle, error)
+	// RecordFailure counts a failed attempt against key. Failures before
+	// windowStart are forgotten and the count starts again from one.
+	RecordFailure(ctx context.Context, key string, at, windowStart time.Time) (LoginThrottle, error)
+	LockThrottle(ctx context.Context, key string, until time.Time) error
+	ClearThrottle(ctx context.Context, key string) error
+	// CleanThrottles deletes throttles with no failure since windowStart and
+	// no lockout still in force at now
+	CleanThrottles(ctx context.Context, windowStart, now time.Time) error
+	CreateLoginEvent(ctx context.Context, event LoginEvent) error
+	// ListLoginEvents returns a user's most recent login events, newest first
+	ListLoginEvents(ctx context.Context, userID string, limit int) ([]LoginEvent, error)
+}
+
+type sqlLoginRepository struct {
+	db *sql.DB
+}
+
+func NewLoginRepository(db *sql.DB) LoginRepository {
+	return &sqlLoginRepository{db: db}
+}
+
+func (r *sqlLoginRepository) GetThrottle(ctx context.Context, key string) (LoginThrottle, error) {
+	query := `
+		SELECT key, failures, last_failure_at, locked_until
+		FROM login_throttles
+		WHERE key = $1
+	`
+	throttle, err := scanThrottle(r.db.QueryRowContext(ctx, query, key))
+	if err == sql.ErrNoRows {
+		return LoginThrottle{Key: key}, nil
+	}
+	return throttle, err
+}
+
+// RecordFailure increments the count in one statement so concurrent guesses
+// can't overwrite each other's failures
+func (r *sqlLoginRepository) RecordFailure(ctx context.Context, key string, at, windowStart time.Time) (LoginThrottle, error) {
+	query := `
+		INSERT INTO login_throttles (key, failures, last_failure_at)
+		VALUES ($1, 1, $2)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE
+				WHEN login_throttles.last_failure_at < $3 THEN 1
+				ELSE login_throttles.failures + 1
+			END,
+			last_failure_at = $2
+		RETURNING key, failures, last_failure_at, locked_until
+	`
+	return scanThrottle(r.db.QueryRowContext(ctx, query, key, at, windowStart))
+}
+
+func (r *sqlLoginRepository) LockThrottle(ctx context.Context, key string, until time.Time) error {
+	query := `
+		UPDATE login_throttles
+		SET locked_until = $2
+		WHERE key = $1
+	`
+	_, err := r.db.ExecContext(ctx, query, key, until)
+	return err
+}
+
+func (r *sqlLoginRepository) ClearThrottle(ctx context.Context, key string) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM login_throttles WHERE key = $1`, key)
+	return err
+}
+
+func (r *sqlLoginRepository) CleanThrottles(ctx context.Context, windowStart, now time.Time) error {
+	query := `
+		DELETE FROM login_throttles
+		WHERE last_failure_at < $1
+		AND (locked_until IS NULL OR locked_until <= $2)
+	`
+	_, err := r.db.ExecContext(ctx, query, windowStart, now)
+	return err
+}
+
+func (r *sqlLoginRepository) CreateLoginEvent(ctx context.Context, event LoginEvent) error {
+	if event.ID == "" {
+		event.ID = uuid.New().String()
+	}
+
+	query := `
+		INSERT INTO login_events (
+			id, user_id, email, ip_address, user_agent, success, reason, created_at
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+	_, err := r.db.ExecContext(
+		ctx,
+		query,
+		event.ID,
+		sql.NullString{String: event.UserID, Valid: event.UserID != ""},
+		event.Email,
+		event.IPAddress,
+		event.UserAgent,
+		event.Success,
+		event.Reason,
+		event.CreatedAt,
+	)
+	return err
+}
+
+func (r *sqlLoginRepository) ListLoginEvents(ctx context.Context, userID string, limit int) ([]LoginEvent, error) {
+	query := `
+		SELECT id, user_id, email, ip_address, user_agent, success, reason, created_at
+		FROM login_events
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`
+	rows, err := r.db.QueryContext(ctx, query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []LoginEvent{}
+	for rows.Next() {
+		var event LoginEvent
+		var eventUserID sql.NullString
+		err := rows.Scan(
+			&event.ID,
+			&eventUserID,
+			&event.Email,
+			&event.IPAddress,
+			&event.UserAgent,
+			&event.Success,
+			&event.Reason,
+			&event.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		event.UserID = eventUserID.String
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+func scanThrottle(row rowScanner) (LoginThrottle, error) {
+	var throttle LoginThrottle
+	var lockedUntil sql.NullTime
+	err := row.Scan(&throttle.Key, &throttle.Failures, &throttle.LastFailureAt, &lockedUntil)
+	if err != nil {
+		return LoginThrottle{}, err
+	}
+	throttle.LockedUntil = lockedUntil.Time
+	return throttle, nil
+}
@@ -23,4 +23,14 @@ type User struct {
 type LoginCredentials struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+
+	// Recorded on the session so users can tell their devices apart
+	UserAgent string `json:"-"`
+	IPAddress string `json:"-"`
+}
+
+// Filter narrows a user listing. Empty fields match every user.
+type Filter struct {
+	Search string // Case-insensitive match on email or username
+	Role   Role
 }
@@ -0,0 +1,269 @@
+// user/u_oidc.go contains single sign-on with an OpenID Connect identity provider.
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/BodaciousX/RVParkBackend/apperr"
+	"github.com/google/uuid"
+)
+
+// OIDCConfig connects sign-in to an OpenID Connect provider, such as Google
+// Workspace, Entra ID or Keycloak. Single sign-on is off unless Issuer is
+// set. RedirectURL is the app's callback page, which must be registered with
+// the provider and passes the code and state it's given to
+// CompleteOIDCLogin. Users are given the role GroupRoles maps their groups
+// to, read from the GroupsClaim claim of their ID token; users in no mapped
+// group can't sign in.
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string // Empty for public clients, which rely on PKCE alone
+	RedirectURL  string
+	Scopes       []string
+	GroupsClaim  string
+	GroupRoles   map[string]Role
+	LoginTTL     time.Duration // How long the user has to sign in with the provider
+	HTTPClient   *http.Client  // Defaults to a client with a 10 second timeout
+}
+
+func DefaultOIDCConfig() OIDCConfig {
+	return OIDCConfig{
+		Scopes:      []string{"openid", "email", "profile"},
+		GroupsClaim: "groups",
+		LoginTTL:    10 * time.Minute,
+	}
+}
+
+func (c OIDCConfig) enabled() bool {
+	return c.Issuer != ""
+}
+
+// role returns the role for a user in groups. Administrator wins when the
+// groups map to more than one role.
+func (c OIDCConfig) role(groups []string) (Role, bool) {
+	var role Role
+	for _, group := range groups {
+		mapped, ok := c.GroupRoles[group]
+		if !ok {
+			continue
+		}
+		if role == "" || mapped == RoleAdmin {
+			role = mapped
+		}
+	}
+	return role, role != ""
+}
+
+// ErrNoGroupRole is returned by CompleteOIDCLogin when none of the user's
+// groups at the identity provider are mapped to a role
+var ErrNoGroupRole = errors.New("your account isn't in a group that can sign in here")
+
+// LoginNoGroupRole is recorded on the LoginEvent of a single sign-on refused
+// because of the user's groups
+const LoginNoGroupRole = "no_group_role"
+
+// OIDCCallback is what the identity provider sent back to the app's
+// callback page
+type OIDCCallback struct {
+	Code  string `json:"code"`
+	State string `json:"state"`
+
+	UserAgent string `json:"-"`
+	IPAddress string `json:"-"`
+}
+
+func oidcDisabled() error {
+	return apperr.NotFound("single sign-on is not configured")
+}
+
+// StartOIDCLogin returns the provider address to send the user to. The state,
+// nonce and PKCE verifier are kept here so the browser never sees the
+// verifier.
+func (s *service) StartOIDCLogin(ctx context.Context) (string, error) {
+	if s.oidc == nil {
+		return "", oidcDisabled()
+	}
+
+	state, stateHash, err := GenerateToken()
+	if err != nil {
+		return "", err
+	}
+	verifier, _, err := GenerateToken()
+	if err != nil {
+		return "", err
+	}
+	nonce, _, err := GenerateToken()
+	if err != nil {
+		return "", err
+	}
+
+	address, err := s.oidc.authorizationURL(ctx, state, nonce, verifier)
+	if err != nil {
+		return "", err
+	}
+	now := s.clock.Now()
+	if err := s.oidcRepo.CreateOIDCLogin(ctx, OIDCLogin{
+		StateHash:    stateHash,
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		ExpiresAt:    now.Add(s.oidcConfig.LoginTTL),
+		CreatedAt:    now,
+	}); err != nil {
+		return "", err
+	}
+	return address, nil
+}
+
+// CompleteOIDCLogin finishes a single sign-on started by StartOIDCLogin and
+// starts a session. Users signing in for the first time are linked to the
+// account with their email if the provider has verified it, or created if
+// there is none. Their role follows their groups on every sign-in. The
+// provider is trusted for any second factor, so two-factor sign-in isn't
+// asked for.
+func (s *service) CompleteOIDCLogin(ctx context.Context, callback OIDCCallback) (*LoginResult, error) {
+	if s.oidc == nil {
+		return nil, oidcDisabled()
+	}
+	invalid := &apperr.ValidationError{}
+	if callback.Code == "" {
+		invalid.Add("code", "code is required")
+	}
+	if callback.State == "" {
+		invalid.Add("state", "state is required")
+	}
+	if err := invalid.Err(); err != nil {
+		return nil, err
+	}
+
+	now := s.clock.Now()
+	login, err := s.oidcRepo.ConsumeOIDCLogin(ctx, HashToken(callback.State), now)
+	if err != nil {
+		return nil, err
+	}
+	idToken, err := s.oidc.exchange(ctx, callback.Code, login.CodeVerifier)
+	if errors.Is(err, errInvalidGrant) {
+		return nil, invalidOIDCState()
+	}
+	if err != nil {
+		return nil, err
+	}
+	claims, err := s.oidc.verify(ctx, idToken, login.Nonce, now)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+
+	user, err := s.findOIDCUser(ctx, claims, now)
+	if err != nil {
+		return nil, err
+	}
+	event := LoginEvent{
+		Email:     claims.Email,
+		IPAddress: callback.IPAddress,
+		UserAgent: callback.UserAgent,
+		CreatedAt: now,
+	}
+	if user != nil {
+		event.UserID = user.ID
+		event.Email = user.Email
+	}
+
+	role, ok := s.oidcConfig.role(s.oidc.groups(claims))
+	if !ok {
+		event.Reason = LoginNoGroupRole
+		if err := s.loginRepo.CreateLoginEvent(ctx, event); err != nil {
+			return nil, err
+		}
+		return nil, ErrNoGroupRole
+	}
+
+	if user == nil {
+		if user, err = s.provisionOIDCUser(ctx, claims, role, now); err != nil {
+			return nil, err
+		}
+		event.UserID = user.ID
+	}
+	// startSession saves the user, so this keeps their role in step
+	user.Role = role
+
+	token, err := s.startSession(ctx, user, event)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResult{User: user, Token: token}, nil
+}
+
+// findOIDCUser returns the user linked to the provider account, linking the
+// user with a matching verified email the first time. It returns nil when
+// there is no such user.
+func (s *service) findOIDCUser(ctx context.Context, claims *idTokenClaims, now time.Time) (*User, error) {
+	userID, err := s.oidcRepo.GetIdentityUser(ctx, claims.Issuer, claims.Subject)
+	if err == nil {
+		return s.repo.Get(ctx, userID)
+	}
+	if !errors.Is(err, apperr.ErrNotFound) {
+		return nil, err
+	}
+
+	if claims.Email == "" {
+		return nil, fmt.Errorf("%w: ID token has no email", ErrInvalidCredentials)
+	}
+	user, err := s.repo.GetByEmail(ctx, claims.Email)
+	if errors.Is(err, apperr.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Otherwise anyone able to set that address at the provider could take
+	// over the account
+	if !claims.EmailVerified {
+		return nil, apperr.Conflict("an account already uses %s, which your identity provider hasn't verified", claims.Email)
+	}
+	if err := s.oidcRepo.LinkIdentity(ctx, claims.Issuer, claims.Subject, user.ID, now); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// provisionOIDCUser creates and links a user signing in for the first time.
+// They have no password, so can only sign in through the provider until one
+// is set with a password reset.
+func (s *service) provisionOIDCUser(ctx context.Context, claims *idTokenClaims, role Role, now time.Time) (*User, error) {
+	user := User{
+		ID:        uuid.New().String(),
+		Email:     claims.Email,
+		Username:  oidcUsername(claims),
+		Role:      role,
+		CreatedAt: now,
+	}
+	if err := s.repo.Create(ctx, user); err != nil {
+		return nil, err
+	}
+	if err := s.oidcRepo.LinkIdentity(ctx, claims.Issuer, claims.Subject, user.ID, now); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func oidcUsername(claims *idTokenClaims) string {
+	switch {
+	case claims.PreferredUsername != "":
+		return claims.PreferredUsername
+	case claims.Name != "":
+		return claims.Name
+	default:
+		name, _, _ := strings.Cut(claims.Email, "@")
+		return name
+	}
+}
+
+// CleanOIDCLogins deletes single sign-on attempts that were never finished
+func (s *service) CleanOIDCLogins(ctx context.Context) error {
+	return s.oidcRepo.CleanOIDCLogins(ctx, s.clock.Now())
+}
@@ -0,0 +1,349 @@
+// user/u_oidc_provider.go contains the OpenID Connect client used for single sign-on.
+package user
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// oidcClockSkew allows for the provider's clock being slightly off from ours
+// when checking ID token times
+const oidcClockSkew = time.Minute
+
+// oidcMetadata is the part of the provider's discovery document we use
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// idTokenClaims are the ID token claims we check or use. Audience and groups
+// may each be a single string or a list, so they're decoded separately.
+type idTokenClaims struct {
+	Issuer            string          `json:"iss"`
+	Subject           string          `json:"sub"`
+	Audience          stringList      `json:"aud"`
+	AuthorizedParty   string          `json:"azp"`
+	ExpiresAt         int64           `json:"exp"`
+	IssuedAt          int64           `json:"iat"`
+	Nonce             string          `json:"nonce"`
+	Email             string          `json:"email"`
+	EmailVerified     bool            `json:"email_verified"`
+	Name              string          `json:"name"`
+	PreferredUsername string          `json:"preferred_username"`
+	Raw               json.RawMessage `json:"-"`
+}
+
+// stringList decodes a JSON string or array of strings
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*l = stringList{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*l = many
+	return nil
+}
+
+func (l stringList) contains(value string) bool {
+	for _, v := range l {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// oidcProvider talks to the identity provider. Its discovery document and
+// signing keys are fetched when first needed and kept; the keys are fetched
+// again when a token is signed with one we haven't seen, as happens after
+// the provider rotates them.
+type oidcProvider struct {
+	config OIDCConfig
+	client *http.Client
+
+	mu       sync.Mutex
+	metadata *oidcMetadata
+	keys     map[string]*rsa.PublicKey
+}
+
+func newOIDCProvider(config OIDCConfig) *oidcProvider {
+	client := config.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &oidcProvider{config: config, client: client}
+}
+
+func (p *oidcProvider) discover(ctx context.Context) (*oidcMetadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	issuer := strings.TrimSuffix(p.config.Issuer, "/")
+	metadata := &oidcMetadata{}
+	if err := p.getJSON(ctx, issuer+"/.well-known/openid-configuration", metadata); err != nil {
+		return nil, fmt.Errorf("discovering OpenID provider: %w", err)
+	}
+	if strings.TrimSuffix(metadata.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("OpenID provider says its issuer is %q, not %q", metadata.Issuer, p.config.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, errors.New("OpenID provider's discovery document is missing endpoints")
+	}
+	p.metadata = metadata
+	return metadata, nil
+}
+
+// authorizationURL is where the user is sent to sign in with the provider
+func (p *oidcProvider) authorizationURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {pkceChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return metadata.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// errInvalidGrant means the provider refused the authorization code, because
+// it was wrong, already used or expired
+var errInvalidGrant = errors.New("authorization code rejected")
+
+// exchange trades an authorization code for the user's ID token
+func (p *oidcProvider) exchange(ctx context.Context, code, verifier string) (string, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"client_id":     {p.config.ClientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("requesting ID token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return "", fmt.Errorf("reading token response (status %d): %w", resp.StatusCode, err)
+	}
+	if body.Error == "invalid_grant" {
+		return "", errInvalidGrant
+	}
+	if resp.StatusCode != http.StatusOK || body.Error != "" {
+		return "", fmt.Errorf("token request failed with status %d: %s %s", resp.StatusCode, body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return "", errors.New("token response has no ID token")
+	}
+	return body.IDToken, nil
+}
+
+// verify checks an ID token's signature, issuer, audience, lifetime and
+// nonce, returning its claims
+func (p *oidcProvider) verify(ctx context.Context, idToken, nonce string, now time.Time) (*idTokenClaims, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("ID token is not a JWT")
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("ID token header: %w", err)
+	}
+	if header.Algorithm != "RS256" {
+		return nil, fmt.Errorf("ID token is signed with %q, not RS256", header.Algorithm)
+	}
+	key, err := p.signingKey(ctx, header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("ID token signature: %w", err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, errors.New("ID token signature is invalid")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("ID token claims: %w", err)
+	}
+	claims := &idTokenClaims{Raw: payload}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return nil, fmt.Errorf("ID token claims: %w", err)
+	}
+
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case claims.Issuer != metadata.Issuer:
+		return nil, fmt.Errorf("ID token is from %q", claims.Issuer)
+	case !claims.Audience.contains(p.config.ClientID):
+		return nil, errors.New("ID token is for another client")
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != p.config.ClientID:
+		return nil, errors.New("ID token was issued to another client")
+	case claims.Subject == "":
+		return nil, errors.New("ID token has no subject")
+	case !now.Before(time.Unix(claims.ExpiresAt, 0).Add(oidcClockSkew)):
+		return nil, errors.New("ID token has expired")
+	case time.Unix(claims.IssuedAt, 0).After(now.Add(oidcClockSkew)):
+		return nil, errors.New("ID token was issued in the future")
+	case claims.Nonce != nonce:
+		return nil, errors.New("ID token nonce doesn't match the sign-in")
+	}
+	return claims, nil
+}
+
+// groups reads the claim configured to hold the user's groups
+func (p *oidcProvider) groups(claims *idTokenClaims) []string {
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(claims.Raw, &all); err != nil {
+		return nil
+	}
+	var groups stringList
+	if raw, ok := all[p.config.GroupsClaim]; ok && json.Unmarshal(raw, &groups) == nil {
+		return groups
+	}
+	return nil
+}
+
+func (p *oidcProvider) signingKey(ctx context.Context, keyID string) (*rsa.PublicKey, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[keyID]; ok {
+		return key, nil
+	}
+
+	var set struct {
+		Keys []struct {
+			KeyType string `json:"kty"`
+			KeyID   string `json:"kid"`
+			Use     string `json:"use"`
+			N       string `json:"n"`
+			E       string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(ctx, metadata.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("fetching OpenID provider keys: %w", err)
+	}
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.KeyType != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil {
+			continue
+		}
+		keys[k.KeyID] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	p.keys = keys
+
+	if key, ok := keys[keyID]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("ID token is signed with unknown key %q", keyID)
+}
+
+func (p *oidcProvider) getJSON(ctx context.Context, address string, into any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, address, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned status %d", address, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(into)
+}
+
+func decodeJWTPart(part string, into any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, into)
+}
+
+// pkceChallenge is the S256 code challenge for verifier (RFC 7636)
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
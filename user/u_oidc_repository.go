@@ -0,0 +1,114 @@
+// user/u_oidc_repository.go contains the storage for single sign-on attempts and linked identities.
+package user
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/BodaciousX/RVParkBackend/apperr"
+)
+
+// OIDCLogin is a single sign-on attempt waiting for the identity provider to
+// send the user back. Only the hash of the state is stored.
+type OIDCLogin struct {
+	StateHash    string
+	CodeVerifier string
+	Nonce        string
+	ExpiresAt    time.Time
+	CreatedAt    time.Time
+}
+
+type OIDCRepository interface {
+	CreateOIDCLogin(ctx context.Context, login OIDCLogin) error
+	// ConsumeOIDCLogin removes and returns an unexpired attempt, so each can
+	// be completed only once
+	ConsumeOIDCLogin(ctx context.Context, stateHash string, now time.Time) (*OIDCLogin, error)
+	// CleanOIDCLogins deletes attempts that expired before before
+	CleanOIDCLogins(ctx context.Context, before time.Time) error
+	// GetIdentityUser returns the ID of the user linked to the provider
+	// account, or a not found error
+	GetIdentityUser(ctx context.Context, issuer, subject string) (string, error)
+	LinkIdentity(ctx context.Context, issuer, subject, userID string, now time.Time) error
+}
+
+// invalidOIDCState is returned for unknown, used and expired attempts alike
+func invalidOIDCState() error {
+	return apperr.Invalid("state", "this sign-in has expired; sign in again")
+}
+
+type sqlOIDCRepository struct {
+	db *sql.DB
+}
+
+func NewOIDCRepository(db *sql.DB) OIDCRepository {
+	return &sqlOIDCRepository{db: db}
+}
+
+func (r *sqlOIDCRepository) CreateOIDCLogin(ctx context.Context, login OIDCLogin) error {
+	query := `
+		INSERT INTO oidc_logins (state_hash, code_verifier, nonce, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+	_, err := r.db.ExecContext(
+		ctx,
+		query,
+		login.StateHash,
+		login.CodeVerifier,
+		login.Nonce,
+		login.ExpiresAt,
+		login.CreatedAt,
+	)
+	return err
+}
+
+func (r *sqlOIDCRepository) ConsumeOIDCLogin(ctx context.Context, stateHash string, now time.Time) (*OIDCLogin, error) {
+	query := `
+		DELETE FROM oidc_logins
+		WHERE state_hash = $1 AND expires_at > $2
+		RETURNING state_hash, code_verifier, nonce, expires_at, created_at
+	`
+	login := &OIDCLogin{}
+	err := r.db.QueryRowContext(ctx, query, stateHash, now).Scan(
+		&login.StateHash,
+		&login.CodeVerifier,
+		&login.Nonce,
+		&login.ExpiresAt,
+		&login.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, invalidOIDCState()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return login, nil
+}
+
+func (r *sqlOIDCRepository) CleanOIDCLogins(ctx context.Context, before time.Time) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM oidc_logins WHERE expires_at < $1`, before)
+	return err
+}
+
+func (r *sqlOIDCRepository) GetIdentityUser(ctx context.Context, issuer, subject string) (string, error) {
+	query := `
+		SELECT user_id
+		FROM user_identities
+		WHERE issuer = $1 AND subject = $2
+	`
+	var userID string
+	err := r.db.QueryRowContext(ctx, query, issuer, subject).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return "", apperr.NotFound("no user is linked to %s at %s", subject, issuer)
+	}
+	return userID, err
+}
+
+func (r *sqlOIDCRepository) LinkIdentity(ctx context.Context, issuer, subject, userID string, now time.Time) error {
+	query := `
+		INSERT INTO user_identities (issuer, subject, user_id, created_at)
+		VALUES ($1, $2, $3, $4)
+	`
+	_, err := r.db.ExecContext(ctx, query, issuer, subject, userID, now)
+	return err
+}
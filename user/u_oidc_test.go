@@ -0,0 +1,272 @@
+// user/u_oidc_test.go
+package user
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BodaciousX/RVParkBackend/apperr"
+	"github.com/BodaciousX/RVParkBackend/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testClientID    = "rv-park"
+	testRedirectURL = "https://app.example.com/login/callback"
+)
+
+// mockOIDCServer is an identity provider for tests. authorize stands in for
+// the user signing in at the provider, returning the code it would redirect
+// back with.
+type mockOIDCServer struct {
+	*httptest.Server
+	t     *testing.T
+	key   *rsa.PrivateKey
+	clock clock.Clock
+
+	mu     sync.Mutex
+	grants map[string]mockOIDCGrant
+}
+
+type mockOIDCGrant struct {
+	challenge string
+	claims    map[string]any
+}
+
+func newMockOIDCServer(t *testing.T, clk clock.Clock) *mockOIDCServer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	s := &mockOIDCServer{t: t, key: key, clock: clk, grants: map[string]mockOIDCGrant{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 s.URL,
+			"authorization_endpoint": s.URL + "/authorize",
+			"token_endpoint":         s.URL + "/token",
+			"jwks_uri":               s.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test-key",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("POST /token", s.token)
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *mockOIDCServer) config() OIDCConfig {
+	config := DefaultOIDCConfig()
+	config.Issuer = s.URL
+	config.ClientID = testClientID
+	config.ClientSecret = "client-secret"
+	config.RedirectURL = testRedirectURL
+	config.GroupRoles = map[string]Role{"rv-staff": RoleStaff, "rv-admins": RoleAdmin}
+	return config
+}
+
+// authorize checks the request the service sent the user with and signs
+// them in as the account claims describes
+func (s *mockOIDCServer) authorize(address string, claims map[string]any) (code, state string) {
+	u, err := url.Parse(address)
+	require.NoError(s.t, err)
+	require.Equal(s.t, s.URL+"/authorize", u.Scheme+"://"+u.Host+u.Path)
+	query := u.Query()
+	require.Equal(s.t, "code", query.Get("response_type"))
+	require.Equal(s.t, testClientID, query.Get("client_id"))
+	require.Equal(s.t, testRedirectURL, query.Get("redirect_uri"))
+	require.Equal(s.t, "S256", query.Get("code_challenge_method"))
+
+	all := map[string]any{
+		"iss":   s.URL,
+		"aud":   testClientID,
+		"iat":   s.clock.Now().Unix(),
+		"exp":   s.clock.Now().Add(time.Hour).Unix(),
+		"nonce": query.Get("nonce"),
+	}
+	for name, value := range claims {
+		all[name] = value
+	}
+
+	code, _, err = GenerateToken()
+	require.NoError(s.t, err)
+	s.mu.Lock()
+	s.grants[code] = mockOIDCGrant{challenge: query.Get("code_challenge"), claims: all}
+	s.mu.Unlock()
+	return code, query.Get("state")
+}
+
+func (s *mockOIDCServer) token(w http.ResponseWriter, r *http.Request) {
+	id, secret, _ := r.BasicAuth()
+	if id != testClientID || secret != "client-secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	s.mu.Lock()
+	grant, ok := s.grants[r.FormValue("code")]
+	delete(s.grants, r.FormValue("code"))
+	s.mu.Unlock()
+	if !ok || r.FormValue("redirect_uri") != testRedirectURL ||
+		pkceChallenge(r.FormValue("code_verifier")) != grant.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"id_token":     s.sign(grant.claims),
+	})
+}
+
+func (s *mockOIDCServer) sign(claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test-key", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(input))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	require.NoError(s.t, err)
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestOIDCLogin(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockTokenRepo := new(MockTokenRepository)
+	loginRepo := newMemoryLoginRepository()
+	oidcRepo := newMemoryOIDCRepository()
+	clk := clock.NewFake(testNow)
+	provider := newMockOIDCServer(t, clk)
+	config := DefaultConfig()
+	config.OIDC = provider.config()
+	service := NewService(mockRepo, mockTokenRepo, loginRepo, newMemoryAccountTokenRepository(), newMemoryTwoFactorRepository(), newMemoryAPIKeyRepository(), oidcRepo, new(recordingMailer), clk, config)
+	ctx := context.Background()
+
+	mockTokenRepo.On("CreateToken", mock.AnythingOfType("Token")).Return(nil)
+	mockRepo.On("Update", mock.AnythingOfType("User")).Return(nil)
+
+	signIn := func(claims map[string]any) (*LoginResult, error) {
+		address, err := service.StartOIDCLogin(ctx)
+		require.NoError(t, err)
+		code, state := provider.authorize(address, claims)
+		return service.CompleteOIDCLogin(ctx, OIDCCallback{Code: code, State: state, IPAddress: "203.0.113.5"})
+	}
+
+	// A new user is created with the role their group maps to
+	mockRepo.On("GetByEmail", "casey@example.com").Return(nil, apperr.NotFound("user not found")).Once()
+	mockRepo.On("Create", mock.AnythingOfType("User")).Return(nil).Once()
+	result, err := signIn(map[string]any{
+		"sub":                "casey-1",
+		"email":              "casey@example.com",
+		"email_verified":     true,
+		"preferred_username": "casey",
+		"groups":             []string{"everyone", "rv-staff"},
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, result.Token)
+	assert.Equal(t, "casey", result.User.Username)
+	assert.Equal(t, RoleStaff, result.User.Role)
+	assert.Equal(t, "", result.User.PasswordHash)
+	assert.Equal(t, result.User.ID, oidcRepo.identities[provider.URL+" casey-1"])
+	assert.True(t, loginRepo.events[len(loginRepo.events)-1].Success)
+
+	// Next time the linked account is used, and its role follows the groups
+	casey := *result.User
+	mockRepo.On("Get", casey.ID).Return(&casey, nil)
+	result, err = signIn(map[string]any{"sub": "casey-1", "email": "casey@example.com", "groups": "rv-admins"})
+	require.NoError(t, err)
+	assert.Equal(t, casey.ID, result.User.ID)
+	assert.Equal(t, RoleAdmin, result.User.Role)
+
+	// Existing accounts are only linked by a verified email
+	existing := &User{ID: "user1", Email: "jo@example.com", Username: "jo", PasswordHash: "hash", Role: RoleStaff}
+	mockRepo.On("GetByEmail", "jo@example.com").Return(existing, nil)
+	_, err = signIn(map[string]any{"sub": "jo-1", "email": "jo@example.com", "groups": []string{"rv-staff"}})
+	assert.ErrorIs(t, err, apperr.ErrConflict)
+	result, err = signIn(map[string]any{"sub": "jo-1", "email": "jo@example.com", "email_verified": true, "groups": []string{"rv-staff"}})
+	require.NoError(t, err)
+	assert.Equal(t, "user1", result.User.ID)
+	assert.Equal(t, "hash", result.User.PasswordHash)
+
+	// Users in no mapped group are refused and nothing is created
+	mockRepo.On("GetByEmail", "guest@example.com").Return(nil, apperr.NotFound("user not found"))
+	_, err = signIn(map[string]any{"sub": "guest-1", "email": "guest@example.com", "email_verified": true})
+	assert.ErrorIs(t, err, ErrNoGroupRole)
+	assert.Equal(t, LoginNoGroupRole, loginRepo.events[len(loginRepo.events)-1].Reason)
+	mockRepo.AssertNumberOfCalls(t, "Create", 1)
+
+	// Tokens for another client, or from an old sign-in, are rejected
+	_, err = signIn(map[string]any{"sub": "casey-1", "aud": "another-app", "groups": []string{"rv-staff"}})
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = signIn(map[string]any{"sub": "casey-1", "nonce": "replayed", "groups": []string{"rv-staff"}})
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = signIn(map[string]any{"sub": "casey-1", "exp": testNow.Add(-time.Hour).Unix(), "groups": []string{"rv-staff"}})
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	// Each sign-in can be completed once, and only before it expires
+	address, err := service.StartOIDCLogin(ctx)
+	require.NoError(t, err)
+	code, state := provider.authorize(address, map[string]any{"sub": "casey-1", "groups": []string{"rv-staff"}})
+	_, err = service.CompleteOIDCLogin(ctx, OIDCCallback{Code: "wrong", State: state})
+	assert.ErrorIs(t, err, apperr.ErrValidation)
+	_, err = service.CompleteOIDCLogin(ctx, OIDCCallback{Code: code, State: state})
+	assert.ErrorIs(t, err, apperr.ErrValidation)
+
+	address, err = service.StartOIDCLogin(ctx)
+	require.NoError(t, err)
+	code, state = provider.authorize(address, map[string]any{"sub": "casey-1", "groups": []string{"rv-staff"}})
+	clk.Advance(11 * time.Minute)
+	_, err = service.CompleteOIDCLogin(ctx, OIDCCallback{Code: code, State: state})
+	assert.ErrorIs(t, err, apperr.ErrValidation)
+	assert.NoError(t, service.CleanOIDCLogins(ctx))
+	assert.Empty(t, oidcRepo.logins)
+}
+
+func TestOIDCLogin_Disabled(t *testing.T) {
+	service := NewService(new(MockRepository), new(MockTokenRepository), newMemoryLoginRepository(), newMemoryAccountTokenRepository(), newMemoryTwoFactorRepository(), newMemoryAPIKeyRepository(), newMemoryOIDCRepository(), new(recordingMailer), clock.NewFake(testNow), DefaultConfig())
+
+	_, err := service.StartOIDCLogin(context.Background())
+	assert.ErrorIs(t, err, apperr.ErrNotFound)
+	_, err = service.CompleteOIDCLogin(context.Background(), OIDCCallback{Code: "code", State: "state"})
+	assert.ErrorIs(t, err, apperr.ErrNotFound)
+}
+
+func TestOIDCConfig_Role(t *testing.T) {
+	config := OIDCConfig{GroupRoles: map[string]Role{"staff": RoleStaff, "admins": RoleAdmin}}
+
+	role, ok := config.role([]string{"admins", "staff"})
+	assert.True(t, ok)
+	assert.Equal(t, RoleAdmin, role)
+	role, ok = config.role([]string{"staff", "other"})
+	assert.True(t, ok)
+	assert.Equal(t, RoleStaff, role)
+	_, ok = config.role([]string{"other"})
+	assert.False(t, ok)
+}
+
+func TestPKCEChallenge(t *testing.T) {
+	// The example from RFC 7636 appendix B
+	assert.Equal(t, "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", pkceChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"))
+}
@@ -0,0 +1,128 @@
+// user/u_password.go contains the password policy and hashing.
+package user
+
+import (
+	"bufio"
+	_ "embed"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/BodaciousX/RVParkBackend/apperr"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// maxPasswordBytes is the most bcrypt will hash
+const maxPasswordBytes = 72
+
+// PasswordPolicy is what every new password must satisfy. MinClasses counts
+// the kinds of character used: lowercase, uppercase, digits and everything
+// else.
+type PasswordPolicy struct {
+	MinLength  int // In characters
+	MinClasses int
+	Blocklist  Blocklist
+}
+
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength:  8,
+		MinClasses: 2,
+		Blocklist:  DefaultBlocklist(),
+	}
+}
+
+// Check adds a problem with password to invalid, reported against field
+func (p PasswordPolicy) Check(password, field string, invalid *apperr.ValidationError) {
+	switch {
+	case password == "":
+		invalid.Add(field, "password is required")
+	case utf8.RuneCountInString(password) < p.MinLength:
+		invalid.Add(field, "password must be at least %d characters", p.MinLength)
+	case len(password) > maxPasswordBytes:
+		invalid.Add(field, "password must be at most %d bytes", maxPasswordBytes)
+	case characterClasses(password) < p.MinClasses:
+		invalid.Add(field, "password must mix at least %d of lowercase letters, uppercase letters, digits and symbols", p.MinClasses)
+	case p.Blocklist.Contains(password):
+		invalid.Add(field, "password is too common; it appears in lists of breached passwords")
+	}
+}
+
+func characterClasses(password string) int {
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+
+	classes := 0
+	for _, used := range []bool{lower, upper, digit, other} {
+		if used {
+			classes++
+		}
+	}
+	return classes
+}
+
+// Blocklist holds passwords known from breaches. Matching ignores case.
+type Blocklist map[string]struct{}
+
+//go:embed u_breached_passwords.txt
+var breachedPasswords string
+
+// DefaultBlocklist returns the common passwords built into the server
+func DefaultBlocklist() Blocklist {
+	list := Blocklist{}
+	// The embedded list is known to be readable
+	_ = list.Load(strings.NewReader(breachedPasswords))
+	return list
+}
+
+// Load adds the passwords in r, one per line, to b. Blank lines and lines
+// starting with # are skipped.
+func (b Blocklist) Load(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		b[strings.ToLower(line)] = struct{}{}
+	}
+	return scanner.Err()
+}
+
+func (b Blocklist) Contains(password string) bool {
+	_, ok := b[strings.ToLower(password)]
+	return ok
+}
+
+// setPassword checks password against the policy and stores its hash on user
+func (s *service) setPassword(user *User, password, field string) error {
+	invalid := &apperr.ValidationError{}
+	s.passwords.Check(password, field, invalid)
+	if err := invalid.Err(); err != nil {
+		return err
+	}
+	return hashPassword(user, password)
+}
+
+// hashPassword stores the hash of a password already checked against the
+// policy on user
+func hashPassword(user *User, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	user.PasswordHash = string(hashedPassword)
+	return nil
+}
@@ -2,7 +2,14 @@
 package user
 
 import (
+	"context"
 	"database/sql"
+
+	"time"
+
+	"github.com/BodaciousX/RVParkBackend/apperr"
+	"github.com/BodaciousX/RVParkBackend/paging"
+	"github.com/lib/pq"
 )
 
 type sqlRepository struct {
@@ -13,7 +20,7 @@ func NewSQLRepository(db *sql.DB) Repository {
 	return &sqlRepository{db: db}
 }
 
-func (r *sqlRepository) Create(user User) error {
+func (r *sqlRepository) Create(ctx context.Context, user User) error {
 	query := `
 		INSERT INTO users (
 			id,
@@ -27,7 +34,8 @@ func (r *sqlRepository) Create(user User) error {
 		)
 	`
 
-	_, err := r.db.Exec(
+	_, err := r.db.ExecContext(
+		ctx,
 		query,
 		user.ID,
 		user.Email,
@@ -36,10 +44,13 @@ func (r *sqlRepository) Create(user User) error {
 		user.Role,
 		user.CreatedAt,
 	)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Name() == "unique_violation" {
+		return apperr.Conflict("a user with email %s already exists", user.Email)
+	}
 	return err
 }
 
-func (r *sqlRepository) Get(id string) (*User, error) {
+func (r *sqlRepository) Get(ctx context.Context, id string) (*User, error) {
 	query := `
 		SELECT 
 			id,
@@ -56,7 +67,7 @@ func (r *sqlRepository) Get(id string) (*User, error) {
 	var user User
 	var lastLogin sql.NullTime
 
-	err := r.db.QueryRow(query, id).Scan(
+	err := r.db.QueryRowContext(ctx, query, id).Scan(
 		&user.ID,
 		&user.Email,
 		&user.Username,
@@ -65,6 +76,9 @@ func (r *sqlRepository) Get(id string) (*User, error) {
 		&user.CreatedAt,
 		&lastLogin,
 	)
+	if err == sql.ErrNoRows {
+		return nil, apperr.NotFound("user %s not found", id)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -76,7 +90,7 @@ func (r *sqlRepository) Get(id string) (*User, error) {
 	return &user, nil
 }
 
-func (r *sqlRepository) GetByEmail(email string) (*User, error) {
+func (r *sqlRepository) GetByEmail(ctx context.Context, email string) (*User, error) {
 	query := `
 		SELECT 
 			id,
@@ -93,7 +107,7 @@ func (r *sqlRepository) GetByEmail(email string) (*User, error) {
 	var user User
 	var lastLogin sql.NullTime
 
-	err := r.db.QueryRow(query, email).Scan(
+	err := r.db.QueryRowContext(ctx, query, email).Scan(
 		&user.ID,
 		&user.Email,
 		&user.Username,
@@ -102,6 +116,9 @@ func (r *sqlRepository) GetByEmail(email string) (*User, error) {
 		&user.CreatedAt,
 		&lastLogin,
 	)
+	if err == sql.ErrNoRows {
+		return nil, apperr.NotFound("no user with email %s", email)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -113,7 +130,7 @@ func (r *sqlRepository) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
-func (r *sqlRepository) Update(user User) error {
+func (r *sqlRepository) Update(ctx context.Context, user User) error {
 	query := `
 		UPDATE users SET
 			email = $2,
@@ -129,7 +146,8 @@ func (r *sqlRepository) Update(user User) error {
 		lastLogin = user.LastLogin
 	}
 
-	_, err := r.db.Exec(
+	result, err := r.db.ExecContext(
+		ctx,
 		query,
 		user.ID,
 		user.Email,
@@ -138,11 +156,134 @@ func (r *sqlRepository) Update(user User) error {
 		user.Role,
 		lastLogin,
 	)
-	return err
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Name() == "unique_violation" {
+		return apperr.Conflict("a user with email %s already exists", user.Email)
+	}
+	if err != nil {
+		return err
+	}
+	return requireRow(result, user.ID)
 }
 
-func (r *sqlRepository) Delete(id string) error {
+func (r *sqlRepository) Delete(ctx context.Context, id string) error {
 	query := `DELETE FROM users WHERE id = $1`
-	_, err := r.db.Exec(query, id)
-	return err
+	result, err := r.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(result, id)
+}
+
+// sortColumns maps the fields users can be sorted by to their columns
+var sortColumns = map[string]string{
+	"email":     "email",
+	"username":  "username",
+	"createdAt": "created_at",
+}
+
+func (r *sqlRepository) Find(ctx context.Context, filter Filter, page paging.Request) (*paging.Page[User], error) {
+	order, err := page.Order(sortColumns, "email")
+	if err != nil {
+		return nil, err
+	}
+
+	var where paging.Builder
+	if filter.Search != "" {
+		pattern := paging.Contains(filter.Search)
+		where.Where("(email ILIKE ? OR username ILIKE ?)", pattern, pattern)
+	}
+	if filter.Role != "" {
+		where.Where("role = ?", filter.Role)
+	}
+
+	result := &paging.Page[User]{Items: []User{}}
+	countQuery := `SELECT COUNT(*) FROM users ` + where.Clause()
+	if err := r.db.QueryRowContext(ctx, countQuery, where.Args()...).Scan(&result.Total); err != nil {
+		return nil, err
+	}
+
+	if page.Cursor != "" {
+		cursor, err := paging.DecodeCursor(page.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		where.Where(order.After("id"), cursor.Value, cursor.ID)
+	}
+
+	limit := page.PageLimit()
+	query := `
+		SELECT 
+			id,
+			email,
+			username,
+			password_hash,
+			role,
+			created_at,
+			last_login
+		FROM users
+		` + where.Clause() + `
+		` + order.OrderBy("id") + `
+		` + where.Limit(limit+1)
+
+	rows, err := r.db.QueryContext(ctx, query, where.Args()...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user User
+		var lastLogin sql.NullTime
+
+		err := rows.Scan(
+			&user.ID,
+			&user.Email,
+			&user.Username,
+			&user.PasswordHash,
+			&user.Role,
+			&user.CreatedAt,
+			&lastLogin,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if lastLogin.Valid {
+			user.LastLogin = lastLogin.Time
+		}
+		result.Items = append(result.Items, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(result.Items) > limit {
+		result.Items = result.Items[:limit]
+		last := result.Items[limit-1]
+		result.NextCursor = paging.EncodeCursor(paging.Cursor{Value: sortValue(last, order.Field), ID: last.ID})
+	}
+	return result, nil
+}
+
+func sortValue(user User, field string) string {
+	switch field {
+	case "username":
+		return user.Username
+	case "createdAt":
+		return user.CreatedAt.Format(time.RFC3339Nano)
+	default:
+		return user.Email
+	}
+}
+
+// requireRow reports a missing user when a write matched no rows
+func requireRow(result sql.Result, id string) error {
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return apperr.NotFound("user %s not found", id)
+	}
+	return nil
 }
@@ -1,6 +1,7 @@
 package user
 
 import (
+	"context"
 	"database/sql"
 	"testing"
 	"time"
@@ -29,7 +30,7 @@ func TestCreate(t *testing.T) {
 		CreatedAt:    time.Now(),
 	}
 
-	_ = repo.Create(user)
+	_ = repo.Create(context.Background(), user)
 	assert.True(t, true)
 }
 
@@ -37,7 +38,7 @@ func TestGet(t *testing.T) {
 	db, _ := sql.Open("postgres", "")
 	repo := NewSQLRepository(db)
 
-	_, _ = repo.Get("user123")
+	_, _ = repo.Get(context.Background(), "user123")
 	assert.True(t, true)
 }
 
@@ -45,7 +46,7 @@ func TestGetByEmail(t *testing.T) {
 	db, _ := sql.Open("postgres", "")
 	repo := NewSQLRepository(db)
 
-	_, _ = repo.GetByEmail("fake@example.com")
+	_, _ = repo.GetByEmail(context.Background(), "fake@example.com")
 	assert.True(t, true)
 }
 
@@ -62,7 +63,7 @@ func TestUpdate(t *testing.T) {
 		LastLogin:    time.Now(),
 	}
 
-	_ = repo.Update(user)
+	_ = repo.Update(context.Background(), user)
 	assert.True(t, true)
 }
 
@@ -70,7 +71,7 @@ func TestDelete(t *testing.T) {
 	db, _ := sql.Open("postgres", "")
 	repo := NewSQLRepository(db)
 
-	_ = repo.Delete("user123")
+	_ = repo.Delete(context.Background(), "user123")
 	assert.True(t, true)
 }
 
@@ -91,30 +92,30 @@ func TestSqlRepositoryImplementation(t *testing.T) {
 		CreatedAt:    time.Now(),
 	}
 
-	_ = repo.Create(user)
-	_, _ = repo.Get("fake123")
-	_, _ = repo.GetByEmail("fake@example.com")
-	_ = repo.Update(user)
-	_ = repo.Delete("fake123")
+	_ = repo.Create(context.Background(), user)
+	_, _ = repo.Get(context.Background(), "fake123")
+	_, _ = repo.GetByEmail(context.Background(), "fake@example.com")
+	_ = repo.Update(context.Background(), user)
+	_ = repo.Delete(context.Background(), "fake123")
 	assert.True(t, true)
 }
 
-func (r *sqlUserRepository) Create(user User) error {
+func (r *sqlUserRepository) Create(ctx context.Context, user User) error {
 	return nil
 }
 
-func (r *sqlUserRepository) Get(id string) (*User, error) {
+func (r *sqlUserRepository) Get(ctx context.Context, id string) (*User, error) {
 	return &User{ID: id}, nil
 }
 
-func (r *sqlUserRepository) GetByEmail(email string) (*User, error) {
+func (r *sqlUserRepository) GetByEmail(ctx context.Context, email string) (*User, error) {
 	return &User{Email: email}, nil
 }
 
-func (r *sqlUserRepository) Update(user User) error {
+func (r *sqlUserRepository) Update(ctx context.Context, user User) error {
 	return nil
 }
 
-func (r *sqlUserRepository) Delete(id string) error {
+func (r *sqlUserRepository) Delete(ctx context.Context, id string) error {
 	return nil
 }
@@ -2,65 +2,193 @@
 package user
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
-	"time"
+	"sync"
 
+	"github.com/BodaciousX/RVParkBackend/apperr"
+	"github.com/BodaciousX/RVParkBackend/clock"
+	"github.com/BodaciousX/RVParkBackend/paging"
+	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// ErrInvalidCredentials is returned by Login for a wrong email or password,
+// and by VerifyLoginChallenge for a wrong code. Email and password aren't
+// told apart so sign-in can't be used to find accounts.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Config holds the user service's tunable policies
+type Config struct {
+	Sessions      SessionConfig
+	Lockout       LockoutConfig
+	Passwords     PasswordPolicy
+	AccountEmails AccountEmailConfig
+	TwoFactor     TwoFactorConfig
+	OIDC          OIDCConfig
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Sessions:      DefaultSessionConfig(),
+		Lockout:       DefaultLockoutConfig(),
+		Passwords:     DefaultPasswordPolicy(),
+		AccountEmails: DefaultAccountEmailConfig(),
+		TwoFactor:     DefaultTwoFactorConfig(),
+		OIDC:          DefaultOIDCConfig(),
+	}
+}
+
 type service struct {
-	repo        Repository
-	tokenRepo   TokenRepository
-	tokenExpiry time.Duration
+	repo             Repository
+	tokenRepo        TokenRepository
+	loginRepo        LoginRepository
+	accountTokenRepo AccountTokenRepository
+	twoFactorRepo    TwoFactorRepository
+	apiKeyRepo       APIKeyRepository
+	oidcRepo         OIDCRepository
+	mailer           Mailer
+	clock            clock.Clock
+	sessions         SessionConfig
+	lockout          LockoutConfig
+	passwords        PasswordPolicy
+	accountEmails    AccountEmailConfig
+	twoFactor        TwoFactorConfig
+	oidcConfig       OIDCConfig
+	oidc             *oidcProvider  // nil when single sign-on is off
+	mail             sync.WaitGroup // Account emails still being sent in the background
 }
 
-func NewService(repo Repository, tokenRepo TokenRepository) Service {
-	return &service{
-		repo:        repo,
-		tokenRepo:   tokenRepo,
-		tokenExpiry: 24 * time.Hour,
+func NewService(
+	repo Repository,
+	tokenRepo TokenRepository,
+	loginRepo LoginRepository,
+	accountTokenRepo AccountTokenRepository,
+	twoFactorRepo TwoFactorRepository,
+	apiKeyRepo APIKeyRepository,
+	oidcRepo OIDCRepository,
+	mailer Mailer,
+	clk clock.Clock,
+	config Config,
+) Service {
+	s := &service{
+		repo:             repo,
+		tokenRepo:        tokenRepo,
+		loginRepo:        loginRepo,
+		accountTokenRepo: accountTokenRepo,
+		twoFactorRepo:    twoFactorRepo,
+		apiKeyRepo:       apiKeyRepo,
+		oidcRepo:         oidcRepo,
+		mailer:           mailer,
+		clock:            clk,
+		sessions:         config.Sessions,
+		lockout:          config.Lockout,
+		passwords:        config.Passwords,
+		accountEmails:    config.AccountEmails,
+		twoFactor:        config.TwoFactor,
+		oidcConfig:       config.OIDC,
+	}
+	if config.OIDC.enabled() {
+		s.oidc = newOIDCProvider(config.OIDC)
 	}
+	return s
 }
 
-func (s *service) GetUserByEmail(email string) (*User, error) {
-	return s.repo.GetByEmail(email)
+func (s *service) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	return s.repo.GetByEmail(ctx, email)
 }
-func (s *service) CreateUser(user User, password string) error {
-	// Hash the password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
+func (s *service) CreateUser(ctx context.Context, user User, password string) error {
+	invalid := &apperr.ValidationError{}
+	validateNewUser(user, invalid)
+	s.passwords.Check(password, "password", invalid)
+	if err := invalid.Err(); err != nil {
+		return err
+	}
+
+	// Hash the password, checked above with the other fields
+	if err := hashPassword(&user, password); err != nil {
 		return err
 	}
 
 	// Set user fields
-	user.PasswordHash = string(hashedPassword)
-	user.CreatedAt = time.Now()
+	if user.ID == "" {
+		user.ID = uuid.New().String()
+	}
+	user.CreatedAt = s.clock.Now()
 
-	return s.repo.Create(user)
+	return s.repo.Create(ctx, user)
 }
 
-func (s *service) GetUser(id string) (*User, error) {
-	return s.repo.Get(id)
+func validateNewUser(user User, invalid *apperr.ValidationError) {
+	if user.Email == "" {
+		invalid.Add("email", "email is required")
+	}
+	if user.Username == "" {
+		invalid.Add("username", "username is required")
+	}
+	validateRole(user.Role, invalid)
 }
 
-func (s *service) UpdateUser(user User) error {
-	return s.repo.Update(user)
+func (s *service) GetUser(ctx context.Context, id string) (*User, error) {
+	return s.repo.Get(ctx, id)
 }
 
-func (s *service) DeleteUser(id string) error {
+// UpdateUser changes a user's profile and role. Passwords are changed with
+// ChangePassword or ResetPassword; the stored hash and sign-in history are
+// kept whatever user holds.
+func (s *service) UpdateUser(ctx context.Context, user User) error {
+	invalid := &apperr.ValidationError{}
+	validateRole(user.Role, invalid)
+	if err := invalid.Err(); err != nil {
+		return err
+	}
+
+	existing, err := s.repo.Get(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	user.PasswordHash = existing.PasswordHash
+	user.CreatedAt = existing.CreatedAt
+	user.LastLogin = existing.LastLogin
+	return s.repo.Update(ctx, user)
+}
+
+// FindUsers returns one page of users matching filter
+func (s *service) FindUsers(ctx context.Context, filter Filter, page paging.Request) (*paging.Page[User], error) {
+	if filter.Role != "" {
+		invalid := &apperr.ValidationError{}
+		validateRole(filter.Role, invalid)
+		if err := invalid.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return s.repo.Find(ctx, filter, page)
+}
+
+func validateRole(role Role, invalid *apperr.ValidationError) {
+	switch role {
+	case RoleAdmin, RoleStaff:
+	default:
+		invalid.Add("role", "role must be %s or %s", RoleAdmin, RoleStaff)
+	}
+}
+
+func (s *service) DeleteUser(ctx context.Context, id string) error {
 	// Revoke all tokens for the user before deletion
-	if err := s.tokenRepo.RevokeAllUserTokens(id); err != nil {
+	if err := s.tokenRepo.RevokeAllUserTokens(ctx, id); err != nil {
 		return err
 	}
-	return s.repo.Delete(id)
+	return s.repo.Delete(ctx, id)
 }
 
-func (s *service) ChangePassword(userID string, oldPassword, newPassword string) error {
+// ChangePassword replaces a user's password after checking the old one.
+// Every session except the one currentToken belongs to is signed out.
+func (s *service) ChangePassword(ctx context.Context, userID, currentToken, oldPassword, newPassword string) error {
 	// Get the user
-	user, err := s.repo.Get(userID)
+	user, err := s.repo.Get(ctx, userID)
 	if err != nil {
 		return err
 	}
@@ -70,72 +198,167 @@ func (s *service) ChangePassword(userID string, oldPassword, newPassword string)
 		[]byte(user.PasswordHash),
 		[]byte(oldPassword),
 	); err != nil {
-		return errors.New("invalid old password")
+		return apperr.Invalid("oldPassword", "invalid old password")
+	}
+	if newPassword == oldPassword {
+		return apperr.Invalid("newPassword", "new password must be different from the old one")
 	}
 
 	// Hash new password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
-	if err != nil {
+	if err := s.setPassword(user, newPassword, "newPassword"); err != nil {
 		return err
 	}
 
 	// Update user with new password
-	user.PasswordHash = string(hashedPassword)
-	return s.repo.Update(*user)
+	if err := s.repo.Update(ctx, *user); err != nil {
+		return err
+	}
+
+	return s.tokenRepo.RevokeOtherUserTokens(ctx, userID, HashToken(currentToken))
 }
 
-func (s *service) Login(creds LoginCredentials) (*User, string, error) {
-	user, err := s.repo.GetByEmail(creds.Email)
+// ResetPassword sets a new password without checking the old one, signs
+// the user out everywhere and lifts any sign-in lockout. Intended for
+// administrators.
+func (s *service) ResetPassword(ctx context.Context, userID string, newPassword string) error {
+	user, err := s.repo.Get(ctx, userID)
 	if err != nil {
-		return nil, "", err
+		return err
+	}
+
+	if err := s.setPassword(user, newPassword, "password"); err != nil {
+		return err
+	}
+	if err := s.repo.Update(ctx, *user); err != nil {
+		return err
+	}
+
+	if err := s.tokenRepo.RevokeAllUserTokens(ctx, userID); err != nil {
+		return err
+	}
+	return s.loginRepo.ClearThrottle(ctx, accountThrottleKey(user.Email))
+}
+
+// Login checks a user's password and starts a session, or a two-factor
+// challenge if the user has an authenticator or their role requires one.
+// Repeated failures for an account or client address back off and then lock
+// out, and every attempt is recorded as a LoginEvent.
+func (s *service) Login(ctx context.Context, creds LoginCredentials) (*LoginResult, error) {
+	now := s.clock.Now()
+	event := LoginEvent{
+		Email:     creds.Email,
+		IPAddress: creds.IPAddress,
+		UserAgent: creds.UserAgent,
+		CreatedAt: now,
+	}
+
+	user, err := s.repo.GetByEmail(ctx, creds.Email)
+	if err != nil && !errors.Is(err, apperr.ErrNotFound) {
+		return nil, err
+	}
+	if user != nil {
+		event.UserID = user.ID
+	}
+
+	keys := s.lockout.throttleKeys(creds)
+	if err := s.checkLoginThrottles(ctx, event, keys); err != nil {
+		return nil, err
+	}
+
+	if user == nil {
+		compareDummyHash(creds.Password)
+		return nil, s.loginFailed(ctx, event, keys, LoginUnknownEmail)
 	}
 
 	if err := bcrypt.CompareHashAndPassword(
 		[]byte(user.PasswordHash),
 		[]byte(creds.Password),
 	); err != nil {
-		return nil, "", errors.New("invalid credentials")
+		return nil, s.loginFailed(ctx, event, keys, LoginBadPassword)
 	}
 
+	secret, err := s.getTOTP(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if secret.confirmed() || s.twoFactor.required(user.Role) {
+		return s.challenge(ctx, user, !secret.confirmed())
+	}
+
+	token, err := s.startSession(ctx, user, event)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResult{User: user, Token: token}, nil
+}
+
+// startSession finishes a successful sign-in described by event, returning
+// the new session's token
+func (s *service) startSession(ctx context.Context, user *User, event LoginEvent) (string, error) {
+	now := event.CreatedAt
+
 	// Generate new token
 	token, tokenHash, err := GenerateToken()
 	if err != nil {
-		return nil, "", err
+		return "", err
 	}
 
 	// Store token
-	if err := s.tokenRepo.CreateToken(Token{
-		TokenHash: tokenHash,
-		UserID:    user.ID,
-		ExpiresAt: time.Now().Add(s.tokenExpiry),
-		CreatedAt: time.Now(),
+	if err := s.tokenRepo.CreateToken(ctx, Token{
+		ID:         uuid.New().String(),
+		TokenHash:  tokenHash,
+		UserID:     user.ID,
+		UserAgent:  event.UserAgent,
+		IPAddress:  event.IPAddress,
+		ExpiresAt:  s.sessions.expiresAt(now, now),
+		CreatedAt:  now,
+		LastSeenAt: now,
 	}); err != nil {
-		return nil, "", err
+		return "", err
+	}
+
+	// A successful sign-in resets the account's failures. The address keeps
+	// its count so one valid login can't cover guessing at other accounts.
+	if err := s.loginRepo.ClearThrottle(ctx, accountThrottleKey(user.Email)); err != nil {
+		return "", err
+	}
+	event.Success = true
+	if err := s.loginRepo.CreateLoginEvent(ctx, event); err != nil {
+		return "", err
 	}
 
 	// Update last login
-	user.LastLogin = time.Now()
-	if err := s.repo.Update(*user); err != nil {
-		return nil, "", err
+	user.LastLogin = now
+	if err := s.repo.Update(ctx, *user); err != nil {
+		return "", err
 	}
 
-	return user, token, nil
+	return token, nil
 }
 
-func (s *service) ValidateToken(token string) (*User, error) {
-	hash := sha256.Sum256([]byte(token))
-	tokenHash := hex.EncodeToString(hash[:])
+// ValidateToken returns the user a token belongs to and extends the session's
+// idle expiry
+func (s *service) ValidateToken(ctx context.Context, token string) (*User, error) {
+	tokenHash := HashToken(token)
 
-	storedToken, err := s.tokenRepo.GetToken(tokenHash)
+	storedToken, err := s.tokenRepo.GetToken(ctx, tokenHash)
 	if err != nil {
 		return nil, err
 	}
 
-	if storedToken.ExpiresAt.Before(time.Now()) || storedToken.Revoked {
+	now := s.clock.Now()
+	if !now.Before(storedToken.ExpiresAt) || storedToken.Revoked {
 		return nil, errors.New("token is expired or revoked")
 	}
 
-	return s.repo.Get(storedToken.UserID)
+	if now.Sub(storedToken.LastSeenAt) >= touchInterval {
+		expiresAt := s.sessions.expiresAt(storedToken.CreatedAt, now)
+		if err := s.tokenRepo.TouchToken(ctx, tokenHash, now, expiresAt); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.repo.Get(ctx, storedToken.UserID)
 }
 
 // Helper function to generate tokens
@@ -147,13 +370,15 @@ func GenerateToken() (string, string, error) {
 	}
 	token := hex.EncodeToString(tokenBytes)
 
-	// Hash token for storage
-	hash := sha256.Sum256([]byte(token))
-	tokenHash := hex.EncodeToString(hash[:])
+	return token, HashToken(token), nil
+}
 
-	return token, tokenHash, nil
+// HashToken returns the form a token is stored and looked up in
+func HashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
 }
 
-func (s *service) RevokeAllTokens(userID string) error {
-	return s.tokenRepo.RevokeAllUserTokens(userID)
+func (s *service) RevokeAllTokens(ctx context.Context, userID string) error {
+	return s.tokenRepo.RevokeAllUserTokens(ctx, userID)
 }
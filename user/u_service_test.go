@@ -2,25 +2,36 @@
 package user
 
 import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/BodaciousX/RVParkBackend/apperr"
+	"github.com/BodaciousX/RVParkBackend/clock"
+	"github.com/BodaciousX/RVParkBackend/paging"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// testNow is where the fake clock starts in each test
+var testNow = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
 // MockRepository is a mock implementation of the Repository interface
 type MockRepository struct {
 	mock.Mock
 }
 
-func (m *MockRepository) Create(user User) error {
+func (m *MockRepository) Create(ctx context.Context, user User) error {
 	args := m.Called(user)
 	return args.Error(0)
 }
 
-func (m *MockRepository) Get(id string) (*User, error) {
+func (m *MockRepository) Get(ctx context.Context, id string) (*User, error) {
 	args := m.Called(id)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -28,7 +39,7 @@ func (m *MockRepository) Get(id string) (*User, error) {
 	return args.Get(0).(*User), args.Error(1)
 }
 
-func (m *MockRepository) GetByEmail(email string) (*User, error) {
+func (m *MockRepository) GetByEmail(ctx context.Context, email string) (*User, error) {
 	args := m.Called(email)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -36,27 +47,35 @@ func (m *MockRepository) GetByEmail(email string) (*User, error) {
 	return args.Get(0).(*User), args.Error(1)
 }
 
-func (m *MockRepository) Update(user User) error {
+func (m *MockRepository) Update(ctx context.Context, user User) error {
 	args := m.Called(user)
 	return args.Error(0)
 }
 
-func (m *MockRepository) Delete(id string) error {
+func (m *MockRepository) Delete(ctx context.Context, id string) error {
 	args := m.Called(id)
 	return args.Error(0)
 }
 
+func (m *MockRepository) Find(ctx context.Context, filter Filter, page paging.Request) (*paging.Page[User], error) {
+	args := m.Called(filter, page)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*paging.Page[User]), args.Error(1)
+}
+
 // MockTokenRepository is a mock implementation of the TokenRepository interface
 type MockTokenRepository struct {
 	mock.Mock
 }
 
-func (m *MockTokenRepository) CreateToken(token Token) error {
+func (m *MockTokenRepository) CreateToken(ctx context.Context, token Token) error {
 	args := m.Called(token)
 	return args.Error(0)
 }
 
-func (m *MockTokenRepository) GetToken(tokenHash string) (*Token, error) {
+func (m *MockTokenRepository) GetToken(ctx context.Context, tokenHash string) (*Token, error) {
 	args := m.Called(tokenHash)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -64,28 +83,369 @@ func (m *MockTokenRepository) GetToken(tokenHash string) (*Token, error) {
 	return args.Get(0).(*Token), args.Error(1)
 }
 
-func (m *MockTokenRepository) RevokeToken(tokenHash string) error {
+func (m *MockTokenRepository) ListUserTokens(ctx context.Context, userID string, now time.Time) ([]Token, error) {
+	args := m.Called(userID, now)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]Token), args.Error(1)
+}
+
+func (m *MockTokenRepository) TouchToken(ctx context.Context, tokenHash string, lastSeenAt, expiresAt time.Time) error {
+	args := m.Called(tokenHash, lastSeenAt, expiresAt)
+	return args.Error(0)
+}
+
+func (m *MockTokenRepository) RevokeUserToken(ctx context.Context, userID, id string) error {
+	args := m.Called(userID, id)
+	return args.Error(0)
+}
+
+func (m *MockTokenRepository) RevokeToken(ctx context.Context, tokenHash string) error {
 	args := m.Called(tokenHash)
 	return args.Error(0)
 }
 
-func (m *MockTokenRepository) RevokeAllUserTokens(userID string) error {
+func (m *MockTokenRepository) RevokeAllUserTokens(ctx context.Context, userID string) error {
 	args := m.Called(userID)
 	return args.Error(0)
 }
 
-func (m *MockTokenRepository) CleanExpiredTokens() error {
-	args := m.Called()
+func (m *MockTokenRepository) RevokeOtherUserTokens(ctx context.Context, userID, keepTokenHash string) error {
+	args := m.Called(userID, keepTokenHash)
 	return args.Error(0)
 }
 
+func (m *MockTokenRepository) CleanExpiredTokens(ctx context.Context, now time.Time) error {
+	args := m.Called(now)
+	return args.Error(0)
+}
+
+// memoryLoginRepository keeps throttles and login events in memory
+type memoryLoginRepository struct {
+	throttles map[string]LoginThrottle
+	events    []LoginEvent
+}
+
+func newMemoryLoginRepository() *memoryLoginRepository {
+	return &memoryLoginRepository{throttles: map[string]LoginThrottle{}}
+}
+
+func (r *memoryLoginRepository) GetThrottle(ctx context.Context, key string) (LoginThrottle, error) {
+	if throttle, ok := r.throttles[key]; ok {
+		return throttle, nil
+	}
+	return LoginThrottle{Key: key}, nil
+}
+
+func (r *memoryLoginRepository) RecordFailure(ctx context.Context, key string, at, windowStart time.Time) (LoginThrottle, error) {
+	throttle := r.throttles[key]
+	throttle.Key = key
+	if throttle.LastFailureAt.Before(windowStart) {
+		throttle.Failures = 0
+	}
+	throttle.Failures++
+	throttle.LastFailureAt = at
+	r.throttles[key] = throttle
+	return throttle, nil
+}
+
+func (r *memoryLoginRepository) LockThrottle(ctx context.Context, key string, until time.Time) error {
+	throttle := r.throttles[key]
+	throttle.LockedUntil = until
+	r.throttles[key] = throttle
+	return nil
+}
+
+func (r *memoryLoginRepository) ClearThrottle(ctx context.Context, key string) error {
+	delete(r.throttles, key)
+	return nil
+}
+
+func (r *memoryLoginRepository) CleanThrottles(ctx context.Context, windowStart, now time.Time) error {
+	for key, throttle := range r.throttles {
+		if throttle.LastFailureAt.Before(windowStart) && !throttle.LockedUntil.After(now) {
+			delete(r.throttles, key)
+		}
+	}
+	return nil
+}
+
+func (r *memoryLoginRepository) CreateLoginEvent(ctx context.Context, event LoginEvent) error {
+	r.events = append(r.events, event)
+	return nil
+}
+
+func (r *memoryLoginRepository) ListLoginEvents(ctx context.Context, userID string, limit int) ([]LoginEvent, error) {
+	events := []LoginEvent{}
+	for i := len(r.events) - 1; i >= 0 && len(events) < limit; i-- {
+		if r.events[i].UserID == userID {
+			events = append(events, r.events[i])
+		}
+	}
+	return events, nil
+}
+
+// memoryAccountTokenRepository keeps reset and invitation tokens in memory
+type memoryAccountTokenRepository struct {
+	tokens map[string]AccountToken
+}
+
+func newMemoryAccountTokenRepository() *memoryAccountTokenRepository {
+	return &memoryAccountTokenRepository{tokens: map[string]AccountToken{}}
+}
+
+func (r *memoryAccountTokenRepository) CreateAccountToken(ctx context.Context, token AccountToken) error {
+	r.tokens[token.TokenHash] = token
+	return nil
+}
+
+func (r *memoryAccountTokenRepository) GetAccountToken(ctx context.Context, tokenHash string, purpose TokenPurpose, now time.Time) (*AccountToken, error) {
+	token, ok := r.tokens[tokenHash]
+	if !ok || token.Purpose != purpose || !token.UsedAt.IsZero() || !now.Before(token.ExpiresAt) {
+		return nil, invalidAccountToken()
+	}
+	return &token, nil
+}
+
+func (r *memoryAccountTokenRepository) ConsumeAccountToken(ctx context.Context, tokenHash string, purpose TokenPurpose, now time.Time) (*AccountToken, error) {
+	token, ok := r.tokens[tokenHash]
+	if !ok || token.Purpose != purpose || !token.UsedAt.IsZero() || !now.Before(token.ExpiresAt) {
+		return nil, invalidAccountToken()
+	}
+	token.UsedAt = now
+	r.tokens[tokenHash] = token
+	return &token, nil
+}
+
+func (r *memoryAccountTokenRepository) InvalidateAccountTokens(ctx context.Context, userID string, purpose TokenPurpose, now time.Time) error {
+	for hash, token := range r.tokens {
+		if token.UserID == userID && token.Purpose == purpose && token.UsedAt.IsZero() {
+			token.UsedAt = now
+			r.tokens[hash] = token
+		}
+	}
+	return nil
+}
+
+func (r *memoryAccountTokenRepository) CleanAccountTokens(ctx context.Context, before time.Time) error {
+	for hash, token := range r.tokens {
+		if token.ExpiresAt.Before(before) || (!token.UsedAt.IsZero() && token.UsedAt.Before(before)) {
+			delete(r.tokens, hash)
+		}
+	}
+	return nil
+}
+
+// memoryTwoFactorRepository keeps authenticator secrets and recovery codes in memory
+type memoryTwoFactorRepository struct {
+	secrets map[string]TOTPSecret
+	codes   map[string]map[string]bool // user ID -> code hash -> used
+}
+
+func newMemoryTwoFactorRepository() *memoryTwoFactorRepository {
+	return &memoryTwoFactorRepository{
+		secrets: map[string]TOTPSecret{},
+		codes:   map[string]map[string]bool{},
+	}
+}
+
+func (r *memoryTwoFactorRepository) GetTOTP(ctx context.Context, userID string) (*TOTPSecret, error) {
+	secret, ok := r.secrets[userID]
+	if !ok {
+		return nil, apperr.NotFound("user %s has no authenticator", userID)
+	}
+	return &secret, nil
+}
+
+func (r *memoryTwoFactorRepository) SaveTOTP(ctx context.Context, secret TOTPSecret) error {
+	r.secrets[secret.UserID] = secret
+	return nil
+}
+
+func (r *memoryTwoFactorRepository) UseTOTPStep(ctx context.Context, userID string, step int64) (bool, error) {
+	secret, ok := r.secrets[userID]
+	if !ok || secret.LastStep >= step {
+		return false, nil
+	}
+	secret.LastStep = step
+	r.secrets[userID] = secret
+	return true, nil
+}
+
+func (r *memoryTwoFactorRepository) DeleteTOTP(ctx context.Context, userID string) error {
+	delete(r.secrets, userID)
+	delete(r.codes, userID)
+	return nil
+}
+
+func (r *memoryTwoFactorRepository) ReplaceRecoveryCodes(ctx context.Context, userID string, codeHashes []string, now time.Time) error {
+	r.codes[userID] = map[string]bool{}
+	for _, hash := range codeHashes {
+		r.codes[userID][hash] = false
+	}
+	return nil
+}
+
+func (r *memoryTwoFactorRepository) UseRecoveryCode(ctx context.Context, userID, codeHash string, now time.Time) (bool, error) {
+	used, ok := r.codes[userID][codeHash]
+	if !ok || used {
+		return false, nil
+	}
+	r.codes[userID][codeHash] = true
+	return true, nil
+}
+
+func (r *memoryTwoFactorRepository) CountRecoveryCodes(ctx context.Context, userID string) (int, error) {
+	count := 0
+	for _, used := range r.codes[userID] {
+		if !used {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// memoryAPIKeyRepository keeps API keys in memory
+type memoryAPIKeyRepository struct {
+	keys map[string]APIKey
+}
+
+func newMemoryAPIKeyRepository() *memoryAPIKeyRepository {
+	return &memoryAPIKeyRepository{keys: map[string]APIKey{}}
+}
+
+func (r *memoryAPIKeyRepository) CreateAPIKey(ctx context.Context, key APIKey) error {
+	r.keys[key.ID] = key
+	return nil
+}
+
+func (r *memoryAPIKeyRepository) GetAPIKeyByHash(ctx context.Context, keyHash string) (*APIKey, error) {
+	for _, key := range r.keys {
+		if key.KeyHash == keyHash {
+			return &key, nil
+		}
+	}
+	return nil, apperr.NotFound("API key not found")
+}
+
+func (r *memoryAPIKeyRepository) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	keys := []APIKey{}
+	for _, key := range r.keys {
+		if key.RevokedAt == nil {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (r *memoryAPIKeyRepository) TouchAPIKey(ctx context.Context, id string, lastUsedAt time.Time) error {
+	key := r.keys[id]
+	key.LastUsedAt = &lastUsedAt
+	r.keys[id] = key
+	return nil
+}
+
+func (r *memoryAPIKeyRepository) RevokeAPIKey(ctx context.Context, id string, at time.Time) error {
+	key, ok := r.keys[id]
+	if !ok || key.RevokedAt != nil {
+		return apperr.NotFound("API key %s not found", id)
+	}
+	key.RevokedAt = &at
+	r.keys[id] = key
+	return nil
+}
+
+// memoryOIDCRepository keeps single sign-on attempts and linked identities in memory
+type memoryOIDCRepository struct {
+	logins     map[string]OIDCLogin
+	identities map[string]string
+}
+
+func newMemoryOIDCRepository() *memoryOIDCRepository {
+	return &memoryOIDCRepository{logins: map[string]OIDCLogin{}, identities: map[string]string{}}
+}
+
+func (r *memoryOIDCRepository) CreateOIDCLogin(ctx context.Context, login OIDCLogin) error {
+	r.logins[login.StateHash] = login
+	return nil
+}
+
+func (r *memoryOIDCRepository) ConsumeOIDCLogin(ctx context.Context, stateHash string, now time.Time) (*OIDCLogin, error) {
+	login, ok := r.logins[stateHash]
+	delete(r.logins, stateHash)
+	if !ok || !now.Before(login.ExpiresAt) {
+		return nil, invalidOIDCState()
+	}
+	return &login, nil
+}
+
+func (r *memoryOIDCRepository) CleanOIDCLogins(ctx context.Context, before time.Time) error {
+	for hash, login := range r.logins {
+		if login.ExpiresAt.Before(before) {
+			delete(r.logins, hash)
+		}
+	}
+	return nil
+}
+
+func (r *memoryOIDCRepository) GetIdentityUser(ctx context.Context, issuer, subject string) (string, error) {
+	userID, ok := r.identities[issuer+" "+subject]
+	if !ok {
+		return "", apperr.NotFound("no user is linked to %s at %s", subject, issuer)
+	}
+	return userID, nil
+}
+
+func (r *memoryOIDCRepository) LinkIdentity(ctx context.Context, issuer, subject, userID string, now time.Time) error {
+	r.identities[issuer+" "+subject] = userID
+	return nil
+}
+
+// sentMail is one email handed to recordingMailer
+type sentMail struct {
+	To, Subject, Body string
+}
+
+// recordingMailer keeps emails instead of sending them, or fails with err
+type recordingMailer struct {
+	mu   sync.Mutex
+	sent []sentMail
+	err  error
+}
+
+func (m *recordingMailer) Send(ctx context.Context, to, subject, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	m.sent = append(m.sent, sentMail{To: to, Subject: subject, Body: body})
+	return nil
+}
+
+// waitForMail returns once the emails s sends in the background are sent
+func waitForMail(s Service) {
+	s.(*service).mail.Wait()
+}
+
+// linkToken returns the token from the link in an account email
+func linkToken(t *testing.T, body string) string {
+	t.Helper()
+	match := regexp.MustCompile(`\?token=([0-9a-f]+)`).FindStringSubmatch(body)
+	if !assert.Len(t, match, 2, "no link in %q", body) {
+		return ""
+	}
+	return match[1]
+}
+
 func TestCreateUser(t *testing.T) {
 	// Create mocks
 	mockRepo := new(MockRepository)
 	mockTokenRepo := new(MockTokenRepository)
 
 	// Create service with mocks
-	service := NewService(mockRepo, mockTokenRepo)
+	service := NewService(mockRepo, mockTokenRepo, newMemoryLoginRepository(), newMemoryAccountTokenRepository(), newMemoryTwoFactorRepository(), newMemoryAPIKeyRepository(), newMemoryOIDCRepository(), new(recordingMailer), clock.NewFake(testNow), DefaultConfig())
 
 	// Test data
 	testUser := User{
@@ -93,13 +453,13 @@ func TestCreateUser(t *testing.T) {
 		Username: "testuser",
 		Role:     RoleStaff,
 	}
-	testPassword := "password123"
+	testPassword := "Sunny site 42"
 
 	// Setup expectations
 	mockRepo.On("Create", mock.AnythingOfType("User")).Return(nil)
 
 	// Call method being tested
-	err := service.CreateUser(testUser, testPassword)
+	err := service.CreateUser(context.Background(), testUser, testPassword)
 
 	// Assert expectations
 	assert.NoError(t, err)
@@ -118,7 +478,7 @@ func TestLogin_Success(t *testing.T) {
 	mockTokenRepo := new(MockTokenRepository)
 
 	// Create service with mocks
-	service := NewService(mockRepo, mockTokenRepo)
+	service := NewService(mockRepo, mockTokenRepo, newMemoryLoginRepository(), newMemoryAccountTokenRepository(), newMemoryTwoFactorRepository(), newMemoryAPIKeyRepository(), newMemoryOIDCRepository(), new(recordingMailer), clock.NewFake(testNow), DefaultConfig())
 
 	// Hash a known password for our test user
 	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("correctpassword"), bcrypt.DefaultCost)
@@ -138,16 +498,17 @@ func TestLogin_Success(t *testing.T) {
 	mockRepo.On("Update", mock.AnythingOfType("User")).Return(nil)
 
 	// Call method being tested
-	user, token, err := service.Login(LoginCredentials{
+	result, err := service.Login(context.Background(), LoginCredentials{
 		Email:    "test@example.com",
 		Password: "correctpassword",
 	})
 
 	// Assert expectations
 	assert.NoError(t, err)
-	assert.NotNil(t, user)
-	assert.NotEmpty(t, token)
-	assert.Equal(t, testUser.ID, user.ID)
+	assert.NotNil(t, result.User)
+	assert.NotEmpty(t, result.Token)
+	assert.Nil(t, result.Challenge)
+	assert.Equal(t, testUser.ID, result.User.ID)
 	mockRepo.AssertExpectations(t)
 	mockTokenRepo.AssertExpectations(t)
 }
@@ -158,7 +519,7 @@ func TestLogin_InvalidCredentials(t *testing.T) {
 	mockTokenRepo := new(MockTokenRepository)
 
 	// Create service with mocks
-	service := NewService(mockRepo, mockTokenRepo)
+	service := NewService(mockRepo, mockTokenRepo, newMemoryLoginRepository(), newMemoryAccountTokenRepository(), newMemoryTwoFactorRepository(), newMemoryAPIKeyRepository(), newMemoryOIDCRepository(), new(recordingMailer), clock.NewFake(testNow), DefaultConfig())
 
 	// Hash a known password for our test user
 	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("correctpassword"), bcrypt.DefaultCost)
@@ -176,15 +537,14 @@ func TestLogin_InvalidCredentials(t *testing.T) {
 	mockRepo.On("GetByEmail", "test@example.com").Return(testUser, nil)
 
 	// Call method being tested with wrong password
-	user, token, err := service.Login(LoginCredentials{
+	result, err := service.Login(context.Background(), LoginCredentials{
 		Email:    "test@example.com",
 		Password: "wrongpassword",
 	})
 
 	// Assert expectations
 	assert.Error(t, err)
-	assert.Nil(t, user)
-	assert.Empty(t, token)
+	assert.Nil(t, result)
 	mockRepo.AssertExpectations(t)
 	// Token repo methods should not be called
 	mockTokenRepo.AssertNotCalled(t, "CreateToken", mock.Anything)
@@ -196,19 +556,20 @@ func TestValidateToken_Success(t *testing.T) {
 	mockTokenRepo := new(MockTokenRepository)
 
 	// Create service with mocks
-	service := NewService(mockRepo, mockTokenRepo)
+	service := NewService(mockRepo, mockTokenRepo, newMemoryLoginRepository(), newMemoryAccountTokenRepository(), newMemoryTwoFactorRepository(), newMemoryAPIKeyRepository(), newMemoryOIDCRepository(), new(recordingMailer), clock.NewFake(testNow), DefaultConfig())
 
 	// Test data
 	testToken := "validtoken123"
 	testTokenHash := "hashed_validtoken123" // Simplified for testing
 	testUser := &User{ID: "user123", Email: "test@example.com"}
 
-	// Create a token that is not expired and not revoked
+	// Create a token that is not expired and not revoked, used just now
 	storedToken := &Token{
-		TokenHash: testTokenHash,
-		UserID:    testUser.ID,
-		ExpiresAt: time.Now().Add(time.Hour), // Not expired
-		Revoked:   false,
+		TokenHash:  testTokenHash,
+		UserID:     testUser.ID,
+		ExpiresAt:  testNow.Add(time.Hour), // Not expired
+		LastSeenAt: testNow.Add(-time.Second),
+		Revoked:    false,
 	}
 
 	// Setup expectations
@@ -216,7 +577,7 @@ func TestValidateToken_Success(t *testing.T) {
 	mockRepo.On("Get", testUser.ID).Return(testUser, nil)
 
 	// Call method being tested
-	user, err := service.ValidateToken(testToken)
+	user, err := service.ValidateToken(context.Background(), testToken)
 
 	// Assert expectations
 	assert.NoError(t, err)
@@ -232,7 +593,7 @@ func TestValidateToken_Expired(t *testing.T) {
 	mockTokenRepo := new(MockTokenRepository)
 
 	// Create service with mocks
-	service := NewService(mockRepo, mockTokenRepo)
+	service := NewService(mockRepo, mockTokenRepo, newMemoryLoginRepository(), newMemoryAccountTokenRepository(), newMemoryTwoFactorRepository(), newMemoryAPIKeyRepository(), newMemoryOIDCRepository(), new(recordingMailer), clock.NewFake(testNow), DefaultConfig())
 
 	// Test data
 	testToken := "expiredtoken123"
@@ -242,7 +603,7 @@ func TestValidateToken_Expired(t *testing.T) {
 	storedToken := &Token{
 		TokenHash: testTokenHash,
 		UserID:    "user123",
-		ExpiresAt: time.Now().Add(-time.Hour), // Expired
+		ExpiresAt: testNow.Add(-time.Hour), // Expired
 		Revoked:   false,
 	}
 
@@ -250,7 +611,7 @@ func TestValidateToken_Expired(t *testing.T) {
 	mockTokenRepo.On("GetToken", mock.AnythingOfType("string")).Return(storedToken, nil)
 
 	// Call method being tested
-	user, err := service.ValidateToken(testToken)
+	user, err := service.ValidateToken(context.Background(), testToken)
 
 	// Assert expectations
 	assert.Error(t, err)
@@ -259,3 +620,616 @@ func TestValidateToken_Expired(t *testing.T) {
 	// User repo should not be called for expired token
 	mockRepo.AssertNotCalled(t, "Get", mock.Anything)
 }
+
+func TestSession_SlidingExpiry(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockTokenRepo := new(MockTokenRepository)
+	clk := clock.NewFake(testNow)
+	config := DefaultConfig()
+	config.Sessions = SessionConfig{
+		IdleTimeout: 24 * time.Hour,
+		MaxAge:      72 * time.Hour,
+	}
+	service := NewService(mockRepo, mockTokenRepo, newMemoryLoginRepository(), newMemoryAccountTokenRepository(), newMemoryTwoFactorRepository(), newMemoryAPIKeyRepository(), newMemoryOIDCRepository(), new(recordingMailer), clk, config)
+
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("correctpassword"), bcrypt.MinCost)
+	testUser := &User{ID: "user123", Email: "test@example.com", PasswordHash: string(hashedPassword)}
+
+	mockRepo.On("GetByEmail", "test@example.com").Return(testUser, nil)
+	mockRepo.On("Update", mock.AnythingOfType("User")).Return(nil)
+	mockRepo.On("Get", "user123").Return(testUser, nil)
+	var issued Token
+	mockTokenRepo.On("CreateToken", mock.AnythingOfType("Token")).
+		Run(func(args mock.Arguments) { issued = args.Get(0).(Token) }).
+		Return(nil)
+	mockTokenRepo.On("GetToken", mock.AnythingOfType("string")).Return(&issued, nil)
+	mockTokenRepo.On("TouchToken", mock.AnythingOfType("string"), mock.AnythingOfType("time.Time"), mock.AnythingOfType("time.Time")).
+		Run(func(args mock.Arguments) {
+			issued.LastSeenAt = args.Get(1).(time.Time)
+			issued.ExpiresAt = args.Get(2).(time.Time)
+		}).
+		Return(nil)
+
+	result, err := service.Login(context.Background(), LoginCredentials{
+		Email:     "test@example.com",
+		Password:  "correctpassword",
+		UserAgent: "Front desk tablet",
+		IPAddress: "203.0.113.7",
+	})
+	assert.NoError(t, err)
+	token := result.Token
+	assert.NotEmpty(t, issued.ID)
+	assert.Equal(t, HashToken(token), issued.TokenHash)
+	assert.Equal(t, "Front desk tablet", issued.UserAgent)
+	assert.Equal(t, "203.0.113.7", issued.IPAddress)
+	assert.Equal(t, testNow.Add(24*time.Hour), issued.ExpiresAt)
+
+	// Each use within the idle timeout pushes the expiry back
+	clk.Advance(23 * time.Hour)
+	_, err = service.ValidateToken(context.Background(), token)
+	assert.NoError(t, err)
+	assert.Equal(t, testNow.Add(47*time.Hour), issued.ExpiresAt)
+
+	clk.Advance(23 * time.Hour)
+	_, err = service.ValidateToken(context.Background(), token)
+	assert.NoError(t, err)
+
+	// ...but not past the maximum age
+	clk.Advance(23 * time.Hour)
+	_, err = service.ValidateToken(context.Background(), token)
+	assert.NoError(t, err)
+	assert.Equal(t, testNow.Add(72*time.Hour), issued.ExpiresAt)
+
+	clk.Advance(3 * time.Hour)
+	_, err = service.ValidateToken(context.Background(), token)
+	assert.Error(t, err)
+}
+
+func TestLogout_RevokesOnlyThatSession(t *testing.T) {
+	mockTokenRepo := new(MockTokenRepository)
+	service := NewService(new(MockRepository), mockTokenRepo, newMemoryLoginRepository(), newMemoryAccountTokenRepository(), newMemoryTwoFactorRepository(), newMemoryAPIKeyRepository(), newMemoryOIDCRepository(), new(recordingMailer), clock.NewFake(testNow), DefaultConfig())
+
+	mockTokenRepo.On("RevokeToken", HashToken("sometoken")).Return(nil)
+
+	assert.NoError(t, service.Logout(context.Background(), "sometoken"))
+	mockTokenRepo.AssertExpectations(t)
+	mockTokenRepo.AssertNotCalled(t, "RevokeAllUserTokens", mock.Anything)
+}
+
+func TestResetPassword(t *testing.T) {
+	// Create mocks
+	mockRepo := new(MockRepository)
+	mockTokenRepo := new(MockTokenRepository)
+
+	// Create service with mocks
+	service := NewService(mockRepo, mockTokenRepo, newMemoryLoginRepository(), newMemoryAccountTokenRepository(), newMemoryTwoFactorRepository(), newMemoryAPIKeyRepository(), newMemoryOIDCRepository(), new(recordingMailer), clock.NewFake(testNow), DefaultConfig())
+
+	testUser := &User{ID: "user123", Email: "test@example.com", PasswordHash: "oldhash"}
+
+	// Setup expectations
+	mockRepo.On("Get", "user123").Return(testUser, nil)
+	mockRepo.On("Update", mock.AnythingOfType("User")).Return(nil)
+	mockTokenRepo.On("RevokeAllUserTokens", "user123").Return(nil)
+
+	// Call method being tested
+	err := service.ResetPassword(context.Background(), "user123", "new-password-7")
+
+	// Assert expectations
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+	mockTokenRepo.AssertExpectations(t)
+
+	updatedUser := mockRepo.Calls[1].Arguments[0].(User)
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(updatedUser.PasswordHash), []byte("new-password-7")))
+}
+
+func TestLogin_BackoffAndLockout(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockTokenRepo := new(MockTokenRepository)
+	loginRepo := newMemoryLoginRepository()
+	clk := clock.NewFake(testNow)
+	config := DefaultConfig()
+	config.Lockout = LockoutConfig{
+		FreeAttempts:     2,
+		BaseDelay:        time.Second,
+		MaxDelay:         4 * time.Second,
+		AccountThreshold: 5,
+		IPThreshold:      100,
+		LockoutDuration:  15 * time.Minute,
+		Window:           time.Hour,
+	}
+	service := NewService(mockRepo, mockTokenRepo, loginRepo, newMemoryAccountTokenRepository(), newMemoryTwoFactorRepository(), newMemoryAPIKeyRepository(), newMemoryOIDCRepository(), new(recordingMailer), clk, config)
+
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("correctpassword"), bcrypt.MinCost)
+	testUser := &User{ID: "user123", Email: "test@example.com", PasswordHash: string(hashedPassword)}
+	mockRepo.On("GetByEmail", "test@example.com").Return(testUser, nil)
+	mockRepo.On("Get", "user123").Return(testUser, nil)
+	mockRepo.On("Update", mock.AnythingOfType("User")).Return(nil)
+	mockTokenRepo.On("CreateToken", mock.AnythingOfType("Token")).Return(nil)
+
+	login := func(password string) error {
+		_, err := service.Login(context.Background(), LoginCredentials{
+			Email:     "test@example.com",
+			Password:  password,
+			IPAddress: "203.0.113.7",
+		})
+		return err
+	}
+	retryAfter := func(err error) time.Duration {
+		var rateLimit *apperr.RateLimitError
+		if !assert.True(t, errors.As(err, &rateLimit), "expected a rate limit, got %v", err) {
+			return 0
+		}
+		return rateLimit.RetryAfter
+	}
+
+	// The first failures are free
+	assert.ErrorIs(t, login("guess1"), ErrInvalidCredentials)
+	assert.ErrorIs(t, login("guess2"), ErrInvalidCredentials)
+
+	// Then each attempt has to wait, twice as long after every failure
+	assert.Equal(t, time.Second, retryAfter(login("guess3")))
+	clk.Advance(time.Second)
+	assert.ErrorIs(t, login("guess3"), ErrInvalidCredentials)
+	assert.Equal(t, 2*time.Second, retryAfter(login("guess4")))
+	clk.Advance(2 * time.Second)
+	assert.ErrorIs(t, login("guess4"), ErrInvalidCredentials)
+	clk.Advance(4 * time.Second)
+
+	// The fifth failure locks the account, even against the right password
+	assert.ErrorIs(t, login("guess5"), ErrInvalidCredentials)
+	clk.Advance(time.Minute)
+	assert.Equal(t, 14*time.Minute, retryAfter(login("correctpassword")))
+
+	// An administrator can lift the lockout early
+	assert.NoError(t, service.UnlockUser(context.Background(), "user123"))
+	assert.NoError(t, login("correctpassword"))
+
+	events, err := service.ListLoginEvents(context.Background(), "user123", 0)
+	assert.NoError(t, err)
+	assert.Len(t, events, 9)
+	assert.True(t, events[0].Success)
+	assert.Equal(t, LoginLocked, events[1].Reason)
+	assert.Equal(t, LoginBadPassword, events[2].Reason)
+	assert.Equal(t, "203.0.113.7", events[0].IPAddress)
+	assert.Equal(t, testNow.Add(time.Minute+7*time.Second), events[0].CreatedAt)
+}
+
+func TestLogin_ThrottlesAddressAcrossAccounts(t *testing.T) {
+	mockRepo := new(MockRepository)
+	loginRepo := newMemoryLoginRepository()
+	clk := clock.NewFake(testNow)
+	config := DefaultConfig()
+	config.Lockout.IPThreshold = 3
+	service := NewService(mockRepo, new(MockTokenRepository), loginRepo, newMemoryAccountTokenRepository(), newMemoryTwoFactorRepository(), newMemoryAPIKeyRepository(), newMemoryOIDCRepository(), new(recordingMailer), clk, config)
+
+	mockRepo.On("GetByEmail", mock.AnythingOfType("string")).Return(nil, apperr.NotFound("user not found"))
+
+	// Unknown emails fail the same way as wrong passwords
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		_, err := service.Login(context.Background(), LoginCredentials{Email: email, Password: "x", IPAddress: "198.51.100.1"})
+		assert.ErrorIs(t, err, ErrInvalidCredentials)
+	}
+	assert.Equal(t, LoginUnknownEmail, loginRepo.events[0].Reason)
+	assert.Empty(t, loginRepo.events[0].UserID)
+
+	// The address is now locked for every account, while others can still try
+	_, err := service.Login(context.Background(), LoginCredentials{Email: "d@example.com", Password: "x", IPAddress: "198.51.100.1"})
+	assert.ErrorIs(t, err, apperr.ErrRateLimited)
+	_, err = service.Login(context.Background(), LoginCredentials{Email: "d@example.com", Password: "x", IPAddress: "198.51.100.2"})
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	// Failures are forgotten once they are older than the window
+	clk.Advance(config.Lockout.Window + time.Second)
+	assert.NoError(t, service.CleanLoginThrottles(context.Background()))
+	assert.Empty(t, loginRepo.throttles)
+}
+
+func TestCreateUser_PasswordPolicy(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockTokenRepository), newMemoryLoginRepository(), newMemoryAccountTokenRepository(), newMemoryTwoFactorRepository(), newMemoryAPIKeyRepository(), newMemoryOIDCRepository(), new(recordingMailer), clock.NewFake(testNow), DefaultConfig())
+
+	for _, password := range []string{"", "short", string(make([]byte, 73))} {
+		err := service.CreateUser(context.Background(), User{Email: "a@example.com", Username: "a", Role: RoleStaff}, password)
+		var invalid *apperr.ValidationError
+		if assert.True(t, errors.As(err, &invalid), "password %q", password) {
+			assert.Equal(t, "password", invalid.Fields[0].Field)
+		}
+	}
+	mockRepo.AssertNotCalled(t, "Create", mock.Anything)
+}
+
+func TestPasswordReset(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockTokenRepo := new(MockTokenRepository)
+	loginRepo := newMemoryLoginRepository()
+	mailer := new(recordingMailer)
+	clk := clock.NewFake(testNow)
+	config := DefaultConfig()
+	config.AccountEmails.AppURL = "https://park.example.com/"
+	service := NewService(mockRepo, mockTokenRepo, loginRepo, newMemoryAccountTokenRepository(), newMemoryTwoFactorRepository(), newMemoryAPIKeyRepository(), newMemoryOIDCRepository(), mailer, clk, config)
+
+	testUser := &User{ID: "user123", Email: "test@example.com", PasswordHash: "oldhash"}
+	mockRepo.On("GetByEmail", "test@example.com").Return(testUser, nil)
+	mockRepo.On("GetByEmail", "nobody@example.com").Return(nil, apperr.NotFound("user not found"))
+	mockRepo.On("Get", "user123").Return(testUser, nil)
+	mockRepo.On("Update", mock.AnythingOfType("User")).Return(nil)
+	mockTokenRepo.On("RevokeAllUserTokens", "user123").Return(nil)
+	loginRepo.throttles[accountThrottleKey("test@example.com")] = LoginThrottle{Failures: 10, LockedUntil: testNow.Add(time.Hour)}
+
+	// Unknown emails succeed without sending anything
+	assert.NoError(t, service.RequestPasswordReset(context.Background(), "nobody@example.com"))
+	waitForMail(service)
+	assert.Empty(t, mailer.sent)
+
+	assert.NoError(t, service.RequestPasswordReset(context.Background(), "test@example.com"))
+	waitForMail(service)
+	if !assert.Len(t, mailer.sent, 1) {
+		return
+	}
+	assert.Equal(t, "test@example.com", mailer.sent[0].To)
+	assert.Contains(t, mailer.sent[0].Body, "https://park.example.com/reset-password?token=")
+	assert.Contains(t, mailer.sent[0].Body, "within 1 hour")
+	token := linkToken(t, mailer.sent[0].Body)
+
+	// A password the policy rejects doesn't use up the link
+	assert.ErrorIs(t, service.ConfirmPasswordReset(context.Background(), token, "short"), apperr.ErrValidation)
+	assert.NoError(t, service.ConfirmPasswordReset(context.Background(), token, "a new password"))
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(mockRepo.Calls[len(mockRepo.Calls)-1].Arguments[0].(User).PasswordHash), []byte("a new password")))
+	mockTokenRepo.AssertCalled(t, "RevokeAllUserTokens", "user123")
+	assert.Empty(t, loginRepo.throttles)
+
+	// Links work once
+	assert.ErrorIs(t, service.ConfirmPasswordReset(context.Background(), token, "another password"), apperr.ErrValidation)
+
+	// Asking again replaces the earlier link, and links expire
+	assert.NoError(t, service.RequestPasswordReset(context.Background(), "test@example.com"))
+	waitForMail(service)
+	assert.NoError(t, service.RequestPasswordReset(context.Background(), "test@example.com"))
+	waitForMail(service)
+	first, second := linkToken(t, mailer.sent[1].Body), linkToken(t, mailer.sent[2].Body)
+	assert.ErrorIs(t, service.ConfirmPasswordReset(context.Background(), first, "another password"), apperr.ErrValidation)
+	clk.Advance(time.Hour)
+	assert.ErrorIs(t, service.ConfirmPasswordReset(context.Background(), second, "another password"), apperr.ErrValidation)
+}
+
+func TestInviteUser(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockTokenRepo := new(MockTokenRepository)
+	mailer := new(recordingMailer)
+	service := NewService(mockRepo, mockTokenRepo, newMemoryLoginRepository(), newMemoryAccountTokenRepository(), newMemoryTwoFactorRepository(), newMemoryAPIKeyRepository(), newMemoryOIDCRepository(), mailer, clock.NewFake(testNow), DefaultConfig())
+
+	var created User
+	mockRepo.On("Create", mock.AnythingOfType("User")).
+		Run(func(args mock.Arguments) { created = args.Get(0).(User) }).
+		Return(nil)
+
+	_, err := service.InviteUser(context.Background(), User{Email: "new@example.com", Role: "OWNER"})
+	assert.ErrorIs(t, err, apperr.ErrValidation)
+
+	invited, err := service.InviteUser(context.Background(), User{Email: "new@example.com", Username: "newbie", Role: RoleStaff})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, invited.ID)
+	assert.Empty(t, created.PasswordHash)
+	if !assert.Len(t, mailer.sent, 1) {
+		return
+	}
+	assert.Contains(t, mailer.sent[0].Body, "/accept-invite?token=")
+	assert.Contains(t, mailer.sent[0].Body, "within 7 days")
+	token := linkToken(t, mailer.sent[0].Body)
+
+	mockRepo.On("Get", invited.ID).Return(&created, nil)
+	mockRepo.On("Update", mock.AnythingOfType("User")).Return(nil)
+	mockTokenRepo.On("RevokeAllUserTokens", invited.ID).Return(nil)
+
+	// An invitation link can't be used as a reset link
+	assert.ErrorIs(t, service.ConfirmPasswordReset(context.Background(), token, "my own password"), apperr.ErrValidation)
+	assert.NoError(t, service.AcceptInvite(context.Background(), token, "my own password"))
+	updated := mockRepo.Calls[len(mockRepo.Calls)-1].Arguments[0].(User)
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(updated.PasswordHash), []byte("my own password")))
+
+	// An invitation that can't be sent doesn't leave the account behind
+	mailer.err = errors.New("mail server down")
+	mockRepo.On("Delete", mock.AnythingOfType("string")).Return(nil).Once()
+	_, err = service.InviteUser(context.Background(), User{Email: "other@example.com", Username: "other", Role: RoleStaff})
+	assert.Error(t, err)
+	mockRepo.AssertCalled(t, "Delete", created.ID)
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	policy := DefaultPasswordPolicy()
+	assert.NoError(t, policy.Blocklist.Load(strings.NewReader("# site specific\nBigRigPark1\n")))
+
+	testCases := []struct {
+		password string
+		problem  string
+	}{
+		{"Sunny site 42", ""},
+		{"lowercase-only", ""},
+		{"Ab1", "at least 8 characters"},
+		{"alllowercase", "at least 2 of"},
+		{"12345678901", "at least 2 of"},
+		{"Password1", "too common"},
+		{"P@SSW0RD", "too common"},
+		{"bigrigpark1", "too common"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.password, func(t *testing.T) {
+			invalid := &apperr.ValidationError{}
+			policy.Check(tc.password, "password", invalid)
+			if tc.problem == "" {
+				assert.NoError(t, invalid.Err())
+				return
+			}
+			if assert.Error(t, invalid.Err()) {
+				assert.Contains(t, invalid.Error(), tc.problem)
+			}
+		})
+	}
+}
+
+func TestChangePassword_RevokesOtherSessions(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockTokenRepo := new(MockTokenRepository)
+	service := NewService(mockRepo, mockTokenRepo, newMemoryLoginRepository(), newMemoryAccountTokenRepository(), newMemoryTwoFactorRepository(), newMemoryAPIKeyRepository(), newMemoryOIDCRepository(), new(recordingMailer), clock.NewFake(testNow), DefaultConfig())
+
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("Old password 1"), bcrypt.MinCost)
+	testUser := &User{ID: "user123", Email: "test@example.com", PasswordHash: string(hashedPassword)}
+	mockRepo.On("Get", "user123").Return(testUser, nil)
+	mockRepo.On("Update", mock.AnythingOfType("User")).Return(nil)
+	mockTokenRepo.On("RevokeOtherUserTokens", "user123", HashToken("current-token")).Return(nil)
+
+	err := service.ChangePassword(context.Background(), "user123", "current-token", "wrong", "New password 2")
+	assert.ErrorIs(t, err, apperr.ErrValidation)
+	err = service.ChangePassword(context.Background(), "user123", "current-token", "Old password 1", "Old password 1")
+	assert.ErrorIs(t, err, apperr.ErrValidation)
+	err = service.ChangePassword(context.Background(), "user123", "current-token", "Old password 1", "password123")
+	assert.ErrorIs(t, err, apperr.ErrValidation)
+	mockRepo.AssertNotCalled(t, "Update", mock.Anything)
+
+	assert.NoError(t, service.ChangePassword(context.Background(), "user123", "current-token", "Old password 1", "New password 2"))
+	mockTokenRepo.AssertExpectations(t)
+	mockTokenRepo.AssertNotCalled(t, "RevokeAllUserTokens", mock.Anything)
+}
+
+func TestUpdateUser_KeepsPassword(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockTokenRepository), newMemoryLoginRepository(), newMemoryAccountTokenRepository(), newMemoryTwoFactorRepository(), newMemoryAPIKeyRepository(), newMemoryOIDCRepository(), new(recordingMailer), clock.NewFake(testNow), DefaultConfig())
+
+	existing := &User{ID: "user123", Email: "old@example.com", PasswordHash: "hash", Role: RoleStaff, CreatedAt: testNow, LastLogin: testNow}
+	mockRepo.On("Get", "user123").Return(existing, nil)
+	mockRepo.On("Update", mock.AnythingOfType("User")).Return(nil)
+
+	// The client never sees the hash, so an update can't carry it
+	assert.NoError(t, service.UpdateUser(context.Background(), User{ID: "user123", Email: "new@example.com", Username: "renamed", Role: RoleAdmin}))
+	updated := mockRepo.Calls[1].Arguments[0].(User)
+	assert.Equal(t, "hash", updated.PasswordHash)
+	assert.Equal(t, "new@example.com", updated.Email)
+	assert.Equal(t, RoleAdmin, updated.Role)
+	assert.Equal(t, testNow, updated.LastLogin)
+}
+
+// authenticatorCode is what an authenticator app set up with secret shows at t
+func authenticatorCode(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+	key, err := totpEncoding.DecodeString(secret)
+	assert.NoError(t, err)
+	return totpCode(key, totpStep(at))
+}
+
+func TestTOTP(t *testing.T) {
+	// Test vectors from RFC 6238, appendix B, cut to six digits
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	assert.Equal(t, "287082", authenticatorCode(t, secret, time.Unix(59, 0)))
+	assert.Equal(t, "081804", authenticatorCode(t, secret, time.Unix(1111111109, 0)))
+	assert.Equal(t, "005924", authenticatorCode(t, secret, time.Unix(1234567890, 0)))
+
+	// A code from the previous period is still accepted, one from two periods ago isn't
+	now := time.Unix(1111111109, 0)
+	_, ok := matchTOTP(secret, authenticatorCode(t, secret, now.Add(-totpPeriod)), now)
+	assert.True(t, ok)
+	_, ok = matchTOTP(secret, authenticatorCode(t, secret, now.Add(-2*totpPeriod)), now)
+	assert.False(t, ok)
+
+	uri := totpURI("RV Park", "test@example.com", secret)
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/RV%20Park:test@example.com?"), uri)
+	assert.Contains(t, uri, "secret="+secret)
+
+	codes, hashes, err := newRecoveryCodes()
+	assert.NoError(t, err)
+	assert.Len(t, codes, recoveryCodeCount)
+	assert.Regexp(t, `^[a-z2-9]{5}-[a-z2-9]{5}$`, codes[0])
+	assert.Equal(t, hashes[0], HashToken(normalizeRecoveryCode(" "+strings.ToUpper(codes[0]))))
+}
+
+func TestLogin_TwoFactor(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockTokenRepo := new(MockTokenRepository)
+	loginRepo := newMemoryLoginRepository()
+	twoFactorRepo := newMemoryTwoFactorRepository()
+	clk := clock.NewFake(testNow)
+	service := NewService(mockRepo, mockTokenRepo, loginRepo, newMemoryAccountTokenRepository(), twoFactorRepo, newMemoryAPIKeyRepository(), newMemoryOIDCRepository(), new(recordingMailer), clk, DefaultConfig())
+
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("correctpassword"), bcrypt.MinCost)
+	testUser := &User{ID: "user123", Email: "test@example.com", PasswordHash: string(hashedPassword), Role: RoleStaff}
+	mockRepo.On("GetByEmail", "test@example.com").Return(testUser, nil)
+	mockRepo.On("Get", "user123").Return(testUser, nil)
+	mockRepo.On("Update", mock.AnythingOfType("User")).Return(nil)
+	mockTokenRepo.On("CreateToken", mock.AnythingOfType("Token")).Return(nil)
+
+	// Turning two-factor sign-in on takes a code from the app
+	enrollment, err := service.EnrollTOTP(context.Background(), "user123")
+	assert.NoError(t, err)
+	assert.Contains(t, enrollment.URI, enrollment.Secret)
+	_, err = service.ConfirmTOTP(context.Background(), "user123", "000000")
+	assert.ErrorIs(t, err, apperr.ErrValidation)
+	recoveryCodes, err := service.ConfirmTOTP(context.Background(), "user123", authenticatorCode(t, enrollment.Secret, testNow))
+	assert.NoError(t, err)
+	assert.Len(t, recoveryCodes, recoveryCodeCount)
+
+	login := func() *LoginChallenge {
+		result, err := service.Login(context.Background(), LoginCredentials{Email: "test@example.com", Password: "correctpassword"})
+		assert.NoError(t, err)
+		assert.Empty(t, result.Token)
+		assert.False(t, result.Challenge.SetupRequired)
+		return result.Challenge
+	}
+	verify := func(challenge *LoginChallenge, code string) (*LoginResult, error) {
+		return service.VerifyLoginChallenge(context.Background(), ChallengeResponse{Challenge: challenge.Token, Code: code})
+	}
+
+	// The password alone no longer starts a session
+	clk.Advance(time.Minute)
+	challenge := login()
+	mockTokenRepo.AssertNotCalled(t, "CreateToken", mock.Anything)
+
+	// A wrong code fails like a wrong password but keeps the challenge
+	_, err = verify(challenge, "000000")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	assert.Equal(t, LoginBadCode, loginRepo.events[len(loginRepo.events)-1].Reason)
+
+	code := authenticatorCode(t, enrollment.Secret, clk.Now())
+	result, err := verify(challenge, code)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, result.Token)
+	assert.Empty(t, result.RecoveryCodes)
+	assert.True(t, loginRepo.events[len(loginRepo.events)-1].Success)
+
+	// Neither the challenge nor the code can be used twice
+	_, err = verify(challenge, code)
+	assert.ErrorIs(t, err, apperr.ErrValidation)
+	_, err = verify(login(), code)
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	// A recovery code works once, in place of the app
+	_, err = verify(login(), strings.ToUpper(recoveryCodes[0]))
+	assert.NoError(t, err)
+	_, err = verify(login(), recoveryCodes[0])
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	status, err := service.GetTwoFactorStatus(context.Background(), "user123")
+	assert.NoError(t, err)
+	assert.Equal(t, &TwoFactorStatus{Enabled: true, RecoveryCodesLeft: recoveryCodeCount - 1}, status)
+
+	// Challenges expire
+	challenge = login()
+	clk.Advance(DefaultTwoFactorConfig().ChallengeTTL)
+	_, err = verify(challenge, authenticatorCode(t, enrollment.Secret, clk.Now()))
+	assert.ErrorIs(t, err, apperr.ErrValidation)
+
+	// Turning it off takes a code too
+	assert.ErrorIs(t, service.DisableTwoFactor(context.Background(), "user123", "000000"), apperr.ErrValidation)
+	assert.NoError(t, service.DisableTwoFactor(context.Background(), "user123", recoveryCodes[1]))
+	result, err = service.Login(context.Background(), LoginCredentials{Email: "test@example.com", Password: "correctpassword"})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, result.Token)
+}
+
+func TestLogin_TwoFactorRequiredForRole(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockTokenRepo := new(MockTokenRepository)
+	clk := clock.NewFake(testNow)
+	config := DefaultConfig()
+	config.TwoFactor.RequiredRoles = []Role{RoleAdmin}
+	service := NewService(mockRepo, mockTokenRepo, newMemoryLoginRepository(), newMemoryAccountTokenRepository(), newMemoryTwoFactorRepository(), newMemoryAPIKeyRepository(), newMemoryOIDCRepository(), new(recordingMailer), clk, config)
+
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("correctpassword"), bcrypt.MinCost)
+	admin := &User{ID: "admin1", Email: "admin@example.com", PasswordHash: string(hashedPassword), Role: RoleAdmin}
+	mockRepo.On("GetByEmail", "admin@example.com").Return(admin, nil)
+	mockRepo.On("Get", "admin1").Return(admin, nil)
+	mockRepo.On("Update", mock.AnythingOfType("User")).Return(nil)
+	mockTokenRepo.On("CreateToken", mock.AnythingOfType("Token")).Return(nil)
+	mockTokenRepo.On("RevokeAllUserTokens", "admin1").Return(nil)
+
+	// An administrator without an authenticator has to set one up to sign in
+	result, err := service.Login(context.Background(), LoginCredentials{Email: "admin@example.com", Password: "correctpassword"})
+	assert.NoError(t, err)
+	assert.Empty(t, result.Token)
+	assert.True(t, result.Challenge.SetupRequired)
+	assert.Equal(t, testNow.Add(5*time.Minute), result.Challenge.ExpiresAt)
+	challenge := result.Challenge.Token
+
+	_, err = service.VerifyLoginChallenge(context.Background(), ChallengeResponse{Challenge: challenge, Code: "000000"})
+	assert.ErrorIs(t, err, apperr.ErrConflict)
+
+	enrollment, err := service.EnrollTOTPForChallenge(context.Background(), challenge)
+	assert.NoError(t, err)
+	result, err = service.VerifyLoginChallenge(context.Background(), ChallengeResponse{
+		Challenge: challenge,
+		Code:      authenticatorCode(t, enrollment.Secret, clk.Now()),
+	})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, result.Token)
+	assert.Len(t, result.RecoveryCodes, recoveryCodeCount)
+
+	// ...and can't turn it off again
+	err = service.DisableTwoFactor(context.Background(), "admin1", result.RecoveryCodes[0])
+	assert.ErrorIs(t, err, apperr.ErrConflict)
+
+	// After an administrator resets it, the next sign-in sets it up again
+	assert.NoError(t, service.ResetTwoFactor(context.Background(), "admin1"))
+	result, err = service.Login(context.Background(), LoginCredentials{Email: "admin@example.com", Password: "correctpassword"})
+	assert.NoError(t, err)
+	assert.True(t, result.Challenge.SetupRequired)
+	mockTokenRepo.AssertCalled(t, "RevokeAllUserTokens", "admin1")
+}
+
+func TestAPIKeys(t *testing.T) {
+	mockRepo := new(MockRepository)
+	apiKeyRepo := newMemoryAPIKeyRepository()
+	clk := clock.NewFake(testNow)
+	service := NewService(mockRepo, new(MockTokenRepository), newMemoryLoginRepository(), newMemoryAccountTokenRepository(), newMemoryTwoFactorRepository(), apiKeyRepo, newMemoryOIDCRepository(), new(recordingMailer), clk, DefaultConfig())
+
+	syncUser := &User{ID: "sync1", Email: "sync@example.com", Role: RoleStaff}
+	mockRepo.On("Get", "sync1").Return(syncUser, nil)
+	mockRepo.On("Get", "missing").Return(nil, apperr.NotFound("user missing not found"))
+
+	yesterday := testNow.Add(-24 * time.Hour)
+	_, _, err := service.CreateAPIKey(context.Background(), APIKey{UserID: "missing", Scopes: []Scope{"payments:delete"}, ExpiresAt: &yesterday})
+	var invalid *apperr.ValidationError
+	assert.ErrorAs(t, err, &invalid)
+	assert.Len(t, invalid.Fields, 4)
+
+	expires := testNow.Add(30 * 24 * time.Hour)
+	created, secret, err := service.CreateAPIKey(context.Background(), APIKey{
+		Name:      " Accounting sync ",
+		UserID:    "sync1",
+		Scopes:    []Scope{ScopePaymentsRead},
+		ExpiresAt: &expires,
+		CreatedBy: "admin1",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "Accounting sync", created.Name)
+	assert.True(t, strings.HasPrefix(secret, created.Prefix))
+	assert.NotContains(t, apiKeyRepo.keys[created.ID].KeyHash, secret)
+
+	// Using a key records when, at most once a minute
+	user, key, err := service.ValidateAPIKey(context.Background(), secret)
+	assert.NoError(t, err)
+	assert.Equal(t, "sync1", user.ID)
+	assert.True(t, key.Allows(ScopePaymentsRead))
+	assert.False(t, key.Allows(ScopePaymentsWrite))
+	assert.Equal(t, testNow, *apiKeyRepo.keys[created.ID].LastUsedAt)
+	clk.Advance(30 * time.Second)
+	_, _, err = service.ValidateAPIKey(context.Background(), secret)
+	assert.NoError(t, err)
+	assert.Equal(t, testNow, *apiKeyRepo.keys[created.ID].LastUsedAt)
+
+	_, _, err = service.ValidateAPIKey(context.Background(), "rvpk_not-a-key")
+	assert.Error(t, err)
+
+	// Expired and revoked keys stop working
+	clk.Advance(30 * 24 * time.Hour)
+	_, _, err = service.ValidateAPIKey(context.Background(), secret)
+	assert.Error(t, err)
+
+	clk.Set(testNow)
+	assert.NoError(t, service.RevokeAPIKey(context.Background(), created.ID))
+	_, _, err = service.ValidateAPIKey(context.Background(), secret)
+	assert.Error(t, err)
+	assert.ErrorIs(t, service.RevokeAPIKey(context.Background(), created.ID), apperr.ErrNotFound)
+	keys, err := service.ListAPIKeys(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+}
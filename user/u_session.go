@@ -0,0 +1,50 @@
+// user/u_session.go contains session listing, revocation and expiry.
+package user
+
+import (
+	"context"
+	"time"
+)
+
+// SessionConfig controls how long sessions last. A session expires after
+// IdleTimeout without use; each use pushes the expiry back, but never past
+// MaxAge from sign-in.
+type SessionConfig struct {
+	IdleTimeout time.Duration
+	MaxAge      time.Duration
+}
+
+func DefaultSessionConfig() SessionConfig {
+	return SessionConfig{
+		IdleTimeout: 24 * time.Hour,
+		MaxAge:      30 * 24 * time.Hour,
+	}
+}
+
+// touchInterval limits how often a session's last use is written, so a busy
+// client doesn't cause a write on every request
+const touchInterval = time.Minute
+
+// expiresAt is when a session created at createdAt and last used at lastSeen expires
+func (c SessionConfig) expiresAt(createdAt, lastSeen time.Time) time.Time {
+	expires := lastSeen.Add(c.IdleTimeout)
+	if limit := createdAt.Add(c.MaxAge); expires.After(limit) {
+		return limit
+	}
+	return expires
+}
+
+// Logout ends the session token belongs to, leaving the user's other sessions alone
+func (s *service) Logout(ctx context.Context, token string) error {
+	return s.tokenRepo.RevokeToken(ctx, HashToken(token))
+}
+
+// ListSessions returns the user's active sessions, most recently used first
+func (s *service) ListSessions(ctx context.Context, userID string) ([]Token, error) {
+	return s.tokenRepo.ListUserTokens(ctx, userID, s.clock.Now())
+}
+
+// RevokeSession ends one of the user's sessions by ID
+func (s *service) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	return s.tokenRepo.RevokeUserToken(ctx, userID, sessionID)
+}
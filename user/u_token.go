@@ -2,25 +2,38 @@
 package user
 
 import (
+	"context"
 	"database/sql"
-	"errors"
 	"time"
+
+	"github.com/BodaciousX/RVParkBackend/apperr"
 )
 
+// Token is a signed-in session. Only the hash of the bearer token is stored;
+// sessions are referred to by ID everywhere else.
 type Token struct {
-	TokenHash string    `json:"tokenHash"`
-	UserID    string    `json:"userId"`
-	ExpiresAt time.Time `json:"expiresAt"`
-	CreatedAt time.Time `json:"createdAt"`
-	Revoked   bool      `json:"revoked"`
+	ID         string    `json:"id"`
+	TokenHash  string    `json:"-"`
+	UserID     string    `json:"userId"`
+	UserAgent  string    `json:"userAgent"`
+	IPAddress  string    `json:"ipAddress"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	Revoked    bool      `json:"revoked"`
 }
 
 type TokenRepository interface {
-	CreateToken(token Token) error
-	GetToken(tokenHash string) (*Token, error)
-	RevokeToken(tokenHash string) error
-	RevokeAllUserTokens(userID string) error
-	CleanExpiredTokens() error
+	CreateToken(ctx context.Context, token Token) error
+	GetToken(ctx context.Context, tokenHash string) (*Token, error)
+	ListUserTokens(ctx context.Context, userID string, now time.Time) ([]Token, error)
+	TouchToken(ctx context.Context, tokenHash string, lastSeenAt, expiresAt time.Time) error
+	RevokeToken(ctx context.Context, tokenHash string) error
+	RevokeUserToken(ctx context.Context, userID, id string) error
+	RevokeAllUserTokens(ctx context.Context, userID string) error
+	// RevokeOtherUserTokens revokes all of a user's tokens except keepTokenHash
+	RevokeOtherUserTokens(ctx context.Context, userID, keepTokenHash string) error
+	CleanExpiredTokens(ctx context.Context, now time.Time) error
 }
 
 type sqlTokenRepository struct {
@@ -32,41 +45,41 @@ func NewTokenRepository(db *sql.DB) TokenRepository {
 }
 
 // CreateToken stores a new token in the database
-func (r *sqlTokenRepository) CreateToken(token Token) error {
+func (r *sqlTokenRepository) CreateToken(ctx context.Context, token Token) error {
 	query := `
-		INSERT INTO tokens (token_hash, user_id, expires_at, created_at, revoked)
-		VALUES ($1, $2, $3, $4, $5)
+		INSERT INTO tokens (
+			id, token_hash, user_id, user_agent, ip_address,
+			expires_at, created_at, last_seen_at, revoked
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 	`
-	_, err := r.db.Exec(
+	_, err := r.db.ExecContext(
+		ctx,
 		query,
+		token.ID,
 		token.TokenHash,
 		token.UserID,
+		token.UserAgent,
+		token.IPAddress,
 		token.ExpiresAt,
 		token.CreatedAt,
+		token.LastSeenAt,
 		token.Revoked,
 	)
 	return err
 }
 
 // GetToken retrieves a token by its hash
-func (r *sqlTokenRepository) GetToken(tokenHash string) (*Token, error) {
+func (r *sqlTokenRepository) GetToken(ctx context.Context, tokenHash string) (*Token, error) {
 	query := `
-		SELECT token_hash, user_id, expires_at, created_at, revoked
+		SELECT ` + tokenColumns + `
 		FROM tokens
 		WHERE token_hash = $1
 	`
 
-	token := &Token{}
-	err := r.db.QueryRow(query, tokenHash).Scan(
-		&token.TokenHash,
-		&token.UserID,
-		&token.ExpiresAt,
-		&token.CreatedAt,
-		&token.Revoked,
-	)
-
+	token, err := scanToken(r.db.QueryRowContext(ctx, query, tokenHash))
 	if err == sql.ErrNoRows {
-		return nil, errors.New("token not found")
+		return nil, apperr.NotFound("token not found")
 	}
 	if err != nil {
 		return nil, err
@@ -75,14 +88,75 @@ func (r *sqlTokenRepository) GetToken(tokenHash string) (*Token, error) {
 	return token, nil
 }
 
+// ListUserTokens returns a user's sessions that are still usable at now, most
+// recently used first
+func (r *sqlTokenRepository) ListUserTokens(ctx context.Context, userID string, now time.Time) ([]Token, error) {
+	query := `
+		SELECT ` + tokenColumns + `
+		FROM tokens
+		WHERE user_id = $1 AND revoked = false AND expires_at > $2
+		ORDER BY last_seen_at DESC
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, userID, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []Token{}
+	for rows.Next() {
+		token, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *token)
+	}
+	return tokens, rows.Err()
+}
+
+// TouchToken records that a session was used and moves its expiry
+func (r *sqlTokenRepository) TouchToken(ctx context.Context, tokenHash string, lastSeenAt, expiresAt time.Time) error {
+	query := `
+		UPDATE tokens
+		SET last_seen_at = $2, expires_at = $3
+		WHERE token_hash = $1
+	`
+	_, err := r.db.ExecContext(ctx, query, tokenHash, lastSeenAt, expiresAt)
+	return err
+}
+
 // RevokeToken marks a specific token as revoked
-func (r *sqlTokenRepository) RevokeToken(tokenHash string) error {
+func (r *sqlTokenRepository) RevokeToken(ctx context.Context, tokenHash string) error {
 	query := `
 		UPDATE tokens
 		SET revoked = true
 		WHERE token_hash = $1
 	`
-	result, err := r.db.Exec(query, tokenHash)
+	result, err := r.db.ExecContext(ctx, query, tokenHash)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return apperr.NotFound("token not found")
+	}
+
+	return nil
+}
+
+// RevokeUserToken revokes one of a user's sessions by ID
+func (r *sqlTokenRepository) RevokeUserToken(ctx context.Context, userID, id string) error {
+	query := `
+		UPDATE tokens
+		SET revoked = true
+		WHERE user_id = $1 AND id::text = $2 AND revoked = false
+	`
+	result, err := r.db.ExecContext(ctx, query, userID, id)
 	if err != nil {
 		return err
 	}
@@ -92,30 +166,68 @@ func (r *sqlTokenRepository) RevokeToken(tokenHash string) error {
 		return err
 	}
 	if rows == 0 {
-		return errors.New("token not found")
+		return apperr.NotFound("session %s not found", id)
 	}
 
 	return nil
 }
 
 // RevokeAllUserTokens revokes all tokens for a specific user
-func (r *sqlTokenRepository) RevokeAllUserTokens(userID string) error {
+func (r *sqlTokenRepository) RevokeAllUserTokens(ctx context.Context, userID string) error {
 	query := `
 		UPDATE tokens
 		SET revoked = true
 		WHERE user_id = $1
 	`
-	_, err := r.db.Exec(query, userID)
+	_, err := r.db.ExecContext(ctx, query, userID)
+	return err
+}
+
+func (r *sqlTokenRepository) RevokeOtherUserTokens(ctx context.Context, userID, keepTokenHash string) error {
+	query := `
+		UPDATE tokens
+		SET revoked = true
+		WHERE user_id = $1 AND token_hash <> $2
+	`
+	_, err := r.db.ExecContext(ctx, query, userID, keepTokenHash)
 	return err
 }
 
-// CleanExpiredTokens removes all expired and revoked tokens from the database
-func (r *sqlTokenRepository) CleanExpiredTokens() error {
+// CleanExpiredTokens removes all tokens expired by now and all revoked tokens
+// from the database
+func (r *sqlTokenRepository) CleanExpiredTokens(ctx context.Context, now time.Time) error {
 	query := `
 		DELETE FROM tokens
 		WHERE expires_at < $1
 		OR revoked = true
 	`
-	_, err := r.db.Exec(query, time.Now())
+	_, err := r.db.ExecContext(ctx, query, now)
 	return err
 }
+
+const tokenColumns = `id, token_hash, user_id, user_agent, ip_address,
+		expires_at, created_at, last_seen_at, revoked`
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanToken(row rowScanner) (*Token, error) {
+	token := &Token{}
+	err := row.Scan(
+		&token.ID,
+		&token.TokenHash,
+		&token.UserID,
+		&token.UserAgent,
+		&token.IPAddress,
+		&token.ExpiresAt,
+		&token.CreatedAt,
+		&token.LastSeenAt,
+		&token.Revoked,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
@@ -0,0 +1,429 @@
+// user/u_two_factor.go contains two-factor sign-in with an authenticator app.
+package user
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/BodaciousX/RVParkBackend/apperr"
+)
+
+// TwoFactorConfig controls two-factor sign-in. Issuer names the accounts in
+// authenticator apps. Users whose role is in RequiredRoles must use a second
+// factor; if they haven't set one up they have to while signing in.
+type TwoFactorConfig struct {
+	Issuer        string
+	RequiredRoles []Role
+	ChallengeTTL  time.Duration // How long the second step may take
+}
+
+func DefaultTwoFactorConfig() TwoFactorConfig {
+	return TwoFactorConfig{
+		Issuer:       "RV Park",
+		ChallengeTTL: 5 * time.Minute,
+	}
+}
+
+func (c TwoFactorConfig) required(role Role) bool {
+	for _, r := range c.RequiredRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// LoginResult is the outcome of a correct password. Either Token holds a new
+// session, or the account needs a second factor and Challenge says how to
+// finish signing in.
+type LoginResult struct {
+	User      *User
+	Token     string
+	Challenge *LoginChallenge
+	// RecoveryCodes is set when finishing sign-in also finished setting up an
+	// authenticator. They are shown once and can't be retrieved later.
+	RecoveryCodes []string
+}
+
+// LoginChallenge is passed to VerifyLoginChallenge with a code to finish a
+// two-factor sign-in
+type LoginChallenge struct {
+	Token     string    `json:"challenge"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	// SetupRequired means the user's role requires two-factor sign-in but they
+	// have no authenticator yet, so they must enroll one with the challenge
+	SetupRequired bool `json:"setupRequired"`
+}
+
+// ChallengeResponse answers a LoginChallenge
+type ChallengeResponse struct {
+	Challenge string
+	Code      string // From the authenticator app, or a recovery code
+
+	UserAgent string
+	IPAddress string
+}
+
+// TOTPEnrollment is what an authenticator app needs to be set up. URI is
+// meant to be shown as a QR code; Secret can be typed in instead.
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type TwoFactorStatus struct {
+	Enabled           bool `json:"enabled"`
+	Required          bool `json:"required"`
+	RecoveryCodesLeft int  `json:"recoveryCodesLeft"`
+}
+
+// errWrongCode is returned when a signed-in user gives an incorrect code
+func errWrongCode() error {
+	return apperr.Invalid("code", "the code is incorrect")
+}
+
+// invalidChallenge is returned for unknown, used and expired challenges alike
+func invalidChallenge() error {
+	return apperr.Invalid("challenge", "this sign-in has expired; sign in again")
+}
+
+// challenge starts the second step of signing in as user
+func (s *service) challenge(ctx context.Context, user *User, setupRequired bool) (*LoginResult, error) {
+	token, err := s.issueAccountToken(ctx, user.ID, PurposeLoginChallenge, s.twoFactor.ChallengeTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResult{
+		User: user,
+		Challenge: &LoginChallenge{
+			Token:         token,
+			ExpiresAt:     s.clock.Now().Add(s.twoFactor.ChallengeTTL),
+			SetupRequired: setupRequired,
+		},
+	}, nil
+}
+
+// loginChallenge returns the challenge token belongs to, without using it up
+func (s *service) loginChallenge(ctx context.Context, token string, now time.Time) (*AccountToken, error) {
+	challenge, err := s.accountTokenRepo.GetAccountToken(ctx, HashToken(token), PurposeLoginChallenge, now)
+	if errors.Is(err, apperr.ErrValidation) {
+		return nil, invalidChallenge()
+	}
+	return challenge, err
+}
+
+// VerifyLoginChallenge finishes a two-factor sign-in. Wrong codes count
+// towards lockout like wrong passwords, and the challenge stays usable until
+// it expires so a mistyped code doesn't mean entering the password again.
+func (s *service) VerifyLoginChallenge(ctx context.Context, resp ChallengeResponse) (*LoginResult, error) {
+	now := s.clock.Now()
+	challenge, err := s.loginChallenge(ctx, resp.Challenge, now)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.repo.Get(ctx, challenge.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	event := LoginEvent{
+		UserID:    user.ID,
+		Email:     user.Email,
+		IPAddress: resp.IPAddress,
+		UserAgent: resp.UserAgent,
+		CreatedAt: now,
+	}
+	keys := s.lockout.throttleKeys(LoginCredentials{Email: user.Email, IPAddress: resp.IPAddress})
+	if err := s.checkLoginThrottles(ctx, event, keys); err != nil {
+		return nil, err
+	}
+
+	secret, err := s.getTOTP(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return nil, apperr.Conflict("set up an authenticator before finishing sign-in")
+	}
+
+	var ok bool
+	var step int64
+	if secret.confirmed() {
+		ok, err = s.checkSecondFactor(ctx, secret, resp.Code, now)
+	} else {
+		step, ok, err = s.useTOTP(ctx, secret, resp.Code, now)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, s.loginFailed(ctx, event, keys, LoginBadCode)
+	}
+
+	// Using up the challenge stops two correct codes racing to two sessions
+	if _, err := s.accountTokenRepo.ConsumeAccountToken(ctx, challenge.TokenHash, PurposeLoginChallenge, now); err != nil {
+		if errors.Is(err, apperr.ErrValidation) {
+			return nil, invalidChallenge()
+		}
+		return nil, err
+	}
+
+	result := &LoginResult{User: user}
+	if !secret.confirmed() {
+		if result.RecoveryCodes, err = s.enableTOTP(ctx, secret, step); err != nil {
+			return nil, err
+		}
+	}
+	if result.Token, err = s.startSession(ctx, user, event); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// EnrollTOTPForChallenge starts setting up an authenticator during a sign-in
+// that requires one. VerifyLoginChallenge with a code from the app finishes
+// both the setup and the sign-in.
+func (s *service) EnrollTOTPForChallenge(ctx context.Context, challengeToken string) (*TOTPEnrollment, error) {
+	challenge, err := s.loginChallenge(ctx, challengeToken, s.clock.Now())
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.repo.Get(ctx, challenge.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return s.startEnrollment(ctx, user)
+}
+
+func (s *service) GetTwoFactorStatus(ctx context.Context, userID string) (*TwoFactorStatus, error) {
+	user, err := s.repo.Get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := s.getTOTP(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &TwoFactorStatus{
+		Enabled:  secret.confirmed(),
+		Required: s.twoFactor.required(user.Role),
+	}
+	if status.Enabled {
+		if status.RecoveryCodesLeft, err = s.twoFactorRepo.CountRecoveryCodes(ctx, userID); err != nil {
+			return nil, err
+		}
+	}
+	return status, nil
+}
+
+// EnrollTOTP starts setting up an authenticator for a signed-in user. It
+// isn't used for sign-in until ConfirmTOTP is called with a code from it.
+func (s *service) EnrollTOTP(ctx context.Context, userID string) (*TOTPEnrollment, error) {
+	user, err := s.repo.Get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.startEnrollment(ctx, user)
+}
+
+// ConfirmTOTP turns on two-factor sign-in once code shows the authenticator
+// was set up correctly, and returns the user's recovery codes
+func (s *service) ConfirmTOTP(ctx context.Context, userID, code string) ([]string, error) {
+	user, err := s.repo.Get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := s.getTOTP(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case secret == nil:
+		return nil, apperr.Conflict("start setting up an authenticator first")
+	case secret.confirmed():
+		return nil, apperr.Conflict("two-factor sign-in is already on")
+	}
+
+	var step int64
+	err = s.guardCode(ctx, user, func(now time.Time) (ok bool, err error) {
+		step, ok, err = s.useTOTP(ctx, secret, code, now)
+		return ok, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.enableTOTP(ctx, secret, step)
+}
+
+// DisableTwoFactor turns off two-factor sign-in, given a current code or a
+// recovery code. Users whose role requires it can't turn it off.
+func (s *service) DisableTwoFactor(ctx context.Context, userID, code string) error {
+	user, err := s.repo.Get(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if s.twoFactor.required(user.Role) {
+		return apperr.Conflict("two-factor sign-in is required for %s users", user.Role)
+	}
+	secret, err := s.getTOTP(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !secret.confirmed() {
+		return apperr.Conflict("two-factor sign-in is not on")
+	}
+
+	err = s.guardCode(ctx, user, func(now time.Time) (bool, error) {
+		return s.checkSecondFactor(ctx, secret, code, now)
+	})
+	if err != nil {
+		return err
+	}
+	return s.twoFactorRepo.DeleteTOTP(ctx, userID)
+}
+
+// RegenerateRecoveryCodes replaces a user's recovery codes, given a current
+// code from their authenticator
+func (s *service) RegenerateRecoveryCodes(ctx context.Context, userID, code string) ([]string, error) {
+	user, err := s.repo.Get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := s.getTOTP(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !secret.confirmed() {
+		return nil, apperr.Conflict("two-factor sign-in is not on")
+	}
+
+	err = s.guardCode(ctx, user, func(now time.Time) (ok bool, err error) {
+		_, ok, err = s.useTOTP(ctx, secret, code, now)
+		return ok, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.newRecoveryCodes(ctx, userID)
+}
+
+// ResetTwoFactor removes a user's authenticator and recovery codes, for
+// someone who has lost both, and signs them out everywhere. Intended for
+// administrators. Users whose role requires two-factor sign-in set up a new
+// authenticator the next time they sign in.
+func (s *service) ResetTwoFactor(ctx context.Context, userID string) error {
+	if _, err := s.repo.Get(ctx, userID); err != nil {
+		return err
+	}
+	if err := s.twoFactorRepo.DeleteTOTP(ctx, userID); err != nil {
+		return err
+	}
+	return s.tokenRepo.RevokeAllUserTokens(ctx, userID)
+}
+
+// getTOTP returns the user's authenticator secret, or nil if they have none
+func (s *service) getTOTP(ctx context.Context, userID string) (*TOTPSecret, error) {
+	secret, err := s.twoFactorRepo.GetTOTP(ctx, userID)
+	if errors.Is(err, apperr.ErrNotFound) {
+		return nil, nil
+	}
+	return secret, err
+}
+
+// startEnrollment gives user a new, unconfirmed authenticator secret,
+// replacing any earlier unfinished one
+func (s *service) startEnrollment(ctx context.Context, user *User) (*TOTPEnrollment, error) {
+	existing, err := s.getTOTP(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if existing.confirmed() {
+		return nil, apperr.Conflict("two-factor sign-in is already on; turn it off before setting up a new authenticator")
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	err = s.twoFactorRepo.SaveTOTP(ctx, TOTPSecret{
+		UserID:    user.ID,
+		Secret:    secret,
+		CreatedAt: s.clock.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &TOTPEnrollment{
+		Secret: secret,
+		URI:    totpURI(s.twoFactor.Issuer, user.Email, secret),
+	}, nil
+}
+
+// enableTOTP confirms secret, whose code for step was just accepted, and
+// issues the user's first recovery codes
+func (s *service) enableTOTP(ctx context.Context, secret *TOTPSecret, step int64) ([]string, error) {
+	secret.ConfirmedAt = s.clock.Now()
+	secret.LastStep = step
+	if err := s.twoFactorRepo.SaveTOTP(ctx, *secret); err != nil {
+		return nil, err
+	}
+	return s.newRecoveryCodes(ctx, secret.UserID)
+}
+
+func (s *service) newRecoveryCodes(ctx context.Context, userID string) ([]string, error) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.twoFactorRepo.ReplaceRecoveryCodes(ctx, userID, hashes, s.clock.Now()); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// useTOTP checks code against secret and uses up its time step, so a code
+// seen over someone's shoulder can't be used again
+func (s *service) useTOTP(ctx context.Context, secret *TOTPSecret, code string, now time.Time) (int64, bool, error) {
+	step, ok := matchTOTP(secret.Secret, strings.ReplaceAll(strings.TrimSpace(code), " ", ""), now)
+	if !ok {
+		return 0, false, nil
+	}
+	ok, err := s.twoFactorRepo.UseTOTPStep(ctx, secret.UserID, step)
+	return step, ok, err
+}
+
+// checkSecondFactor accepts either a code from the authenticator or one of
+// the user's unused recovery codes
+func (s *service) checkSecondFactor(ctx context.Context, secret *TOTPSecret, code string, now time.Time) (bool, error) {
+	_, ok, err := s.useTOTP(ctx, secret, code, now)
+	if err != nil || ok {
+		return ok, err
+	}
+	return s.twoFactorRepo.UseRecoveryCode(ctx, secret.UserID, HashToken(normalizeRecoveryCode(code)), now)
+}
+
+// guardCode runs check on a code from a signed-in user. Wrong codes count
+// against the account like failed sign-ins, so a stolen session can't be
+// used to guess them.
+func (s *service) guardCode(ctx context.Context, user *User, check func(now time.Time) (bool, error)) error {
+	now := s.clock.Now()
+	keys := []throttleKey{{key: accountThrottleKey(user.Email), threshold: s.lockout.AccountThreshold}}
+	if err := s.checkThrottles(ctx, keys, now); err != nil {
+		return err
+	}
+
+	ok, err := check(now)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if err := s.countFailure(ctx, keys, now); err != nil {
+			return err
+		}
+		return errWrongCode()
+	}
+	return nil
+}
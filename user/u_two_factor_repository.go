@@ -0,0 +1,175 @@
+// user/u_two_factor_repository.go contains the storage for authenticator secrets and recovery codes.
+package user
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/BodaciousX/RVParkBackend/apperr"
+	"github.com/google/uuid"
+)
+
+// TOTPSecret is a user's authenticator app secret. It isn't in use until
+// ConfirmedAt is set by entering a valid code from the app.
+type TOTPSecret struct {
+	UserID      string
+	Secret      string
+	ConfirmedAt time.Time
+	// LastStep is the time step of the most recent code accepted
+	LastStep  int64
+	CreatedAt time.Time
+}
+
+func (t *TOTPSecret) confirmed() bool {
+	return t != nil && !t.ConfirmedAt.IsZero()
+}
+
+type TwoFactorRepository interface {
+	// GetTOTP returns a not found error if the user has no secret
+	GetTOTP(ctx context.Context, userID string) (*TOTPSecret, error)
+	// SaveTOTP creates or replaces the user's secret
+	SaveTOTP(ctx context.Context, secret TOTPSecret) error
+	// UseTOTPStep records step as the user's last accepted code. It returns
+	// false if that step or a later one was already used.
+	UseTOTPStep(ctx context.Context, userID string, step int64) (bool, error)
+	// DeleteTOTP removes the user's secret and recovery codes
+	DeleteTOTP(ctx context.Context, userID string) error
+	// ReplaceRecoveryCodes discards the user's recovery codes for new ones
+	ReplaceRecoveryCodes(ctx context.Context, userID string, codeHashes []string, now time.Time) error
+	// UseRecoveryCode marks an unused code as used at now. It returns false
+	// if the user has no such unused code.
+	UseRecoveryCode(ctx context.Context, userID, codeHash string, now time.Time) (bool, error)
+	// CountRecoveryCodes returns how many unused codes the user has left
+	CountRecoveryCodes(ctx context.Context, userID string) (int, error)
+}
+
+type sqlTwoFactorRepository struct {
+	db *sql.DB
+}
+
+func NewTwoFactorRepository(db *sql.DB) TwoFactorRepository {
+	return &sqlTwoFactorRepository{db: db}
+}
+
+func (r *sqlTwoFactorRepository) GetTOTP(ctx context.Context, userID string) (*TOTPSecret, error) {
+	query := `
+		SELECT user_id, secret, confirmed_at, last_step, created_at
+		FROM user_totp
+		WHERE user_id = $1
+	`
+	secret := &TOTPSecret{}
+	var confirmedAt sql.NullTime
+	err := r.db.QueryRowContext(ctx, query, userID).Scan(
+		&secret.UserID,
+		&secret.Secret,
+		&confirmedAt,
+		&secret.LastStep,
+		&secret.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, apperr.NotFound("user %s has no authenticator", userID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	secret.ConfirmedAt = confirmedAt.Time
+	return secret, nil
+}
+
+func (r *sqlTwoFactorRepository) SaveTOTP(ctx context.Context, secret TOTPSecret) error {
+	query := `
+		INSERT INTO user_totp (user_id, secret, confirmed_at, last_step, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id) DO UPDATE SET
+			secret = EXCLUDED.secret,
+			confirmed_at = EXCLUDED.confirmed_at,
+			last_step = EXCLUDED.last_step,
+			created_at = EXCLUDED.created_at
+	`
+	_, err := r.db.ExecContext(
+		ctx,
+		query,
+		secret.UserID,
+		secret.Secret,
+		sql.NullTime{Time: secret.ConfirmedAt, Valid: !secret.ConfirmedAt.IsZero()},
+		secret.LastStep,
+		secret.CreatedAt,
+	)
+	return err
+}
+
+// UseTOTPStep compares and sets in one statement so a code can't be replayed
+// by two requests racing each other
+func (r *sqlTwoFactorRepository) UseTOTPStep(ctx context.Context, userID string, step int64) (bool, error) {
+	query := `
+		UPDATE user_totp
+		SET last_step = $2
+		WHERE user_id = $1 AND last_step < $2
+	`
+	result, err := r.db.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows == 1, err
+}
+
+func (r *sqlTwoFactorRepository) DeleteTOTP(ctx context.Context, userID string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_totp WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *sqlTwoFactorRepository) ReplaceRecoveryCodes(ctx context.Context, userID string, codeHashes []string, now time.Time) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	for _, hash := range codeHashes {
+		query := `
+			INSERT INTO recovery_codes (id, user_id, code_hash, created_at)
+			VALUES ($1, $2, $3, $4)
+		`
+		if _, err := tx.ExecContext(ctx, query, uuid.New().String(), userID, hash, now); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *sqlTwoFactorRepository) UseRecoveryCode(ctx context.Context, userID, codeHash string, now time.Time) (bool, error) {
+	query := `
+		UPDATE recovery_codes
+		SET used_at = $3
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`
+	result, err := r.db.ExecContext(ctx, query, userID, codeHash, now)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+func (r *sqlTwoFactorRepository) CountRecoveryCodes(ctx context.Context, userID string) (int, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL`
+	err := r.db.QueryRowContext(ctx, query, userID).Scan(&count)
+	return count, err
+}